@@ -49,6 +49,10 @@ func publishHandler(b bus.IBus) web.Handler {
 const (
 	UserRole  auth.Role = 1
 	AdminRole auth.Role = 99
+
+	// the roles within an organization, on their own scale
+	OrgMemberRole auth.Role = 1
+	OrgAdminRole  auth.Role = 10
 )
 
 func runMigrations(db *gorm.DB) error {
@@ -99,11 +103,20 @@ func main() {
 			UserRole:  "user",
 			AdminRole: "admin",
 		},
+		OrgRoles: map[auth.Role]string{
+			OrgMemberRole: "member",
+			OrgAdminRole:  "admin",
+		},
 	})
 
-	r := s.HttpRouter()
-	auth.RegisterAuthRoutes(r, "/api/v1", as, UserRole)
-	r.GET("/api/v1/example", exampleMiddleware, exampleHandler)
+	api := s.HttpRouter().Group("/api/v1")
+	auth.RegisterAuthRoutes(api, "", as, UserRole)
+	auth.RegisterRBACRoutes(api, "", as, AdminRole)
+	auth.RegisterAdminRoutes(api, "", as, AdminRole)
+	auth.RegisterOrgRoutes(api, "", as, UserRole, OrgAdminRole)
+	auth.RegisterImpersonationRoutes(api, "", as, AdminRole)
+	auth.RegisterJWKSRoute(s.HttpRouter(), as)
+	api.GET("/example", exampleMiddleware, exampleHandler)
 
 	b := s.GetBus()
 
@@ -112,7 +125,7 @@ func main() {
 		return nil
 	})
 
-	r.GET("/api/v1/bus/publish", publishHandler(b))
+	api.GET("/bus/publish", publishHandler(b))
 
 	s.Start()
 }
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS sessions (
+    id         TEXT        PRIMARY KEY,
+    user_id    TEXT        NOT NULL DEFAULT '',
+    data       BYTEA       NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id    ON sessions (user_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions (expires_at);
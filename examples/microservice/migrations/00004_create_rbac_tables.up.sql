@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS permissions (
+    id          BIGSERIAL   PRIMARY KEY,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    name        TEXT        NOT NULL,
+    description TEXT        NOT NULL DEFAULT '',
+    CONSTRAINT permissions_name_unique UNIQUE (name)
+);
+
+CREATE TABLE IF NOT EXISTS roles (
+    id          BIGSERIAL   PRIMARY KEY,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    name        TEXT        NOT NULL,
+    description TEXT        NOT NULL DEFAULT '',
+    CONSTRAINT roles_name_unique UNIQUE (name)
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_id       BIGINT NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+    permission_id BIGINT NOT NULL REFERENCES permissions (id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id    BIGINT      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    role_id    BIGINT      NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, role_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_role_permissions_permission_id ON role_permissions (permission_id);
+CREATE INDEX IF NOT EXISTS idx_user_roles_role_id             ON user_roles (role_id);
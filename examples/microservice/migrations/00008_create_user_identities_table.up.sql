@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS user_identities (
+    id         BIGSERIAL   PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    user_id    BIGINT      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    provider   TEXT        NOT NULL,
+    subject    TEXT        NOT NULL,
+    email      TEXT        NOT NULL DEFAULT ''
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_identities_provider_subject ON user_identities (provider, subject);
+CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities (user_id);
+
+-- users who logged in with Google keep logging in with their identity
+INSERT INTO user_identities (user_id, provider, subject, email, created_at)
+SELECT id, 'google', google_id, COALESCE(email, ''), NOW()
+FROM users
+WHERE google_id IS NOT NULL AND google_id <> ''
+ON CONFLICT (provider, subject) DO NOTHING;
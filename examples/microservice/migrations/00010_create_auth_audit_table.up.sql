@@ -0,0 +1,18 @@
+-- the events have no foreign keys, they outlive the users
+CREATE TABLE IF NOT EXISTS auth_audit (
+    id         BIGSERIAL   PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    action     TEXT        NOT NULL,
+    outcome    TEXT        NOT NULL,
+    actor_id   BIGINT      NOT NULL DEFAULT 0,
+    subject_id BIGINT      NOT NULL DEFAULT 0,
+    ip         TEXT        NOT NULL DEFAULT '',
+    user_agent TEXT        NOT NULL DEFAULT '',
+    request_id TEXT        NOT NULL DEFAULT '',
+    details    TEXT        NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_auth_audit_created_at ON auth_audit (created_at);
+CREATE INDEX IF NOT EXISTS idx_auth_audit_action     ON auth_audit (action);
+CREATE INDEX IF NOT EXISTS idx_auth_audit_actor_id   ON auth_audit (actor_id);
+CREATE INDEX IF NOT EXISTS idx_auth_audit_subject_id ON auth_audit (subject_id);
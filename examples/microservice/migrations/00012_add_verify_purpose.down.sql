@@ -0,0 +1,13 @@
+DROP INDEX IF EXISTS idx_verify_target_purpose;
+
+-- the tokens the packed targets cannot hold are dropped
+DELETE FROM verify WHERE purpose NOT IN ('verify', 'reset', 'unlock');
+UPDATE verify SET target = target || ':email-reset-password' WHERE purpose = 'reset' AND target LIKE '%@%';
+UPDATE verify SET target = target || ':mobile-reset-password' WHERE purpose = 'reset' AND target NOT LIKE '%@%';
+UPDATE verify SET target = target || ':unlock-account' WHERE purpose = 'unlock';
+
+ALTER TABLE verify DROP COLUMN IF EXISTS device;
+ALTER TABLE verify DROP COLUMN IF EXISTS user_id;
+ALTER TABLE verify DROP COLUMN IF EXISTS purpose;
+
+ALTER TABLE verify ADD CONSTRAINT verify_target_unique UNIQUE (target);
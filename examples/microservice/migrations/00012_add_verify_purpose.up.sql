@@ -0,0 +1,17 @@
+ALTER TABLE verify ADD COLUMN IF NOT EXISTS purpose TEXT   NOT NULL DEFAULT 'verify';
+ALTER TABLE verify ADD COLUMN IF NOT EXISTS user_id BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE verify ADD COLUMN IF NOT EXISTS device  TEXT   NOT NULL DEFAULT '';
+
+ALTER TABLE verify DROP CONSTRAINT IF EXISTS verify_target_unique;
+
+-- the purpose was packed in the target as target:purpose
+UPDATE verify SET purpose = 'reset', target = LEFT(target, LENGTH(target) - LENGTH(':email-reset-password'))
+    WHERE target LIKE '%:email-reset-password';
+UPDATE verify SET purpose = 'reset', target = LEFT(target, LENGTH(target) - LENGTH(':mobile-reset-password'))
+    WHERE target LIKE '%:mobile-reset-password';
+UPDATE verify SET purpose = 'unlock', target = LEFT(target, LENGTH(target) - LENGTH(':unlock-account'))
+    WHERE target LIKE '%:unlock-account';
+-- the magic links and one-time codes only live minutes, they are not kept
+DELETE FROM verify WHERE target LIKE '%:magic-link:%' OR target LIKE '%:otp:%';
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_verify_target_purpose ON verify (target, purpose, user_id, device);
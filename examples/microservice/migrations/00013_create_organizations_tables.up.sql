@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS organizations (
+    id         BIGSERIAL   PRIMARY KEY,
+    name       TEXT        NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS org_members (
+    id         BIGSERIAL   PRIMARY KEY,
+    org_id     BIGINT      NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+    user_id    BIGINT      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    role       INTEGER     NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_org_members_org_user ON org_members (org_id, user_id);
+CREATE INDEX IF NOT EXISTS idx_org_members_user_id ON org_members (user_id);
+
+CREATE TABLE IF NOT EXISTS org_invitations (
+    id         BIGSERIAL   PRIMARY KEY,
+    org_id     BIGINT      NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+    email      TEXT        NOT NULL,
+    role       INTEGER     NOT NULL,
+    token_hash TEXT        NOT NULL,
+    invited_by BIGINT      NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    CONSTRAINT org_invitations_token_hash_unique UNIQUE (token_hash)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_org_invitations_org_email ON org_invitations (org_id, email);
+
+-- the current organization of the session, its JWT tokens carry it in the org claim
+ALTER TABLE user_sessions ADD COLUMN IF NOT EXISTS org_id BIGINT NOT NULL DEFAULT 0;
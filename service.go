@@ -1,17 +1,27 @@
 package microservice
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/unluckythoughts/go-microservice/v2/tools/bus"
 	"github.com/unluckythoughts/go-microservice/v2/tools/cache"
-	"github.com/unluckythoughts/go-microservice/v2/tools/context"
+	localcontext "github.com/unluckythoughts/go-microservice/v2/tools/context"
 	"github.com/unluckythoughts/go-microservice/v2/tools/db"
+	"github.com/unluckythoughts/go-microservice/v2/tools/health"
 	"github.com/unluckythoughts/go-microservice/v2/tools/logger"
+	"github.com/unluckythoughts/go-microservice/v2/tools/metrics"
 	"github.com/unluckythoughts/go-microservice/v2/tools/ratelimiter"
 	"github.com/unluckythoughts/go-microservice/v2/tools/sessions"
 	"github.com/unluckythoughts/go-microservice/v2/tools/sockets"
+	"github.com/unluckythoughts/go-microservice/v2/tools/tracing"
 	"github.com/unluckythoughts/go-microservice/v2/tools/web"
 	"github.com/unluckythoughts/go-microservice/v2/tools/worker"
 	"github.com/unluckythoughts/go-microservice/v2/utils"
@@ -21,8 +31,24 @@ import (
 )
 
 type (
+	// Hook is a function that runs as part of the service start or stop sequence
+	Hook func(ctx context.Context) error
+
 	IService interface {
+		// Start runs the service and exits the process if it fails to run or stop cleanly
 		Start()
+		// Run starts the service and blocks until the context is cancelled or
+		// SIGINT/SIGTERM is received, then shuts the service down gracefully
+		Run(ctx context.Context) error
+		// Shutdown stops the service, draining http and socket connections,
+		// the worker, the bus and the database and cache pools in order
+		Shutdown(ctx context.Context) error
+		// OnStart registers a hook that runs before the service starts serving requests
+		OnStart(hook Hook)
+		// OnStop registers a hook that runs after the service stops serving requests,
+		// before the worker, bus and connection pools are closed.
+		// Hooks run in the reverse order they were registered.
+		OnStop(hook Hook)
 		HttpRouter() web.Router
 		SocketRegister(method string, handler sockets.Handler)
 		GetDB() *gorm.DB
@@ -31,6 +57,14 @@ type (
 		GetAlerts() (*alerts.SlackClient, *alerts.TextClient)
 		GetLogger() *zap.Logger
 		GetWorker() *worker.Worker
+		// GetHealth returns the registry of checks served on /_live and /_ready
+		GetHealth() *health.Registry
+		// GetMetrics returns the registry served on /_metrics, handlers can register
+		// their own metrics on it. It is nil when metrics are disabled.
+		GetMetrics() *metrics.Registry
+		// GetSessions returns the session store, server side stores can list
+		// and delete the sessions of a user
+		GetSessions() sessions.Store
 	}
 
 	Options struct {
@@ -39,67 +73,121 @@ type (
 		EnableCache     bool   `env:"SERVICE_ENABLE_CACHE" envDefault:"false"`
 		EnableBus       bool   `env:"SERVICE_ENABLE_BUS" envDefault:"false"`
 		EnableRateLimit bool   `env:"SERVICE_ENABLE_RATE_LIMIT" envDefault:"false"`
+		EnableMetrics   bool   `env:"SERVICE_ENABLE_METRICS" envDefault:"true"`
+		EnableTracing   bool   `env:"SERVICE_ENABLE_TRACING" envDefault:"true"`
+		// ShutdownTimeout is the deadline for the graceful shutdown sequence
+		// Default is 30 seconds
+		ShutdownTimeout time.Duration `env:"SERVICE_SHUTDOWN_TIMEOUT" envDefault:"30s"`
 		ProxyTransport  web.ProxyTransport
 	}
 
 	service struct {
-		l      *zap.Logger
-		db     *gorm.DB
-		cache  *redis.Client
-		server *web.Server
-		worker *worker.Worker
-		bus    bus.IBus
-		slack  *alerts.SlackClient
-		text   *alerts.TextClient
+		l               *zap.Logger
+		db              *gorm.DB
+		cache           *redis.Client
+		server          *web.Server
+		worker          *worker.Worker
+		bus             bus.IBus
+		slack           *alerts.SlackClient
+		text            *alerts.TextClient
+		metrics         *metrics.Registry
+		tracer          *tracing.Provider
+		sessions        sessions.Store
+		shutdownTimeout time.Duration
+		hookMutex       sync.Mutex
+		onStart         []Hook
+		onStop          []Hook
+		shutdownOnce    sync.Once
+		shutdownErr     error
 	}
 )
 
+const defaultShutdownTimeout = 30 * time.Second
+
 func getLogger() *zap.Logger {
 	opts := logger.Options{}
 	utils.ParseEnvironmentVars(&opts)
 	return logger.New(opts)
 }
 
-func getWorker(l *zap.Logger, db *gorm.DB) *worker.Worker {
-	return worker.New(context.NewContext(l.Named("worker")), db)
+func getMetrics() *metrics.Registry {
+	opts := metrics.Options{}
+	utils.ParseEnvironmentVars(&opts)
+
+	return metrics.New(opts)
+}
+
+func getTracer(l *zap.Logger, name string) *tracing.Provider {
+	opts := tracing.Options{}
+	utils.ParseEnvironmentVars(&opts)
+	opts.Logger = l
+	opts.ServiceName = name
+
+	return tracing.New(opts)
 }
 
-func getServer(l *zap.Logger) *web.Server {
+func getWorker(l *zap.Logger, db *gorm.DB, m *metrics.Registry) *worker.Worker {
+	w := worker.New(localcontext.NewContext(l.Named("worker")), db)
+	w.SetMetrics(m)
+	return w
+}
+
+func getServer(l *zap.Logger, m *metrics.Registry) *web.Server {
 	opts := web.Options{}
 	utils.ParseEnvironmentVars(&opts)
 	opts.Logger = l
+	opts.Metrics = m
 
 	return web.NewServer(opts)
 }
 
-func getSessionStore(l *zap.Logger) sessions.Store {
+func getSessionStore(l *zap.Logger, db *gorm.DB, cache *redis.Client) sessions.Store {
 	opts := sessions.Options{}
 	utils.ParseEnvironmentVars(&opts)
 	opts.Logger = l
 
-	return sessions.NewStore(opts)
+	switch opts.Store {
+	case sessions.StoreRedis:
+		if cache == nil {
+			l.Fatal("Redis session store is selected but cache is not configured")
+		}
+		return sessions.NewRedisStore(opts, cache)
+	case sessions.StoreDB:
+		if db == nil {
+			l.Fatal("DB session store is selected but db is not configured")
+		}
+		return sessions.NewGormStore(opts, db)
+	case "", sessions.StoreCookie:
+		return sessions.NewStore(opts)
+	default:
+		l.Fatal("Unsupported session store: " + opts.Store)
+		return nil
+	}
 }
 
-func getDB(l *zap.Logger) *gorm.DB {
+func getDB(l *zap.Logger, m *metrics.Registry) *gorm.DB {
 	opts := db.Options{}
 	utils.ParseEnvironmentVars(&opts)
 	opts.Logger = l
+	opts.Metrics = m
 
 	return db.New(opts)
 }
 
-func getCache(l *zap.Logger) *redis.Client {
+func getCache(l *zap.Logger, m *metrics.Registry) *redis.Client {
 	opts := cache.Options{}
 	utils.ParseEnvironmentVars(&opts)
 	opts.Logger = l
+	opts.Metrics = m
 
 	return cache.New(opts)
 }
 
-func getBus(l *zap.Logger) bus.IBus {
+func getBus(l *zap.Logger, m *metrics.Registry) bus.IBus {
 	opts := bus.Options{}
 	utils.ParseEnvironmentVars(&opts)
 	opts.Logger = l
+	opts.Metrics = m
 
 	return bus.New(opts)
 }
@@ -109,10 +197,26 @@ func New(opts Options) IService {
 	logName = strings.ReplaceAll(logName, " ", "-")
 	l := getLogger().Named(logName)
 	l.Info("Starting " + opts.Name + " service")
+
+	var m *metrics.Registry
+	if opts.EnableMetrics {
+		m = getMetrics()
+	}
+
 	s := &service{
-		l:      l,
-		server: getServer(l.Named("web")),
-		worker: getWorker(l.Named("worker"), nil), // New worker will be set later if db enabled
+		l:               l,
+		metrics:         m,
+		server:          getServer(l.Named("web"), m),
+		worker:          getWorker(l.Named("worker"), nil, m), // New worker will be set later if db enabled
+		shutdownTimeout: defaultShutdownTimeout,
+	}
+
+	if opts.EnableTracing {
+		s.tracer = getTracer(l.Named("tracing"), logName)
+	}
+
+	if opts.ShutdownTimeout > 0 {
+		s.shutdownTimeout = opts.ShutdownTimeout
 	}
 
 	if opts.ProxyTransport != nil {
@@ -123,22 +227,24 @@ func New(opts Options) IService {
 	s.text = alerts.NewTextClient(l.Named("text"))
 
 	if opts.EnableDB {
-		db := getDB(l.Named("db"))
+		db := getDB(l.Named("db"), m)
 		s.db = db
 		// Recreate worker with DB for distributed locking
-		s.worker = getWorker(l.Named("worker"), db)
+		s.worker = getWorker(l.Named("worker"), db, m)
 	}
 
 	if opts.EnableBus {
-		b := getBus(l.Named("bus"))
+		b := getBus(l.Named("bus"), m)
 		s.bus = b
 	}
 
 	if opts.EnableCache {
-		c := getCache(l.Named("cache"))
+		c := getCache(l.Named("cache"), m)
 		s.cache = c
 	}
 
+	s.registerHealthChecks()
+
 	if opts.EnableRateLimit {
 		if s.cache == nil {
 			l.Fatal("Rate limiting is enabled but cache is not configured")
@@ -152,22 +258,166 @@ func New(opts Options) IService {
 		s.server.GetRouter().Use(rl.GetMiddleware())
 	}
 
-	if s.cache != nil {
-		// TODO: support other cache types for sessions
-		l.Info("TODO: Using cache for session store")
-	}
+	s.sessions = getSessionStore(l.Named("sessions"), s.db, s.cache)
+	s.server.GetRouter().Use(sessions.GetMiddleware(s.sessions))
 
-	store := getSessionStore(l.Named("sessions"))
-	m := sessions.GetMiddleware(store)
+	return s
+}
 
-	s.server.GetRouter().Use(m)
+// registerHealthChecks adds the health checks of every enabled component
+func (s *service) registerHealthChecks() {
+	h := s.server.GetHealth()
+	h.AddLivenessCheck(s.worker)
 
-	return s
+	if s.db != nil {
+		h.AddReadinessCheck(db.NewHealthChecker(s.db))
+	}
+
+	if s.cache != nil {
+		h.AddReadinessCheck(cache.NewHealthChecker(s.cache))
+	}
+
+	if s.bus != nil {
+		h.AddReadinessCheck(s.bus)
+	}
 }
 
 func (s *service) Start() {
-	s.server.Start()
+	if err := s.Run(context.Background()); err != nil {
+		s.l.Fatal(err.Error())
+	}
+}
+
+func (s *service) Run(ctx context.Context) error {
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	for _, hook := range s.getHooks(false) {
+		if err := hook(ctx); err != nil {
+			// the components created in New and the hooks that already ran are stopped
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+			defer cancel()
+
+			return errors.Join(fmt.Errorf("start hook failed: %w", err), s.Shutdown(shutdownCtx))
+		}
+	}
+
 	s.worker.Start()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.server.ListenAndServe()
+	}()
+
+	var runErr error
+	select {
+	case <-ctx.Done():
+		s.l.Info("Received shutdown signal")
+	case runErr = <-serveErr:
+		if runErr != nil {
+			runErr = fmt.Errorf("web server stopped: %w", runErr)
+		}
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+
+	return errors.Join(runErr, s.Shutdown(shutdownCtx))
+}
+
+func (s *service) Shutdown(ctx context.Context) error {
+	s.shutdownOnce.Do(func() {
+		s.l.Info("Stopping service")
+		s.shutdownErr = s.shutdown(ctx)
+		if s.shutdownErr != nil {
+			s.l.Error("Service stopped with errors", zap.Error(s.shutdownErr))
+			return
+		}
+		s.l.Info("Service stopped")
+	})
+
+	return s.shutdownErr
+}
+
+// shutdown runs the stop sequence, every step is attempted even if a previous one failed
+func (s *service) shutdown(ctx context.Context) error {
+	errs := []error{s.server.Shutdown(ctx)}
+
+	for _, hook := range s.getHooks(true) {
+		errs = append(errs, hook(ctx))
+	}
+
+	errs = append(errs, runWithContext(ctx, func() error {
+		s.worker.Stop()
+		return nil
+	}))
+
+	if s.bus != nil {
+		errs = append(errs, runWithContext(ctx, s.bus.Close))
+	}
+
+	if s.cache != nil {
+		errs = append(errs, s.cache.Close())
+	}
+
+	if s.db != nil {
+		sqlDB, err := s.db.DB()
+		if err == nil {
+			err = sqlDB.Close()
+		}
+		errs = append(errs, err)
+	}
+
+	if s.tracer != nil {
+		errs = append(errs, s.tracer.Shutdown(ctx))
+	}
+
+	return errors.Join(errs...)
+}
+
+// runWithContext runs fn and returns early with the context error if the deadline passes first
+func runWithContext(ctx context.Context, fn func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// getHooks returns a copy of the start hooks, or of the stop hooks in reverse order
+func (s *service) getHooks(stop bool) []Hook {
+	s.hookMutex.Lock()
+	defer s.hookMutex.Unlock()
+
+	if !stop {
+		return append([]Hook{}, s.onStart...)
+	}
+
+	hooks := make([]Hook, 0, len(s.onStop))
+	for i := len(s.onStop) - 1; i >= 0; i-- {
+		hooks = append(hooks, s.onStop[i])
+	}
+	return hooks
+}
+
+func (s *service) OnStart(hook Hook) {
+	s.hookMutex.Lock()
+	defer s.hookMutex.Unlock()
+
+	s.onStart = append(s.onStart, hook)
+}
+
+func (s *service) OnStop(hook Hook) {
+	s.hookMutex.Lock()
+	defer s.hookMutex.Unlock()
+
+	s.onStop = append(s.onStop, hook)
 }
 
 func (s *service) HttpRouter() web.Router {
@@ -206,6 +456,18 @@ func (s *service) GetWorker() *worker.Worker {
 	return s.worker
 }
 
+func (s *service) GetHealth() *health.Registry {
+	return s.server.GetHealth()
+}
+
+func (s *service) GetMetrics() *metrics.Registry {
+	return s.metrics
+}
+
+func (s *service) GetSessions() sessions.Store {
+	return s.sessions
+}
+
 func (s *service) GetAlerts() (*alerts.SlackClient, *alerts.TextClient) {
 	return s.slack, s.text
 }
@@ -0,0 +1,126 @@
+package microservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/unluckythoughts/go-microservice/v2/tools/web"
+	"go.uber.org/zap"
+)
+
+// newTestService returns a service without database, cache or bus listening on a free port
+func newTestService(t *testing.T) (*service, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := listener.Addr().(*net.TCPAddr).Port
+	require.NoError(t, listener.Close())
+
+	l := zap.NewNop()
+	s := &service{
+		l:               l,
+		server:          web.NewServer(web.Options{Logger: l, Port: port, SocketPath: "/socket", WorkerCount: 1}),
+		worker:          getWorker(l, nil, nil),
+		shutdownTimeout: time.Second,
+	}
+
+	return s, fmt.Sprintf("http://127.0.0.1:%d", port)
+}
+
+// recorder records the steps of the start and stop sequences
+type recorder struct {
+	mu    sync.Mutex
+	steps []string
+}
+
+func (r *recorder) hook(step string) Hook {
+	return func(ctx context.Context) error {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.steps = append(r.steps, step)
+		return nil
+	}
+}
+
+func (r *recorder) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string{}, r.steps...)
+}
+
+func TestRunAndShutdown(t *testing.T) {
+	s, addr := newTestService(t)
+	rec := &recorder{}
+	s.OnStart(rec.hook("start 1"))
+	s.OnStart(rec.hook("start 2"))
+	s.OnStop(rec.hook("stop 1"))
+	s.OnStop(rec.hook("stop 2"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+
+	require.Eventually(t, func() bool {
+		resp, err := http.Get(addr + "/_live")
+		if err != nil {
+			return false
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}, 2*time.Second, 10*time.Millisecond, "the server and the worker are running")
+	assert.Equal(t, []string{"start 1", "start 2"}, rec.get())
+
+	cancel()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("run did not return after the context was cancelled")
+	}
+
+	assert.Equal(t, []string{"start 1", "start 2", "stop 2", "stop 1"}, rec.get(), "stop hooks run in reverse order")
+	assert.Error(t, s.worker.Check(context.Background()), "the worker is stopped")
+	_, err := http.Get(addr + "/_live")
+	assert.Error(t, err, "the server stopped accepting connections")
+}
+
+func TestRunStartHookFailure(t *testing.T) {
+	s, _ := newTestService(t)
+	rec := &recorder{}
+	s.OnStart(func(ctx context.Context) error { return errors.New("boom") })
+	s.OnStart(rec.hook("start 2"))
+
+	s.OnStop(rec.hook("stop 1"))
+
+	err := s.Run(context.Background())
+	assert.ErrorContains(t, err, "boom")
+	assert.Equal(t, []string{"stop 1"}, rec.get(), "the next start hooks do not run and the service is stopped")
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	s, _ := newTestService(t)
+	rec := &recorder{}
+	s.OnStop(rec.hook("stop 1"))
+	s.OnStop(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := s.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second, "shutdown returns at the deadline")
+	assert.Equal(t, []string{"stop 1"}, rec.get(), "the next steps run after a failed one")
+
+	assert.Equal(t, err, s.Shutdown(context.Background()), "shutdown runs once")
+}
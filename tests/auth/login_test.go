@@ -6,6 +6,7 @@ import (
 
 	"github.com/stretchr/testify/suite"
 	"github.com/unluckythoughts/go-microservice/v2/tools/auth"
+	"github.com/unluckythoughts/go-microservice/v2/tools/web"
 )
 
 type LoginSuite struct {
@@ -55,6 +56,8 @@ func (s *LoginSuite) TestLogin_InvalidPassword() {
 
 	s.Assert().Error(err)
 	s.Assert().NotEqual(http.StatusOK, status, "login with wrong password must be rejected")
+	s.Assert().Equal(http.StatusUnauthorized, status)
+	s.Assert().Equal("auth.invalid_credentials", web.ErrorCode(err))
 }
 
 func (s *LoginSuite) TestLogin_UnknownEmail() {
@@ -65,4 +68,6 @@ func (s *LoginSuite) TestLogin_UnknownEmail() {
 
 	s.Assert().Error(err)
 	s.Assert().NotEqual(http.StatusOK, status)
+	// unknown emails are not distinguishable from wrong passwords
+	s.Assert().Equal("auth.invalid_credentials", web.ErrorCode(err))
 }
@@ -0,0 +1,78 @@
+package auth_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/unluckythoughts/go-microservice/v2/tools/auth"
+	"github.com/unluckythoughts/go-microservice/v2/tools/web"
+)
+
+type RefreshSuite struct {
+	Suite
+	user  *auth.User
+	login auth.LoginResponse
+}
+
+func TestRefreshSuite(t *testing.T) {
+	suite.Run(t, new(RefreshSuite))
+}
+
+func (s *RefreshSuite) SetupTest() {
+	user, err := s.newUser(s.T())
+	s.Require().NoError(err)
+	s.user = user
+
+	resp, status, err := s.client.Login(auth.Credentials{
+		Email:    user.Email,
+		Password: auth.Password("TestPass12!"),
+	})
+	s.Require().NoError(err)
+	s.Require().Equal(http.StatusCreated, status)
+	s.Require().NotEmpty(resp.RefreshToken, "expected a refresh token in the login response")
+	s.login = resp
+}
+
+func (s *RefreshSuite) TearDownTest() {
+	err := s.deleteUser(s.T(), s.user.ID)
+	s.Assert().NoError(err)
+	s.user = nil
+	s.client.ClearBearerToken()
+}
+
+func (s *RefreshSuite) TestRefresh_Success() {
+	resp, status, err := s.client.Refresh(auth.RefreshRequest{RefreshToken: s.login.RefreshToken})
+	s.Require().NoError(err)
+	s.Assert().Equal(http.StatusCreated, status)
+	s.Assert().NotEmpty(resp.Token)
+	s.Assert().NotEmpty(resp.RefreshToken)
+	s.Assert().NotEqual(s.login.RefreshToken, resp.RefreshToken, "the refresh token must be rotated")
+
+	s.client.SetBearerToken(resp.Token)
+	_, status, err = s.client.GetUser()
+	s.Assert().NoError(err)
+	s.Assert().Equal(http.StatusOK, status)
+}
+
+func (s *RefreshSuite) TestRefresh_ReuseRevokesFamily() {
+	resp, _, err := s.client.Refresh(auth.RefreshRequest{RefreshToken: s.login.RefreshToken})
+	s.Require().NoError(err)
+
+	_, status, err := s.client.Refresh(auth.RefreshRequest{RefreshToken: s.login.RefreshToken})
+	s.Assert().Error(err)
+	s.Assert().Equal(http.StatusUnauthorized, status)
+	s.Assert().Equal("auth.refresh_token_reused", web.ErrorCode(err))
+
+	_, status, err = s.client.Refresh(auth.RefreshRequest{RefreshToken: resp.RefreshToken})
+	s.Assert().Error(err)
+	s.Assert().Equal(http.StatusUnauthorized, status)
+	s.Assert().Equal("auth.invalid_refresh_token", web.ErrorCode(err))
+}
+
+func (s *RefreshSuite) TestRefresh_InvalidToken() {
+	_, status, err := s.client.Refresh(auth.RefreshRequest{RefreshToken: "invalid"})
+	s.Assert().Error(err)
+	s.Assert().Equal(http.StatusUnauthorized, status)
+	s.Assert().Equal("auth.invalid_refresh_token", web.ErrorCode(err))
+}
@@ -48,8 +48,10 @@ func (s *Suite) SetupSuite() {
 		LogLevel: zap.ErrorLevel.String(),
 	})
 	s.db = initializeTestDB(l)
+	// the suite only uses the database of the service, not its tokens
 	s.as = auth.New(auth.Options{
-		DB:     s.db,
-		Logger: l.Named("auth"),
+		DB:                   s.db,
+		Logger:               l.Named("auth"),
+		AllowEphemeralJWTKey: true,
 	})
 }
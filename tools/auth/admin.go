@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"strings"
+
+	"github.com/unluckythoughts/go-microservice/v2/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultUserPageSize = 50
+	maxUserPageSize     = 500
+	// forcedPasswordLength is the length of the random password replacing the
+	// password of the users forced to reset it
+	forcedPasswordLength = 32
+)
+
+// userSortColumns are the columns the users can be sorted by
+var userSortColumns = map[string]bool{
+	"id": true, "name": true, "email": true, "role": true, "created_at": true, "updated_at": true,
+}
+
+// likeEscaper escapes the wildcards of the LIKE patterns, the patterns use \ as escape character
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// userQueryScope returns the conditions of the query
+func userQueryScope(q UserQuery) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if search := strings.TrimSpace(q.Search); search != "" {
+			pattern := "%" + likeEscaper.Replace(strings.ToLower(search)) + "%"
+			db = db.Where(`(LOWER(name) LIKE ? ESCAPE '\' OR LOWER(email) LIKE ? ESCAPE '\' OR mobile LIKE ? ESCAPE '\')`,
+				pattern, pattern, pattern)
+		}
+		if q.Email != "" {
+			db = db.Where("LOWER(email) = ?", strings.ToLower(strings.TrimSpace(q.Email)))
+		}
+		if q.Role != 0 {
+			db = db.Where("role = ?", q.Role)
+		}
+		if q.Verified != nil {
+			if *q.Verified {
+				db = db.Where("(email_verified = ? OR mobile_verified = ?)", true, true)
+			} else {
+				db = db.Where("email_verified = ? AND mobile_verified = ?", false, false)
+			}
+		}
+		if q.Disabled != nil {
+			db = db.Where("disabled = ?", *q.Disabled)
+		}
+		if !q.CreatedFrom.IsZero() {
+			db = db.Where("created_at >= ?", q.CreatedFrom)
+		}
+		if !q.CreatedTo.IsZero() {
+			db = db.Where("created_at < ?", q.CreatedTo)
+		}
+
+		return db
+	}
+}
+
+// ListUsers returns the page of the users matching the query, the last
+// created first unless sorted otherwise. Deleted users are not listed
+func (s *Service) ListUsers(q UserQuery) (*UserPage, error) {
+	if q.Sort == "" {
+		q.Sort = "created_at"
+	}
+	if !userSortColumns[q.Sort] {
+		return nil, ErrInvalidSort
+	}
+
+	switch strings.ToLower(q.Order) {
+	case "", "desc":
+		q.Order = "DESC"
+	case "asc":
+		q.Order = "ASC"
+	default:
+		return nil, ErrInvalidSort
+	}
+
+	if q.Page < 1 {
+		q.Page = 1
+	}
+	if q.PageSize < 1 {
+		q.PageSize = defaultUserPageSize
+	} else if q.PageSize > maxUserPageSize {
+		q.PageSize = maxUserPageSize
+	}
+
+	page := &UserPage{Users: []User{}, Page: q.Page, PageSize: q.PageSize}
+	err := s.db.Model(&User{}).Scopes(userQueryScope(q)).Count(&page.Total).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Scopes(userQueryScope(q)).
+		Order(q.Sort + " " + q.Order + ", id " + q.Order).
+		Offset((q.Page - 1) * q.PageSize).
+		Limit(q.PageSize).
+		Find(&page.Users).Error
+	if err != nil {
+		return nil, err
+	}
+
+	utils.ClearValues(page.Users, "Password", "GoogleID")
+	return page, nil
+}
+
+// checkManageUser returns ErrForbidden unless the admin can manage the user of
+// userID, like impersonation admins only change the role of, suspend, reset or
+// delete the users with a lower role than theirs, never themselves
+func (s *Service) checkManageUser(admin *User, userID uint) error {
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if admin.ID == user.ID || user.Role >= admin.Role {
+		return ErrForbidden
+	}
+
+	return nil
+}
+
+// checkGrantRole returns ErrForbidden unless the admin can give the role,
+// admins only give roles lower than theirs
+func checkGrantRole(admin *User, role Role) error {
+	if role >= admin.Role {
+		return ErrForbidden
+	}
+
+	return nil
+}
+
+// SetUserRole changes the ordinal role of the user, the role has to be one of
+// Options.UserRoles
+func (s *Service) SetUserRole(userID uint, role Role) error {
+	if _, ok := s.userRoles[role]; !ok {
+		return ErrInvalidRole
+	}
+
+	result := s.db.Model(&User{}).Where("id = ?", userID).Update("role", role)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// SuspendUser disables the user and logs it out of all its sessions, its API
+// keys are refused until the user is unsuspended
+func (s *Service) SuspendUser(userID uint) error {
+	if err := s.setUserDisabled(userID, true); err != nil {
+		return err
+	}
+
+	return s.RevokeSessions(userID, "")
+}
+
+// UnsuspendUser enables the user again
+func (s *Service) UnsuspendUser(userID uint) error {
+	return s.setUserDisabled(userID, false)
+}
+
+func (s *Service) setUserDisabled(userID uint, disabled bool) error {
+	result := s.db.Model(&User{}).Where("id = ?", userID).Update("disabled", disabled)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// ForcePasswordReset replaces the password of the user with a random one, logs
+// it out of all its sessions and sends it a password reset token. It returns
+// the channel the token was sent on
+func (s *Service) ForcePasswordReset(userID uint) (string, error) {
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return "", err
+	}
+	if user.Email == "" && user.Mobile.String() == "" {
+		return "", ErrEmailOrMobileRequired
+	}
+
+	password, err := utils.GenerateRandomString(forcedPasswordLength)
+	if err != nil {
+		return "", err
+	}
+	if err := s.UpdateUserPassword(user.ID, Password(password)); err != nil {
+		return "", err
+	}
+	if err := s.RevokeSessions(user.ID, ""); err != nil {
+		return "", err
+	}
+
+	return s.sendPasswordReset(user, "")
+}
@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"errors"
+
+	localcontext "github.com/unluckythoughts/go-microservice/v2/tools/context"
+	"github.com/unluckythoughts/go-microservice/v2/tools/web"
+)
+
+// getAdminError returns the coded errors of the user management methods as is and wraps the others
+func getAdminError(err error) error {
+	for _, coded := range []error{
+		ErrUserNotFound, ErrInvalidRole, ErrInvalidSort, ErrEmailOrMobileRequired, ErrForbidden,
+		ErrCannotImpersonate, ErrUserDisabled, ErrNotOrgMember,
+	} {
+		if errors.Is(err, coded) {
+			return err
+		}
+	}
+
+	return ErrInternal.WithCause(err)
+}
+
+// checkManagedUser returns the authenticated admin, or ErrForbidden unless the
+// admin can manage the user of userID
+func (s *Service) checkManagedUser(ctx localcontext.Context, userID uint) (*User, error) {
+	admin, err := getAuthenticatedUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkManageUser(admin, userID); err != nil {
+		return nil, getAdminError(err)
+	}
+
+	return admin, nil
+}
+
+// ListUsersHandler returns the page of the users matching the query
+// example path: GET .../admin/users?search=john&verified=true&sort=name&order=asc
+func (s *Service) ListUsersHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, q UserQuery) (*UserPage, error) {
+		page, err := s.ListUsers(q)
+		if err != nil {
+			return nil, getAdminError(err)
+		}
+		return page, nil
+	})(r)
+}
+
+// AdminGetUserHandler returns the user
+// example path: GET .../admin/users/:userId
+func (s *Service) AdminGetUserHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, req userPathRequest) (*User, error) {
+		user, err := s.GetUserByID(req.UserID)
+		if err != nil {
+			return nil, getAdminError(err)
+		}
+		return user, nil
+	})(r)
+}
+
+// UpdateUserRoleHandler changes the ordinal role of the user
+// example path: PUT .../admin/users/:userId/role
+func (s *Service) UpdateUserRoleHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, body UserRoleRequest) (string, error) {
+		admin, err := s.checkManagedUser(ctx, body.UserID)
+		if err != nil {
+			return "", err
+		}
+		if err := checkGrantRole(admin, body.Role); err != nil {
+			return "", err
+		}
+
+		if err := s.SetUserRole(body.UserID, body.Role); err != nil {
+			return "", getAdminError(err)
+		}
+
+		s.audit(AuditUserRoleChanged, body.UserID, "role", body.Role)
+		return "user role updated successfully", nil
+	})(r)
+}
+
+// SuspendUserHandler disables the user and logs it out everywhere
+// example path: POST .../admin/users/:userId/suspend
+func (s *Service) SuspendUserHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, req userPathRequest) (string, error) {
+		if _, err := s.checkManagedUser(ctx, req.UserID); err != nil {
+			return "", err
+		}
+
+		if err := s.SuspendUser(req.UserID); err != nil {
+			return "", getAdminError(err)
+		}
+
+		s.audit(AuditUserSuspended, req.UserID)
+		return "user suspended successfully", nil
+	})(r)
+}
+
+// UnsuspendUserHandler enables the suspended user again
+// example path: POST .../admin/users/:userId/unsuspend
+func (s *Service) UnsuspendUserHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, req userPathRequest) (string, error) {
+		if _, err := s.checkManagedUser(ctx, req.UserID); err != nil {
+			return "", err
+		}
+
+		if err := s.UnsuspendUser(req.UserID); err != nil {
+			return "", getAdminError(err)
+		}
+
+		s.audit(AuditUserUnsuspended, req.UserID)
+		return "user unsuspended successfully", nil
+	})(r)
+}
+
+// ForcePasswordResetHandler replaces the password of the user, logs it out
+// everywhere and sends it a password reset token
+// example path: POST .../admin/users/:userId/reset-password
+func (s *Service) ForcePasswordResetHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, req userPathRequest) (string, error) {
+		if _, err := s.checkManagedUser(ctx, req.UserID); err != nil {
+			return "", err
+		}
+
+		channel, err := s.ForcePasswordReset(req.UserID)
+		if err != nil {
+			return "", getAdminError(err)
+		}
+
+		s.audit(AuditPasswordResetForced, req.UserID, "channel", channel)
+		return "password reset sent successfully", nil
+	})(r)
+}
+
+// DeleteUserHandler soft deletes the user and logs it out everywhere, hard
+// deletes remove the user and its data
+// example path: DELETE .../admin/users/:userId?hard=true
+func (s *Service) DeleteUserHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, req DeleteUserRequest) (string, error) {
+		if _, err := s.checkManagedUser(ctx, req.UserID); err != nil {
+			return "", err
+		}
+
+		if req.Hard {
+			if err := s.HardDeleteUser(req.UserID); err != nil {
+				return "", getAdminError(err)
+			}
+		} else {
+			if err := s.DeleteUser(req.UserID); err != nil {
+				return "", getAdminError(err)
+			}
+			if err := s.RevokeSessions(req.UserID, ""); err != nil {
+				return "", ErrInternal.WithCause(err)
+			}
+		}
+
+		s.audit(AuditUserDeleted, req.UserID, "hard", req.Hard)
+		return "user deleted successfully", nil
+	})(r)
+}
+
+// ImpersonateHandler returns a token authenticating the requests of the admin
+// as the user until it expires or the admin logs out with it
+// example path: POST .../auth/impersonate/:userId
+func (s *Service) ImpersonateHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, body ImpersonateRequest) (*ImpersonationResponse, error) {
+		admin, err := getAuthenticatedUser(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := s.Impersonate(admin, body.UserID, getAuthenticatedSession(ctx), body.OrgID)
+		if errors.Is(err, ErrCannotImpersonate) {
+			s.auditFailure(AuditImpersonationStarted, body.UserID, "reason", "not_allowed")
+			return nil, err
+		} else if err != nil {
+			return nil, getAdminError(err)
+		}
+
+		s.audit(AuditImpersonationStarted, body.UserID, "org_id", body.OrgID, "expires_at", resp.ExpiresAt)
+		return resp, nil
+	})(r)
+}
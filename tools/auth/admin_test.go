@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListUsers(t *testing.T) {
+	s := newTestService(t)
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	users := []*User{
+		{Name: "Alice Admin", Email: "alice@example.com", EmailVerified: true, Role: 99},
+		{Name: "Bob", Email: "bob@example.com", Role: 1},
+		{Name: "Carol", Email: "carol_100%@example.com", MobileVerified: true, Role: 1, Disabled: true},
+	}
+	for i, user := range users {
+		user.Password = "Password@123"
+		user.CreatedAt = created.Add(time.Duration(i) * time.Hour)
+		require.NoError(t, s.CreateUser(user))
+	}
+
+	names := func(q UserQuery) []string {
+		page, err := s.ListUsers(q)
+		require.NoError(t, err)
+
+		list := []string{}
+		for _, user := range page.Users {
+			assert.Empty(t, user.Password)
+			list = append(list, user.Name)
+		}
+		return list
+	}
+
+	verified, disabled := true, false
+	assert.Equal(t, []string{"Carol", "Bob", "Alice Admin"}, names(UserQuery{}), "the last created first")
+	assert.Equal(t, []string{"Alice Admin", "Bob", "Carol"}, names(UserQuery{Sort: "name", Order: "asc"}))
+	assert.Equal(t, []string{"Alice Admin"}, names(UserQuery{Search: "ADMIN"}))
+	assert.Equal(t, []string{"Carol"}, names(UserQuery{Search: "_100%"}))
+	assert.Empty(t, names(UserQuery{Search: "b%m"}), "wildcards are escaped")
+	assert.Equal(t, []string{"Bob"}, names(UserQuery{Email: "Bob@Example.com"}))
+	assert.Equal(t, []string{"Alice Admin"}, names(UserQuery{Role: 99}))
+	assert.Equal(t, []string{"Carol", "Alice Admin"}, names(UserQuery{Verified: &verified}))
+	assert.Equal(t, []string{"Bob", "Alice Admin"}, names(UserQuery{Disabled: &disabled}))
+	assert.Equal(t, []string{"Bob"}, names(UserQuery{CreatedFrom: created.Add(time.Hour), CreatedTo: created.Add(2 * time.Hour)}))
+
+	page, err := s.ListUsers(UserQuery{Sort: "id", Order: "asc", Page: 2, PageSize: 2})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), page.Total)
+	require.Len(t, page.Users, 1)
+	assert.Equal(t, users[2].ID, page.Users[0].ID)
+
+	_, err = s.ListUsers(UserQuery{Sort: "password"})
+	assert.ErrorIs(t, err, ErrInvalidSort)
+	_, err = s.ListUsers(UserQuery{Order: "sideways"})
+	assert.ErrorIs(t, err, ErrInvalidSort)
+
+	require.NoError(t, s.DeleteUser(users[1].ID))
+	assert.Equal(t, []string{"Carol", "Alice Admin"}, names(UserQuery{}), "deleted users are not listed")
+}
+
+func TestSetUserRole(t *testing.T) {
+	s, _, user := newMFATestService(t)
+
+	require.NoError(t, s.SetUserRole(user.ID, 99))
+	updated, err := s.GetUserByID(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, Role(99), updated.Role)
+
+	assert.ErrorIs(t, s.SetUserRole(user.ID, 5), ErrInvalidRole)
+	assert.ErrorIs(t, s.SetUserRole(user.ID+1, 1), ErrUserNotFound)
+}
+
+func TestSuspendUser(t *testing.T) {
+	s, _, user := newMFATestService(t)
+
+	session, err := s.createSession(user.ID, "laptop", "10.0.0.1")
+	require.NoError(t, err)
+	refresh, err := s.createRefreshToken(s.db, user.ID, session.SessionID)
+	require.NoError(t, err)
+
+	require.NoError(t, s.SuspendUser(user.ID))
+	suspended, err := s.GetUserByID(user.ID)
+	require.NoError(t, err)
+	assert.True(t, suspended.Disabled)
+	assert.ErrorIs(t, s.checkSession(user.ID, session.SessionID, "10.0.0.1"), ErrSessionRevoked)
+	_, _, err = s.RotateRefreshToken(refresh)
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+
+	_, err = s.getLoginResponse(nil, suspended)
+	assert.ErrorIs(t, err, ErrUserDisabled)
+
+	require.NoError(t, s.UnsuspendUser(user.ID))
+	unsuspended, err := s.GetUserByID(user.ID)
+	require.NoError(t, err)
+	assert.False(t, unsuspended.Disabled)
+
+	assert.ErrorIs(t, s.SuspendUser(user.ID+1), ErrUserNotFound)
+}
+
+func TestForcePasswordReset(t *testing.T) {
+	s, _, user := newMFATestService(t)
+	notifier := &recordingNotifier{}
+	s.notifier = notifier
+
+	channel, err := s.ForcePasswordReset(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ChannelEmail, channel)
+
+	ok, err := s.VerifyUserPassword(user.ID, "Password@123")
+	require.NoError(t, err)
+	assert.False(t, ok, "the old password does not work anymore")
+
+	require.Len(t, notifier.messages, 1)
+	msg := notifier.messages[0]
+	assert.Equal(t, MessagePasswordReset, msg.Kind)
+	assert.Equal(t, user.Email, msg.To)
+	v, err := s.GetVerification(msg.Token)
+	require.NoError(t, err)
+	assert.Equal(t, user.Email, v.Target)
+	assert.Equal(t, resetPurpose, v.Purpose)
+
+	_, err = s.ForcePasswordReset(user.ID + 1)
+	assert.ErrorIs(t, err, ErrUserNotFound)
+}
+
+func TestHardDeleteUser(t *testing.T) {
+	s, _, user := newMFATestService(t)
+
+	_, err := s.createSession(user.ID, "laptop", "10.0.0.1")
+	require.NoError(t, err)
+	_, _, err = s.CreateAPIKey(user.ID, "ci", []string{"*"}, nil)
+	require.NoError(t, err)
+	_, err = s.LinkIdentity(user.ID, "fake", &OAuthIdentity{Subject: "subject"})
+	require.NoError(t, err)
+	_, err = s.CreateOrg(user.ID, "Acme", testOrgAdminRole)
+	require.NoError(t, err)
+
+	// soft deleted users can be hard deleted
+	require.NoError(t, s.DeleteUser(user.ID))
+	require.NoError(t, s.HardDeleteUser(user.ID))
+
+	for _, model := range []any{&User{}, &UserSession{}, &APIKey{}, &UserIdentity{}, &OrgMember{}} {
+		var count int64
+		require.NoError(t, s.db.Unscoped().Model(model).Count(&count).Error)
+		assert.Zero(t, count, "%T", model)
+	}
+
+	assert.ErrorIs(t, s.HardDeleteUser(user.ID), ErrUserNotFound)
+}
+
+func TestAdminHierarchy(t *testing.T) {
+	s, admin, user, _ := newImpersonationTestService(t)
+
+	require.NoError(t, s.checkManageUser(admin, user.ID))
+	assert.ErrorIs(t, s.checkManageUser(admin, admin.ID), ErrForbidden, "admins do not manage themselves")
+	assert.ErrorIs(t, s.checkManageUser(user, admin.ID), ErrForbidden, "only users with a lower role are managed")
+	assert.ErrorIs(t, s.checkManageUser(admin, user.ID+10), ErrUserNotFound)
+
+	peer := &User{Name: "peer", Email: "peer@example.com", Password: "Password@123", Role: admin.Role}
+	require.NoError(t, s.CreateUser(peer))
+	assert.ErrorIs(t, s.checkManageUser(admin, peer.ID), ErrForbidden, "admins do not manage admins of the same role")
+
+	require.NoError(t, checkGrantRole(admin, 1))
+	assert.ErrorIs(t, checkGrantRole(admin, admin.Role), ErrForbidden, "admins do not give their own role")
+}
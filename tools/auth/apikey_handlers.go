@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"errors"
+
+	localcontext "github.com/unluckythoughts/go-microservice/v2/tools/context"
+	"github.com/unluckythoughts/go-microservice/v2/tools/web"
+)
+
+type (
+	// apiKeyPathRequest is a request for the API key of the path
+	apiKeyPathRequest struct {
+		ID uint `path:"id" valid:"required~API key id is required"`
+	}
+
+	// userAPIKeyPathRequest is a request for the API key of the user of the path
+	userAPIKeyPathRequest struct {
+		UserID uint `path:"userId" valid:"required~user id is required"`
+		ID     uint `path:"id" valid:"required~API key id is required"`
+	}
+)
+
+// getAPIKeyError returns the coded errors of the API key methods as is and wraps the others
+func getAPIKeyError(err error) error {
+	for _, coded := range []error{
+		ErrAPIKeyNotFound, ErrAPIKeyScopesRequired, ErrInvalidExpiry, ErrInvalidPermission,
+		ErrInvalidServiceAccountName, ErrUserExists, ErrUserNotFound,
+	} {
+		if errors.Is(err, coded) {
+			return err
+		}
+	}
+
+	return ErrInternal.WithCause(err)
+}
+
+// createAPIKey creates the API key of the request for the user
+func (s *Service) createAPIKey(userID uint, body CreateAPIKeyRequest) (*CreateAPIKeyResponse, error) {
+	apiKey, key, err := s.CreateAPIKey(userID, body.Name, body.Scopes, body.ExpiresAt)
+	if err != nil {
+		return nil, getAPIKeyError(err)
+	}
+
+	s.audit(AuditAPIKeyCreated, userID, "api_key_id", apiKey.ID, "name", apiKey.Name)
+	return &CreateAPIKeyResponse{APIKey: *apiKey, Key: key}, nil
+}
+
+// CreateAPIKeyHandler creates an API key for the authenticated user, the key is
+// only returned in this response. API keys cannot create other keys
+// example path: POST .../api-keys
+func (s *Service) CreateAPIKeyHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, body CreateAPIKeyRequest) (*CreateAPIKeyResponse, error) {
+		user, err := getAuthenticatedUser(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := getAuthenticatedAPIKey(ctx); ok {
+			return nil, ErrForbidden
+		}
+
+		return s.createAPIKey(user.ID, body)
+	})(r)
+}
+
+// ListAPIKeysHandler returns the API keys of the authenticated user
+// example path: GET .../api-keys
+func (s *Service) ListAPIKeysHandler(r web.Request) (any, error) {
+	return handle(s, func(s *Service, r web.Request) (any, error) {
+		user, err := GetAuthenticatedUser(r)
+		if err != nil {
+			return nil, err
+		}
+
+		keys, err := s.ListAPIKeys(user.ID)
+		if err != nil {
+			return nil, ErrInternal.WithCause(err)
+		}
+
+		return keys, nil
+	})(r)
+}
+
+// RevokeAPIKeyHandler revokes the API key of the authenticated user
+// example path: DELETE .../api-keys/:id
+func (s *Service) RevokeAPIKeyHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, req apiKeyPathRequest) (string, error) {
+		user, err := getAuthenticatedUser(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		if err := s.RevokeAPIKey(user.ID, req.ID); err != nil {
+			return "", getAPIKeyError(err)
+		}
+
+		s.audit(AuditAPIKeyRevoked, user.ID, "api_key_id", req.ID)
+		return "API key revoked successfully", nil
+	})(r)
+}
+
+// CreateServiceAccountHandler creates a service account
+// example path: POST .../admin/service-accounts
+func (s *Service) CreateServiceAccountHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, body ServiceAccountRequest) (*User, error) {
+		user, err := s.CreateServiceAccount(body.Name, body.Role)
+		if err != nil {
+			return nil, getAPIKeyError(err)
+		}
+		return user, nil
+	})(r)
+}
+
+// ListUserAPIKeysHandler returns the API keys of the user or service account
+// example path: GET .../admin/users/:userId/api-keys
+func (s *Service) ListUserAPIKeysHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, req userPathRequest) ([]APIKey, error) {
+		keys, err := s.ListAPIKeys(req.UserID)
+		if err != nil {
+			return nil, ErrInternal.WithCause(err)
+		}
+		return keys, nil
+	})(r)
+}
+
+// CreateUserAPIKeyHandler creates an API key for the user or service account,
+// the key is only returned in this response
+// example path: POST .../admin/users/:userId/api-keys
+func (s *Service) CreateUserAPIKeyHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, body CreateAPIKeyRequest) (*CreateAPIKeyResponse, error) {
+		if _, err := s.GetUserByID(body.UserID); err != nil {
+			return nil, getAPIKeyError(err)
+		}
+
+		return s.createAPIKey(body.UserID, body)
+	})(r)
+}
+
+// RevokeUserAPIKeyHandler revokes the API key of the user or service account
+// example path: DELETE .../admin/users/:userId/api-keys/:id
+func (s *Service) RevokeUserAPIKeyHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, req userAPIKeyPathRequest) (string, error) {
+		if err := s.RevokeAPIKey(req.UserID, req.ID); err != nil {
+			return "", getAPIKeyError(err)
+		}
+
+		s.audit(AuditAPIKeyRevoked, req.UserID, "api_key_id", req.ID)
+		return "API key revoked successfully", nil
+	})(r)
+}
@@ -0,0 +1,263 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	localcontext "github.com/unluckythoughts/go-microservice/v2/tools/context"
+	"github.com/unluckythoughts/go-microservice/v2/tools/web"
+	"github.com/unluckythoughts/go-microservice/v2/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	// APIKeyHeader is the header API keys are sent in, they can also be sent
+	// in the Authorization header as "ApiKey <key>"
+	APIKeyHeader = "X-API-Key"
+
+	apiKeyAuthScheme = "ApiKey "
+	apiKeyIDLength   = 12
+	apiKeySecretLen  = 32
+	// lastUsedInterval is how often the last used time of a key is updated
+	lastUsedInterval = time.Minute
+	// serviceAccountDomain is the domain of the email of service accounts,
+	// .invalid is reserved so it never receives emails
+	serviceAccountDomain = "service-accounts.invalid"
+)
+
+// apiKeyContextKey is the request context key of the API key the request is authenticated with
+type apiKeyContextKey struct{}
+
+// formatAPIKey returns the prefix shown for the key and the key given to the client,
+// the prefix, the key ID and the secret separated by _
+func (s *Service) formatAPIKey(keyID, secret string) (string, string) {
+	prefix := s.apiKeyPrefix + "_" + keyID
+	return prefix, prefix + "_" + secret
+}
+
+// parseAPIKey returns the key ID and the secret of the key
+func (s *Service) parseAPIKey(key string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(key, s.apiKeyPrefix+"_")
+	if !ok {
+		return "", "", false
+	}
+
+	keyID, secret, ok := strings.Cut(rest, "_")
+	if !ok || len(keyID) != apiKeyIDLength || len(secret) != apiKeySecretLen {
+		return "", "", false
+	}
+
+	return keyID, secret, true
+}
+
+// getAPIKeyFromRequest returns the API key sent in the X-API-Key or the Authorization header
+func getAPIKeyFromRequest(r web.Request) (string, bool) {
+	if key := r.GetHeader(APIKeyHeader); key != "" {
+		return key, true
+	}
+
+	if key, ok := strings.CutPrefix(r.GetHeader("Authorization"), apiKeyAuthScheme); ok {
+		return strings.TrimSpace(key), true
+	}
+
+	return "", false
+}
+
+// CreateAPIKey creates an API key for the user with the scopes, the permissions
+// the key grants if the user has them. It returns the key, which is only saved
+// hashed and cannot be retrieved later
+func (s *Service) CreateAPIKey(userID uint, name string, scopes []string, expiresAt *time.Time) (*APIKey, string, error) {
+	if len(scopes) == 0 {
+		return nil, "", ErrAPIKeyScopesRequired
+	}
+	if err := validatePermissions(scopes); err != nil {
+		return nil, "", err
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, "", ErrInvalidExpiry
+	}
+
+	keyID, err := utils.GenerateRandomString(apiKeyIDLength)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := utils.GenerateRandomString(apiKeySecretLen)
+	if err != nil {
+		return nil, "", err
+	}
+	prefix, key := s.formatAPIKey(keyID, secret)
+	apiKey := &APIKey{
+		UserID:    userID,
+		Name:      name,
+		KeyID:     keyID,
+		Prefix:    prefix,
+		Hash:      hashAPIKeySecret(keyID, secret),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}
+	if err := s.db.Create(apiKey).Error; err != nil {
+		return nil, "", err
+	}
+
+	return apiKey, key, nil
+}
+
+// ListAPIKeys returns the API keys of the user that were not revoked
+func (s *Service) ListAPIKeys(userID uint) ([]APIKey, error) {
+	keys := []APIKey{}
+	err := s.db.Where("user_id = ? AND revoked_at IS NULL", userID).Order("id").Find(&keys).Error
+	return keys, err
+}
+
+// RevokeAPIKey revokes the API key of the user
+func (s *Service) RevokeAPIKey(userID, id uint) error {
+	res := s.db.Model(&APIKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+// hashAPIKeySecret returns the hash saved for the secret of the key. Secrets
+// are long and random, so a fast hash keyed with the key ID is enough and
+// wrong keys cost no more than a lookup
+func hashAPIKeySecret(keyID, secret string) string {
+	mac := hmac.New(sha256.New, []byte(keyID))
+	mac.Write([]byte(secret))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyAPIKeySecret compares the secret with the hash of the key in constant time
+func verifyAPIKeySecret(apiKey *APIKey, secret string) bool {
+	hash := hashAPIKeySecret(apiKey.KeyID, secret)
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(apiKey.Hash)) == 1
+}
+
+// AuthenticateAPIKey returns the API key and its user, it fails if the key was
+// revoked or expired
+func (s *Service) AuthenticateAPIKey(key string) (*User, *APIKey, error) {
+	keyID, secret, ok := s.parseAPIKey(key)
+	if !ok {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	apiKey := &APIKey{}
+	err := s.db.Where("key_id = ?", keyID).First(apiKey).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrInvalidAPIKey
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	if apiKey.RevokedAt != nil || (apiKey.ExpiresAt != nil && !apiKey.ExpiresAt.After(now)) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	if !verifyAPIKeySecret(apiKey, secret) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	user, err := s.GetUserByID(apiKey.UserID)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, nil, ErrInvalidAPIKey
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	// the last used time is only updated once per interval to spare writes
+	err = s.db.Model(&APIKey{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", apiKey.ID, now.Add(-lastUsedInterval)).
+		Update("last_used_at", now).Error
+	if err != nil {
+		s.l.Sugar().Warnw("failed to update the last used time of the API key", "key", apiKey.Prefix, "error", err)
+	}
+
+	return user, apiKey, nil
+}
+
+// CreateServiceAccount creates a user for a machine client, it can only
+// authenticate with API keys
+func (s *Service) CreateServiceAccount(name string, role Role) (*User, error) {
+	if !roleNameRegex.MatchString(name) {
+		return nil, ErrInvalidServiceAccountName
+	}
+
+	password, err := utils.GenerateRandomString(32)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &User{
+		Name:           name,
+		Email:          fmt.Sprintf("%s@%s", strings.ToLower(name), serviceAccountDomain),
+		Role:           role,
+		Password:       Password(password),
+		ServiceAccount: true,
+	}
+
+	exists, err := s.userExists(user.Email, "")
+	if err != nil {
+		return nil, err
+	} else if exists {
+		return nil, ErrUserExists
+	}
+
+	if err := s.CreateUser(user); err != nil {
+		return nil, err
+	}
+
+	utils.ClearValues(user, "Password")
+	return user, nil
+}
+
+// setAuthenticatedAPIKey puts the API key the request is authenticated with in the request context
+func setAuthenticatedAPIKey(ctx localcontext.Context, apiKey *APIKey) {
+	_ = ctx.WithValue(apiKeyContextKey{}, apiKey)
+}
+
+// getAuthenticatedAPIKey returns the API key the request is authenticated with, if any
+func getAuthenticatedAPIKey(ctx localcontext.Context) (*APIKey, bool) {
+	apiKey, ok := ctx.Value(apiKeyContextKey{}).(*APIKey)
+	return apiKey, ok && apiKey != nil
+}
+
+// GetAuthenticatedAPIKey returns the API key the request is authenticated with,
+// false for requests authenticated with a token or a session
+func GetAuthenticatedAPIKey(r web.Request) (*APIKey, bool) {
+	return getAuthenticatedAPIKey(r.GetContext())
+}
+
+// missingAPIKeyScopes returns the required permissions missing from the scopes
+// of the API key the request is authenticated with, none without API key
+func missingAPIKeyScopes(r web.Request, required ...string) []string {
+	apiKey, ok := GetAuthenticatedAPIKey(r)
+	if !ok {
+		return nil
+	}
+
+	return missingPermissions(apiKey.Scopes, required)
+}
+
+// APIKeyRateLimitKey counts the requests authenticated with an API key under
+// the key, to be used as ratelimiter.KeyFunc after the auth middlewares
+func APIKeyRateLimitKey(r web.Request) string {
+	apiKey, ok := GetAuthenticatedAPIKey(r)
+	if !ok {
+		return ""
+	}
+
+	return "apikey:" + apiKey.KeyID
+}
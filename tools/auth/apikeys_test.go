@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	localcontext "github.com/unluckythoughts/go-microservice/v2/tools/context"
+	"github.com/unluckythoughts/go-microservice/v2/tools/web"
+	"go.uber.org/zap"
+)
+
+func TestAPIKeys(t *testing.T) {
+	s := newTestService(t)
+
+	user, err := s.CreateServiceAccount("billing-worker", 1)
+	require.NoError(t, err)
+	assert.True(t, user.ServiceAccount)
+	_, err = s.CreateServiceAccount("billing-worker", 1)
+	assert.ErrorIs(t, err, ErrUserExists)
+
+	apiKey, key, err := s.CreateAPIKey(user.ID, "invoices", []string{"invoices:*"}, nil)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(key, apiKey.Prefix+"_"))
+	assert.True(t, strings.HasPrefix(key, "ak_"))
+	assert.NotContains(t, apiKey.Hash, key[len(apiKey.Prefix)+1:])
+	assert.Equal(t, hashAPIKeySecret(apiKey.KeyID, key[len(apiKey.Prefix)+1:]), apiKey.Hash, "secrets are hashed with a keyed SHA-256")
+
+	for range 2 {
+		authenticated, authKey, err := s.AuthenticateAPIKey(key)
+		require.NoError(t, err)
+		assert.Equal(t, user.ID, authenticated.ID)
+		assert.Equal(t, Scopes{"invoices:*"}, authKey.Scopes)
+	}
+
+	keys, err := s.ListAPIKeys(user.ID)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.NotNil(t, keys[0].LastUsedAt)
+
+	// a wrong secret fails even after the key was verified
+	_, _, err = s.AuthenticateAPIKey(key[:len(key)-1] + "x")
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+	_, _, err = s.AuthenticateAPIKey("not-a-key")
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+
+	assert.ErrorIs(t, s.RevokeAPIKey(user.ID+1, apiKey.ID), ErrAPIKeyNotFound)
+	require.NoError(t, s.RevokeAPIKey(user.ID, apiKey.ID))
+	_, _, err = s.AuthenticateAPIKey(key)
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+
+	keys, err = s.ListAPIKeys(user.ID)
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestAPIKeyExpiry(t *testing.T) {
+	s := newTestService(t)
+
+	past := time.Now().Add(-time.Minute)
+	_, _, err := s.CreateAPIKey(5, "expired", []string{"*"}, &past)
+	assert.ErrorIs(t, err, ErrInvalidExpiry)
+	_, _, err = s.CreateAPIKey(5, "no scopes", nil, nil)
+	assert.ErrorIs(t, err, ErrAPIKeyScopesRequired)
+	_, _, err = s.CreateAPIKey(5, "invalid scopes", []string{"orders read"}, nil)
+	assert.ErrorIs(t, err, ErrInvalidPermission)
+
+	user := &User{Name: "user", Email: "user@example.com", Password: "Password@123"}
+	require.NoError(t, s.CreateUser(user))
+
+	future := time.Now().Add(time.Hour)
+	apiKey, key, err := s.CreateAPIKey(user.ID, "expiring", []string{"*"}, &future)
+	require.NoError(t, err)
+	_, _, err = s.AuthenticateAPIKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, s.db.Model(apiKey).Update("expires_at", time.Now().Add(-time.Second)).Error)
+	_, _, err = s.AuthenticateAPIKey(key)
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	granted := &APIKey{Scopes: Scopes{"orders:read", "invoices:*"}}
+	assert.Empty(t, missingPermissions(granted.Scopes, []string{"orders:read", "invoices:pay"}))
+	assert.Equal(t, []string{"orders:write", "*"}, missingPermissions(granted.Scopes, []string{"orders:write", "*"}))
+
+	var scopes Scopes
+	require.NoError(t, scopes.Scan("a:b,c"))
+	assert.Equal(t, Scopes{"a:b", "c"}, scopes)
+	require.NoError(t, scopes.Scan(nil))
+	assert.Equal(t, Scopes{}, scopes)
+}
+
+// contextRequest is a request with only a context
+type contextRequest struct {
+	web.Request
+	ctx localcontext.Context
+}
+
+func (r contextRequest) GetContext() localcontext.Context { return r.ctx }
+
+func TestAPIKeyRateLimitKey(t *testing.T) {
+	r := contextRequest{ctx: localcontext.NewContext(zap.NewNop())}
+	assert.Empty(t, APIKeyRateLimitKey(r), "requests without API key fall back to the IP")
+
+	setAuthenticatedAPIKey(r.ctx, &APIKey{KeyID: "abc123"})
+	assert.Equal(t, "apikey:abc123", APIKeyRateLimitKey(r))
+}
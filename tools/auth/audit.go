@@ -0,0 +1,283 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/unluckythoughts/go-microservice/v2/tools/bus"
+	localcontext "github.com/unluckythoughts/go-microservice/v2/tools/context"
+	"github.com/unluckythoughts/go-microservice/v2/tools/web"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Audit actions of the auth service
+const (
+	AuditLogin                  = "login"
+	AuditLoginFailed            = "login_failed"
+	AuditLoginThrottled         = "login_throttled"
+	AuditLogout                 = "logout"
+	AuditAccountLocked          = "account_locked"
+	AuditAccountUnlocked        = "account_unlocked"
+	AuditUserRegistered         = "user_registered"
+	AuditPasswordChanged        = "password_changed"
+	AuditPasswordResetRequested = "password_reset_requested"
+	AuditPasswordReset          = "password_reset"
+	AuditMFAVerified            = "mfa_verified"
+	AuditMFAEnabled             = "mfa_enabled"
+	AuditMFADisabled            = "mfa_disabled"
+	AuditPasswordlessLogin      = "passwordless_login"
+	AuditOAuthLogin             = "oauth_login"
+	AuditIdentityLinked         = "identity_linked"
+	AuditIdentityUnlinked       = "identity_unlinked"
+	AuditSessionRevoked         = "session_revoked"
+	AuditSessionsRevoked        = "sessions_revoked"
+	AuditAPIKeyCreated          = "api_key_created"
+	AuditAPIKeyRevoked          = "api_key_revoked"
+	AuditRoleCreated            = "role_created"
+	AuditRoleUpdated            = "role_updated"
+	AuditRoleDeleted            = "role_deleted"
+	AuditUserRolesChanged       = "user_roles_changed"
+	AuditUserRoleChanged        = "user_role_changed"
+	AuditUserSuspended          = "user_suspended"
+	AuditUserUnsuspended        = "user_unsuspended"
+	AuditPasswordResetForced    = "password_reset_forced"
+	AuditUserDeleted            = "user_deleted"
+	AuditContactChangeRequested = "contact_change_requested"
+	AuditContactChanged         = "contact_changed"
+	AuditOrgCreated             = "org_created"
+	AuditOrgUpdated             = "org_updated"
+	AuditOrgDeleted             = "org_deleted"
+	AuditOrgSwitched            = "org_switched"
+	AuditOrgMemberInvited       = "org_member_invited"
+	AuditOrgInvitationRevoked   = "org_invitation_revoked"
+	AuditOrgInvitationAccepted  = "org_invitation_accepted"
+	AuditOrgMemberRoleChanged   = "org_member_role_changed"
+	AuditOrgMemberRemoved       = "org_member_removed"
+	AuditImpersonationStarted   = "impersonation_started"
+	AuditImpersonationEnded     = "impersonation_ended"
+	AuditImpersonatedRequest    = "impersonated_request"
+	AuditImpersonationDenied    = "impersonation_denied"
+)
+
+// Outcomes of the audit events
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 500
+)
+
+type (
+	// AuditSink records the audit events, see NewDBAuditSink, NewLogAuditSink,
+	// NewBusAuditSink and AuditSinks
+	AuditSink interface {
+		Record(ctx context.Context, event AuditEvent) error
+	}
+
+	// AuditSinks records the events in all its sinks
+	AuditSinks []AuditSink
+
+	// BusPublisher publishes messages on the message bus, bus.IBus is one
+	BusPublisher interface {
+		Publish(msg bus.Message) error
+	}
+
+	dbAuditSink struct {
+		db *gorm.DB
+	}
+
+	logAuditSink struct {
+		l *zap.Logger
+	}
+
+	busAuditSink struct {
+		b     BusPublisher
+		topic string
+	}
+)
+
+// Record records the event in all the sinks, even when some of them fail
+func (sinks AuditSinks) Record(ctx context.Context, event AuditEvent) error {
+	var errs []error
+	for _, sink := range sinks {
+		if err := sink.Record(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// NewDBAuditSink returns a sink saving the events in the auth_audit table, the
+// admin routes query the events of this table
+func NewDBAuditSink(db *gorm.DB) AuditSink {
+	return &dbAuditSink{db: db}
+}
+
+func (ds *dbAuditSink) Record(ctx context.Context, event AuditEvent) error {
+	return ds.db.WithContext(ctx).Create(&event).Error
+}
+
+// NewLogAuditSink returns a sink logging the events at info level
+func NewLogAuditSink(l *zap.Logger) AuditSink {
+	return &logAuditSink{l: l}
+}
+
+func (ls *logAuditSink) Record(_ context.Context, event AuditEvent) error {
+	ls.l.Info(event.Action,
+		zap.String("event", event.Action),
+		zap.String("outcome", event.Outcome),
+		zap.Uint("actor_id", event.ActorID),
+		zap.Uint("subject_id", event.SubjectID),
+		zap.String("ip", event.IP),
+		zap.String("user_agent", event.UserAgent),
+		zap.String("request_id", event.RequestID),
+		zap.Any("details", event.Details),
+	)
+
+	return nil
+}
+
+// NewBusAuditSink returns a sink publishing the events as JSON on the topic of
+// the message bus, the message type is the action of the event
+func NewBusAuditSink(b BusPublisher, topic string) AuditSink {
+	return &busAuditSink{b: b, topic: topic}
+}
+
+func (bs *busAuditSink) Record(ctx context.Context, event AuditEvent) error {
+	msg := bus.Message{RoutingKeys: []string{bs.topic}}
+	if err := msg.From(event, event.RequestID, event.Action); err != nil {
+		return err
+	}
+
+	return bs.b.Publish(msg.WithContext(ctx))
+}
+
+// getAuditSink returns the sink of the options, the events are logged and
+// saved in the auth_audit table by default
+func getAuditSink(opts Options) AuditSink {
+	if opts.AuditSink != nil {
+		return opts.AuditSink
+	}
+
+	sinks := AuditSinks{NewLogAuditSink(opts.Logger.Named("audit"))}
+	if opts.DB != nil {
+		sinks = append(sinks, NewDBAuditSink(opts.DB))
+	}
+
+	return sinks
+}
+
+// audit records a successful action about the user of subjectID, 0 for unknown
+// users. fields are key value pairs of the details of the event
+func (s *Service) audit(action string, subjectID uint, fields ...any) {
+	s.recordAudit(action, AuditSuccess, subjectID, fields)
+}
+
+// auditFailure records a failed action about the user of subjectID, 0 for unknown users
+func (s *Service) auditFailure(action string, subjectID uint, fields ...any) {
+	s.recordAudit(action, AuditFailure, subjectID, fields)
+}
+
+// recordAudit records the event in the audit sink, the actor and the client
+// are the ones of the request of the service context, the actor of the
+// impersonated requests is the admin. Failures are only logged
+func (s *Service) recordAudit(action, outcome string, subjectID uint, fields []any) {
+	event := AuditEvent{
+		CreatedAt: s.now(),
+		Action:    action,
+		Outcome:   outcome,
+		SubjectID: subjectID,
+	}
+
+	ctx := s.getContext()
+	if info, ok := web.GetRequestInfo(ctx); ok {
+		event.IP = info.IP
+		event.UserAgent = info.UserAgent
+		event.RequestID = info.ID
+	}
+	if webCtx, ok := localcontext.IsWebContext(ctx); ok {
+		if user, err := getAuthenticatedUser(webCtx); err == nil {
+			event.ActorID = user.ID
+		}
+		// the admin impersonating the user is the actor of the events of its requests
+		if impersonator := getImpersonator(webCtx); impersonator != nil {
+			if event.ActorID != 0 {
+				fields = append(fields, "impersonated_user_id", event.ActorID)
+			}
+			event.ActorID = impersonator.ID
+		}
+	}
+
+	if len(fields) > 0 {
+		event.Details = AuditDetails{}
+		for i := 0; i+1 < len(fields); i += 2 {
+			event.Details[fmt.Sprint(fields[i])] = fields[i+1]
+		}
+	}
+
+	if s.auditSink == nil {
+		return
+	}
+	if err := s.auditSink.Record(ctx, event); err != nil {
+		s.l.Warn("could not record the audit event", zap.String("action", action), zap.Error(err))
+	}
+}
+
+// auditQueryScope returns the conditions of the query
+func auditQueryScope(q AuditQuery) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if q.UserID != 0 {
+			db = db.Where("(actor_id = ? OR subject_id = ?)", q.UserID, q.UserID)
+		}
+		if q.Action != "" {
+			db = db.Where("action = ?", q.Action)
+		}
+		if q.Outcome != "" {
+			db = db.Where("outcome = ?", q.Outcome)
+		}
+		if !q.From.IsZero() {
+			db = db.Where("created_at >= ?", q.From)
+		}
+		if !q.To.IsZero() {
+			db = db.Where("created_at < ?", q.To)
+		}
+
+		return db
+	}
+}
+
+// ListAuditEvents returns the page of the events of the auth_audit table
+// matching the query, the last first
+func (s *Service) ListAuditEvents(q AuditQuery) (*AuditEventPage, error) {
+	if q.Page < 1 {
+		q.Page = 1
+	}
+	if q.PageSize < 1 {
+		q.PageSize = defaultAuditPageSize
+	} else if q.PageSize > maxAuditPageSize {
+		q.PageSize = maxAuditPageSize
+	}
+
+	page := &AuditEventPage{Events: []AuditEvent{}, Page: q.Page, PageSize: q.PageSize}
+	err := s.db.Model(&AuditEvent{}).Scopes(auditQueryScope(q)).Count(&page.Total).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Scopes(auditQueryScope(q)).
+		Order("created_at DESC, id DESC").
+		Offset((q.Page - 1) * q.PageSize).
+		Limit(q.PageSize).
+		Find(&page.Events).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
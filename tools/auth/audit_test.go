@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/unluckythoughts/go-microservice/v2/tools/bus"
+)
+
+// recordingSink keeps the recorded events, it fails with err when set
+type recordingSink struct {
+	events []AuditEvent
+	err    error
+}
+
+func (rs *recordingSink) Record(_ context.Context, event AuditEvent) error {
+	rs.events = append(rs.events, event)
+	return rs.err
+}
+
+// fakePublisher keeps the published messages
+type fakePublisher struct {
+	msgs []bus.Message
+}
+
+func (fp *fakePublisher) Publish(msg bus.Message) error {
+	fp.msgs = append(fp.msgs, msg)
+	return nil
+}
+
+func TestAuditFailedLogins(t *testing.T) {
+	s, _, user := newMFATestService(t)
+	sink := &recordingSink{}
+	s.auditSink = sink
+
+	lockoutAfter := s.throttle.lockoutAfter
+	for range lockoutAfter {
+		s.loginFailed(user.Email, "10.0.0.1", user)
+	}
+
+	require.Len(t, sink.events, lockoutAfter+1)
+	for _, event := range sink.events[:lockoutAfter] {
+		assert.Equal(t, AuditLoginFailed, event.Action)
+		assert.Equal(t, AuditFailure, event.Outcome)
+		assert.Equal(t, user.ID, event.SubjectID)
+		assert.Zero(t, event.ActorID, "the login requests are anonymous")
+	}
+
+	locked := sink.events[lockoutAfter]
+	assert.Equal(t, AuditAccountLocked, locked.Action)
+	assert.Equal(t, AuditSuccess, locked.Outcome)
+	assert.Equal(t, AuditDetails{"reason": "login"}, locked.Details)
+}
+
+func TestListAuditEvents(t *testing.T) {
+	s, clock, user := newMFATestService(t)
+	start := clock.now()
+
+	s.audit(AuditLogin, user.ID)
+	clock.add(time.Minute)
+	s.auditFailure(AuditLoginFailed, user.ID)
+	clock.add(time.Minute)
+	s.audit(AuditLogin, user.ID+1)
+	clock.add(time.Minute)
+	s.audit(AuditRoleCreated, 0, "role", "editor", "permissions", []string{"posts:write"})
+
+	page, err := s.ListAuditEvents(AuditQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), page.Total)
+	assert.Equal(t, defaultAuditPageSize, page.PageSize)
+	require.Len(t, page.Events, 4)
+	assert.Equal(t, AuditRoleCreated, page.Events[0].Action, "the last events come first")
+	assert.Equal(t, AuditDetails{"role": "editor", "permissions": []any{"posts:write"}}, page.Events[0].Details)
+	assert.Nil(t, page.Events[3].Details)
+
+	page, err = s.ListAuditEvents(AuditQuery{UserID: user.ID})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), page.Total)
+
+	page, err = s.ListAuditEvents(AuditQuery{Action: AuditLogin, Outcome: AuditSuccess})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), page.Total)
+
+	page, err = s.ListAuditEvents(AuditQuery{From: start.Add(time.Minute), To: start.Add(3 * time.Minute)})
+	require.NoError(t, err)
+	require.Len(t, page.Events, 2)
+	assert.Equal(t, user.ID+1, page.Events[0].SubjectID)
+	assert.Equal(t, AuditLoginFailed, page.Events[1].Action)
+
+	page, err = s.ListAuditEvents(AuditQuery{Page: 2, PageSize: 3})
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), page.Total)
+	require.Len(t, page.Events, 1)
+	assert.Equal(t, AuditLogin, page.Events[0].Action)
+	assert.Equal(t, user.ID, page.Events[0].SubjectID)
+
+	page, err = s.ListAuditEvents(AuditQuery{PageSize: maxAuditPageSize + 1})
+	require.NoError(t, err)
+	assert.Equal(t, maxAuditPageSize, page.PageSize)
+}
+
+func TestAuditSinks(t *testing.T) {
+	failing := &recordingSink{err: errors.New("sink is down")}
+	working := &recordingSink{}
+	sinks := AuditSinks{failing, working}
+
+	err := sinks.Record(context.Background(), AuditEvent{Action: AuditLogin})
+	assert.ErrorIs(t, err, failing.err)
+	assert.Len(t, failing.events, 1)
+	assert.Len(t, working.events, 1, "the other sinks record the event")
+
+	publisher := &fakePublisher{}
+	sink := NewBusAuditSink(publisher, "auth.audit")
+	event := AuditEvent{Action: AuditLogout, Outcome: AuditSuccess, SubjectID: 3, RequestID: "request-1"}
+	require.NoError(t, sink.Record(context.Background(), event))
+
+	require.Len(t, publisher.msgs, 1)
+	msg := publisher.msgs[0]
+	assert.Equal(t, []string{"auth.audit"}, msg.RoutingKeys)
+	assert.Equal(t, AuditLogout, msg.Type)
+	assert.Equal(t, "request-1", msg.CorelationID)
+
+	published := AuditEvent{}
+	require.NoError(t, json.Unmarshal(msg.Body, &published))
+	assert.Equal(t, event, published)
+}
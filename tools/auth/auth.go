@@ -1,10 +1,14 @@
 package auth
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	localcontext "github.com/unluckythoughts/go-microservice/v2/tools/context"
+	"github.com/unluckythoughts/go-microservice/v2/tools/web"
 	"github.com/unluckythoughts/go-microservice/v2/utils"
 	"go.uber.org/zap"
 	"golang.org/x/oauth2"
@@ -13,19 +17,47 @@ import (
 )
 
 type Service struct {
+	// ctx is the context of the database and cache queries, see WithContext
+	ctx          context.Context
 	db           *gorm.DB
 	cache        *redis.Client
 	l            *zap.Logger
 	ignoreRoutes []string
-	jwtKey       string
+	keys         *web.KeySet
 	jwtIssuer    string
 	jwtAudience  string
 	tokenValid   time.Duration
+	// refreshTokenValid is the validity of refresh tokens, 0 when they are disabled
+	refreshTokenValid time.Duration
 	// Roles are defined as a map where the key is Role and the value is the role name
 	// Higher value Roles have more privileges and can access all resources of lower value Roles
-	userRoles                map[Role]string
+	userRoles map[Role]string
+	// orgRoles are the roles of the members within the organizations
+	orgRoles                 map[Role]string
 	defaultMobileCountryCode string
+	apiKeyPrefix             string
 	GoogleOauthConfig        oauth2.Config
+	mfaIssuer                string
+	// throttle delays the logins after failed attempts, nil when disabled
+	throttle *loginThrottle
+	// passwordlessThrottle limits the passwordless tokens and one-time code
+	// guesses, it cannot be disabled
+	passwordlessThrottle *loginThrottle
+	notifier             Notifier
+	// auditSink records the audit events
+	auditSink AuditSink
+	// passwordlessTokenValid is the validity of the magic links and one-time codes
+	passwordlessTokenValid time.Duration
+	magicLinkURL           string
+	selfRegistration       bool
+	// invitationValid is the validity of the invitations to join an organization
+	invitationValid time.Duration
+	// impersonationValid is the validity of the tokens of the admins impersonating users
+	impersonationValid time.Duration
+	// oauthProviders are the identity providers users log in with, by name
+	oauthProviders map[string]OAuthProvider
+	// now returns the current time, tests replace it with a fixed clock
+	now func() time.Time
 }
 
 type Options struct {
@@ -36,9 +68,26 @@ type Options struct {
 	Cache *redis.Client
 	// Logger is the logger to use, if nil, it will use the default logger
 	Logger *zap.Logger
-	// JwtKey is the secret key used to sign JWT tokens
-	// If empty, it will panic
+	// JwtKey is the secret key used to sign JWT tokens with HS256 when no private key is set
+	// New fails without a key unless AllowEphemeralJWTKey is set
 	JwtKey string `env:"AUTH_JWT_KEY"`
+	// AllowEphemeralJWTKey generates a random key when no key is set, the tokens
+	// are only valid on this instance until it restarts. It is meant for development
+	AllowEphemeralJWTKey bool `env:"AUTH_ALLOW_EPHEMERAL_JWT_KEY" envDefault:"false"`
+	// JWTPrivateKey is the PEM encoded RSA, ECDSA or Ed25519 private key signing
+	// the JWT tokens, its public key is published on /.well-known/jwks.json
+	JWTPrivateKey string `env:"AUTH_JWT_PRIVATE_KEY"`
+	// JWTPrivateKeyFile is the path of the PEM file of the private key, used when JWTPrivateKey is empty
+	JWTPrivateKeyFile string `env:"AUTH_JWT_PRIVATE_KEY_FILE"`
+	// JWTKeyID is the kid header of the tokens signed with the private key
+	// Default is the RFC 7638 thumbprint of the key
+	JWTKeyID string `env:"AUTH_JWT_KEY_ID"`
+	// JWTVerificationKeyFiles are the paths of PEM files of previous private or public keys,
+	// the tokens they signed stay valid after a rotation until they are removed
+	// This can be a comma-separated list of paths
+	JWTVerificationKeyFiles []string `env:"AUTH_JWT_VERIFICATION_KEY_FILES" envSeparator:","`
+	// KeySet signs and verifies the JWT tokens, it overrides the keys above
+	KeySet *web.KeySet
 	// JWTIssuer is the "iss" claim value in generated tokens
 	// Default is "microservice"
 	JWTIssuer string `env:"AUTH_JWT_ISSUER" envDefault:"microservice"`
@@ -46,8 +95,79 @@ type Options struct {
 	// Default is "api"
 	JWTAudience string `env:"AUTH_JWT_AUDIENCE" envDefault:"api"`
 	// TokenValidInHours is the duration for which the JWT token is valid
+	// when refresh tokens are disabled
 	// Default is 4 hours
 	TokenValidInHours uint `env:"AUTH_TOKEN_VALID" envDefault:"4"`
+	// AccessTokenValidInMinutes is the duration for which the JWT token is valid
+	// when it is issued with a refresh token
+	// Default is 15 minutes
+	AccessTokenValidInMinutes uint `env:"AUTH_ACCESS_TOKEN_VALID" envDefault:"15"`
+	// RefreshTokenValidInHours is the duration for which a refresh token is valid,
+	// every refresh issues a new refresh token
+	// Default is 720 hours (30 days)
+	RefreshTokenValidInHours uint `env:"AUTH_REFRESH_TOKEN_VALID" envDefault:"720"`
+	// DisableRefreshTokens issues only JWT tokens valid for TokenValidInHours,
+	// refresh tokens need the refresh_tokens table
+	DisableRefreshTokens bool `env:"AUTH_DISABLE_REFRESH_TOKENS" envDefault:"false"`
+	// APIKeyPrefix starts the API keys, so they are recognisable, e.g. by secret scanners
+	// Default is "ak"
+	APIKeyPrefix string `env:"AUTH_API_KEY_PREFIX" envDefault:"ak"`
+	// LoginBackoffAfter is the number of failed logins of an account after which
+	// every further attempt has to wait twice as long, starting at 1 second
+	// Default is 3
+	LoginBackoffAfter uint `env:"AUTH_LOGIN_BACKOFF_AFTER" envDefault:"3"`
+	// LoginLockoutAfter is the number of failed logins locking an account,
+	// the user gets an unlock token by email
+	// Default is 10
+	LoginLockoutAfter uint `env:"AUTH_LOGIN_LOCKOUT_AFTER" envDefault:"10"`
+	// LoginIPLockoutAfter is the number of failed logins from an IP locking
+	// its logins to every account
+	// Default is 100
+	LoginIPLockoutAfter uint `env:"AUTH_LOGIN_IP_LOCKOUT_AFTER" envDefault:"100"`
+	// LoginIPRequestLimit is the number of logins an IP can attempt before
+	// waiting LoginLockoutMinutes without attempts, they are counted before
+	// the password is hashed
+	// Default is 300
+	LoginIPRequestLimit uint `env:"AUTH_LOGIN_IP_REQUEST_LIMIT" envDefault:"300"`
+	// LoginLockoutMinutes is the duration of the lockout, failed logins are
+	// forgotten after as long without failures
+	// Default is 15 minutes
+	LoginLockoutMinutes uint `env:"AUTH_LOGIN_LOCKOUT_MINUTES" envDefault:"15"`
+	// DisableLoginThrottling accepts unlimited failed logins, the failed logins
+	// are counted in Redis when Cache is set and in the login_attempts table otherwise
+	DisableLoginThrottling bool `env:"AUTH_DISABLE_LOGIN_THROTTLING" envDefault:"false"`
+	// PasswordlessTokenValidInMinutes is the validity of the magic links and
+	// one-time codes of the passwordless logins
+	// Default is 10 minutes
+	PasswordlessTokenValidInMinutes uint `env:"AUTH_PASSWORDLESS_TOKEN_VALID" envDefault:"10"`
+	// PasswordlessRequestLimit is the number of magic links or codes an email or
+	// mobile can ask for before waiting LoginLockoutMinutes without requests
+	// Default is 5
+	PasswordlessRequestLimit uint `env:"AUTH_PASSWORDLESS_REQUEST_LIMIT" envDefault:"5"`
+	// MagicLinkURL is the page of the client the magic links open, it gets the
+	// token in the "token" query parameter and posts it to /auth/magic-link/verify
+	// If empty, the emails contain the token instead of a link
+	MagicLinkURL string `env:"AUTH_MAGIC_LINK_URL"`
+	// InvitationValidInHours is the validity of the invitations to join an organization
+	// Default is 168 hours (7 days)
+	InvitationValidInHours uint `env:"AUTH_INVITATION_VALID" envDefault:"168"`
+	// ImpersonationValidInMinutes is the validity of the tokens of the admins
+	// impersonating users, they cannot be refreshed. Default is 30 minutes
+	ImpersonationValidInMinutes uint `env:"AUTH_IMPERSONATION_VALID" envDefault:"30"`
+	// DisableSelfRegistration removes the /auth/register route and passwordless
+	// logins of unknown emails and mobiles do not create users
+	DisableSelfRegistration bool `env:"AUTH_DISABLE_SELF_REGISTRATION" envDefault:"false"`
+	// MFAIssuer is the issuer shown by the authenticator apps for the TOTP codes
+	// Default is the JWTIssuer
+	MFAIssuer string `env:"AUTH_MFA_ISSUER"`
+	// Notifier sends the verification, password reset, welcome, unlock, invitation and passwordless login messages,
+	// see NewMailNotifier, NewTextNotifier and ChannelNotifier
+	// Default is a LogNotifier, which does not send the messages
+	Notifier Notifier
+	// AuditSink records the audit events of logins, logouts, password, MFA, API key and role changes,
+	// see NewDBAuditSink, NewLogAuditSink, NewBusAuditSink and AuditSinks
+	// Default logs the events and saves them in the auth_audit table
+	AuditSink AuditSink
 	// IgnoreRoutes are the routes that do not require authentication
 	// Default is /api/v1/auth/login
 	// This can be a comma-separated list of routes
@@ -57,10 +177,21 @@ type Options struct {
 	// Higher Role has more privileges and can access all resources of lower Role.
 	// Default roles are 0:user, 99:admin
 	UserRoles map[Role]string
+	// OrgRoles are the roles of the members within the organizations, on a
+	// scale of their own: within the current organization EnsureRole checks the
+	// role of the member for these roles only, the other roles are the roles of
+	// the user itself, so no membership grants a user role
+	// Default roles are 1:member, 10:admin
+	OrgRoles map[Role]string
 	// Default Mobile country code for new users
 	DefaultMobileCountryCode string `env:"AUTH_DEFAULT_MOBILE_COUNTRY_CODE" envDefault:"+1"`
 
-	// GoogleOauth contains the configuration for Google OAuth
+	// OAuthProviders are the identity providers users log in with on the
+	// /auth/oauth/:provider routes, see NewOIDCProvider and NewOAuth2Provider
+	OAuthProviders []OAuthProvider
+
+	// GoogleOauth contains the configuration for Google OAuth, it adds the
+	// provider of NewGoogleProvider when OAuthProviders has no "google" provider
 	GoogleOauth struct {
 		ClientID     string `env:"CLIENT_ID"`
 		ClientSecret string `env:"CLIENT_SECRET"`
@@ -84,13 +215,37 @@ func getOptions(override Options) Options {
 	}
 	if override.JwtKey != "" {
 		opts.JwtKey = override.JwtKey
-	} else {
+	}
+	if override.JWTPrivateKey != "" {
+		opts.JWTPrivateKey = override.JWTPrivateKey
+	}
+	if override.JWTPrivateKeyFile != "" {
+		opts.JWTPrivateKeyFile = override.JWTPrivateKeyFile
+	}
+	if override.JWTKeyID != "" {
+		opts.JWTKeyID = override.JWTKeyID
+	}
+	if len(override.JWTVerificationKeyFiles) > 0 {
+		opts.JWTVerificationKeyFiles = override.JWTVerificationKeyFiles
+	}
+	opts.KeySet = override.KeySet
+
+	if override.AllowEphemeralJWTKey {
+		opts.AllowEphemeralJWTKey = true
+	}
+
+	if opts.KeySet == nil && opts.JWTPrivateKey == "" && opts.JWTPrivateKeyFile == "" && opts.JwtKey == "" {
+		// instances with their own random key reject the tokens of the others
+		if !opts.AllowEphemeralJWTKey {
+			panic("auth service requires a JWT key: set AUTH_JWT_KEY, AUTH_JWT_PRIVATE_KEY or AUTH_JWT_PRIVATE_KEY_FILE, or AUTH_ALLOW_EPHEMERAL_JWT_KEY for development")
+		}
+
 		var err error
 		opts.JwtKey, err = utils.GenerateRandomString(32)
 		if err != nil {
 			panic(err)
 		}
-		opts.Logger.Info("Generated random JWT key for auth service")
+		opts.Logger.Warn("Generated random JWT key for auth service, tokens are only valid on this instance until it restarts")
 		opts.Logger.Debug("JWT key loaded", zap.Int("length", len(opts.JwtKey)))
 	}
 	if override.JWTIssuer != "" {
@@ -102,6 +257,60 @@ func getOptions(override Options) Options {
 	if override.TokenValidInHours > 0 {
 		opts.TokenValidInHours = override.TokenValidInHours
 	}
+	if override.AccessTokenValidInMinutes > 0 {
+		opts.AccessTokenValidInMinutes = override.AccessTokenValidInMinutes
+	}
+	if override.RefreshTokenValidInHours > 0 {
+		opts.RefreshTokenValidInHours = override.RefreshTokenValidInHours
+	}
+	if override.DisableRefreshTokens {
+		opts.DisableRefreshTokens = true
+	}
+	if override.LoginBackoffAfter > 0 {
+		opts.LoginBackoffAfter = override.LoginBackoffAfter
+	}
+	if override.LoginLockoutAfter > 0 {
+		opts.LoginLockoutAfter = override.LoginLockoutAfter
+	}
+	if override.LoginIPLockoutAfter > 0 {
+		opts.LoginIPLockoutAfter = override.LoginIPLockoutAfter
+	}
+	if override.LoginIPRequestLimit > 0 {
+		opts.LoginIPRequestLimit = override.LoginIPRequestLimit
+	}
+	if override.LoginLockoutMinutes > 0 {
+		opts.LoginLockoutMinutes = override.LoginLockoutMinutes
+	}
+	if override.DisableLoginThrottling {
+		opts.DisableLoginThrottling = true
+	}
+	if override.PasswordlessTokenValidInMinutes > 0 {
+		opts.PasswordlessTokenValidInMinutes = override.PasswordlessTokenValidInMinutes
+	}
+	if override.PasswordlessRequestLimit > 0 {
+		opts.PasswordlessRequestLimit = override.PasswordlessRequestLimit
+	}
+	if override.MagicLinkURL != "" {
+		opts.MagicLinkURL = override.MagicLinkURL
+	}
+	if override.InvitationValidInHours > 0 {
+		opts.InvitationValidInHours = override.InvitationValidInHours
+	}
+	if override.ImpersonationValidInMinutes > 0 {
+		opts.ImpersonationValidInMinutes = override.ImpersonationValidInMinutes
+	}
+	if override.DisableSelfRegistration {
+		opts.DisableSelfRegistration = true
+	}
+	if override.MFAIssuer != "" {
+		opts.MFAIssuer = override.MFAIssuer
+	}
+	if opts.MFAIssuer == "" {
+		opts.MFAIssuer = opts.JWTIssuer
+	}
+	if override.APIKeyPrefix != "" {
+		opts.APIKeyPrefix = override.APIKeyPrefix
+	}
 	if override.DefaultMobileCountryCode != "" {
 		opts.DefaultMobileCountryCode = override.DefaultMobileCountryCode
 	}
@@ -110,24 +319,122 @@ func getOptions(override Options) Options {
 		opts.GoogleOauth.ClientSecret = override.GoogleOauth.ClientSecret
 	}
 
+	opts.Notifier = override.Notifier
+	if opts.Notifier == nil {
+		opts.Logger.Warn("No notifier set for auth service, verification and reset messages are only logged")
+		opts.Notifier = NewLogNotifier(opts.Logger.Named("notifier"))
+	}
+
+	opts.AuditSink = override.AuditSink
+	opts.OAuthProviders = override.OAuthProviders
 	opts.IgnoreRoutes = override.IgnoreRoutes
 	opts.UserRoles = override.UserRoles
+	opts.OrgRoles = override.OrgRoles
 
 	return opts
 }
 
+// readKeyFile returns the key of the PEM file
+func readKeyFile(path string) (any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return web.ParseKeyPEM(data)
+}
+
+// getKeySet returns the key set of the options, the private key signs the
+// tokens if set, otherwise the HS256 JwtKey
+func getKeySet(opts Options) (*web.KeySet, error) {
+	if opts.KeySet != nil {
+		return opts.KeySet, nil
+	}
+
+	var private any = []byte(opts.JwtKey)
+	var err error
+	if opts.JWTPrivateKey != "" {
+		private, err = web.ParseKeyPEM([]byte(opts.JWTPrivateKey))
+	} else if opts.JWTPrivateKeyFile != "" {
+		private, err = readKeyFile(opts.JWTPrivateKeyFile)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not load JWT private key: %w", err)
+	}
+
+	signing, err := web.NewKey(opts.JWTKeyID, private)
+	if err != nil {
+		return nil, fmt.Errorf("could not load JWT private key: %w", err)
+	}
+
+	verification := []*web.Key{}
+	for _, path := range opts.JWTVerificationKeyFiles {
+		key, err := readKeyFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not load JWT verification key %s: %w", path, err)
+		}
+		k, err := web.NewKey("", key)
+		if err != nil {
+			return nil, fmt.Errorf("could not load JWT verification key %s: %w", path, err)
+		}
+		verification = append(verification, k)
+	}
+
+	return web.NewKeySet(signing, verification...), nil
+}
+
+// getLoginThrottle returns the login throttle of the options, counting the
+// failed logins in Redis if set and in the database otherwise
+func getLoginThrottle(opts Options) *loginThrottle {
+	lockout := time.Duration(opts.LoginLockoutMinutes) * time.Minute
+
+	var store attemptStore = &gormAttemptStore{db: opts.DB, ttl: lockout}
+	if opts.Cache != nil {
+		store = &redisAttemptStore{client: opts.Cache, ttl: lockout}
+	}
+
+	return &loginThrottle{
+		store:          store,
+		backoffAfter:   int(opts.LoginBackoffAfter),
+		lockoutAfter:   int(opts.LoginLockoutAfter),
+		ipLockoutAfter: int(opts.LoginIPLockoutAfter),
+		ipRequestLimit: int(opts.LoginIPRequestLimit),
+		requestLimit:   int(opts.PasswordlessRequestLimit),
+		lockout:        lockout,
+	}
+}
+
 func New(override Options) *Service {
 	opts := getOptions(override)
 
+	keys, err := getKeySet(opts)
+	if err != nil {
+		panic(err)
+	}
+
 	s := &Service{
 		db:           opts.DB,
 		cache:        opts.Cache,
 		l:            opts.Logger,
 		ignoreRoutes: opts.IgnoreRoutes,
-		jwtKey:       opts.JwtKey,
+		keys:         keys,
 		jwtIssuer:    opts.JWTIssuer,
 		jwtAudience:  opts.JWTAudience,
 		tokenValid:   time.Duration(opts.TokenValidInHours) * time.Hour,
+		apiKeyPrefix: opts.APIKeyPrefix,
+		mfaIssuer:    opts.MFAIssuer,
+		notifier:     opts.Notifier,
+		now:          time.Now,
+	}
+
+	if !opts.DisableRefreshTokens {
+		s.tokenValid = time.Duration(opts.AccessTokenValidInMinutes) * time.Minute
+		s.refreshTokenValid = time.Duration(opts.RefreshTokenValidInHours) * time.Hour
+	}
+
+	s.passwordlessThrottle = getLoginThrottle(opts)
+	if !opts.DisableLoginThrottling {
+		s.throttle = s.passwordlessThrottle
 	}
 
 	if len(opts.UserRoles) == 0 {
@@ -136,6 +443,15 @@ func New(override Options) *Service {
 			99: "admin",
 		}
 	}
+	s.userRoles = opts.UserRoles
+
+	if len(opts.OrgRoles) == 0 {
+		opts.OrgRoles = map[Role]string{
+			1:  "member",
+			10: "admin",
+		}
+	}
+	s.orgRoles = opts.OrgRoles
 
 	if opts.GoogleOauth.ClientID != "" && opts.GoogleOauth.ClientSecret != "" {
 		s.GoogleOauthConfig = oauth2.Config{
@@ -150,10 +466,32 @@ func New(override Options) *Service {
 	}
 
 	s.defaultMobileCountryCode = opts.DefaultMobileCountryCode
+	s.passwordlessTokenValid = time.Duration(opts.PasswordlessTokenValidInMinutes) * time.Minute
+	s.magicLinkURL = opts.MagicLinkURL
+	s.selfRegistration = !opts.DisableSelfRegistration
+	s.invitationValid = time.Duration(opts.InvitationValidInHours) * time.Hour
+	s.impersonationValid = time.Duration(opts.ImpersonationValidInMinutes) * time.Minute
+	s.auditSink = getAuditSink(opts)
+
+	s.oauthProviders, err = getOAuthProviders(opts)
+	if err != nil {
+		panic(err)
+	}
 
 	return s
 }
 
+// KeySet returns the keys signing and verifying the JWT tokens
+func (s *Service) KeySet() *web.KeySet {
+	return s.keys
+}
+
+// RotateSigningKey signs the new JWT tokens with the key, the tokens signed with
+// the previous key stay valid until they expire
+func (s *Service) RotateSigningKey(key *web.Key) {
+	s.keys.Rotate(key, s.tokenValid)
+}
+
 // RoleName returns the name of the role for the given Role
 func (s *Service) RoleName(role Role) string {
 	return s.userRoles[role]
@@ -186,3 +524,47 @@ func (s *Service) FormatMobileNumber(mobile string) string {
 func (s *Service) GetUserRoles() map[Role]string {
 	return s.userRoles
 }
+
+// GetOrgRoles returns the roles of the members within the organizations
+func (s *Service) GetOrgRoles() map[Role]string {
+	return s.orgRoles
+}
+
+// WithContext returns a copy of the service that runs its database and cache
+// queries with the context, so they are canceled with it. The handlers get a
+// copy bound to the request context from handle and typed.
+func (s *Service) WithContext(ctx context.Context) *Service {
+	c := *s
+	c.ctx = ctx
+	if s.db != nil {
+		c.db = s.db.WithContext(ctx)
+	}
+
+	return &c
+}
+
+// handle returns a handler calling fn with a copy of the service bound to the
+// request context, see WithContext. The handlers of the service are built with
+// it or with typed, they never bind the context themselves
+func handle(s *Service, fn func(s *Service, r web.Request) (any, error)) web.Handler {
+	return func(r web.Request) (any, error) {
+		return fn(s.WithContext(r.GetContext()), r)
+	}
+}
+
+// typed returns a web.Typed handler calling fn with a copy of the service
+// bound to the request context
+func typed[In, Out any](s *Service, fn func(s *Service, ctx localcontext.Context, in In) (Out, error)) web.Handler {
+	return web.Typed(func(ctx localcontext.Context, in In) (Out, error) {
+		return fn(s.WithContext(ctx), ctx, in)
+	})
+}
+
+// getContext returns the context of the database and cache queries
+func (s *Service) getContext() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+
+	return s.ctx
+}
@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	localcontext "github.com/unluckythoughts/go-microservice/v2/tools/context"
+	"github.com/unluckythoughts/go-microservice/v2/tools/web"
+	"go.uber.org/zap"
+)
+
+func writeKeyFile(t *testing.T, key any) string {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "key.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+	return path
+}
+
+func TestTokensSignedWithPrivateKey(t *testing.T) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	s := New(Options{Logger: zap.NewNop(), JWTPrivateKeyFile: writeKeyFile(t, private), JWTKeyID: "key-1"})
+	token, err := s.createAccessToken(5, "", 0)
+	require.NoError(t, err)
+
+	claims, err := s.getUserDataFromAuthHeader("Bearer " + token)
+	require.NoError(t, err)
+	assert.Equal(t, uint(5), claims.userID)
+
+	// other services verify the tokens with the published keys
+	data, err := json.Marshal(s.KeySet().JWKS())
+	require.NoError(t, err)
+	remote, err := web.ParseJWKS(data)
+	require.NoError(t, err)
+	parsed, err := remote.Parse(token)
+	require.NoError(t, err)
+	assert.Equal(t, "key-1", parsed.Header["kid"])
+	assert.Equal(t, web.AlgES256, parsed.Method.Alg())
+}
+
+func TestRotateSigningKey(t *testing.T) {
+	s := New(Options{Logger: zap.NewNop(), JwtKey: "test-key"})
+	oldToken, err := s.createAccessToken(5, "", 0)
+	require.NoError(t, err)
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key, err := web.NewKey("", private)
+	require.NoError(t, err)
+	s.RotateSigningKey(key)
+
+	newToken, err := s.createAccessToken(6, "", 0)
+	require.NoError(t, err)
+
+	claims, err := s.getUserDataFromAuthHeader("Bearer " + newToken)
+	require.NoError(t, err)
+	assert.Equal(t, uint(6), claims.userID)
+
+	claims, err = s.getUserDataFromAuthHeader("Bearer " + oldToken)
+	require.NoError(t, err, "tokens of the previous key stay valid")
+	assert.Equal(t, uint(5), claims.userID)
+}
+
+func TestInvalidPrivateKey(t *testing.T) {
+	assert.Panics(t, func() {
+		New(Options{Logger: zap.NewNop(), JWTPrivateKey: "not a key"})
+	})
+}
+
+func TestMissingJWTKey(t *testing.T) {
+	t.Setenv("AUTH_JWT_KEY", "")
+	assert.Panics(t, func() {
+		New(Options{Logger: zap.NewNop()})
+	}, "instances must share a configured key")
+
+	s := New(Options{Logger: zap.NewNop(), AllowEphemeralJWTKey: true})
+	token, err := s.createAccessToken(5, "", 0)
+	require.NoError(t, err)
+	claims, err := s.getUserDataFromAuthHeader("Bearer " + token)
+	require.NoError(t, err)
+	assert.Equal(t, uint(5), claims.userID)
+}
+
+func TestHandlersBindRequestContext(t *testing.T) {
+	s := New(Options{Logger: zap.NewNop(), AllowEphemeralJWTKey: true})
+	r := contextRequest{ctx: localcontext.NewContext(zap.NewNop())}
+
+	_, err := handle(s, func(bound *Service, _ web.Request) (any, error) {
+		assert.Equal(t, r.ctx, bound.getContext(), "the handler runs with the request context")
+		return nil, nil
+	})(r)
+	require.NoError(t, err)
+	assert.Equal(t, context.Background(), s.getContext(), "the service itself is not bound")
+}
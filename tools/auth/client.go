@@ -1,7 +1,12 @@
 package auth
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	neturl "net/url"
+	"strconv"
+	"time"
 
 	"github.com/unluckythoughts/go-microservice/v2/tools/web"
 )
@@ -31,6 +36,17 @@ type client struct {
 type ClientWithAuth interface {
 	web.Client
 	Login(req Credentials) (LoginResponse, int, error)
+	Refresh(req RefreshRequest) (LoginResponse, int, error)
+	VerifyMFA(req MFAVerifyRequest) (LoginResponse, int, error)
+	Unlock(req UnlockRequest) (string, int, error)
+	MagicLink(req MagicLinkRequest) (PasswordlessResponse, int, error)
+	VerifyMagicLink(req VerifyMagicLinkRequest) (LoginResponse, int, error)
+	OTP(req OTPRequest) (PasswordlessResponse, int, error)
+	VerifyOTP(req VerifyOTPRequest) (LoginResponse, int, error)
+	EnrollMFA() (MFAEnrollment, int, error)
+	ConfirmMFA(req MFACodeRequest) (MFARecoveryCodes, int, error)
+	DisableMFA(req MFACodeRequest) (string, int, error)
+	RegenerateRecoveryCodes(req MFACodeRequest) (MFARecoveryCodes, int, error)
 	Register(req RegisterRequest) (string, int, error)
 	Logout() (string, int, error)
 	ResetPassword(target, targetType string) (string, int, error)
@@ -39,6 +55,40 @@ type ClientWithAuth interface {
 	VerifyToken(target, token string) (bool, int, error)
 	GetUser() (LoginResponse, int, error)
 	UpdateUser(req UpdateUserRequest) (string, int, error)
+	ConfirmContactChange(token string) (string, int, error)
+	CreateAPIKey(req CreateAPIKeyRequest) (CreateAPIKeyResponse, int, error)
+	ListAPIKeys() ([]APIKey, int, error)
+	RevokeAPIKey(id uint) (string, int, error)
+	StartOAuth(provider, redirectURI string) (OAuthStartResponse, int, error)
+	OAuthCallback(req OAuthCallbackRequest) (LoginResponse, int, error)
+	ListIdentities() ([]UserIdentity, int, error)
+	UnlinkIdentity(id uint) (string, int, error)
+	LogoutEverywhere() (string, int, error)
+	ListSessions() ([]UserSession, int, error)
+	RevokeSession(id uint) (string, int, error)
+	RevokeOtherSessions() (string, int, error)
+	ListUsers(q UserQuery) (UserPage, int, error)
+	GetUserByID(id uint) (User, int, error)
+	SetUserRole(id uint, role Role) (string, int, error)
+	SuspendUser(id uint) (string, int, error)
+	UnsuspendUser(id uint) (string, int, error)
+	ForcePasswordReset(id uint) (string, int, error)
+	DeleteUser(id uint, hard bool) (string, int, error)
+	ListOrgs() ([]OrgMembership, int, error)
+	CreateOrg(name string) (Organization, int, error)
+	SwitchOrg(orgID uint) (LoginResponse, int, error)
+	AcceptInvitation(token string) (OrgMembership, int, error)
+	GetCurrentOrg() (OrgMembership, int, error)
+	RenameOrg(name string) (Organization, int, error)
+	DeleteOrg() (string, int, error)
+	LeaveOrg() (string, int, error)
+	ListOrgMembers() ([]OrgMemberInfo, int, error)
+	SetOrgMemberRole(userID uint, role Role) (string, int, error)
+	RemoveOrgMember(userID uint) (string, int, error)
+	ListOrgInvitations() ([]OrgInvitation, int, error)
+	InviteOrgMember(req InviteRequest) (OrgInvitation, int, error)
+	RevokeOrgInvitation(id uint) (string, int, error)
+	Impersonate(userID, orgID uint) (ImpersonationResponse, int, error)
 }
 
 func NewClientWithAuth(baseURL string, defaultHeaders ...http.Header) ClientWithAuth {
@@ -57,6 +107,72 @@ func (cl *client) Login(req Credentials) (LoginResponse, int, error) {
 	return extractData[LoginResponse](base, status, err)
 }
 
+func (cl *client) Refresh(req RefreshRequest) (LoginResponse, int, error) {
+	var base web.HTTPResponse
+	status, err := cl.c.PostResponse("/auth/refresh", req, &base)
+	return extractData[LoginResponse](base, status, err)
+}
+
+func (cl *client) VerifyMFA(req MFAVerifyRequest) (LoginResponse, int, error) {
+	var base web.HTTPResponse
+	status, err := cl.c.PostResponse("/auth/mfa/verify", req, &base)
+	return extractData[LoginResponse](base, status, err)
+}
+
+func (cl *client) Unlock(req UnlockRequest) (string, int, error) {
+	var base web.HTTPResponse
+	status, err := cl.c.PostResponse("/auth/unlock", req, &base)
+	return extractData[string](base, status, err)
+}
+
+func (cl *client) MagicLink(req MagicLinkRequest) (PasswordlessResponse, int, error) {
+	var base web.HTTPResponse
+	status, err := cl.c.PostResponse("/auth/magic-link", req, &base)
+	return extractData[PasswordlessResponse](base, status, err)
+}
+
+func (cl *client) VerifyMagicLink(req VerifyMagicLinkRequest) (LoginResponse, int, error) {
+	var base web.HTTPResponse
+	status, err := cl.c.PostResponse("/auth/magic-link/verify", req, &base)
+	return extractData[LoginResponse](base, status, err)
+}
+
+func (cl *client) OTP(req OTPRequest) (PasswordlessResponse, int, error) {
+	var base web.HTTPResponse
+	status, err := cl.c.PostResponse("/auth/otp", req, &base)
+	return extractData[PasswordlessResponse](base, status, err)
+}
+
+func (cl *client) VerifyOTP(req VerifyOTPRequest) (LoginResponse, int, error) {
+	var base web.HTTPResponse
+	status, err := cl.c.PostResponse("/auth/otp/verify", req, &base)
+	return extractData[LoginResponse](base, status, err)
+}
+
+func (cl *client) EnrollMFA() (MFAEnrollment, int, error) {
+	var base web.HTTPResponse
+	status, err := cl.c.PostResponse("/auth/mfa/enroll", nil, &base)
+	return extractData[MFAEnrollment](base, status, err)
+}
+
+func (cl *client) ConfirmMFA(req MFACodeRequest) (MFARecoveryCodes, int, error) {
+	var base web.HTTPResponse
+	status, err := cl.c.PostResponse("/auth/mfa/confirm", req, &base)
+	return extractData[MFARecoveryCodes](base, status, err)
+}
+
+func (cl *client) DisableMFA(req MFACodeRequest) (string, int, error) {
+	var base web.HTTPResponse
+	status, err := cl.c.PostResponse("/auth/mfa/disable", req, &base)
+	return extractData[string](base, status, err)
+}
+
+func (cl *client) RegenerateRecoveryCodes(req MFACodeRequest) (MFARecoveryCodes, int, error) {
+	var base web.HTTPResponse
+	status, err := cl.c.PostResponse("/auth/mfa/recovery-codes", req, &base)
+	return extractData[MFARecoveryCodes](base, status, err)
+}
+
 func (cl *client) Register(req RegisterRequest) (string, int, error) {
 	var base web.HTTPResponse
 	status, err := cl.c.PostResponse("/auth/register", req, &base)
@@ -107,7 +223,38 @@ func (cl *client) UpdateUser(req UpdateUserRequest) (string, int, error) {
 	return extractData[string](base, status, err)
 }
 
+func (cl *client) ConfirmContactChange(token string) (string, int, error) {
+	var base web.HTTPResponse
+	status, err := cl.c.PostResponse("/auth/user/confirm-change", ConfirmChangeRequest{Token: token}, &base)
+	return extractData[string](base, status, err)
+}
+
+func (cl *client) CreateAPIKey(req CreateAPIKeyRequest) (CreateAPIKeyResponse, int, error) {
+	var base web.HTTPResponse
+	status, err := cl.c.PostResponse("/auth/api-keys", req, &base)
+	return extractData[CreateAPIKeyResponse](base, status, err)
+}
+
+func (cl *client) ListAPIKeys() ([]APIKey, int, error) {
+	var base web.HTTPResponse
+	status, err := cl.c.GetResponse("/auth/api-keys", &base)
+	return extractData[[]APIKey](base, status, err)
+}
+
+func (cl *client) RevokeAPIKey(id uint) (string, int, error) {
+	url := fmt.Sprintf("/auth/api-keys/%d", id)
+	var base web.HTTPResponse
+	status, err := cl.c.DeleteResponse(url, nil, &base)
+	return extractData[string](base, status, err)
+}
+
 // Helper methods to satisfy the web.Client interface for the embedded client
+
+// WithContext returns a client with the auth methods sending its requests with the context
+func (cl *client) WithContext(ctx context.Context) web.Client {
+	return &client{c: cl.c.WithContext(ctx)}
+}
+
 func (cl *client) SetBearerToken(token string) {
 	cl.c.SetBearerToken(token)
 }
@@ -143,3 +290,238 @@ func (cl *client) DeleteResponse(path string, body any, response any, headers ..
 func (cl *client) Send(method, path string, body []byte, resp any, headers ...http.Header) (int, error) {
 	return cl.c.Send(method, path, body, resp, headers...)
 }
+
+func (cl *client) StartOAuth(provider, redirectURI string) (OAuthStartResponse, int, error) {
+	url := "/auth/oauth/" + provider + "?redirect_uri=" + neturl.QueryEscape(redirectURI)
+	var base web.HTTPResponse
+	status, err := cl.c.GetResponse(url, &base)
+	return extractData[OAuthStartResponse](base, status, err)
+}
+
+func (cl *client) OAuthCallback(req OAuthCallbackRequest) (LoginResponse, int, error) {
+	var base web.HTTPResponse
+	status, err := cl.c.PostResponse("/auth/oauth/"+req.Provider+"/callback", req, &base)
+	return extractData[LoginResponse](base, status, err)
+}
+
+func (cl *client) ListIdentities() ([]UserIdentity, int, error) {
+	var base web.HTTPResponse
+	status, err := cl.c.GetResponse("/auth/identities", &base)
+	return extractData[[]UserIdentity](base, status, err)
+}
+
+func (cl *client) UnlinkIdentity(id uint) (string, int, error) {
+	url := fmt.Sprintf("/auth/identities/%d", id)
+	var base web.HTTPResponse
+	status, err := cl.c.DeleteResponse(url, nil, &base)
+	return extractData[string](base, status, err)
+}
+
+func (cl *client) LogoutEverywhere() (string, int, error) {
+	var base web.HTTPResponse
+	status, err := cl.c.PostResponse("/auth/logout/everywhere", nil, &base)
+	return extractData[string](base, status, err)
+}
+
+func (cl *client) ListSessions() ([]UserSession, int, error) {
+	var base web.HTTPResponse
+	status, err := cl.c.GetResponse("/auth/sessions", &base)
+	return extractData[[]UserSession](base, status, err)
+}
+
+func (cl *client) RevokeSession(id uint) (string, int, error) {
+	url := fmt.Sprintf("/auth/sessions/%d", id)
+	var base web.HTTPResponse
+	status, err := cl.c.DeleteResponse(url, nil, &base)
+	return extractData[string](base, status, err)
+}
+
+func (cl *client) RevokeOtherSessions() (string, int, error) {
+	var base web.HTTPResponse
+	status, err := cl.c.DeleteResponse("/auth/sessions", nil, &base)
+	return extractData[string](base, status, err)
+}
+
+// userQueryValues returns the query parameters of the user query
+func userQueryValues(q UserQuery) neturl.Values {
+	v := neturl.Values{}
+	set := func(key, value string) {
+		if value != "" {
+			v.Set(key, value)
+		}
+	}
+
+	set("search", q.Search)
+	set("email", q.Email)
+	if q.Role != 0 {
+		v.Set("role", strconv.Itoa(int(q.Role)))
+	}
+	if q.Verified != nil {
+		v.Set("verified", strconv.FormatBool(*q.Verified))
+	}
+	if q.Disabled != nil {
+		v.Set("disabled", strconv.FormatBool(*q.Disabled))
+	}
+	if !q.CreatedFrom.IsZero() {
+		v.Set("created_from", q.CreatedFrom.Format(time.RFC3339Nano))
+	}
+	if !q.CreatedTo.IsZero() {
+		v.Set("created_to", q.CreatedTo.Format(time.RFC3339Nano))
+	}
+	set("sort", q.Sort)
+	set("order", q.Order)
+	if q.Page > 0 {
+		v.Set("page", strconv.Itoa(q.Page))
+	}
+	if q.PageSize > 0 {
+		v.Set("page_size", strconv.Itoa(q.PageSize))
+	}
+
+	return v
+}
+
+func (cl *client) ListUsers(q UserQuery) (UserPage, int, error) {
+	url := "/admin/users"
+	if query := userQueryValues(q).Encode(); query != "" {
+		url += "?" + query
+	}
+	var base web.HTTPResponse
+	status, err := cl.c.GetResponse(url, &base)
+	return extractData[UserPage](base, status, err)
+}
+
+func (cl *client) GetUserByID(id uint) (User, int, error) {
+	url := fmt.Sprintf("/admin/users/%d", id)
+	var base web.HTTPResponse
+	status, err := cl.c.GetResponse(url, &base)
+	return extractData[User](base, status, err)
+}
+
+func (cl *client) SetUserRole(id uint, role Role) (string, int, error) {
+	url := fmt.Sprintf("/admin/users/%d/role", id)
+	var base web.HTTPResponse
+	status, err := cl.c.PutResponse(url, UserRoleRequest{Role: role}, &base)
+	return extractData[string](base, status, err)
+}
+
+func (cl *client) SuspendUser(id uint) (string, int, error) {
+	url := fmt.Sprintf("/admin/users/%d/suspend", id)
+	var base web.HTTPResponse
+	status, err := cl.c.PostResponse(url, nil, &base)
+	return extractData[string](base, status, err)
+}
+
+func (cl *client) UnsuspendUser(id uint) (string, int, error) {
+	url := fmt.Sprintf("/admin/users/%d/unsuspend", id)
+	var base web.HTTPResponse
+	status, err := cl.c.PostResponse(url, nil, &base)
+	return extractData[string](base, status, err)
+}
+
+func (cl *client) ForcePasswordReset(id uint) (string, int, error) {
+	url := fmt.Sprintf("/admin/users/%d/reset-password", id)
+	var base web.HTTPResponse
+	status, err := cl.c.PostResponse(url, nil, &base)
+	return extractData[string](base, status, err)
+}
+
+func (cl *client) DeleteUser(id uint, hard bool) (string, int, error) {
+	url := fmt.Sprintf("/admin/users/%d?hard=%t", id, hard)
+	var base web.HTTPResponse
+	status, err := cl.c.DeleteResponse(url, nil, &base)
+	return extractData[string](base, status, err)
+}
+
+func (cl *client) ListOrgs() ([]OrgMembership, int, error) {
+	var base web.HTTPResponse
+	status, err := cl.c.GetResponse("/auth/orgs", &base)
+	return extractData[[]OrgMembership](base, status, err)
+}
+
+func (cl *client) CreateOrg(name string) (Organization, int, error) {
+	var base web.HTTPResponse
+	status, err := cl.c.PostResponse("/auth/orgs", OrgRequest{Name: name}, &base)
+	return extractData[Organization](base, status, err)
+}
+
+func (cl *client) SwitchOrg(orgID uint) (LoginResponse, int, error) {
+	var base web.HTTPResponse
+	status, err := cl.c.PostResponse("/auth/orgs/switch", SwitchOrgRequest{OrgID: orgID}, &base)
+	return extractData[LoginResponse](base, status, err)
+}
+
+func (cl *client) AcceptInvitation(token string) (OrgMembership, int, error) {
+	var base web.HTTPResponse
+	status, err := cl.c.PostResponse("/auth/orgs/invitations/accept", AcceptInvitationRequest{Token: token}, &base)
+	return extractData[OrgMembership](base, status, err)
+}
+
+func (cl *client) GetCurrentOrg() (OrgMembership, int, error) {
+	var base web.HTTPResponse
+	status, err := cl.c.GetResponse("/auth/org", &base)
+	return extractData[OrgMembership](base, status, err)
+}
+
+func (cl *client) RenameOrg(name string) (Organization, int, error) {
+	var base web.HTTPResponse
+	status, err := cl.c.PutResponse("/auth/org", OrgRequest{Name: name}, &base)
+	return extractData[Organization](base, status, err)
+}
+
+func (cl *client) DeleteOrg() (string, int, error) {
+	var base web.HTTPResponse
+	status, err := cl.c.DeleteResponse("/auth/org", nil, &base)
+	return extractData[string](base, status, err)
+}
+
+func (cl *client) LeaveOrg() (string, int, error) {
+	var base web.HTTPResponse
+	status, err := cl.c.PostResponse("/auth/org/leave", nil, &base)
+	return extractData[string](base, status, err)
+}
+
+func (cl *client) ListOrgMembers() ([]OrgMemberInfo, int, error) {
+	var base web.HTTPResponse
+	status, err := cl.c.GetResponse("/auth/org/members", &base)
+	return extractData[[]OrgMemberInfo](base, status, err)
+}
+
+func (cl *client) SetOrgMemberRole(userID uint, role Role) (string, int, error) {
+	url := fmt.Sprintf("/auth/org/members/%d", userID)
+	var base web.HTTPResponse
+	status, err := cl.c.PutResponse(url, OrgMemberRoleRequest{Role: role}, &base)
+	return extractData[string](base, status, err)
+}
+
+func (cl *client) RemoveOrgMember(userID uint) (string, int, error) {
+	url := fmt.Sprintf("/auth/org/members/%d", userID)
+	var base web.HTTPResponse
+	status, err := cl.c.DeleteResponse(url, nil, &base)
+	return extractData[string](base, status, err)
+}
+
+func (cl *client) ListOrgInvitations() ([]OrgInvitation, int, error) {
+	var base web.HTTPResponse
+	status, err := cl.c.GetResponse("/auth/org/invitations", &base)
+	return extractData[[]OrgInvitation](base, status, err)
+}
+
+func (cl *client) InviteOrgMember(req InviteRequest) (OrgInvitation, int, error) {
+	var base web.HTTPResponse
+	status, err := cl.c.PostResponse("/auth/org/invitations", req, &base)
+	return extractData[OrgInvitation](base, status, err)
+}
+
+func (cl *client) RevokeOrgInvitation(id uint) (string, int, error) {
+	url := fmt.Sprintf("/auth/org/invitations/%d", id)
+	var base web.HTTPResponse
+	status, err := cl.c.DeleteResponse(url, nil, &base)
+	return extractData[string](base, status, err)
+}
+
+func (cl *client) Impersonate(userID, orgID uint) (ImpersonationResponse, int, error) {
+	url := fmt.Sprintf("/auth/impersonate/%d", userID)
+	var base web.HTTPResponse
+	status, err := cl.c.PostResponse(url, ImpersonateRequest{OrgID: orgID}, &base)
+	return extractData[ImpersonationResponse](base, status, err)
+}
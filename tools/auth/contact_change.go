@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"strings"
+
+	"github.com/unluckythoughts/go-microservice/v2/utils"
+)
+
+// contactChange is a change of the email or mobile of a user
+type contactChange struct {
+	channel string
+	from    string
+	to      string
+}
+
+// getContactChanges returns the changes of the email and mobile of the user,
+// empty values and the current values are not changes
+func getContactChanges(user *User, email, mobile string) ([]contactChange, error) {
+	changes := []contactChange{}
+
+	email = strings.TrimSpace(email)
+	if email != "" && email != user.Email {
+		changes = append(changes, contactChange{channel: ChannelEmail, from: user.Email, to: email})
+	}
+
+	if mobile != "" {
+		var m Mobile
+		if err := m.Set(mobile); err != nil {
+			return nil, ErrInvalidMobile.WithCause(err)
+		}
+		if m.String() != user.Mobile.String() {
+			changes = append(changes, contactChange{channel: ChannelMobile, from: user.Mobile.String(), to: m.String()})
+		}
+	}
+
+	return changes, nil
+}
+
+// RequestContactChange sends a change token to the new email and mobile of the
+// user and notifies its current ones. The user keeps its current email and
+// mobile until the change is confirmed with ConfirmContactChange. It returns
+// the channels pending confirmation
+func (s *Service) RequestContactChange(user *User, email, mobile string) ([]string, error) {
+	changes, err := getContactChanges(user, email, mobile)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, change := range changes {
+		if err := s.checkContactFree(change.channel, change.to); err != nil {
+			return nil, err
+		}
+	}
+
+	channels := []string{}
+	for _, change := range changes {
+		token, err := s.createVerifyToken(change.to, changePurpose, user.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		msg := Message{Kind: MessageChange, Channel: change.channel, To: change.to, Name: user.Name, Token: token}
+		if err := s.notify(msg); err != nil {
+			return nil, err
+		}
+
+		// the notice is only a warning, failures do not fail the change
+		if change.from != "" {
+			_ = s.notify(Message{Kind: MessageChangeNotice, Channel: change.channel, To: change.from, Name: user.Name})
+		}
+
+		channels = append(channels, change.channel)
+	}
+
+	return channels, nil
+}
+
+// ConfirmContactChange replaces the email or mobile of the user with the one
+// the change token was sent to, the new value is verified. It returns the
+// channel of the change
+func (s *Service) ConfirmContactChange(userID uint, token string) (string, error) {
+	v, err := s.getVerification(token, changePurpose)
+	if err != nil {
+		return "", err
+	} else if v.UserID != userID {
+		return "", ErrInvalidVerifyToken
+	}
+
+	if err := s.useVerification(v); err != nil {
+		return "", err
+	}
+
+	channel, column, verified := ChannelMobile, "mobile", "mobile_verified"
+	if utils.IsEmail(v.Target) {
+		channel, column, verified = ChannelEmail, "email", "email_verified"
+	}
+
+	// the email or mobile may have been taken since the change was asked for
+	if err := s.checkContactFree(channel, v.Target); err != nil {
+		return "", err
+	}
+
+	result := s.db.Model(&User{}).Where("id = ?", userID).
+		Updates(map[string]any{column: v.Target, verified: true})
+	if result.Error != nil {
+		return "", result.Error
+	} else if result.RowsAffected == 0 {
+		return "", ErrUserNotFound
+	}
+
+	return channel, nil
+}
+
+// checkContactFree returns ErrUserExists when a user has the email or mobile
+func (s *Service) checkContactFree(channel, target string) error {
+	email, mobile := target, ""
+	if channel == ChannelMobile {
+		email, mobile = "", target
+	}
+
+	exists, err := s.userExists(email, mobile)
+	if err != nil {
+		return err
+	} else if exists {
+		return ErrUserExists
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContactChange(t *testing.T) {
+	s, clock, user := newMFATestService(t)
+	notifier := &recordingNotifier{}
+	s.notifier = notifier
+	require.NoError(t, s.db.Model(user).Update("email_verified", true).Error)
+
+	channels, err := s.RequestContactChange(user, "new@example.com", "+1 (555) 010-0100")
+	require.NoError(t, err)
+	assert.Equal(t, []string{ChannelEmail, ChannelMobile}, channels)
+
+	pending, err := s.GetUserByID(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "user@example.com", pending.Email, "the email is pending until confirmed")
+	assert.True(t, pending.EmailVerified)
+	assert.Empty(t, pending.Mobile.String())
+
+	require.Len(t, notifier.messages, 3)
+	emailChange, notice, mobileChange := notifier.messages[0], notifier.messages[1], notifier.messages[2]
+	assert.Equal(t, MessageChange, emailChange.Kind)
+	assert.Equal(t, "new@example.com", emailChange.To)
+	assert.Equal(t, MessageChangeNotice, notice.Kind)
+	assert.Equal(t, "user@example.com", notice.To)
+	assert.Empty(t, notice.Token)
+	assert.Equal(t, MessageChange, mobileChange.Kind)
+	assert.Equal(t, "15550100100", mobileChange.To, "users without a mobile get no notice")
+
+	_, err = s.ConfirmContactChange(user.ID+1, emailChange.Token)
+	assert.ErrorIs(t, err, ErrInvalidVerifyToken, "only the user asking for the change can confirm it")
+	_, err = s.ConfirmContactChange(user.ID, "wrong")
+	assert.ErrorIs(t, err, ErrInvalidVerifyToken)
+
+	channel, err := s.ConfirmContactChange(user.ID, emailChange.Token)
+	require.NoError(t, err)
+	assert.Equal(t, ChannelEmail, channel)
+	_, err = s.ConfirmContactChange(user.ID, emailChange.Token)
+	assert.ErrorIs(t, err, ErrInvalidVerifyToken, "change tokens can only be used once")
+
+	changed, err := s.GetUserByID(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "new@example.com", changed.Email)
+	assert.True(t, changed.EmailVerified)
+	assert.Empty(t, changed.Mobile.String())
+
+	clock.add(verifyTokenValid + time.Second)
+	_, err = s.ConfirmContactChange(user.ID, mobileChange.Token)
+	assert.ErrorIs(t, err, ErrExpiredToken)
+}
+
+func TestContactChangeTaken(t *testing.T) {
+	s, _, user := newMFATestService(t)
+	notifier := &recordingNotifier{}
+	s.notifier = notifier
+
+	other := &User{Name: "other", Email: "other@example.com", Password: "Password@123", Role: 1}
+	require.NoError(t, s.CreateUser(other))
+
+	_, err := s.RequestContactChange(user, "other@example.com", "")
+	assert.ErrorIs(t, err, ErrUserExists)
+	assert.Empty(t, notifier.messages)
+
+	_, err = s.RequestContactChange(user, "", "12")
+	assert.ErrorIs(t, err, ErrInvalidMobile)
+
+	channels, err := s.RequestContactChange(user, user.Email, "")
+	require.NoError(t, err)
+	assert.Empty(t, channels, "the current email is not a change")
+
+	channels, err = s.RequestContactChange(user, "taken@example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{ChannelEmail}, channels)
+	token := notifier.messages[0].Token
+
+	// the email is taken before the change is confirmed
+	require.NoError(t, s.db.Model(other).Update("email", "taken@example.com").Error)
+	_, err = s.ConfirmContactChange(user.ID, token)
+	assert.ErrorIs(t, err, ErrUserExists)
+}
+
+func TestVerifyTokenMarksUserVerified(t *testing.T) {
+	s, _, user := newMFATestService(t)
+
+	token, err := s.CreateVerifyToken(user.Email)
+	require.NoError(t, err)
+
+	_, err = s.VerifyToken(user.Email, token)
+	require.NoError(t, err)
+	assert.True(t, s.IsVerified(user.Email))
+
+	verified, err := s.GetUserByID(user.ID)
+	require.NoError(t, err)
+	assert.True(t, verified.EmailVerified)
+	assert.False(t, verified.MobileVerified, "the status is tracked per email and mobile")
+
+	// tokens of the other purposes do not verify the email
+	reset, err := s.createVerifyToken(user.Email, resetPurpose, 0)
+	require.NoError(t, err)
+	_, err = s.VerifyToken(user.Email, reset)
+	assert.Error(t, err)
+}
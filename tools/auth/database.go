@@ -2,54 +2,81 @@ package auth
 
 import (
 	"errors"
-	"net/http"
 	"time"
 
-	"github.com/unluckythoughts/go-microservice/v2/tools/web"
 	"github.com/unluckythoughts/go-microservice/v2/utils"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
-func (s *Service) CreateVerifyToken(target string) (string, error) {
-	var token string
-	var err error
+// Purposes of the verify tokens
+const (
+	verifyPurpose    = "verify"
+	resetPurpose     = "reset"
+	changePurpose    = "change"
+	unlockPurpose    = "unlock"
+	magicLinkPurpose = "magic-link"
+	otpPurpose       = "otp"
+)
+
+const (
+	verifyTokenLength = 8
+	verifyTokenValid  = time.Hour
+)
 
+// generateVerifyToken returns a random verify token
+func generateVerifyToken() (string, error) {
+	return utils.GenerateRandomString(verifyTokenLength)
+}
+
+// saveVerification saves the verification with a new unique token of
+// generate, it replaces the token of the same target, purpose, user and device
+func (s *Service) saveVerification(verify *Verify, generate func() (string, error)) (string, error) {
 	for {
-		token, err = utils.GenerateRandomString(8)
+		token, err := generate()
 		if err != nil {
 			return "", err
 		}
 
-		var v Verify
-		if err := s.db.Where("token = ?", token).First(&v).Error; err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				// No existing token for this target, we can proceed to create a new one
-				break
-			}
-
+		err = s.db.Unscoped().Where("token = ?", token).First(&Verify{}).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// no existing verification has this token, we can proceed to save it
+			verify.Token = token
+			break
+		} else if err != nil {
 			return "", err
 		}
 	}
 
-	verify := &Verify{
-		Target:    target,
-		Token:     token,
-		Verified:  false,
-		ExpiresAt: time.Now().Add(time.Hour),
-	}
-
-	err = s.db.
+	err := s.db.
 		Clauses(clause.OnConflict{
-			Columns:   []clause.Column{{Name: "target"}},
-			DoUpdates: clause.AssignmentColumns([]string{"token", "verified", "expires_at"}),
+			Columns: []clause.Column{
+				{Name: "target"}, {Name: "purpose"}, {Name: "user_id"}, {Name: "device"},
+			},
+			DoUpdates: clause.AssignmentColumns([]string{"token", "verified", "expires_at", "deleted_at"}),
 		}).
 		Create(verify).Error
 	if err != nil {
 		return "", err
 	}
 
-	return token, nil
+	return verify.Token, nil
+}
+
+// createVerifyToken returns a new token of the purpose for the email or mobile,
+// userID is the user changing its email or mobile for the change tokens
+func (s *Service) createVerifyToken(target, purpose string, userID uint) (string, error) {
+	return s.saveVerification(&Verify{
+		Target:    target,
+		Purpose:   purpose,
+		UserID:    userID,
+		ExpiresAt: s.now().Add(verifyTokenValid),
+	}, generateVerifyToken)
+}
+
+// CreateVerifyToken returns a new token verifying the email or mobile
+func (s *Service) CreateVerifyToken(target string) (string, error) {
+	return s.createVerifyToken(target, verifyPurpose, 0)
 }
 
 func (s *Service) GetVerification(token string) (*Verify, error) {
@@ -62,9 +89,25 @@ func (s *Service) GetVerification(token string) (*Verify, error) {
 	return &verify, nil
 }
 
+// getVerification returns the verification of the token and purpose, it
+// returns ErrInvalidVerifyToken when there is none
+func (s *Service) getVerification(token, purpose string) (*Verify, error) {
+	v, err := s.GetVerification(token)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidVerifyToken
+	} else if err != nil {
+		return nil, err
+	} else if v.Purpose != purpose {
+		return nil, ErrInvalidVerifyToken
+	}
+
+	return v, nil
+}
+
+// IsVerified returns whether the email or mobile was verified with a verify token
 func (s *Service) IsVerified(target string) bool {
 	var verify Verify
-	err := s.db.Where("target = ?", target).First(&verify).Error
+	err := s.db.Where("target = ? AND purpose = ?", target, verifyPurpose).First(&verify).Error
 	if err != nil {
 		return false
 	}
@@ -72,16 +115,18 @@ func (s *Service) IsVerified(target string) bool {
 	return verify.Verified
 }
 
+// VerifyToken verifies the email or mobile with the token sent to it, the user
+// with the email or mobile is marked verified
 func (s *Service) VerifyToken(target string, token string) (bool, error) {
 	var verify Verify
 	err := s.db.
-		Where("target = ? AND token = ?", target, token).
+		Where("target = ? AND token = ? AND purpose = ?", target, token, verifyPurpose).
 		First(&verify).Error
 	if err != nil {
 		return false, err
 	}
 
-	if verify.ExpiresAt.Before(time.Now()) {
+	if verify.ExpiresAt.Before(s.now()) {
 		return false, ErrExpiredToken
 	}
 
@@ -91,9 +136,24 @@ func (s *Service) VerifyToken(target string, token string) (bool, error) {
 		return false, err
 	}
 
+	if err := s.setTargetVerified(target); err != nil {
+		return false, err
+	}
+
 	return true, nil
 }
 
+// setTargetVerified marks the email or mobile of the target verified for the
+// user that has it, the status is tracked per email and mobile
+func (s *Service) setTargetVerified(target string) error {
+	if utils.IsEmail(target) {
+		return s.db.Model(&User{}).Where("email = ?", target).Update("email_verified", true).Error
+	}
+
+	mobile := Mobile(target)
+	return s.db.Model(&User{}).Where("mobile = ?", mobile.String()).Update("mobile_verified", true).Error
+}
+
 // CreateUser creates a new user with hashed password
 func (s *Service) CreateUser(user *User) error {
 	// Hash the password before saving
@@ -113,7 +173,7 @@ func (s *Service) GetUserByID(id uint) (*User, error) {
 	err := s.db.First(&user, id).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, err
 	}
@@ -122,13 +182,21 @@ func (s *Service) GetUserByID(id uint) (*User, error) {
 	return &user, nil
 }
 
+// hasPassword reports whether the user can log in with a password, the
+// password of the users returned by the getters is cleared
+func (s *Service) hasPassword(userID uint) (bool, error) {
+	var count int64
+	err := s.db.Model(&User{}).Where("id = ? AND password <> ''", userID).Count(&count).Error
+	return count > 0, err
+}
+
 // GetUserByEmail retrieves a user by email
 func (s *Service) GetUserByEmail(email string) (*User, error) {
 	var user User
 	err := s.db.Where("email = ?", email).First(&user).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, err
 	}
@@ -142,7 +210,7 @@ func (s *Service) GetUserByMobile(mobile string) (*User, error) {
 	err := s.db.Where("mobile = ?", mobile).First(&user).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, err
 	}
@@ -154,7 +222,7 @@ func (s *Service) GetUserByMobile(mobile string) (*User, error) {
 func (s *Service) GetAllUsers(offset, limit int) ([]User, error) {
 	var users []User
 	err := s.db.Offset(offset).Limit(limit).Find(&users).Error
-	utils.ClearValues(&users, "Password", "GoogleID")
+	utils.ClearValues(users, "Password", "GoogleID")
 	return users, err
 }
 
@@ -178,7 +246,7 @@ func (s *Service) UpdateEmailVerified(id uint, verified bool) error {
 		return result.Error
 	}
 	if result.RowsAffected == 0 {
-		return errors.New("user not found")
+		return ErrUserNotFound
 	}
 	return nil
 }
@@ -190,7 +258,7 @@ func (s *Service) UpdateMobileVerified(id uint, verified bool) error {
 		return result.Error
 	}
 	if result.RowsAffected == 0 {
-		return errors.New("user not found")
+		return ErrUserNotFound
 	}
 	return nil
 }
@@ -204,7 +272,7 @@ func (s *Service) UpdateUserPartial(id uint, updates any) error {
 		return result.Error
 	}
 	if result.RowsAffected == 0 {
-		return errors.New("user not found")
+		return ErrUserNotFound
 	}
 	return nil
 }
@@ -216,27 +284,35 @@ func (s *Service) DeleteUser(id uint) error {
 		return result.Error
 	}
 	if result.RowsAffected == 0 {
-		return errors.New("user not found")
+		return ErrUserNotFound
 	}
 	return nil
 }
 
-// HardDeleteUser permanently deletes a user and all related data
+// HardDeleteUser permanently deletes a user and all related data, including
+// soft deleted users. The audit events of the user are kept
 func (s *Service) HardDeleteUser(id uint) error {
-	// First delete related addresses
-	if err := s.db.Unscoped().Where("user_id = ?", id).Error; err != nil {
-		return err
-	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		// First delete the related data
+		for _, model := range []any{
+			&RefreshToken{}, &UserSession{}, &APIKey{}, &UserMFA{}, &MFARecoveryCode{},
+			&UserIdentity{}, &UserRoleAssignment{}, &OrgMember{},
+		} {
+			if err := tx.Unscoped().Where("user_id = ?", id).Delete(model).Error; err != nil {
+				return err
+			}
+		}
 
-	// Then delete the user
-	result := s.db.Unscoped().Delete(&User{}, id)
-	if result.Error != nil {
-		return result.Error
-	}
-	if result.RowsAffected == 0 {
-		return errors.New("user not found")
-	}
-	return nil
+		// Then delete the user
+		result := tx.Unscoped().Delete(&User{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrUserNotFound
+		}
+		return nil
+	})
 }
 
 // CountUsers returns the total number of users
@@ -253,6 +329,26 @@ func (s *Service) UserExists(id uint) (bool, error) {
 	return count > 0, err
 }
 
+// userExists checks if a user with the email or mobile exists
+func (s *Service) userExists(email, mobile string) (bool, error) {
+	if email == "" && mobile == "" {
+		return false, nil
+	}
+
+	q := s.db.Model(&User{})
+	if email != "" && mobile != "" {
+		q = q.Where("email = ? OR mobile = ?", email, mobile)
+	} else if email != "" {
+		q = q.Where("email = ?", email)
+	} else {
+		q = q.Where("mobile = ?", mobile)
+	}
+
+	var count int64
+	err := q.Count(&count).Error
+	return count > 0, err
+}
+
 // EmailExists checks if an email is already taken
 func (s *Service) EmailExists(email string) (bool, error) {
 	var count int64
@@ -265,7 +361,7 @@ func (s *Service) GetUserByGoogleID(googleID string) (*User, error) {
 	err := s.db.Where("google_id = ?", googleID).First(&user).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, err
 	}
@@ -287,7 +383,7 @@ func (s *Service) UpdateUserPassword(userID uint, newPassword Password) error {
 		return result.Error
 	}
 	if result.RowsAffected == 0 {
-		return errors.New("user not found")
+		return ErrUserNotFound
 	}
 	return nil
 }
@@ -298,7 +394,7 @@ func (s *Service) VerifyUserPassword(userID uint, password Password) (bool, erro
 	err := s.db.Select("password").First(&user, userID).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return false, errors.New("user not found")
+			return false, ErrUserNotFound
 		}
 		return false, err
 	}
@@ -312,7 +408,7 @@ func (s *Service) VerifyUserPasswordByEmail(email string, password Password) (*U
 	err := s.db.Where("email = ?", email).First(&user).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, false, errors.New("user not found")
+			return nil, false, ErrUserNotFound
 		}
 		return nil, false, err
 	}
@@ -332,7 +428,7 @@ func (s *Service) VerifyUserPasswordByMobile(mobile string, password Password) (
 	err := s.db.Where("mobile = ?", mobile).First(&user).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, false, errors.New("user not found")
+			return nil, false, ErrUserNotFound
 		}
 		return nil, false, err
 	}
@@ -354,7 +450,7 @@ func (s *Service) ChangeUserPassword(userID uint, oldPassword, newPassword Passw
 		return err
 	}
 	if !isValid {
-		return web.NewError(http.StatusBadRequest, errors.New("old password is incorrect"))
+		return ErrIncorrectPassword
 	}
 
 	// Update with new password
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/unluckythoughts/go-microservice/v2/tools/web"
+)
+
+// Errors returned by the auth handlers, clients can match on the code
+// sent in the response instead of the message
+var (
+	ErrInvalidCredentials        = web.NewCodedError(http.StatusUnauthorized, "auth.invalid_credentials", "invalid credentials")
+	ErrEmailOrMobileRequired     = web.NewCodedError(http.StatusBadRequest, "auth.email_or_mobile_required", "email or mobile is required")
+	ErrTargetRequired            = web.NewCodedError(http.StatusBadRequest, "auth.target_required", "target is required")
+	ErrInvalidEmail              = web.NewCodedError(http.StatusBadRequest, "auth.invalid_email", "invalid email format")
+	ErrInvalidMobile             = web.NewCodedError(http.StatusBadRequest, "auth.invalid_mobile", "invalid mobile number format")
+	ErrInvalidTargetType         = web.NewCodedError(http.StatusBadRequest, "auth.invalid_target_type", "invalid target type: must be 'email' or 'mobile'")
+	ErrVerifyTokenRequired       = web.NewCodedError(http.StatusBadRequest, "auth.verify_token_required", "verification token is required")
+	ErrInvalidVerifyToken        = web.NewCodedError(http.StatusBadRequest, "auth.invalid_verify_token", "invalid verification token")
+	ErrExpiredToken              = web.NewCodedError(http.StatusBadRequest, "auth.verify_token_expired", "verification token has expired")
+	ErrUserExists                = web.NewCodedError(http.StatusConflict, "auth.user_exists", "user already exists")
+	ErrUserNotFound              = web.NewCodedError(http.StatusNotFound, "auth.user_not_found", "user not found")
+	ErrIncorrectPassword         = web.NewCodedError(http.StatusBadRequest, "auth.incorrect_password", "old password is incorrect")
+	ErrUnauthorized              = web.NewCodedError(http.StatusUnauthorized, "auth.unauthorized", "unauthorized: Please log in to access this link")
+	ErrInvalidAuthToken          = web.NewCodedError(http.StatusUnauthorized, "auth.invalid_token", "unauthorized: invalid or expired token")
+	ErrForbidden                 = web.NewCodedError(http.StatusForbidden, "auth.forbidden", "forbidden: You do not have permission to access this resource")
+	ErrInvalidRefreshToken       = web.NewCodedError(http.StatusUnauthorized, "auth.invalid_refresh_token", "invalid or expired refresh token")
+	ErrRefreshTokenReused        = web.NewCodedError(http.StatusUnauthorized, "auth.refresh_token_reused", "refresh token was already used, please log in again")
+	ErrInvalidRoleName           = web.NewCodedError(http.StatusBadRequest, "auth.invalid_role_name", "invalid role name")
+	ErrInvalidPermission         = web.NewCodedError(http.StatusBadRequest, "auth.invalid_permission", "invalid permission name")
+	ErrRoleExists                = web.NewCodedError(http.StatusConflict, "auth.role_exists", "role already exists")
+	ErrRoleNotFound              = web.NewCodedError(http.StatusNotFound, "auth.role_not_found", "role not found")
+	ErrPermissionExists          = web.NewCodedError(http.StatusConflict, "auth.permission_exists", "permission already exists")
+	ErrPermissionNotFound        = web.NewCodedError(http.StatusNotFound, "auth.permission_not_found", "permission not found")
+	ErrInvalidAPIKey             = web.NewCodedError(http.StatusUnauthorized, "auth.invalid_api_key", "invalid, expired or revoked API key")
+	ErrAPIKeyNotFound            = web.NewCodedError(http.StatusNotFound, "auth.api_key_not_found", "API key not found")
+	ErrAPIKeyScopesRequired      = web.NewCodedError(http.StatusBadRequest, "auth.api_key_scopes_required", "API keys need at least one scope")
+	ErrInvalidExpiry             = web.NewCodedError(http.StatusBadRequest, "auth.invalid_expiry", "expiry has to be in the future")
+	ErrInvalidServiceAccountName = web.NewCodedError(http.StatusBadRequest, "auth.invalid_service_account_name", "invalid service account name")
+	ErrTooManyAttempts           = web.NewCodedError(http.StatusTooManyRequests, "auth.too_many_attempts", "too many failed attempts, please try again later")
+	ErrInvalidMFACode            = web.NewCodedError(http.StatusUnauthorized, "auth.invalid_mfa_code", "invalid or already used code")
+	ErrInvalidMFAToken           = web.NewCodedError(http.StatusUnauthorized, "auth.invalid_mfa_token", "invalid or expired MFA token, please log in again")
+	ErrMFANotEnrolled            = web.NewCodedError(http.StatusBadRequest, "auth.mfa_not_enrolled", "MFA enrollment was not started")
+	ErrMFANotEnabled             = web.NewCodedError(http.StatusBadRequest, "auth.mfa_not_enabled", "MFA is not enabled")
+	ErrMFAAlreadyEnabled         = web.NewCodedError(http.StatusConflict, "auth.mfa_already_enabled", "MFA is already enabled")
+	ErrOAuthProviderNotFound     = web.NewCodedError(http.StatusNotFound, "auth.oauth_provider_not_found", "identity provider not found")
+	ErrInvalidOAuthState         = web.NewCodedError(http.StatusBadRequest, "auth.invalid_oauth_state", "invalid or expired login state, please start the login again")
+	ErrIdentityNotLinked         = web.NewCodedError(http.StatusUnauthorized, "auth.identity_not_linked", "no user is linked to this identity")
+	ErrIdentityLinked            = web.NewCodedError(http.StatusConflict, "auth.identity_linked", "identity is already linked to a user")
+	ErrIdentityNotFound          = web.NewCodedError(http.StatusNotFound, "auth.identity_not_found", "identity not found")
+	ErrLastLoginMethod           = web.NewCodedError(http.StatusBadRequest, "auth.last_login_method", "users without a password cannot unlink their last identity")
+	ErrOAuthFailed               = web.NewCodedError(http.StatusBadRequest, "auth.oauth_failed", "failed to log in with the identity provider")
+	ErrSessionNotFound           = web.NewCodedError(http.StatusNotFound, "auth.session_not_found", "session not found")
+	ErrSessionRevoked            = web.NewCodedError(http.StatusUnauthorized, "auth.session_revoked", "unauthorized: the session was logged out or expired")
+	ErrUserDisabled              = web.NewCodedError(http.StatusForbidden, "auth.user_disabled", "the account is suspended")
+	ErrInvalidRole               = web.NewCodedError(http.StatusBadRequest, "auth.invalid_role", "invalid role")
+	ErrInvalidSort               = web.NewCodedError(http.StatusBadRequest, "auth.invalid_sort", "invalid sort field or order")
+	ErrOrgNotFound               = web.NewCodedError(http.StatusNotFound, "auth.org_not_found", "organization not found")
+	ErrNotOrgMember              = web.NewCodedError(http.StatusForbidden, "auth.not_org_member", "the user is not a member of the organization")
+	ErrNoCurrentOrg              = web.NewCodedError(http.StatusBadRequest, "auth.no_current_org", "no organization selected, switch to one first")
+	ErrOrgMemberExists           = web.NewCodedError(http.StatusConflict, "auth.org_member_exists", "the user is already a member of the organization")
+	ErrLastOrgAdmin              = web.NewCodedError(http.StatusConflict, "auth.last_org_admin", "the last admin of the organization cannot leave or be demoted")
+	ErrInvalidInvitation         = web.NewCodedError(http.StatusBadRequest, "auth.invalid_invitation", "invalid or expired invitation")
+	ErrInvitationNotFound        = web.NewCodedError(http.StatusNotFound, "auth.invitation_not_found", "invitation not found")
+	ErrInvitationEmailMismatch   = web.NewCodedError(http.StatusForbidden, "auth.invitation_email_mismatch", "the invitation was sent to another email")
+	ErrCannotImpersonate         = web.NewCodedError(http.StatusForbidden, "auth.cannot_impersonate", "the user cannot be impersonated")
+	ErrImpersonationNotAllowed   = web.NewCodedError(http.StatusForbidden, "auth.impersonation_not_allowed", "this action is not allowed while impersonating a user")
+	ErrUnverifiedAccount         = web.NewCodedError(http.StatusForbidden, "auth.unverified_account", "the account has a password and is not verified, log in with the password to verify it")
+	ErrInternal                  = web.NewCodedError(http.StatusInternalServerError, "auth.internal_error", "internal server error")
+)
@@ -1,7 +1,6 @@
 package auth
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -20,7 +19,7 @@ func getFirstKey(m map[Role]string) Role {
 // getGoogleUserInfo fetches user information from Google OAuth
 func (s *Service) getGoogleUserInfo(accessToken string) (*googleUserInfo, error) {
 	client := &http.Client{}
-	req, err := http.NewRequest("GET", "https://www.googleapis.com/oauth2/v2/userinfo", nil)
+	req, err := http.NewRequestWithContext(s.getContext(), http.MethodGet, "https://www.googleapis.com/oauth2/v2/userinfo", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -45,47 +44,45 @@ func (s *Service) getGoogleUserInfo(accessToken string) (*googleUserInfo, error)
 	return &userInfo, nil
 }
 
-// GoogleOAuthLogin handles Google OAuth authentication
+// GoogleOAuthLogin handles Google OAuth authentication with a code exchanged by
+// the client, the identity is linked like the ones of the OAuth routes
+//
+// Deprecated: the /auth/oauth/google routes check the state, nonce and PKCE
+// verifier of the login, see NewGoogleProvider
 func (s *Service) GoogleOAuthLogin(r web.Request) (any, error) {
-	// Extract the OAuth request from the request body
-	oauthReq := &GoogleOAuthRequest{}
-	err := r.GetValidatedBody(oauthReq)
-	if err != nil {
-		return nil, web.NewError(http.StatusBadRequest, err)
-	}
+	return handle(s, func(s *Service, r web.Request) (any, error) {
+		// Extract the OAuth request from the request body
+		oauthReq := &GoogleOAuthRequest{}
+		err := r.GetValidatedBody(oauthReq)
+		if err != nil {
+			return nil, err
+		}
 
-	s.GoogleOauthConfig.RedirectURL = oauthReq.RedirectURI
+		s.GoogleOauthConfig.RedirectURL = oauthReq.RedirectURI
 
-	// Exchange authorization code for access token
-	token, err := s.GoogleOauthConfig.Exchange(context.Background(), oauthReq.Code)
-	if err != nil {
-		return nil, web.NewError(http.StatusBadRequest, fmt.Errorf("failed to exchange code for token: %w", err))
-	}
-
-	// Get user info from Google
-	userInfo, err := s.getGoogleUserInfo(token.AccessToken)
-	if err != nil {
-		return nil, web.NewError(http.StatusInternalServerError, fmt.Errorf("failed to get user info: %w", err))
-	}
+		// Exchange authorization code for access token
+		token, err := s.GoogleOauthConfig.Exchange(r.GetContext(), oauthReq.Code)
+		if err != nil {
+			return nil, ErrOAuthFailed.WithCause(fmt.Errorf("failed to exchange code for token: %w", err))
+		}
 
-	// Check if user exists by Google ID
-	user, err := s.GetUserByGoogleID(userInfo.ID)
-	if err != nil {
-		// User doesn't exist, create new user
-		user = &User{
-			Name:          userInfo.Name,
-			Email:         userInfo.Email,
-			GoogleID:      userInfo.ID,
-			GoogleAvatar:  userInfo.Picture,
-			Role:          getFirstKey(s.userRoles), // Default to user role
-			EmailVerified: true,
+		// Get user info from Google
+		userInfo, err := s.getGoogleUserInfo(token.AccessToken)
+		if err != nil {
+			return nil, ErrOAuthFailed.WithCause(fmt.Errorf("failed to get user info: %w", err))
 		}
 
-		err = s.CreateUser(user)
+		user, err := s.OAuthLogin("google", &OAuthIdentity{
+			Subject:       userInfo.ID,
+			Email:         userInfo.Email,
+			EmailVerified: userInfo.VerifiedEmail,
+			Name:          userInfo.Name,
+			Picture:       userInfo.Picture,
+		}, getFirstKey(s.userRoles)) // Default to user role
 		if err != nil {
-			return nil, web.NewError(http.StatusInternalServerError, fmt.Errorf("failed to create user: %w", err))
+			return nil, getOAuthError(err)
 		}
-	}
 
-	return s.getAuthResponse(r.GetContext(), user)
+		return s.getLoginResponse(r, user)
+	})(r)
 }
@@ -3,18 +3,53 @@ package auth
 import (
 	"errors"
 	"fmt"
-	"net/http"
 	"strings"
 
+	localcontext "github.com/unluckythoughts/go-microservice/v2/tools/context"
 	"github.com/unluckythoughts/go-microservice/v2/tools/web"
 	"github.com/unluckythoughts/go-microservice/v2/utils"
 	"gorm.io/gorm"
 )
 
-// LogoutHandler handles user logout requests
+// LogoutHandler handles user logout requests, the session of the request and
+// its refresh tokens are revoked
 // example path: GET .../logout
 func (s *Service) LogoutHandler(r web.Request) (any, error) {
-	// Invalidate bearer token if present so it cannot be reused after logout
+	return handle(s, func(s *Service, r web.Request) (any, error) {
+		// logging out of an impersonation keeps the session of the admin
+		if user, err := GetAuthenticatedUser(r); err == nil && user.ImpersonatorID != 0 {
+			s.invalidateRequestToken(r)
+			s.audit(AuditImpersonationEnded, user.ID)
+			return "impersonation ended", nil
+		}
+
+		if sessionID := getAuthenticatedSession(r.GetContext()); sessionID != "" {
+			if err := s.endSession(sessionID); err != nil {
+				return nil, ErrInternal.WithCause(err)
+			}
+		}
+		if user, err := GetAuthenticatedUser(r); err == nil {
+			s.audit(AuditLogout, user.ID)
+		}
+
+		if err := s.clearRequestAuth(r); err != nil {
+			return nil, err
+		}
+		return "logout successful", nil
+	})(r)
+}
+
+// clearRequestAuth invalidates the bearer token of the request and clears its session
+func (s *Service) clearRequestAuth(r web.Request) error {
+	s.invalidateRequestToken(r)
+
+	// Clear the session and invalidate the cookie
+	return r.GetContext().ClearSession()
+}
+
+// invalidateRequestToken invalidates the bearer token of the request, if
+// present, so it cannot be reused after logout
+func (s *Service) invalidateRequestToken(r web.Request) {
 	authHeader := r.GetHeader("Authorization")
 	if strings.HasPrefix(authHeader, "Bearer ") {
 		token := strings.TrimPrefix(authHeader, "Bearer ")
@@ -22,121 +57,202 @@ func (s *Service) LogoutHandler(r web.Request) (any, error) {
 			r.GetContext().Sugar().Warnw("failed to add token to invalidation list", "error", err)
 		}
 	}
-
-	// Clear the session and invalidate the cookie
-	err := r.GetContext().ClearSession()
-	if err != nil {
-		return nil, err
-	}
-	return "logout successful", nil
 }
 
 // LoginHandler handles user login requests
 // example path: POST .../login
 func (s *Service) LoginHandler(r web.Request) (any, error) {
-	details := Credentials{}
-	err := r.GetValidatedBody(&details)
-	if err != nil {
-		return "", err
-	}
+	return handle(s, func(s *Service, r web.Request) (any, error) {
+		details := Credentials{}
+		err := r.GetValidatedBody(&details)
+		if err != nil {
+			return "", err
+		}
 
-	if details.Email == "" && details.Mobile == "" {
-		return "", web.NewError(http.StatusBadRequest, fmt.Errorf("email or mobile is required"))
-	}
+		if details.Email == "" && details.Mobile == "" {
+			return "", ErrEmailOrMobileRequired
+		}
 
-	if details.Mobile != "" {
-		user, ok, err := s.VerifyUserPasswordByMobile(details.Mobile, details.Password)
-		if err != nil {
+		identifier := details.Email
+		if details.Mobile != "" {
+			mobile := Mobile(details.Mobile)
+			identifier = mobile.String()
+		}
+
+		// accounts and IPs with recent failures or too many logins have to wait
+		// before the password is hashed, unknown emails are throttled like the others
+		ip := web.GetClientIP(r)
+		if err := s.checkAttempts(accountAttemptKey(identifier), ipAttemptKey(ip)); err != nil {
+			return "", err
+		}
+		if err := s.countLoginRequest(ip); err != nil {
 			return "", err
 		}
 
-		if !ok {
-			return "", fmt.Errorf("invalid mobile or password")
+		var user *User
+		var ok bool
+		if details.Mobile != "" {
+			user, ok, err = s.VerifyUserPasswordByMobile(details.Mobile, details.Password)
+		} else {
+			user, ok, err = s.VerifyUserPasswordByEmail(details.Email, details.Password)
 		}
-		return s.getAuthResponse(r.GetContext(), user)
-	}
 
-	user, ok, err := s.VerifyUserPasswordByEmail(details.Email, details.Password)
-	if err != nil {
-		return "", err
-	}
-	if !ok {
-		return "", fmt.Errorf("invalid email or password")
-	}
+		// unknown users get the same error as wrong passwords, after as long
+		if errors.Is(err, ErrUserNotFound) {
+			_, _ = utils.CompareValue(details.Password.String(), dummyPasswordHash())
+			s.loginFailed(identifier, ip, nil)
+			return "", ErrInvalidCredentials
+		} else if err != nil {
+			return "", ErrInternal.WithCause(err)
+		}
+		// service accounts only authenticate with API keys
+		if !ok || user.ServiceAccount {
+			s.loginFailed(identifier, ip, user)
+			return "", ErrInvalidCredentials
+		}
+
+		s.resetAttempts(accountAttemptKey(identifier))
+
+		resp, err := s.getLoginResponse(r, user)
+		if err != nil {
+			return nil, err
+		}
 
-	return s.getAuthResponse(r.GetContext(), user)
+		s.audit(AuditLogin, user.ID, "mfa_required", resp.MFARequired)
+		return resp, nil
+	})(r)
+}
+
+// RefreshHandler exchanges a refresh token for a new JWT token and refresh token
+// example path: POST .../refresh
+func (s *Service) RefreshHandler(r web.Request) (any, error) {
+	return handle(s, func(s *Service, r web.Request) (any, error) {
+		body := RefreshRequest{}
+		err := r.GetValidatedBody(&body)
+		if err != nil {
+			return nil, err
+		}
+
+		if s.refreshTokenValid == 0 {
+			return nil, ErrInvalidRefreshToken
+		}
+
+		rt, refreshToken, err := s.rotateRefreshToken(body.RefreshToken)
+		if errors.Is(err, ErrInvalidRefreshToken) || errors.Is(err, ErrRefreshTokenReused) {
+			return nil, err
+		} else if err != nil {
+			return nil, ErrInternal.WithCause(err)
+		}
+
+		// deleted users cannot refresh their tokens
+		if _, err := s.GetUserByID(rt.UserID); errors.Is(err, ErrUserNotFound) {
+			return nil, ErrInvalidRefreshToken
+		} else if err != nil {
+			return nil, ErrInternal.WithCause(err)
+		}
+
+		sessionID, orgID, err := s.refreshSession(rt.FamilyID, web.GetClientIP(r))
+		if errors.Is(err, ErrInvalidRefreshToken) {
+			return nil, err
+		} else if err != nil {
+			return nil, ErrInternal.WithCause(err)
+		}
+
+		token, err := s.createAccessToken(rt.UserID, sessionID, orgID)
+		if err != nil {
+			return nil, ErrInternal.WithCause(err)
+		}
+
+		return LoginResponse{Token: token, RefreshToken: refreshToken}, nil
+	})(r)
+}
+
+// UnlockHandler unlocks the account locked after failed logins with the token sent by email
+// example path: POST .../unlock
+func (s *Service) UnlockHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, body UnlockRequest) (string, error) {
+		err := s.UnlockAccount(body.Token)
+		if errors.Is(err, ErrInvalidVerifyToken) || errors.Is(err, ErrExpiredToken) {
+			return "", err
+		} else if err != nil {
+			return "", ErrInternal.WithCause(err)
+		}
+		return "account unlocked successfully", nil
+	})(r)
 }
 
 // SendTokenHandler handles the creation of a verification token for a given target (email or mobile)
 // example path: PATCH .../verify/:target?type=(email or mobile)
 func (s *Service) SendTokenHandler(r web.Request) (any, error) {
-	targetType := r.GetURLParam("type")
-	target := r.GetRouteParam("target")
+	return handle(s, func(s *Service, r web.Request) (any, error) {
+		targetType := r.GetURLParam("type")
+		target := r.GetRouteParam("target")
 
-	if target == "" {
-		return nil, web.NewError(http.StatusBadRequest, fmt.Errorf("target is required"))
-	}
+		if target == "" {
+			return nil, ErrTargetRequired
+		}
 
-	target = strings.TrimSpace(target)
+		target = strings.TrimSpace(target)
 
-	switch targetType {
-	case "email", "":
-		if !utils.IsEmail(target) {
-			return nil, web.NewError(http.StatusBadRequest, fmt.Errorf("invalid email format"))
-		}
-	case "mobile":
-		if !utils.IsMobile(target) {
-			return nil, web.NewError(http.StatusBadRequest, fmt.Errorf("invalid mobile number format"))
+		switch targetType {
+		case "email", "":
+			if !utils.IsEmail(target) {
+				return nil, ErrInvalidEmail
+			}
+		case "mobile":
+			if !utils.IsMobile(target) {
+				return nil, ErrInvalidMobile
+			}
+		default:
+			return nil, ErrInvalidTargetType
 		}
-	default:
-		return nil, web.NewError(http.StatusBadRequest, fmt.Errorf("invalid target type: must be 'email' or 'mobile'"))
-	}
-
-	token, err := s.CreateVerifyToken(target)
-	if err != nil {
-		return nil, web.NewError(http.StatusInternalServerError, err)
-	}
 
-	switch targetType {
-	case "email", "":
-		r.GetContext().Sugar().Info("verification token created for email", "email", target, "token", token)
-		// TODO: send verification email to the user with the token
-	case "mobile":
-		r.GetContext().Sugar().Info("verification token created for mobile", "mobile", target, "token", token)
-		// TODO: send verification SMS to the user with the token
-	}
+		token, err := s.CreateVerifyToken(target)
+		if err != nil {
+			return nil, ErrInternal.WithCause(err)
+		}
 
-	return "verification token created successfully", nil
+		channel := ChannelEmail
+		if targetType == "mobile" {
+			channel = ChannelMobile
+		}
+		if err := s.notify(Message{Kind: MessageVerify, Channel: channel, To: target, Token: token}); err != nil {
+			return nil, ErrInternal.WithCause(err)
+		}
 
+		return "verification token created successfully", nil
+	})(r)
 }
 
 // VerifyTokenHandler handles the verification of a token for a given target (email or mobile)
 // example path: GET .../verify/:target/:token
 func (s *Service) VerifyTokenHandler(r web.Request) (any, error) {
-	target := r.GetRouteParam("target")
-	token := r.GetRouteParam("token")
+	return handle(s, func(s *Service, r web.Request) (any, error) {
+		target := r.GetRouteParam("target")
+		token := r.GetRouteParam("token")
 
-	if token == "" {
-		return nil, web.NewError(http.StatusBadRequest, fmt.Errorf("verification token is required"))
-	}
+		if token == "" {
+			return nil, ErrVerifyTokenRequired
+		}
 
-	ok, err := s.VerifyToken(target, token)
-	if err != nil {
-		if errors.Is(err, ErrExpiredToken) {
-			return nil, web.NewError(http.StatusBadRequest, err)
-		} else if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, web.NewError(http.StatusBadRequest, fmt.Errorf("invalid verification token"))
+		ok, err := s.VerifyToken(target, token)
+		if err != nil {
+			if errors.Is(err, ErrExpiredToken) {
+				return nil, err
+			} else if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrInvalidVerifyToken
+			}
+			return nil, ErrInternal.WithCause(err)
 		}
-		return nil, web.NewError(http.StatusInternalServerError, err)
-	}
 
-	return ok, nil
+		return ok, nil
+	})(r)
 }
 
 // GetRegisterHandlerForUserRole returns a handler for user registration with a specific role
 // example path: POST .../register
 func (s *Service) GetRegisterHandlerForUserRole(role Role) web.Handler {
-	return func(r web.Request) (any, error) {
+	return handle(s, func(s *Service, r web.Request) (any, error) {
 		details := RegisterRequest{}
 		err := r.GetValidatedBody(&details)
 		if err != nil {
@@ -144,7 +260,7 @@ func (s *Service) GetRegisterHandlerForUserRole(role Role) web.Handler {
 		}
 
 		if details.Email == "" && details.Mobile == "" {
-			return nil, web.NewError(http.StatusBadRequest, fmt.Errorf("email or mobile is required"))
+			return nil, ErrEmailOrMobileRequired
 		}
 
 		user := User{
@@ -157,7 +273,7 @@ func (s *Service) GetRegisterHandlerForUserRole(role Role) web.Handler {
 		if details.Mobile != "" {
 			err := user.Mobile.Set(details.Mobile)
 			if err != nil {
-				return nil, web.NewError(http.StatusBadRequest, fmt.Errorf("invalid mobile number: %w", err))
+				return nil, ErrInvalidMobile.WithCause(err)
 			}
 		}
 
@@ -169,217 +285,266 @@ func (s *Service) GetRegisterHandlerForUserRole(role Role) web.Handler {
 			user.MobileVerified = true
 		}
 
+		exists, err := s.userExists(user.Email, user.Mobile.String())
+		if err != nil {
+			return nil, ErrInternal.WithCause(err)
+		} else if exists {
+			return nil, ErrUserExists
+		}
+
 		err = s.CreateUser(&user)
 		if err != nil {
-			return nil, web.NewError(http.StatusInternalServerError, err)
+			return nil, ErrInternal.WithCause(err)
 		}
+		s.audit(AuditUserRegistered, user.ID, "role", user.Role)
+		s.sendWelcome(&user)
 
 		return "user registered successfully", nil
-	}
+	})
 }
 
 // GetUser returns the currently authenticated user
 // example path: GET .../user
 func (s *Service) GetUserHandler(r web.Request) (any, error) {
-	user, err := GetAuthenticatedUser(r)
-	if err != nil {
-		return nil, err
-	}
+	return handle(s, func(s *Service, r web.Request) (any, error) {
+		user, err := GetAuthenticatedUser(r)
+		if err != nil {
+			return nil, err
+		}
 
-	return user, nil
+		return user, nil
+	})(r)
 }
 
-// UpdateUserHandler handles user profile update requests
+// UpdateUserHandler handles user profile update requests, the name is updated
+// right away while a new email or mobile is pending until confirmed with the
+// token sent to it, see ConfirmContactChangeHandler
 // example path: PUT .../user
 func (s *Service) UpdateUserHandler(r web.Request) (any, error) {
-	user, err := GetAuthenticatedUser(r)
-	if err != nil {
-		return nil, err
-	}
+	return typed(s, (*Service).updateUser)(r)
+}
 
-	body := UpdateUserRequest{}
-	err = r.GetValidatedBody(&body)
+func (s *Service) updateUser(ctx localcontext.Context, body UpdateUserRequest) (string, error) {
+	user, err := getAuthenticatedUser(ctx)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	new_user := User{
-		Name:  body.Name,
-		Email: body.Email,
+	channels, err := s.RequestContactChange(user, body.Email, body.Mobile)
+	if errors.Is(err, ErrUserExists) || errors.Is(err, ErrInvalidMobile) {
+		return "", err
+	} else if err != nil {
+		return "", ErrInternal.WithCause(err)
 	}
-	new_user.Mobile.Set(body.Mobile)
-
-	if new_user.Email != "" && new_user.Email != user.Email {
-		new_user.EmailVerified = false
+	for _, channel := range channels {
+		s.audit(AuditContactChangeRequested, user.ID, "channel", channel)
 	}
 
-	if new_user.Mobile != "" && new_user.Mobile != user.Mobile {
-		new_user.MobileVerified = false
+	err = s.UpdateUserPartial(user.ID, User{Name: body.Name})
+	if errors.Is(err, ErrUserNotFound) {
+		return "", err
+	} else if err != nil {
+		return "", ErrInternal.WithCause(err)
 	}
 
-	err = s.UpdateUserPartial(user.ID, new_user)
-	if err != nil {
-		return nil, err
+	if len(channels) > 0 {
+		return fmt.Sprintf("user updated successfully, the new %s is pending confirmation",
+			strings.Join(channels, " and ")), nil
 	}
-
 	return "user updated successfully", nil
 }
 
+// ConfirmContactChangeHandler replaces the email or mobile of the authenticated
+// user with the new one the change token was sent to
+// example path: POST .../user/confirm-change
+func (s *Service) ConfirmContactChangeHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, body ConfirmChangeRequest) (string, error) {
+		user, err := getAuthenticatedUser(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		channel, err := s.ConfirmContactChange(user.ID, body.Token)
+		if errors.Is(err, ErrInvalidVerifyToken) || errors.Is(err, ErrExpiredToken) {
+			s.auditFailure(AuditContactChanged, user.ID, "reason", "invalid_token")
+			return "", err
+		} else if errors.Is(err, ErrUserExists) || errors.Is(err, ErrUserNotFound) {
+			return "", err
+		} else if err != nil {
+			return "", ErrInternal.WithCause(err)
+		}
+
+		s.audit(AuditContactChanged, user.ID, "channel", channel)
+		return channel + " changed successfully", nil
+	})(r)
+}
+
 // ChangePasswordHandler handles password change requests for authenticated users
 // example path: POST .../user/change-password
 func (s *Service) ChangePasswordHandler(r web.Request) (any, error) {
-	user, err := GetAuthenticatedUser(r)
-	if err != nil {
-		return nil, err
-	}
+	return handle(s, func(s *Service, r web.Request) (any, error) {
+		user, err := GetAuthenticatedUser(r)
+		if err != nil {
+			return nil, err
+		}
 
-	body := ChangePasswordRequest{}
-	err = r.GetValidatedBody(&body)
-	if err != nil {
-		return nil, err
-	}
+		body := ChangePasswordRequest{}
+		err = r.GetValidatedBody(&body)
+		if err != nil {
+			return nil, err
+		}
 
-	err = s.ChangeUserPassword(user.ID, body.OldPassword, body.NewPassword)
-	if err != nil {
-		return nil, err
-	}
+		err = s.ChangeUserPassword(user.ID, body.OldPassword, body.NewPassword)
+		if errors.Is(err, ErrIncorrectPassword) {
+			s.auditFailure(AuditPasswordChanged, user.ID, "reason", "incorrect_password")
+			return nil, err
+		} else if errors.Is(err, ErrUserNotFound) {
+			return nil, err
+		} else if err != nil {
+			return nil, ErrInternal.WithCause(err)
+		}
 
-	return "password changed successfully", nil
+		s.audit(AuditPasswordChanged, user.ID)
+		return "password changed successfully", nil
+	})(r)
 }
 
+// getResetTarget returns the email or mobile of the target type the reset
+// token is sent to, or the other one the user has, with its channel
 func getResetTarget(user *User, targetType string) (string, string, error) {
-	if targetType == "email" && user.Email != "" {
-		return user.Email + ":email-reset-password", "email", nil
+	if targetType == ChannelEmail && user.Email != "" {
+		return user.Email, ChannelEmail, nil
 	}
 
-	if targetType == "mobile" && user.Mobile.String() != "" {
-		return user.Mobile.String() + ":mobile-reset-password", "mobile", nil
+	if targetType == ChannelMobile && user.Mobile.String() != "" {
+		return user.Mobile.String(), ChannelMobile, nil
 	}
 
 	if user.Email != "" {
-		return user.Email + ":email-reset-password", "email", nil
+		return user.Email, ChannelEmail, nil
 	}
 
 	if user.Mobile.String() != "" {
-		return user.Mobile.String() + ":mobile-reset-password", "mobile", nil
+		return user.Mobile.String(), ChannelMobile, nil
 	}
 
 	return "", "", fmt.Errorf("user has neither email nor mobile")
 }
 
-func (s *Service) getTarget(target string) (string, bool) {
-	if target == "" {
-		return "", false
-	}
-
-	vals := strings.Split(target, ":")
-	if len(vals) < 2 {
-		return "", false
-	}
-
-	switch vals[len(vals)-1] {
-	case "email-reset-password", "mobile-reset-password":
-		return strings.Join(vals[:len(vals)-1], ":"), true
-	}
-
-	return "", false
-}
-
 // ResetPasswordHandler handles password reset requests
 // example path: GET .../user/reset-password/:target?type=(email or mobile)
 func (s *Service) ResetPasswordHandler(r web.Request) (any, error) {
-	targetType := r.GetURLParam("type")
-	target := r.GetRouteParam("target")
+	return handle(s, func(s *Service, r web.Request) (any, error) {
+		targetType := r.GetURLParam("type")
+		target := r.GetRouteParam("target")
 
-	if target == "" {
-		return nil, web.NewError(http.StatusBadRequest, fmt.Errorf("target is required"))
-	}
+		if target == "" {
+			return nil, ErrTargetRequired
+		}
 
-	target = strings.TrimSpace(target)
+		target = strings.TrimSpace(target)
 
-	switch targetType {
-	case "email", "":
-		if !utils.IsEmail(target) {
-			return nil, web.NewError(http.StatusBadRequest, fmt.Errorf("invalid email format"))
+		switch targetType {
+		case "email", "":
+			if !utils.IsEmail(target) {
+				return nil, ErrInvalidEmail
+			}
+		case "mobile":
+			if !utils.IsMobile(target) {
+				return nil, ErrInvalidMobile
+			}
+		default:
+			return nil, ErrInvalidTargetType
 		}
-	case "mobile":
-		if !utils.IsMobile(target) {
-			return nil, web.NewError(http.StatusBadRequest, fmt.Errorf("invalid mobile number format"))
+
+		var user User
+		err := s.db.Where("email = ? OR mobile = ?", target, target).First(&user).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrUserNotFound
+			}
+			return nil, ErrInternal.WithCause(err)
 		}
-	default:
-		return nil, web.NewError(http.StatusBadRequest, fmt.Errorf("invalid target type: must be 'email' or 'mobile'"))
-	}
 
-	var user User
-	err := s.db.Where("email = ? OR mobile = ?", target, target).First(&user).Error
-	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, web.NewError(http.StatusNotFound, fmt.Errorf("user not found for the given target"))
+		currentUser, err := GetAuthenticatedUser(r)
+		if err != nil {
+			return nil, err
 		}
-		return nil, web.NewError(http.StatusInternalServerError, err)
-	}
 
-	currentUser, err := GetAuthenticatedUser(r)
-	if err != nil {
-		return nil, web.NewError(http.StatusUnauthorized, err)
-	}
+		if currentUser.ID != user.ID {
+			return nil, ErrForbidden
+		}
 
-	if currentUser.ID != user.ID {
-		return nil, web.NewError(http.StatusForbidden, fmt.Errorf("cannot reset password for another user"))
-	}
+		targetType, err = s.sendPasswordReset(&user, targetType)
+		if err != nil {
+			return nil, ErrInternal.WithCause(err)
+		}
+		s.audit(AuditPasswordResetRequested, user.ID, "channel", targetType)
+
+		return "verification token created successfully", nil
+	})(r)
+}
 
-	target, targetType, err = getResetTarget(&user, targetType)
+// sendPasswordReset sends a password reset token to the email or mobile of the
+// target type, or the other one the user has, and returns the channel used
+func (s *Service) sendPasswordReset(user *User, targetType string) (string, error) {
+	target, targetType, err := getResetTarget(user, targetType)
 	if err != nil {
-		return nil, web.NewError(http.StatusInternalServerError, err)
+		return "", err
 	}
 
-	token, err := s.CreateVerifyToken(target)
+	token, err := s.createVerifyToken(target, resetPurpose, 0)
 	if err != nil {
-		return nil, web.NewError(http.StatusInternalServerError, err)
+		return "", err
 	}
 
-	switch targetType {
-	case "email", "":
-		r.GetContext().Sugar().Info("verification token created for email", "email", target, "token", token)
-		// TODO: send verification email to the user with the token
-	case "mobile":
-		r.GetContext().Sugar().Info("verification token created for mobile", "mobile", target, "token", token)
-		// TODO: send verification SMS to the user with the token
+	err = s.notify(Message{Kind: MessagePasswordReset, Channel: targetType, To: target, Name: user.Name, Token: token})
+	if err != nil {
+		return "", err
 	}
 
-	return "verification token created successfully", nil
-
+	return targetType, nil
 }
 
 // UpdatePasswordHandler handles password reset requests using a verification token
 // example path: POST .../user/update-password
 func (s *Service) UpdatePasswordHandler(r web.Request) (any, error) {
-	body := UpdatePasswordRequest{}
-	err := r.GetValidatedBody(&body)
-	if err != nil {
-		return nil, err
-	}
+	return handle(s, func(s *Service, r web.Request) (any, error) {
+		body := UpdatePasswordRequest{}
+		err := r.GetValidatedBody(&body)
+		if err != nil {
+			return nil, err
+		}
 
-	v, err := s.GetVerification(body.VerifyToken)
-	if err != nil {
-		return nil, err
-	}
+		v, err := s.getVerification(body.VerifyToken, resetPurpose)
+		if errors.Is(err, ErrInvalidVerifyToken) {
+			return nil, err
+		} else if err != nil {
+			return nil, ErrInternal.WithCause(err)
+		}
 
-	target, ok := s.getTarget(v.Target)
-	if !ok {
-		return nil, web.NewError(http.StatusInternalServerError, fmt.Errorf("invalid verification target"))
-	}
+		var user User
+		err = s.db.Where("email = ? OR mobile = ?", v.Target, v.Target).First(&user).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidVerifyToken
+		} else if err != nil {
+			return nil, ErrInternal.WithCause(err)
+		}
 
-	var user User
-	err = s.db.Where("email = ? OR mobile = ?", target, target).First(&user).Error
-	if err != nil {
-		return nil, web.NewError(http.StatusInternalServerError, fmt.Errorf("user not found for the given verification token"))
-	}
+		// reset tokens can only be used once
+		err = s.useVerification(v)
+		if errors.Is(err, ErrExpiredToken) || errors.Is(err, ErrInvalidVerifyToken) {
+			return nil, err
+		} else if err != nil {
+			return nil, ErrInternal.WithCause(err)
+		}
 
-	err = s.UpdateUserPassword(user.ID, body.NewPassword)
-	if err != nil {
-		return nil, err
-	}
+		err = s.UpdateUserPassword(user.ID, body.NewPassword)
+		if err != nil {
+			return nil, ErrInternal.WithCause(err)
+		}
+		s.audit(AuditPasswordReset, user.ID)
 
-	return "password reset successful", nil
+		return "password reset successful", nil
+	})(r)
 }
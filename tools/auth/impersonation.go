@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"time"
+
+	localcontext "github.com/unluckythoughts/go-microservice/v2/tools/context"
+	"github.com/unluckythoughts/go-microservice/v2/tools/web"
+)
+
+// impersonatorContextKey is the request context key of the admin impersonating the authenticated user
+type impersonatorContextKey struct{}
+
+// getImpersonator returns the admin impersonating the authenticated user of
+// the request, nil when the request is not impersonated
+func getImpersonator(ctx localcontext.Context) *User {
+	impersonator, _ := ctx.Value(impersonatorContextKey{}).(*User)
+	return impersonator
+}
+
+// GetImpersonator returns the admin impersonating the authenticated user of
+// the request, false when the request is not impersonated
+func GetImpersonator(r web.Request) (*User, bool) {
+	impersonator := getImpersonator(r.GetContext())
+	return impersonator, impersonator != nil
+}
+
+// GetActor returns the user really making the request: the admin impersonating
+// the authenticated user or else the authenticated user
+func GetActor(r web.Request) (*User, error) {
+	if impersonator := getImpersonator(r.GetContext()); impersonator != nil {
+		return impersonator, nil
+	}
+
+	return GetAuthenticatedUser(r)
+}
+
+// checkImpersonation returns ErrCannotImpersonate unless the actor can
+// impersonate the user, admins only impersonate the active users with a
+// lower role than theirs
+func checkImpersonation(actor, user *User) error {
+	if actor.Disabled {
+		return ErrUserDisabled
+	}
+	if actor.ID == user.ID || user.ServiceAccount || user.Disabled || user.Role >= actor.Role {
+		return ErrCannotImpersonate
+	}
+
+	return nil
+}
+
+// Impersonate returns a token authenticating the requests of the actor as the
+// user of userID in the organization of orgID, 0 for none. The token belongs
+// to the session of the actor, logging out of the session ends the
+// impersonation, and it cannot be refreshed
+func (s *Service) Impersonate(actor *User, userID uint, sessionID string, orgID uint) (*ImpersonationResponse, error) {
+	// API keys have no session to revoke the impersonation with
+	if sessionID == "" {
+		return nil, ErrForbidden
+	}
+
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkImpersonation(actor, user); err != nil {
+		return nil, err
+	}
+
+	if orgID != 0 {
+		if _, err := s.GetOrgMembership(orgID, user.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	resp := &ImpersonationResponse{ExpiresAt: time.Now().Add(s.impersonationValid).Truncate(time.Second)}
+	resp.Token, err = s.signAccessToken(accessClaims{
+		userID:    user.ID,
+		sessionID: sessionID,
+		orgID:     orgID,
+		actorID:   actor.ID,
+	}, resp.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// setImpersonator checks the admin of actorID can still impersonate the user
+// and puts it in the request context, every impersonated request is audited
+func (s *Service) setImpersonator(r web.MiddlewareRequest, user *User, actorID uint) error {
+	actor, err := s.GetUserByID(actorID)
+	if err != nil {
+		return ErrUnauthorized.WithCause(err)
+	}
+	if err := checkImpersonation(actor, user); err != nil {
+		return err
+	}
+
+	user.ImpersonatorID = actor.ID
+	// the auth middleware and EnsureRole both authenticate the request, it is audited once
+	if err := r.GetContext().WithValue(impersonatorContextKey{}, actor); err == nil {
+		s.audit(AuditImpersonatedRequest, user.ID, "method", r.GetMethod(), "path", r.GetPath())
+	}
+
+	return nil
+}
+
+// DenyImpersonation returns a middleware refusing the requests of the admins
+// impersonating a user, the destructive routes opt out of impersonation with
+// it, e.g. changing the password. It authenticates the request when no auth
+// middleware did before
+func (s *Service) DenyImpersonation() web.Middleware {
+	return func(r web.MiddlewareRequest) error {
+		user, err := getAuthenticatedUser(r.GetContext())
+		if err != nil {
+			if user, err = s.getUserFromRequest(r); err != nil {
+				return err
+			}
+			setAuthenticatedUser(r.GetContext(), user)
+		}
+
+		if user.ImpersonatorID == 0 {
+			return nil
+		}
+
+		s.WithContext(r.GetContext()).auditFailure(AuditImpersonationDenied, user.ID,
+			"method", r.GetMethod(), "path", r.GetPath())
+		return ErrImpersonationNotAllowed
+	}
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	localcontext "github.com/unluckythoughts/go-microservice/v2/tools/context"
+	"go.uber.org/zap"
+)
+
+func newImpersonationTestService(t *testing.T) (*Service, *User, *User, string) {
+	s, _, user := newMFATestService(t)
+
+	admin := &User{Name: "admin", Email: "admin@example.com", Password: "Password@123", Role: 99}
+	require.NoError(t, s.CreateUser(admin))
+	session, err := s.createSession(admin.ID, "laptop", "10.0.0.1")
+	require.NoError(t, err)
+
+	return s, admin, user, session.SessionID
+}
+
+func TestImpersonate(t *testing.T) {
+	s, admin, user, sessionID := newImpersonationTestService(t)
+
+	resp, err := s.Impersonate(admin, user.ID, sessionID, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Minute, s.impersonationValid)
+	assert.WithinDuration(t, time.Now().Add(s.impersonationValid), resp.ExpiresAt, time.Second)
+
+	claims, err := s.getUserDataFromAuthHeader("Bearer " + resp.Token)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, claims.userID)
+	assert.Equal(t, admin.ID, claims.actorID)
+	assert.Equal(t, sessionID, claims.sessionID, "the token belongs to the session of the admin")
+	assert.Zero(t, claims.orgID)
+
+	token, err := s.createAccessToken(user.ID, sessionID, 0)
+	require.NoError(t, err)
+	claims, err = s.getUserDataFromAuthHeader("Bearer " + token)
+	require.NoError(t, err)
+	assert.Zero(t, claims.actorID, "the tokens of the user have no actor")
+}
+
+func TestImpersonateRules(t *testing.T) {
+	s, admin, user, sessionID := newImpersonationTestService(t)
+
+	_, err := s.Impersonate(admin, admin.ID, sessionID, 0)
+	assert.ErrorIs(t, err, ErrCannotImpersonate, "admins cannot impersonate themselves")
+	_, err = s.Impersonate(user, admin.ID, sessionID, 0)
+	assert.ErrorIs(t, err, ErrCannotImpersonate, "only users with a lower role can be impersonated")
+	_, err = s.Impersonate(admin, user.ID+10, sessionID, 0)
+	assert.ErrorIs(t, err, ErrUserNotFound)
+	_, err = s.Impersonate(admin, user.ID, "", 0)
+	assert.ErrorIs(t, err, ErrForbidden, "API keys have no session")
+
+	org, err := s.CreateOrg(admin.ID, "Acme", testOrgAdminRole)
+	require.NoError(t, err)
+	_, err = s.Impersonate(admin, user.ID, sessionID, org.ID)
+	assert.ErrorIs(t, err, ErrNotOrgMember)
+
+	require.NoError(t, s.SuspendUser(user.ID))
+	suspended, err := s.GetUserByID(user.ID)
+	require.NoError(t, err)
+	assert.ErrorIs(t, checkImpersonation(admin, suspended), ErrCannotImpersonate)
+
+	// the tokens stop working once the admin is demoted or suspended
+	require.NoError(t, s.UnsuspendUser(user.ID))
+	active, err := s.GetUserByID(user.ID)
+	require.NoError(t, err)
+	require.NoError(t, checkImpersonation(admin, active))
+	demoted := *admin
+	demoted.Role = active.Role
+	assert.ErrorIs(t, checkImpersonation(&demoted, active), ErrCannotImpersonate)
+	disabled := *admin
+	disabled.Disabled = true
+	assert.ErrorIs(t, checkImpersonation(&disabled, active), ErrUserDisabled)
+}
+
+func TestImpersonatedAuditActor(t *testing.T) {
+	s, admin, user, _ := newImpersonationTestService(t)
+	sink := &recordingSink{}
+	s.auditSink = sink
+
+	ctx := localcontext.NewContext(zap.NewNop())
+	impersonated := *user
+	impersonated.ImpersonatorID = admin.ID
+	setAuthenticatedUser(ctx, &impersonated)
+	require.NoError(t, ctx.WithValue(impersonatorContextKey{}, admin))
+
+	s.WithContext(ctx).audit(AuditPasswordChanged, user.ID)
+	require.Len(t, sink.events, 1)
+	event := sink.events[0]
+	assert.Equal(t, admin.ID, event.ActorID, "the admin is the actor of the impersonated requests")
+	assert.Equal(t, user.ID, event.SubjectID)
+	assert.EqualValues(t, user.ID, event.Details["impersonated_user_id"])
+}
@@ -1,12 +1,10 @@
 package auth
 
 import (
-	"context"
 	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
-	"github.com/unluckythoughts/go-microservice/v2/tools/web"
 )
 
 const invalidTokenPrefix = "invalid_token:"
@@ -18,7 +16,7 @@ func (s *Service) invalidateToken(tokenString string) error {
 		return nil
 	}
 
-	token, err := web.ParseJWT(s.jwtKey, tokenString,
+	token, err := s.keys.Parse(tokenString,
 		jwt.WithIssuer(s.jwtIssuer),
 		jwt.WithAudience(s.jwtAudience),
 	)
@@ -42,7 +40,7 @@ func (s *Service) invalidateToken(tokenString string) error {
 	}
 
 	key := fmt.Sprintf("%s%s", invalidTokenPrefix, tokenString)
-	return s.cache.SetEX(context.Background(), key, "1", ttl).Err()
+	return s.cache.SetEX(s.getContext(), key, "1", ttl).Err()
 }
 
 // isTokenInvalidated returns true if the token was explicitly invalidated (e.g. on logout).
@@ -52,7 +50,7 @@ func (s *Service) isTokenInvalidated(tokenString string) bool {
 	}
 
 	key := fmt.Sprintf("%s%s", invalidTokenPrefix, tokenString)
-	exists, err := s.cache.Exists(context.Background(), key).Result()
+	exists, err := s.cache.Exists(s.getContext(), key).Result()
 	if err != nil {
 		return false
 	}
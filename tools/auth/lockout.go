@@ -0,0 +1,381 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/unluckythoughts/go-microservice/v2/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// dummyPasswordHash is compared with the password of unknown users, so they
+// take as long to reject as wrong passwords and do not reveal which emails exist
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := utils.GetHash("dummy-password")
+	return hash
+})
+
+// loginAttempts are the recent failed attempts of a key
+type loginAttempts struct {
+	Failures     int
+	LastFailedAt time.Time
+}
+
+// attemptStore counts the failed attempts of the keys, failures are forgotten
+// once the last one is older than the ttl
+type attemptStore interface {
+	get(ctx context.Context, key string, now time.Time) (loginAttempts, error)
+	fail(ctx context.Context, key string, now time.Time) (loginAttempts, error)
+	reset(ctx context.Context, key string) error
+}
+
+// failAttemptScript counts a failure, forgetting the failures older than the ttl
+var failAttemptScript = redis.NewScript(`
+	local last = tonumber(redis.call('HGET', KEYS[1], 'last'))
+	if last and tonumber(ARGV[1]) - last > tonumber(ARGV[2]) then
+		redis.call('DEL', KEYS[1])
+	end
+	local failures = redis.call('HINCRBY', KEYS[1], 'failures', 1)
+	redis.call('HSET', KEYS[1], 'last', ARGV[1])
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return failures
+`)
+
+// redisAttemptStore keeps the failed attempts in Redis hashes expiring after the ttl
+type redisAttemptStore struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+func (st *redisAttemptStore) redisKey(key string) string {
+	return "auth:attempts:" + key
+}
+
+func (st *redisAttemptStore) get(ctx context.Context, key string, now time.Time) (loginAttempts, error) {
+	vals, err := st.client.HMGet(ctx, st.redisKey(key), "failures", "last").Result()
+	if err != nil {
+		return loginAttempts{}, err
+	}
+
+	failures, _ := vals[0].(string)
+	last, _ := vals[1].(string)
+	a := loginAttempts{}
+	a.Failures, _ = strconv.Atoi(failures)
+	lastMillis, _ := strconv.ParseInt(last, 10, 64)
+	a.LastFailedAt = time.UnixMilli(lastMillis)
+
+	if now.Sub(a.LastFailedAt) > st.ttl {
+		return loginAttempts{}, nil
+	}
+	return a, nil
+}
+
+func (st *redisAttemptStore) fail(ctx context.Context, key string, now time.Time) (loginAttempts, error) {
+	failures, err := failAttemptScript.Run(ctx, st.client, []string{st.redisKey(key)},
+		now.UnixMilli(), st.ttl.Milliseconds()).Int()
+	if err != nil {
+		return loginAttempts{}, err
+	}
+
+	return loginAttempts{Failures: failures, LastFailedAt: time.UnixMilli(now.UnixMilli())}, nil
+}
+
+func (st *redisAttemptStore) reset(ctx context.Context, key string) error {
+	return st.client.Del(ctx, st.redisKey(key)).Err()
+}
+
+// gormAttemptStore keeps the failed attempts in the login_attempts table
+type gormAttemptStore struct {
+	db  *gorm.DB
+	ttl time.Duration
+}
+
+func (st *gormAttemptStore) get(ctx context.Context, key string, now time.Time) (loginAttempts, error) {
+	row := LoginAttempt{}
+	err := st.db.WithContext(ctx).Where("key = ? AND last_failed_at >= ?", key, now.Add(-st.ttl)).First(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return loginAttempts{}, nil
+	} else if err != nil {
+		return loginAttempts{}, err
+	}
+
+	return loginAttempts{Failures: row.Failures, LastFailedAt: row.LastFailedAt}, nil
+}
+
+func (st *gormAttemptStore) fail(ctx context.Context, key string, now time.Time) (loginAttempts, error) {
+	db := st.db.WithContext(ctx)
+	err := db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "key"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			"failures": gorm.Expr(
+				"CASE WHEN login_attempts.last_failed_at < ? THEN 1 ELSE login_attempts.failures + 1 END",
+				now.Add(-st.ttl),
+			),
+			"last_failed_at": now,
+		}),
+	}).Create(&LoginAttempt{Key: key, Failures: 1, LastFailedAt: now}).Error
+	if err != nil {
+		return loginAttempts{}, err
+	}
+
+	return st.get(ctx, key, now)
+}
+
+func (st *gormAttemptStore) reset(ctx context.Context, key string) error {
+	return st.db.WithContext(ctx).Where("key = ?", key).Delete(&LoginAttempt{}).Error
+}
+
+// loginThrottle delays the attempts of the keys with recent failures
+type loginThrottle struct {
+	store attemptStore
+	// backoffAfter is the number of failures of an account before each further
+	// attempt has to wait twice as long as the previous one
+	backoffAfter int
+	// lockoutAfter is the number of failures locking an account
+	lockoutAfter int
+	// ipLockoutAfter is the number of failures locking all the accounts for an IP
+	ipLockoutAfter int
+	// ipRequestLimit is the number of logins an IP can attempt, failed or not
+	ipRequestLimit int
+	// requestLimit is the number of passwordless tokens a target can ask for
+	requestLimit int
+	lockout      time.Duration
+}
+
+// retryAfter returns how long the key has to wait before its next attempt
+func (lt *loginThrottle) retryAfter(a loginAttempts, backoffAfter, lockoutAfter int, now time.Time) time.Duration {
+	var wait time.Duration
+	switch {
+	case a.Failures >= lockoutAfter:
+		wait = lt.lockout
+	case a.Failures >= backoffAfter:
+		wait = min(time.Second<<(a.Failures-backoffAfter), lt.lockout)
+	default:
+		return 0
+	}
+
+	return max(a.LastFailedAt.Add(wait).Sub(now), 0)
+}
+
+// accountAttemptKey returns the attempt key of the email or mobile a user logs in with
+func accountAttemptKey(identifier string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(identifier))
+}
+
+// ipAttemptKey returns the attempt key of the client IP
+func ipAttemptKey(ip string) string {
+	return "ip:" + ip
+}
+
+// mfaAttemptKey returns the attempt key of the MFA codes of the user
+func mfaAttemptKey(userID uint) string {
+	return fmt.Sprintf("mfa:%d", userID)
+}
+
+// loginRequestKey returns the request key of the logins of the client IP
+func loginRequestKey(ip string) string {
+	return "login:" + ip
+}
+
+// passwordlessRequestKey returns the request key of the email or mobile asking for passwordless tokens
+func passwordlessRequestKey(target string) string {
+	return "passwordless:" + strings.ToLower(target)
+}
+
+// otpAttemptKey returns the attempt key of the one-time codes sent to the mobile
+func otpAttemptKey(mobile string) string {
+	return "otp:" + mobile
+}
+
+// countRequest counts a request of the key, once the key made limit requests
+// it gets ErrTooManyAttempts until it made none for the lockout duration. The
+// request is counted before it is checked, so concurrent requests cannot get
+// past the limit. Errors of the store let the request through
+func (s *Service) countRequest(key string, limit int) error {
+	if s.throttle == nil {
+		return nil
+	}
+
+	ctx, now := s.getContext(), s.now()
+	a, err := s.throttle.store.fail(ctx, key, now)
+	if err != nil {
+		s.l.Sugar().Warnw("failed to count the request", "key", key, "error", err)
+		return nil
+	}
+	if a.Failures > limit {
+		return ErrTooManyAttempts.WithRetryAfter(s.throttle.lockout)
+	}
+
+	return nil
+}
+
+// countLoginRequest counts a login of the client IP before the password is
+// hashed, so an IP cannot keep the server hashing passwords with attempts
+// whose failures are not counted yet
+func (s *Service) countLoginRequest(ip string) error {
+	if s.throttle == nil {
+		return nil
+	}
+
+	if err := s.countRequest(loginRequestKey(ip), s.throttle.ipRequestLimit); err != nil {
+		s.auditFailure(AuditLoginThrottled, 0, "ip_key", loginRequestKey(ip))
+		return err
+	}
+	return nil
+}
+
+// withPasswordlessThrottle returns the service counting the attempts with the
+// passwordless throttle, which DisableLoginThrottling does not disable: every
+// token is a message sent and the one-time codes are short
+func (s *Service) withPasswordlessThrottle() *Service {
+	c := *s
+	c.throttle = s.passwordlessThrottle
+	return &c
+}
+
+// countPasswordlessRequest counts a magic link or one-time code asked for the email or mobile
+func (s *Service) countPasswordlessRequest(target string) error {
+	s = s.withPasswordlessThrottle()
+	if s.throttle == nil {
+		return nil
+	}
+
+	return s.countRequest(passwordlessRequestKey(target), s.throttle.requestLimit)
+}
+
+// checkAttempts returns ErrTooManyAttempts while the account key or the IP key
+// has to wait, the IP key can be empty. Errors of the store let the attempt
+// through, like the rate limiter
+func (s *Service) checkAttempts(accountKey, ipKey string) error {
+	if s.throttle == nil {
+		return nil
+	}
+
+	ctx, now := s.getContext(), s.now()
+	account, err := s.throttle.store.get(ctx, accountKey, now)
+	if err != nil {
+		s.l.Sugar().Warnw("failed to get the failed attempts", "key", accountKey, "error", err)
+		return nil
+	}
+	wait := s.throttle.retryAfter(account, s.throttle.backoffAfter, s.throttle.lockoutAfter, now)
+
+	if ipKey != "" {
+		ip, err := s.throttle.store.get(ctx, ipKey, now)
+		if err != nil {
+			s.l.Sugar().Warnw("failed to get the failed attempts", "key", ipKey, "error", err)
+			return nil
+		}
+		wait = max(wait, s.throttle.retryAfter(ip, s.throttle.ipLockoutAfter, s.throttle.ipLockoutAfter, now))
+	}
+
+	if wait > 0 {
+		s.auditFailure(AuditLoginThrottled, 0, "key", accountKey, "ip_key", ipKey)
+		return ErrTooManyAttempts.WithRetryAfter(wait.Truncate(time.Second) + time.Second)
+	}
+
+	return nil
+}
+
+// failAttempt counts a failed attempt of the account key and the IP key, it
+// reports whether the attempt locked the account
+func (s *Service) failAttempt(accountKey, ipKey string) bool {
+	if s.throttle == nil {
+		return false
+	}
+
+	ctx, now := s.getContext(), s.now()
+	if ipKey != "" {
+		if _, err := s.throttle.store.fail(ctx, ipKey, now); err != nil {
+			s.l.Sugar().Warnw("failed to count the failed attempt", "key", ipKey, "error", err)
+		}
+	}
+
+	account, err := s.throttle.store.fail(ctx, accountKey, now)
+	if err != nil {
+		s.l.Sugar().Warnw("failed to count the failed attempt", "key", accountKey, "error", err)
+		return false
+	}
+
+	return account.Failures == s.throttle.lockoutAfter
+}
+
+// resetAttempts forgets the failed attempts of the keys
+func (s *Service) resetAttempts(keys ...string) {
+	if s.throttle == nil {
+		return
+	}
+
+	for _, key := range keys {
+		if err := s.throttle.store.reset(s.getContext(), key); err != nil {
+			s.l.Sugar().Warnw("failed to reset the failed attempts", "key", key, "error", err)
+		}
+	}
+}
+
+// loginFailed counts the failed login of the email or mobile, the user is nil
+// for unknown emails. The user gets an unlock token when the account is locked
+func (s *Service) loginFailed(identifier, ip string, user *User) {
+	var userID uint
+	if user != nil {
+		userID = user.ID
+	}
+	s.auditFailure(AuditLoginFailed, userID)
+
+	if !s.failAttempt(accountAttemptKey(identifier), ipAttemptKey(ip)) {
+		return
+	}
+
+	s.audit(AuditAccountLocked, userID, "reason", "login")
+	if user == nil || user.Email == "" {
+		return
+	}
+
+	token, err := s.createVerifyToken(user.Email, unlockPurpose, 0)
+	if err != nil {
+		s.l.Sugar().Errorw("failed to create the unlock token", "user_id", user.ID, "error", err)
+		return
+	}
+
+	// failures are logged by notify, the failed login is rejected either way
+	_ = s.notify(Message{Kind: MessageUnlock, Channel: ChannelEmail, To: user.Email, Name: user.Name, Token: token})
+}
+
+// UnlockAccount forgets the failed logins of the user of the unlock token
+func (s *Service) UnlockAccount(token string) error {
+	v, err := s.getVerification(token, unlockPurpose)
+	if err != nil {
+		return err
+	}
+	if v.ExpiresAt.Before(s.now()) {
+		return ErrExpiredToken
+	}
+
+	user, err := s.GetUserByEmail(v.Target)
+	if errors.Is(err, ErrUserNotFound) {
+		return ErrInvalidVerifyToken
+	} else if err != nil {
+		return err
+	}
+
+	// unlock tokens can only be used once, the row is deleted for good since a
+	// soft deleted row would hide the next unlock token upserted on its target
+	if err := s.db.Unscoped().Delete(v).Error; err != nil {
+		return err
+	}
+
+	keys := []string{accountAttemptKey(user.Email)}
+	if user.Mobile != "" {
+		keys = append(keys, accountAttemptKey(user.Mobile.String()))
+	}
+	s.resetAttempts(keys...)
+	s.audit(AuditAccountUnlocked, user.ID)
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testAttemptStore(t *testing.T, st attemptStore, ttl time.Duration) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	a, err := st.get(ctx, "account:a", now)
+	require.NoError(t, err)
+	assert.Zero(t, a.Failures)
+
+	for i := 1; i <= 3; i++ {
+		a, err = st.fail(ctx, "account:a", now)
+		require.NoError(t, err)
+		assert.Equal(t, i, a.Failures)
+	}
+	_, err = st.fail(ctx, "account:b", now)
+	require.NoError(t, err)
+
+	a, err = st.get(ctx, "account:a", now.Add(ttl))
+	require.NoError(t, err)
+	assert.Equal(t, 3, a.Failures)
+	assert.WithinDuration(t, now, a.LastFailedAt, time.Millisecond)
+
+	// failures are forgotten after the ttl without failures
+	later := now.Add(ttl + time.Second)
+	a, err = st.get(ctx, "account:a", later)
+	require.NoError(t, err)
+	assert.Zero(t, a.Failures)
+	a, err = st.fail(ctx, "account:a", later)
+	require.NoError(t, err)
+	assert.Equal(t, 1, a.Failures)
+
+	require.NoError(t, st.reset(ctx, "account:a"))
+	a, err = st.get(ctx, "account:a", later)
+	require.NoError(t, err)
+	assert.Zero(t, a.Failures)
+	a, err = st.get(ctx, "account:b", now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, a.Failures)
+}
+
+func TestRedisAttemptStore(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	testAttemptStore(t, &redisAttemptStore{client: client, ttl: time.Hour}, time.Hour)
+}
+
+func TestGormAttemptStore(t *testing.T) {
+	s := newTestService(t)
+	testAttemptStore(t, &gormAttemptStore{db: s.db, ttl: time.Hour}, time.Hour)
+}
+
+func TestRetryAfter(t *testing.T) {
+	lt := &loginThrottle{lockout: 15 * time.Minute}
+	now := time.Now()
+
+	for failures, wait := range map[int]time.Duration{
+		0: 0, 2: 0, 3: time.Second, 4: 2 * time.Second, 6: 8 * time.Second, 9: 64 * time.Second, 10: 15 * time.Minute,
+	} {
+		assert.Equal(t, wait, lt.retryAfter(loginAttempts{Failures: failures, LastFailedAt: now}, 3, 10, now), failures)
+	}
+
+	assert.Equal(t, time.Duration(0), lt.retryAfter(loginAttempts{Failures: 4, LastFailedAt: now}, 3, 10, now.Add(3*time.Second)))
+}
+
+func TestLoginLockout(t *testing.T) {
+	s, clock, user := newMFATestService(t)
+	notifier := &recordingNotifier{}
+	s.notifier = notifier
+
+	locked := func(identifier string) bool {
+		err := s.checkAttempts(accountAttemptKey(identifier), ipAttemptKey("10.0.0.1"))
+		if err != nil {
+			assert.ErrorIs(t, err, ErrTooManyAttempts)
+		}
+		return err != nil
+	}
+
+	// unknown emails are throttled like known ones, so they cannot be told apart
+	for _, email := range []string{"unknown@example.com", user.Email} {
+		var u *User
+		if email == user.Email {
+			u = user
+		}
+
+		for range s.throttle.backoffAfter {
+			require.False(t, locked(email))
+			s.loginFailed(email, "10.0.0.1", u)
+		}
+		assert.True(t, locked(email), "backoff after %d failures", s.throttle.backoffAfter)
+
+		for failures := s.throttle.backoffAfter; failures < s.throttle.lockoutAfter; failures++ {
+			clock.add(time.Second << (failures - s.throttle.backoffAfter))
+			require.False(t, locked(email))
+			s.loginFailed(email, "10.0.0.1", u)
+		}
+		clock.add(10 * time.Minute)
+		assert.True(t, locked(email), "locked after %d failures", s.throttle.lockoutAfter)
+	}
+	assert.True(t, locked("User@Example.com"))
+	assert.False(t, locked("other@example.com"))
+	assert.False(t, locked("unknown@example.com"), "the first lockout ended")
+
+	require.Len(t, notifier.messages, 1, "only the known user gets an unlock message")
+	msg := notifier.messages[0]
+	assert.Equal(t, MessageUnlock, msg.Kind)
+	assert.Equal(t, user.Email, msg.To)
+
+	require.NoError(t, s.UnlockAccount(msg.Token))
+	assert.False(t, locked(user.Email))
+	assert.ErrorIs(t, s.UnlockAccount(msg.Token), ErrInvalidVerifyToken)
+
+	// the next lockout gets a new unlock token
+	for range s.throttle.lockoutAfter {
+		s.loginFailed(user.Email, "10.0.0.3", user)
+	}
+	require.Len(t, notifier.messages, 2)
+	require.NoError(t, s.UnlockAccount(notifier.messages[1].Token))
+}
+
+func TestLoginIPLockout(t *testing.T) {
+	s, _, _ := newMFATestService(t)
+	s.throttle.ipLockoutAfter = 3
+
+	for i := range 3 {
+		s.loginFailed(string(rune('a'+i))+"@example.com", "10.0.0.2", nil)
+	}
+
+	err := s.checkAttempts(accountAttemptKey("z@example.com"), ipAttemptKey("10.0.0.2"))
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+	assert.NoError(t, s.checkAttempts(accountAttemptKey("z@example.com"), ipAttemptKey("10.0.0.3")))
+}
+
+func TestLoginIPRequestLimit(t *testing.T) {
+	s, clock, _ := newMFATestService(t)
+	s.throttle.ipRequestLimit = 3
+
+	// successful logins count too, the password is not hashed yet
+	for range 3 {
+		require.NoError(t, s.countLoginRequest("10.0.0.2"))
+	}
+	assert.ErrorIs(t, s.countLoginRequest("10.0.0.2"), ErrTooManyAttempts)
+	assert.NoError(t, s.countLoginRequest("10.0.0.3"), "the limit is per IP")
+
+	clock.add(s.throttle.lockout + time.Second)
+	assert.NoError(t, s.countLoginRequest("10.0.0.2"))
+}
@@ -0,0 +1,365 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/unluckythoughts/go-microservice/v2/tools/web"
+	"github.com/unluckythoughts/go-microservice/v2/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	// mfaTokenAudience is the audience of the MFA challenge tokens, so they are
+	// never accepted as access tokens
+	mfaTokenAudience = "mfa"
+	// mfaTokenValid is the time users have to enter their code after the password
+	mfaTokenValid      = 5 * time.Minute
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+)
+
+// getMFA returns the MFA settings of the user, nil if the user never enrolled
+func (s *Service) getMFA(userID uint) (*UserMFA, error) {
+	mfa := &UserMFA{}
+	err := s.db.Where("user_id = ?", userID).First(mfa).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+
+	return mfa, err
+}
+
+// IsMFAEnabled reports whether the user has to enter a code after the password
+func (s *Service) IsMFAEnabled(userID uint) (bool, error) {
+	mfa, err := s.getMFA(userID)
+	if err != nil {
+		return false, err
+	}
+
+	return mfa != nil && mfa.Enabled, nil
+}
+
+// GetMFAStatus returns whether MFA is enabled for the user and the number of
+// recovery codes left
+func (s *Service) GetMFAStatus(userID uint) (*MFAStatus, error) {
+	status := &MFAStatus{}
+	enabled, err := s.IsMFAEnabled(userID)
+	if err != nil || !enabled {
+		return status, err
+	}
+
+	status.Enabled = true
+	var count int64
+	err = s.db.Model(&MFARecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count).Error
+	status.RecoveryCodesLeft = int(count)
+	return status, err
+}
+
+// EnrollMFA creates a new TOTP secret for the user, MFA is enabled once
+// ConfirmMFA verified a first code of it
+func (s *Service) EnrollMFA(user *User) (*MFAEnrollment, error) {
+	mfa, err := s.getMFA(user.ID)
+	if err != nil {
+		return nil, err
+	} else if mfa != nil && mfa.Enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	// enrolling again replaces the secret that was never confirmed
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&UserMFA{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&UserMFA{UserID: user.ID, Secret: secret}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &MFAEnrollment{
+		Secret: secret,
+		URI:    totpURI(s.mfaIssuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmMFA enables MFA for the user if the code is valid for the enrolled
+// secret, it returns the recovery codes, which are only saved hashed. The
+// failed codes are throttled like the codes of VerifyMFA
+func (s *Service) ConfirmMFA(userID uint, code string) ([]string, error) {
+	var codes []string
+	err := s.throttleMFA(userID, func() error {
+		var err error
+		codes, err = s.confirmMFA(userID, code)
+		return err
+	})
+
+	return codes, err
+}
+
+// confirmMFA enables MFA for the user without throttling
+func (s *Service) confirmMFA(userID uint, code string) ([]string, error) {
+	mfa, err := s.getMFA(userID)
+	if err != nil {
+		return nil, err
+	} else if mfa == nil {
+		return nil, ErrMFANotEnrolled
+	} else if mfa.Enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	step, ok := validateTOTP(mfa.Secret, code, s.now())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	var codes []string
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&UserMFA{}).Where("user_id = ?", userID).
+			Updates(map[string]any{"enabled": true, "last_used_step": step}).Error
+		if err != nil {
+			return err
+		}
+
+		codes, err = createRecoveryCodes(tx, mfa)
+		return err
+	})
+
+	return codes, err
+}
+
+// VerifyMFA checks the TOTP or recovery code of the user, both can only be used
+// once. The failed codes are throttled per user, the user is locked out of MFA
+// after too many of them
+func (s *Service) VerifyMFA(userID uint, code string) error {
+	return s.throttleMFA(userID, func() error {
+		return s.verifyMFA(userID, code)
+	})
+}
+
+// throttleMFA runs check, a check of an MFA code of the user, with the failed
+// codes counted by the passwordless throttle: codes are short, they are
+// throttled even when the login throttling is disabled
+func (s *Service) throttleMFA(userID uint, check func() error) error {
+	// the account key of the codes has no IP key, the user logged in already
+	throttled, key := s.withPasswordlessThrottle(), mfaAttemptKey(userID)
+	if err := throttled.checkAttempts(key, ""); err != nil {
+		return err
+	}
+
+	if err := check(); err != nil {
+		if errors.Is(err, ErrInvalidMFACode) && throttled.failAttempt(key, "") {
+			s.audit(AuditAccountLocked, userID, "reason", "mfa")
+		}
+		return err
+	}
+	throttled.resetAttempts(key)
+
+	return nil
+}
+
+// verifyMFA checks the TOTP or recovery code of the user without throttling
+func (s *Service) verifyMFA(userID uint, code string) error {
+	mfa, err := s.getMFA(userID)
+	if err != nil {
+		return err
+	} else if mfa == nil || !mfa.Enabled {
+		return ErrMFANotEnabled
+	}
+
+	code = strings.TrimSpace(code)
+	if isTOTPCode(code) {
+		return s.useTOTPCode(mfa, code)
+	}
+
+	return s.useRecoveryCode(mfa, code)
+}
+
+// useTOTPCode checks the TOTP code, codes of the step of the last used code or
+// an earlier one are rejected so an intercepted code cannot be replayed
+func (s *Service) useTOTPCode(mfa *UserMFA, code string) error {
+	step, ok := validateTOTP(mfa.Secret, code, s.now())
+	if !ok || step <= mfa.LastUsedStep {
+		return ErrInvalidMFACode
+	}
+
+	res := s.db.Model(&UserMFA{}).
+		Where("user_id = ? AND last_used_step < ?", mfa.UserID, step).
+		Update("last_used_step", step)
+	if res.Error != nil {
+		return res.Error
+	} else if res.RowsAffected == 0 {
+		// a concurrent request used the code first
+		return ErrInvalidMFACode
+	}
+
+	return nil
+}
+
+// useRecoveryCode marks the recovery code of the user as used
+func (s *Service) useRecoveryCode(mfa *UserMFA, code string) error {
+	code = normalizeRecoveryCode(code)
+	if len(code) != recoveryCodeLength {
+		return ErrInvalidMFACode
+	}
+
+	res := s.db.Model(&MFARecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", mfa.UserID, hashRecoveryCode(mfa.Secret, code)).
+		Update("used_at", s.now())
+	if res.Error != nil {
+		return res.Error
+	} else if res.RowsAffected == 0 {
+		return ErrInvalidMFACode
+	}
+
+	s.l.Sugar().Infow("recovery code used", "user_id", mfa.UserID)
+	return nil
+}
+
+// DisableMFA disables MFA for the user after checking a code, the secret and
+// the recovery codes are deleted
+func (s *Service) DisableMFA(userID uint, code string) error {
+	if err := s.VerifyMFA(userID, code); err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&MFARecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&UserMFA{}).Error
+	})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user after
+// checking a code, the previous codes stop working
+func (s *Service) RegenerateRecoveryCodes(userID uint, code string) ([]string, error) {
+	if err := s.VerifyMFA(userID, code); err != nil {
+		return nil, err
+	}
+
+	mfa, err := s.getMFA(userID)
+	if err != nil {
+		return nil, err
+	} else if mfa == nil {
+		return nil, ErrMFANotEnabled
+	}
+
+	var codes []string
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&MFARecoveryCode{}).Error; err != nil {
+			return err
+		}
+
+		var err error
+		codes, err = createRecoveryCodes(tx, mfa)
+		return err
+	})
+
+	return codes, err
+}
+
+// hashRecoveryCode returns the hash saved for the normalized recovery code.
+// Recovery codes are random, so like refresh tokens they are looked up by a
+// fast hash, keyed with the TOTP secret of the user
+func hashRecoveryCode(secret, code string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// createRecoveryCodes saves new hashed recovery codes for the MFA of the user and returns them
+func createRecoveryCodes(tx *gorm.DB, mfa *UserMFA) ([]string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	rows := make([]MFARecoveryCode, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		code, err := utils.GenerateRandomString(recoveryCodeLength)
+		if err != nil {
+			return nil, err
+		}
+		half := recoveryCodeLength / 2
+		codes = append(codes, code[:half]+"-"+code[half:])
+		rows = append(rows, MFARecoveryCode{UserID: mfa.UserID, CodeHash: hashRecoveryCode(mfa.Secret, code)})
+	}
+
+	if err := tx.Create(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// normalizeRecoveryCode removes the separators users may type in recovery codes
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// createMFAToken returns the challenge token exchanged with a code for the
+// tokens of the user
+func (s *Service) createMFAToken(userID uint) (string, error) {
+	now := s.now()
+	return s.keys.Sign(jwt.MapClaims{
+		"sub": strconv.Itoa(int(userID)),
+		"iss": s.jwtIssuer,
+		"aud": mfaTokenAudience,
+		"iat": now.Unix(),
+		"exp": now.Add(mfaTokenValid).Unix(),
+	})
+}
+
+// parseMFAToken returns the user of the challenge token
+func (s *Service) parseMFAToken(token string) (uint, error) {
+	parsed, err := s.keys.Parse(token,
+		jwt.WithIssuer(s.jwtIssuer),
+		jwt.WithAudience(mfaTokenAudience),
+		jwt.WithTimeFunc(s.now),
+	)
+	if err != nil {
+		return 0, ErrInvalidMFAToken.WithCause(err)
+	}
+
+	sub, err := parsed.Claims.GetSubject()
+	if err != nil {
+		return 0, ErrInvalidMFAToken.WithCause(err)
+	}
+	userID, err := strconv.Atoi(sub)
+	if err != nil || userID <= 0 {
+		return 0, ErrInvalidMFAToken
+	}
+
+	return uint(userID), nil
+}
+
+// getLoginResponse returns the tokens of the user who logged in, or the MFA
+// challenge when the user enabled MFA, suspended users are refused
+func (s *Service) getLoginResponse(r web.Request, user *User) (LoginResponse, error) {
+	if user.Disabled {
+		return LoginResponse{}, ErrUserDisabled
+	}
+
+	enabled, err := s.IsMFAEnabled(user.ID)
+	if err != nil {
+		return LoginResponse{}, ErrInternal.WithCause(err)
+	} else if !enabled {
+		return s.getAuthResponse(r, user)
+	}
+
+	token, err := s.createMFAToken(user.ID)
+	if err != nil {
+		return LoginResponse{}, ErrInternal.WithCause(err)
+	}
+
+	return LoginResponse{MFARequired: true, MFAToken: token}, nil
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"errors"
+
+	localcontext "github.com/unluckythoughts/go-microservice/v2/tools/context"
+	"github.com/unluckythoughts/go-microservice/v2/tools/web"
+)
+
+// getMFAError returns the coded errors of the MFA methods as is and wraps the others
+func getMFAError(err error) error {
+	for _, coded := range []error{
+		ErrInvalidMFACode, ErrInvalidMFAToken, ErrMFANotEnrolled, ErrMFANotEnabled, ErrMFAAlreadyEnabled,
+		ErrTooManyAttempts,
+	} {
+		if errors.Is(err, coded) {
+			return err
+		}
+	}
+
+	return ErrInternal.WithCause(err)
+}
+
+// VerifyMFAHandler exchanges the MFA challenge token of the login response and
+// a TOTP or recovery code for the tokens of the user
+// example path: POST .../mfa/verify
+func (s *Service) VerifyMFAHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, body MFAVerifyRequest) (LoginResponse, error) {
+		userID, err := s.parseMFAToken(body.MFAToken)
+		if err != nil {
+			return LoginResponse{}, err
+		}
+
+		if err := s.VerifyMFA(userID, body.Code); err != nil {
+			if errors.Is(err, ErrInvalidMFACode) {
+				s.auditFailure(AuditMFAVerified, userID)
+			}
+			return LoginResponse{}, getMFAError(err)
+		}
+		s.audit(AuditMFAVerified, userID)
+
+		user, err := s.GetUserByID(userID)
+		if errors.Is(err, ErrUserNotFound) {
+			return LoginResponse{}, ErrInvalidMFAToken
+		} else if err != nil {
+			return LoginResponse{}, ErrInternal.WithCause(err)
+		}
+
+		return s.getAuthResponse(r, user)
+	})(r)
+}
+
+// GetMFAStatusHandler returns whether the authenticated user enabled MFA
+// example path: GET .../mfa
+func (s *Service) GetMFAStatusHandler(r web.Request) (any, error) {
+	return handle(s, func(s *Service, r web.Request) (any, error) {
+		user, err := GetAuthenticatedUser(r)
+		if err != nil {
+			return nil, err
+		}
+
+		status, err := s.GetMFAStatus(user.ID)
+		if err != nil {
+			return nil, ErrInternal.WithCause(err)
+		}
+
+		return status, nil
+	})(r)
+}
+
+// EnrollMFAHandler creates a TOTP secret for the authenticated user, MFA is
+// enabled once a first code is confirmed
+// example path: POST .../mfa/enroll
+func (s *Service) EnrollMFAHandler(r web.Request) (any, error) {
+	return handle(s, func(s *Service, r web.Request) (any, error) {
+		user, err := GetAuthenticatedUser(r)
+		if err != nil {
+			return nil, err
+		}
+
+		enrollment, err := s.EnrollMFA(user)
+		if err != nil {
+			return nil, getMFAError(err)
+		}
+
+		return enrollment, nil
+	})(r)
+}
+
+// ConfirmMFAHandler enables MFA with a first code of the enrolled secret and
+// returns the recovery codes
+// example path: POST .../mfa/confirm
+func (s *Service) ConfirmMFAHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, body MFACodeRequest) (*MFARecoveryCodes, error) {
+		user, err := getAuthenticatedUser(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		codes, err := s.ConfirmMFA(user.ID, body.Code)
+		if err != nil {
+			return nil, getMFAError(err)
+		}
+
+		s.audit(AuditMFAEnabled, user.ID)
+		return &MFARecoveryCodes{RecoveryCodes: codes}, nil
+	})(r)
+}
+
+// DisableMFAHandler disables MFA after checking a TOTP or recovery code
+// example path: POST .../mfa/disable
+func (s *Service) DisableMFAHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, body MFACodeRequest) (string, error) {
+		user, err := getAuthenticatedUser(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		if err := s.DisableMFA(user.ID, body.Code); err != nil {
+			return "", getMFAError(err)
+		}
+
+		s.audit(AuditMFADisabled, user.ID)
+		return "MFA disabled successfully", nil
+	})(r)
+}
+
+// RegenerateRecoveryCodesHandler replaces the recovery codes after checking a
+// TOTP or recovery code
+// example path: POST .../mfa/recovery-codes
+func (s *Service) RegenerateRecoveryCodesHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, body MFACodeRequest) (*MFARecoveryCodes, error) {
+		user, err := getAuthenticatedUser(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		codes, err := s.RegenerateRecoveryCodes(user.ID, body.Code)
+		if err != nil {
+			return nil, getMFAError(err)
+		}
+		return &MFARecoveryCodes{RecoveryCodes: codes}, nil
+	})(r)
+}
@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fixedClock is a clock tests move forward by hand
+type fixedClock struct {
+	t time.Time
+}
+
+func (c *fixedClock) now() time.Time {
+	return c.t
+}
+
+func (c *fixedClock) add(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+// currentTOTPCode returns the TOTP code of the secret at t
+func currentTOTPCode(t *testing.T, secret string, at time.Time) string {
+	key, err := totpEncoding.DecodeString(secret)
+	require.NoError(t, err)
+	return totpCode(key, totpStep(at))
+}
+
+func newMFATestService(t *testing.T) (*Service, *fixedClock, *User) {
+	s := newTestService(t)
+	clock := &fixedClock{t: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	s.now = clock.now
+
+	user := &User{Name: "user", Email: "user@example.com", Password: "Password@123", Role: 1}
+	require.NoError(t, s.CreateUser(user))
+
+	return s, clock, user
+}
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B SHA1 test vectors, truncated to 6 digits
+	key := []byte("12345678901234567890")
+	for unix, code := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		assert.Equal(t, code, totpCode(key, totpStep(time.Unix(unix, 0))), unix)
+	}
+
+	secret := totpEncoding.EncodeToString(key)
+	at := time.Unix(1111111109, 0)
+	step, ok := validateTOTP(secret, "081804", at.Add(totpPeriod))
+	assert.True(t, ok, "codes of the previous step are accepted")
+	assert.Equal(t, totpStep(at), step)
+	_, ok = validateTOTP(secret, "081804", at.Add(2*totpPeriod))
+	assert.False(t, ok)
+}
+
+func TestMFAEnrollment(t *testing.T) {
+	s, clock, user := newMFATestService(t)
+
+	_, err := s.ConfirmMFA(user.ID, "123456")
+	assert.ErrorIs(t, err, ErrMFANotEnrolled)
+
+	enrollment, err := s.EnrollMFA(user)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(enrollment.URI, "otpauth://totp/microservice:user@example.com?"))
+	assert.Contains(t, enrollment.URI, "secret="+enrollment.Secret)
+
+	enabled, err := s.IsMFAEnabled(user.ID)
+	require.NoError(t, err)
+	assert.False(t, enabled, "MFA is only enabled after the first code")
+
+	_, err = s.ConfirmMFA(user.ID, "000000")
+	assert.ErrorIs(t, err, ErrInvalidMFACode)
+
+	codes, err := s.ConfirmMFA(user.ID, currentTOTPCode(t, enrollment.Secret, clock.t))
+	require.NoError(t, err)
+	assert.Len(t, codes, recoveryCodeCount)
+
+	status, err := s.GetMFAStatus(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, &MFAStatus{Enabled: true, RecoveryCodesLeft: recoveryCodeCount}, status)
+
+	_, err = s.EnrollMFA(user)
+	assert.ErrorIs(t, err, ErrMFAAlreadyEnabled)
+
+	// the code confirming MFA cannot be used again
+	assert.ErrorIs(t, s.VerifyMFA(user.ID, currentTOTPCode(t, enrollment.Secret, clock.t)), ErrInvalidMFACode)
+
+	clock.add(totpPeriod)
+	code := currentTOTPCode(t, enrollment.Secret, clock.t)
+	require.NoError(t, s.VerifyMFA(user.ID, code))
+	assert.ErrorIs(t, s.VerifyMFA(user.ID, code), ErrInvalidMFACode)
+
+	// recovery codes work once, with or without the separator
+	require.NoError(t, s.VerifyMFA(user.ID, strings.ToUpper(codes[0])))
+	assert.ErrorIs(t, s.VerifyMFA(user.ID, codes[0]), ErrInvalidMFACode)
+	require.NoError(t, s.VerifyMFA(user.ID, strings.ReplaceAll(codes[1], "-", "")))
+
+	status, err = s.GetMFAStatus(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, recoveryCodeCount-2, status.RecoveryCodesLeft)
+}
+
+func TestMFARegenerateAndDisable(t *testing.T) {
+	s, clock, user := newMFATestService(t)
+
+	enrollment, err := s.EnrollMFA(user)
+	require.NoError(t, err)
+	codes, err := s.ConfirmMFA(user.ID, currentTOTPCode(t, enrollment.Secret, clock.t))
+	require.NoError(t, err)
+
+	_, err = s.RegenerateRecoveryCodes(user.ID, "not-a-code")
+	assert.ErrorIs(t, err, ErrInvalidMFACode)
+
+	newCodes, err := s.RegenerateRecoveryCodes(user.ID, codes[0])
+	require.NoError(t, err)
+	assert.Len(t, newCodes, recoveryCodeCount)
+	assert.ErrorIs(t, s.VerifyMFA(user.ID, codes[1]), ErrInvalidMFACode, "previous codes stop working")
+
+	clock.add(totpPeriod)
+	require.NoError(t, s.DisableMFA(user.ID, currentTOTPCode(t, enrollment.Secret, clock.t)))
+
+	enabled, err := s.IsMFAEnabled(user.ID)
+	require.NoError(t, err)
+	assert.False(t, enabled)
+	assert.ErrorIs(t, s.VerifyMFA(user.ID, newCodes[0]), ErrMFANotEnabled)
+}
+
+func TestMFAToken(t *testing.T) {
+	s, clock, user := newMFATestService(t)
+
+	token, err := s.createMFAToken(user.ID)
+	require.NoError(t, err)
+
+	userID, err := s.parseMFAToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, userID)
+
+	// challenge tokens are not access tokens and access tokens are not challenge tokens
+	_, err = s.getUserDataFromAuthHeader("Bearer " + token)
+	assert.Error(t, err)
+	access, err := s.createAccessToken(user.ID, "", 0)
+	require.NoError(t, err)
+	_, err = s.parseMFAToken(access)
+	assert.ErrorIs(t, err, ErrInvalidMFAToken)
+
+	clock.add(mfaTokenValid + time.Second)
+	_, err = s.parseMFAToken(token)
+	assert.ErrorIs(t, err, ErrInvalidMFAToken)
+}
+
+func TestMFACodesThrottled(t *testing.T) {
+	s, clock, user := newMFATestService(t)
+
+	enrollment, err := s.EnrollMFA(user)
+	require.NoError(t, err)
+	codes, err := s.ConfirmMFA(user.ID, currentTOTPCode(t, enrollment.Secret, clock.t))
+	require.NoError(t, err)
+
+	// the codes checked by every MFA method count towards the same lockout
+	throttle := s.passwordlessThrottle
+	for range throttle.lockoutAfter {
+		_, err = s.RegenerateRecoveryCodes(user.ID, "0000000000")
+		if !assert.ErrorIs(t, err, ErrInvalidMFACode) {
+			break
+		}
+		clock.add(throttle.lockout / 2)
+	}
+	assert.ErrorIs(t, s.DisableMFA(user.ID, codes[0]), ErrTooManyAttempts)
+	assert.ErrorIs(t, s.VerifyMFA(user.ID, codes[0]), ErrTooManyAttempts)
+
+	clock.add(throttle.lockout + time.Second)
+	require.NoError(t, s.DisableMFA(user.ID, codes[0]))
+}
+
+func TestConfirmMFAThrottled(t *testing.T) {
+	s, clock, user := newMFATestService(t)
+	// the codes are throttled even without login throttling
+	s.throttle = nil
+
+	enrollment, err := s.EnrollMFA(user)
+	require.NoError(t, err)
+	throttle := s.passwordlessThrottle
+	for range throttle.lockoutAfter {
+		_, err = s.ConfirmMFA(user.ID, "000000")
+		if !assert.ErrorIs(t, err, ErrInvalidMFACode) {
+			break
+		}
+		clock.add(throttle.lockout / 2)
+	}
+
+	code := currentTOTPCode(t, enrollment.Secret, clock.t)
+	_, err = s.ConfirmMFA(user.ID, code)
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+	assert.ErrorIs(t, s.VerifyMFA(user.ID, code), ErrTooManyAttempts, "confirming and verifying share the lockout")
+
+	clock.add(throttle.lockout + time.Second)
+	_, err = s.ConfirmMFA(user.ID, currentTOTPCode(t, enrollment.Secret, clock.t))
+	require.NoError(t, err)
+}
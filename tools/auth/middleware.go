@@ -3,49 +3,110 @@ package auth
 import (
 	"errors"
 	"fmt"
-	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	localcontext "github.com/unluckythoughts/go-microservice/v2/tools/context"
+	"github.com/unluckythoughts/go-microservice/v2/tools/sessions"
 	"github.com/unluckythoughts/go-microservice/v2/tools/web"
 )
 
-func (s *Service) getAuthResponse(ctx localcontext.Context, user *User) (LoginResponse, error) {
+// userContextKey is the request context key of the authenticated user
+type userContextKey struct{}
+
+// getAuthResponse records a new session of the user on the device of the
+// request and returns its tokens
+func (s *Service) getAuthResponse(r web.Request, user *User) (LoginResponse, error) {
 	resp := LoginResponse{}
+	ctx := r.GetContext()
+
+	if user.Disabled {
+		return resp, ErrUserDisabled
+	}
 
-	err := ctx.PutSessionValue("user_id", user.ID)
+	session, err := s.createSession(user.ID, r.GetHeader("User-Agent"), web.GetClientIP(r))
 	if err != nil {
-		return resp, err
+		return resp, ErrInternal.WithCause(err)
 	}
 
-	// Generate a JWT token for the user
-	strToken, err := web.CreateJWT(s.jwtKey, jwt.MapClaims{
-		"sub": strconv.Itoa(int(user.ID)),
-		"iss": s.jwtIssuer,
-		"aud": s.jwtAudience,
-		"iat": time.Now().Unix(),
-		"exp": time.Now().Add(s.tokenValid).Unix(),
-	})
+	err = ctx.PutSessionValue(sessions.UserIDKey, user.ID)
+	if err != nil {
+		return resp, ErrInternal.WithCause(err)
+	}
+	err = ctx.PutSessionValue(sessionIDKey, session.SessionID)
+	if err != nil {
+		return resp, ErrInternal.WithCause(err)
+	}
+	// new sessions have no current organization until they switch to one
+	err = ctx.PutSessionValue(orgIDKey, uint(0))
 	if err != nil {
-		return resp, err
+		return resp, ErrInternal.WithCause(err)
 	}
 
-	resp.Token = strToken
+	resp.Token, err = s.createAccessToken(user.ID, session.SessionID, 0)
+	if err != nil {
+		return resp, ErrInternal.WithCause(err)
+	}
+
+	if s.refreshTokenValid > 0 {
+		// the refresh tokens of the session are of its family, revoking the session revokes them
+		resp.RefreshToken, err = s.createRefreshToken(s.db, user.ID, session.SessionID)
+		if err != nil {
+			return resp, ErrInternal.WithCause(err)
+		}
+	}
 
 	// Generate a CSRF token for this session so the client can include it
 	// in the X-CSRF-Token header on subsequent state-changing requests.
 	csrfToken, err := web.GenerateCSRFToken(ctx)
 	if err != nil {
-		return resp, fmt.Errorf("failed to generate CSRF token: %w", err)
+		return resp, ErrInternal.WithCause(fmt.Errorf("failed to generate CSRF token: %w", err))
 	}
 	resp.CSRFToken = csrfToken
 
 	return resp, nil
 }
 
+// accessClaims are the claims of the JWT tokens identifying the request
+type accessClaims struct {
+	userID    uint
+	sessionID string
+	orgID     uint
+	// actorID is the admin impersonating the user, 0 for the tokens of the user
+	actorID uint
+}
+
+// createAccessToken returns a JWT token for the user, its sid claim is the
+// session ID and its org claim the current organization, if any
+func (s *Service) createAccessToken(userID uint, sessionID string, orgID uint) (string, error) {
+	return s.signAccessToken(accessClaims{userID: userID, sessionID: sessionID, orgID: orgID}, time.Now().Add(s.tokenValid))
+}
+
+// signAccessToken returns a JWT token of the claims expiring at expiresAt, the
+// act claim of the impersonation tokens is the admin impersonating the user
+func (s *Service) signAccessToken(ac accessClaims, expiresAt time.Time) (string, error) {
+	claims := jwt.MapClaims{
+		"sub": strconv.Itoa(int(ac.userID)),
+		"iss": s.jwtIssuer,
+		"aud": s.jwtAudience,
+		"iat": time.Now().Unix(),
+		"exp": expiresAt.Unix(),
+	}
+	if ac.sessionID != "" {
+		claims["sid"] = ac.sessionID
+	}
+	if ac.orgID != 0 {
+		claims["org"] = strconv.FormatUint(uint64(ac.orgID), 10)
+	}
+	if ac.actorID != 0 {
+		claims["act"] = map[string]any{"sub": strconv.FormatUint(uint64(ac.actorID), 10)}
+	}
+
+	return s.keys.Sign(claims)
+}
+
 func (s *Service) isRouteIgnored(path string) bool {
 	for _, route := range s.ignoreRoutes {
 		if strings.HasPrefix(path, route) {
@@ -55,88 +116,186 @@ func (s *Service) isRouteIgnored(path string) bool {
 	return false
 }
 
-func (s *Service) getUserDataFromAuthHeader(headerValue string) (uint, error) {
+// getUserDataFromAuthHeader returns the user ID, session ID, organization ID
+// and impersonating admin ID of the JWT token of the header, the session ID is
+// empty for tokens issued before sessions were recorded and the organization
+// ID 0 without organization
+func (s *Service) getUserDataFromAuthHeader(headerValue string) (accessClaims, error) {
 	if headerValue == "" {
-		return 0, fmt.Errorf("authorization header is empty")
+		return accessClaims{}, fmt.Errorf("authorization header is empty")
 	}
 
 	headerValue = strings.TrimPrefix(headerValue, "Bearer ")
 
 	// Reject tokens that have been explicitly invalidated (e.g. logged out)
 	if s.isTokenInvalidated(headerValue) {
-		return 0, fmt.Errorf("token has been invalidated")
+		return accessClaims{}, fmt.Errorf("token has been invalidated")
 	}
 
-	token, err := web.ParseJWT(s.jwtKey, headerValue,
+	token, err := s.keys.Parse(headerValue,
 		jwt.WithIssuer(s.jwtIssuer),
 		jwt.WithAudience(s.jwtAudience),
 	)
 	if err != nil {
-		return 0, err
+		return accessClaims{}, err
 	}
 
 	if !token.Valid {
-		return 0, fmt.Errorf("invalid bearer token")
+		return accessClaims{}, fmt.Errorf("invalid bearer token")
 	}
 
 	// Get the claims from the token
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		return 0, fmt.Errorf("error getting claims from JWT token")
+		return accessClaims{}, fmt.Errorf("error getting claims from JWT token")
 	}
 
 	// Get the user ID from the claims
 	strUserID, err := claims.GetSubject()
 	if err != nil {
-		return 0, fmt.Errorf("error getting user ID from JWT token: %w", err)
+		return accessClaims{}, fmt.Errorf("error getting user ID from JWT token: %w", err)
 	}
 
 	// Convert the user ID to an integer
 	intUserID, err := strconv.Atoi(strUserID)
 	if err != nil {
-		return 0, fmt.Errorf("error converting user ID to integer: %w", err)
+		return accessClaims{}, fmt.Errorf("error converting user ID to integer: %w", err)
+	}
+
+	ac := accessClaims{userID: uint(intUserID)}
+	ac.sessionID, _ = claims["sid"].(string)
+
+	if strOrgID, ok := claims["org"].(string); ok {
+		orgID, err := strconv.ParseUint(strOrgID, 10, 0)
+		if err != nil {
+			return accessClaims{}, fmt.Errorf("error converting organization ID to integer: %w", err)
+		}
+		ac.orgID = uint(orgID)
+	}
+
+	if act, ok := claims["act"].(map[string]any); ok {
+		strActorID, _ := act["sub"].(string)
+		actorID, err := strconv.ParseUint(strActorID, 10, 0)
+		if err != nil || actorID == 0 {
+			return accessClaims{}, fmt.Errorf("invalid actor of the JWT token")
+		}
+		ac.actorID = uint(actorID)
 	}
 
-	return uint(intUserID), nil
+	return ac, nil
 }
+
+// getUserFromRequest returns the user authenticated by the API key, JWT token
+// or cookie session of the request, suspended users are refused. The current
+// organization of the request is put in the request context, see GetCurrentOrg
 func (s *Service) getUserFromRequest(r web.MiddlewareRequest) (*User, error) {
+	user, orgID, err := s.authenticateRequest(r)
+	if err != nil {
+		return nil, err
+	} else if user.Disabled {
+		return nil, ErrUserDisabled
+	}
+
+	if orgID != 0 {
+		// the tokens of members removed from the organization keep its ID until
+		// they are refreshed, their requests have no current organization
+		membership, err := s.WithContext(r.GetContext()).GetOrgMembership(orgID, user.ID)
+		if err == nil {
+			setCurrentOrg(r.GetContext(), membership)
+		} else if !errors.Is(err, ErrNotOrgMember) {
+			return nil, ErrInternal.WithCause(err)
+		}
+	}
+
+	return user, nil
+}
+
+// authenticateRequest returns the user of the request and the ID of its
+// current organization, 0 without organization
+func (s *Service) authenticateRequest(r web.MiddlewareRequest) (*User, uint, error) {
+	s = s.WithContext(r.GetContext())
+
+	// API keys need no CSRF token, browsers never send them on their own
+	if key, ok := getAPIKeyFromRequest(r); ok {
+		user, apiKey, err := s.AuthenticateAPIKey(key)
+		if errors.Is(err, ErrInvalidAPIKey) {
+			return nil, 0, err
+		} else if err != nil {
+			return nil, 0, ErrInternal.WithCause(err)
+		}
+
+		setAuthenticatedAPIKey(r.GetContext(), apiKey)
+		return user, 0, nil
+	}
+
 	authHeader := r.GetHeader("Authorization")
 	if authHeader != "" {
-		userID, err := s.getUserDataFromAuthHeader(authHeader)
+		claims, err := s.getUserDataFromAuthHeader(authHeader)
 		if err != nil {
-			return nil, web.NewError(http.StatusUnauthorized, fmt.Errorf("unauthorized: %w", err))
+			return nil, 0, ErrInvalidAuthToken.WithCause(err)
+		}
+		// impersonation tokens belong to the session of the admin
+		sessionUserID := claims.userID
+		if claims.actorID != 0 {
+			sessionUserID = claims.actorID
 		}
-		user, err := s.GetUserByID(userID)
+		if err := s.checkSession(sessionUserID, claims.sessionID, web.GetClientIP(r)); err != nil {
+			return nil, 0, err
+		}
+		user, err := s.GetUserByID(claims.userID)
 		if err != nil {
-			return nil, web.NewError(http.StatusUnauthorized, fmt.Errorf("unauthorized: Please log in to access this link; %w", err))
+			return nil, 0, ErrUnauthorized.WithCause(err)
+		}
+		if claims.actorID != 0 {
+			if err := s.setImpersonator(r, user, claims.actorID); err != nil {
+				return nil, 0, err
+			}
 		}
-		return user, nil
+
+		setAuthenticatedSession(r.GetContext(), claims.sessionID)
+		return user, claims.orgID, nil
 	}
 
 	// Session-based auth: validate CSRF on state-changing requests before trusting the session.
 	switch r.GetMethod() {
 	case "POST", "PUT", "PATCH", "DELETE":
 		if err := web.ValidateCSRFToken(r); err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 	}
 
-	strUserID, err := r.GetContext().GetSessionValue("user_id")
+	strUserID, err := r.GetContext().GetSessionValue(sessions.UserIDKey)
 	if err != nil {
-		return nil, web.NewError(http.StatusUnauthorized, errors.New("unauthorized: Please log in to access this link"))
+		return nil, 0, ErrUnauthorized.WithCause(err)
 	}
 
 	userID, ok := strUserID.(uint)
 	if !ok || userID <= 0 {
-		return nil, web.NewError(http.StatusUnauthorized, errors.New("unauthorized: Please log in to access this link"))
+		return nil, 0, ErrUnauthorized
+	}
+
+	// cookie sessions of logins before sessions were recorded have no session ID, they are refused
+	sessionID := ""
+	if val, err := r.GetContext().GetSessionValue(sessionIDKey); err == nil {
+		sessionID, _ = val.(string)
+	}
+	if err := s.checkSession(userID, sessionID, web.GetClientIP(r)); err != nil {
+		return nil, 0, err
 	}
 
 	user, err := s.GetUserByID(userID)
 	if err != nil {
-		return nil, web.NewError(http.StatusUnauthorized, fmt.Errorf("unauthorized: Please log in to access this link; %w", err))
+		return nil, 0, ErrUnauthorized.WithCause(err)
 	}
 
-	return user, nil
+	// the cookie session keeps the organization the session switched to
+	orgID := uint(0)
+	if val, err := r.GetContext().GetSessionValue(orgIDKey); err == nil {
+		orgID, _ = val.(uint)
+	}
+
+	setAuthenticatedSession(r.GetContext(), sessionID)
+	return user, orgID, nil
 }
 
 func (s *Service) GetAuthMiddleware() web.Middleware {
@@ -150,41 +309,71 @@ func (s *Service) GetAuthMiddleware() web.Middleware {
 			return err
 		}
 
-		r.GetContext().PutSessionValue("user", user)
+		setAuthenticatedUser(r.GetContext(), user)
 		return nil
 	}
 }
 
+// GetAuthenticatedUser returns the user authenticated by the auth middlewares,
+// the ImpersonatorID of the users impersonated by an admin is the ID of the
+// admin, see GetImpersonator and GetActor
 func GetAuthenticatedUser(r web.Request) (*User, error) {
-	user, err := r.GetContext().GetSessionValue("user")
-	if err != nil {
-		return nil, web.NewError(http.StatusUnauthorized, fmt.Errorf("unauthorized: %w", err))
-	}
+	return getAuthenticatedUser(r.GetContext())
+}
 
-	if user == nil {
-		return nil, web.NewError(http.StatusUnauthorized, fmt.Errorf("unauthorized: Please log in to access this link"))
-	}
+// setAuthenticatedUser puts the user in the request context, it is not put
+// in the session so it is never saved in the cookie or the session store
+func setAuthenticatedUser(ctx localcontext.Context, user *User) {
+	// the key only exists if another auth middleware already ran for the same user
+	_ = ctx.WithValue(userContextKey{}, user)
+}
 
-	authUser, ok := user.(*User)
-	if !ok {
-		return nil, web.NewError(http.StatusInternalServerError, fmt.Errorf("internal server error: user data is not valid"))
+// getAuthenticatedUser returns the user put in the request context by the auth middlewares
+func getAuthenticatedUser(ctx localcontext.Context) (*User, error) {
+	user, ok := ctx.Value(userContextKey{}).(*User)
+	if !ok || user == nil {
+		return nil, ErrUnauthorized
 	}
 
-	return authUser, nil
+	return user, nil
 }
 
+// EnsureRole returns a middleware allowing the users with the role or a higher one,
+// requests authenticated with an API key also need the "*" scope. Within the
+// current organization of the request, the organization roles (Options.OrgRoles)
+// are the role of the user in the organization
 func (s *Service) EnsureRole(role Role) web.Middleware {
+	return s.ensureRole(role, true)
+}
+
+// EnsureGlobalRole returns a middleware allowing the users whose own role is
+// the role or a higher one, whatever their role in the current organization.
+// It guards the routes administering all the users, e.g. RegisterAdminRoutes
+func (s *Service) EnsureGlobalRole(role Role) web.Middleware {
+	return s.ensureRole(role, false)
+}
+
+func (s *Service) ensureRole(role Role, inOrg bool) web.Middleware {
 	return func(r web.MiddlewareRequest) error {
 		user, err := s.getUserFromRequest(r)
 		if err != nil {
 			return err
 		}
 
-		if user.Role < role {
-			return web.NewError(http.StatusForbidden, fmt.Errorf("forbidden: You do not have permission to access this resource"))
+		if missing := missingAPIKeyScopes(r, "*"); len(missing) > 0 {
+			return ErrForbidden.WithDetails(map[string][]string{"missing_scopes": missing})
+		}
+
+		// memberships are on the scale of the organization roles, they never grant a user role
+		if membership := getCurrentOrg(r.GetContext()); inOrg && membership != nil && s.isOrgRole(role) {
+			if membership.Role < role {
+				return ErrForbidden
+			}
+		} else if user.Role < role {
+			return ErrForbidden
 		}
 
-		r.GetContext().PutSessionValue("user", user)
+		setAuthenticatedUser(r.GetContext(), user)
 
 		return nil
 	}
@@ -1,7 +1,6 @@
 package auth
 
 import (
-	"fmt"
 	"time"
 
 	"gorm.io/gorm"
@@ -19,17 +18,30 @@ type User struct {
 	// Google OAuth fields
 	GoogleID     string `gorm:"column:google_id" json:"-"`
 	GoogleAvatar string `gorm:"column:google_avatar" json:"google_avatar,omitempty"`
+	// ServiceAccount users are machine clients, they can only authenticate with API keys
+	ServiceAccount bool `gorm:"column:service_account;not null;default:false" json:"service_account"`
+	// Disabled users are suspended by an admin, they cannot log in or authenticate
+	Disabled bool `gorm:"column:disabled;not null;default:false" json:"disabled"`
+	// ImpersonatorID is the admin impersonating the authenticated user, it is not saved
+	ImpersonatorID uint `gorm:"-" json:"impersonator_id,omitempty"`
 }
 
 func (User) TableName() string {
 	return "users"
 }
 
-var ErrExpiredToken = fmt.Errorf("verification token has expired")
-
+// Verify is a token sent to an email or mobile, a target has a token per
+// purpose, user and device
 type Verify struct {
 	gorm.Model
-	Target    string    `gorm:"column:target;not null;uniqueIndex" json:"-"`
+	// Target is the email or mobile the token is sent to
+	Target string `gorm:"column:target;not null;uniqueIndex:idx_verify_target_purpose" json:"-"`
+	// Purpose is what the token is for: verify, reset, change, unlock, magic-link or otp
+	Purpose string `gorm:"column:purpose;not null;default:verify;uniqueIndex:idx_verify_target_purpose" json:"-"`
+	// UserID is the user changing its email or mobile to the target of the change tokens
+	UserID uint `gorm:"column:user_id;not null;default:0;uniqueIndex:idx_verify_target_purpose" json:"-"`
+	// Device is the hash of the device token the passwordless tokens are bound to
+	Device    string    `gorm:"column:device;not null;default:'';uniqueIndex:idx_verify_target_purpose" json:"-"`
 	Token     string    `gorm:"column:token;not null;uniqueIndex" json:"-"`
 	Verified  bool      `gorm:"column:verified;not null;default:false" json:"-"`
 	ExpiresAt time.Time `gorm:"column:expires_at;not null" json:"-"`
@@ -39,9 +51,250 @@ func (Verify) TableName() string {
 	return "verify"
 }
 
+// RefreshToken is a refresh token saved by its hash. Every refresh rotates it,
+// the tokens issued from the same log in share the family ID
+type RefreshToken struct {
+	ID        uint       `gorm:"primaryKey" json:"-"`
+	CreatedAt time.Time  `gorm:"column:created_at;not null" json:"-"`
+	UserID    uint       `gorm:"column:user_id;not null;index" json:"-"`
+	FamilyID  string     `gorm:"column:family_id;not null;index" json:"-"`
+	TokenHash string     `gorm:"column:token_hash;not null;uniqueIndex" json:"-"`
+	ExpiresAt time.Time  `gorm:"column:expires_at;not null" json:"-"`
+	RotatedAt *time.Time `gorm:"column:rotated_at" json:"-"`
+	RevokedAt *time.Time `gorm:"column:revoked_at" json:"-"`
+}
+
+func (RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
+
+// Permission is a named permission, e.g. orders:write
+type Permission struct {
+	ID          uint      `gorm:"primaryKey" json:"-"`
+	CreatedAt   time.Time `gorm:"column:created_at;not null" json:"created_at"`
+	Name        string    `gorm:"column:name;not null;uniqueIndex" json:"name"`
+	Description string    `gorm:"column:description;not null;default:''" json:"description"`
+}
+
+func (Permission) TableName() string {
+	return "permissions"
+}
+
+// RoleDefinition is a named role granting a set of permissions, users can have
+// several roles. Roles named like the ordinal roles (Options.UserRoles) are
+// granted to the users with that ordinal role or a higher one
+type RoleDefinition struct {
+	ID          uint         `gorm:"primaryKey" json:"-"`
+	CreatedAt   time.Time    `gorm:"column:created_at;not null" json:"created_at"`
+	UpdatedAt   time.Time    `gorm:"column:updated_at;not null" json:"updated_at"`
+	Name        string       `gorm:"column:name;not null;uniqueIndex" json:"name"`
+	Description string       `gorm:"column:description;not null;default:''" json:"description"`
+	Permissions []Permission `gorm:"many2many:role_permissions;joinForeignKey:RoleID;joinReferences:PermissionID" json:"permissions"`
+}
+
+func (RoleDefinition) TableName() string {
+	return "roles"
+}
+
+// UserRoleAssignment assigns a role to a user
+type UserRoleAssignment struct {
+	UserID    uint      `gorm:"column:user_id;primaryKey"`
+	RoleID    uint      `gorm:"column:role_id;primaryKey;index"`
+	CreatedAt time.Time `gorm:"column:created_at;not null"`
+}
+
+func (UserRoleAssignment) TableName() string {
+	return "user_roles"
+}
+
+// APIKey is an API key of a user or a service account saved by its hash,
+// the key is only returned when it is created
+type APIKey struct {
+	ID     uint   `gorm:"primaryKey" json:"id"`
+	UserID uint   `gorm:"column:user_id;not null;index" json:"user_id"`
+	Name   string `gorm:"column:name;not null;default:''" json:"name"`
+	// KeyID identifies the key, it is the part of the key between the prefix and the secret
+	KeyID string `gorm:"column:key_id;not null;uniqueIndex" json:"-"`
+	// Prefix is the start of the key, shown so users can recognise their keys
+	Prefix string `gorm:"column:prefix;not null" json:"prefix"`
+	Hash   string `gorm:"column:hash;not null" json:"-"`
+	// Scopes are the permissions granted to the requests authenticated with the key,
+	// when the user has them
+	Scopes     Scopes     `gorm:"column:scopes;type:text;not null" json:"scopes"`
+	CreatedAt  time.Time  `gorm:"column:created_at;not null" json:"created_at"`
+	ExpiresAt  *time.Time `gorm:"column:expires_at" json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `gorm:"column:last_used_at" json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `gorm:"column:revoked_at" json:"-"`
+}
+
+func (APIKey) TableName() string {
+	return "api_keys"
+}
+
+// LoginAttempt counts the recent failed logins of an account or an IP, it is
+// only used without Redis
+type LoginAttempt struct {
+	Key          string    `gorm:"column:key;primaryKey"`
+	Failures     int       `gorm:"column:failures;not null;default:0"`
+	LastFailedAt time.Time `gorm:"column:last_failed_at;not null"`
+}
+
+func (LoginAttempt) TableName() string {
+	return "login_attempts"
+}
+
+type UnlockRequest struct {
+	Token string `json:"token" valid:"required~token is required"`
+}
+
+type MagicLinkRequest struct {
+	Email string `json:"email" valid:"required~email is required,email~email is not valid"`
+}
+
+type OTPRequest struct {
+	Mobile string `json:"mobile" valid:"required~mobile is required,mobile~mobile is not valid"`
+}
+
+// PasswordlessResponse is the response of the magic link and OTP requests
+type PasswordlessResponse struct {
+	// DeviceToken binds the token sent to the user to the device that asked
+	// for it, clients without the session cookie send it back when verifying
+	DeviceToken string `json:"device_token"`
+}
+
+type VerifyMagicLinkRequest struct {
+	Token       string `json:"token" valid:"required~token is required"`
+	DeviceToken string `json:"device_token"`
+}
+
+type VerifyOTPRequest struct {
+	Mobile      string `json:"mobile" valid:"required~mobile is required,mobile~mobile is not valid"`
+	Code        string `json:"code" valid:"required~code is required"`
+	DeviceToken string `json:"device_token"`
+}
+
+// UserMFA is the TOTP secret of a user, MFA is enabled once a first code confirmed it
+type UserMFA struct {
+	UserID  uint   `gorm:"column:user_id;primaryKey"`
+	Secret  string `gorm:"column:secret;not null"`
+	Enabled bool   `gorm:"column:enabled;not null;default:false"`
+	// LastUsedStep is the time step of the last code used, codes cannot be used twice
+	LastUsedStep int64     `gorm:"column:last_used_step;not null;default:0"`
+	CreatedAt    time.Time `gorm:"column:created_at;not null"`
+	UpdatedAt    time.Time `gorm:"column:updated_at;not null"`
+}
+
+func (UserMFA) TableName() string {
+	return "user_mfa"
+}
+
+// MFARecoveryCode is a hashed one-time code logging in without the TOTP code
+type MFARecoveryCode struct {
+	ID        uint       `gorm:"primaryKey"`
+	UserID    uint       `gorm:"column:user_id;not null;index"`
+	CodeHash  string     `gorm:"column:code_hash;not null"`
+	CreatedAt time.Time  `gorm:"column:created_at;not null"`
+	UsedAt    *time.Time `gorm:"column:used_at"`
+}
+
+func (MFARecoveryCode) TableName() string {
+	return "mfa_recovery_codes"
+}
+
+// LoginResponse are the tokens of the user, or the MFA challenge when the user
+// enabled MFA, MFAToken is then exchanged with a code on /auth/mfa/verify
 type LoginResponse struct {
-	Token     string `json:"token"`
-	CSRFToken string `json:"csrf_token"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	CSRFToken    string `json:"csrf_token,omitempty"`
+	MFARequired  bool   `json:"mfa_required,omitempty"`
+	MFAToken     string `json:"mfa_token,omitempty"`
+}
+
+// ImpersonateRequest is the user an admin impersonates and the organization
+// of the user the requests act in, 0 for none
+type ImpersonateRequest struct {
+	UserID uint `path:"userId" valid:"required~user id is required"`
+	OrgID  uint `json:"org_id"`
+}
+
+// ImpersonationResponse is the token authenticating the requests of an admin
+// as the impersonated user until ExpiresAt, it cannot be refreshed
+type ImpersonationResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// MFAEnrollment is the TOTP secret to add to an authenticator app, URI is
+// usually shown as a QR code
+type MFAEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type MFAStatus struct {
+	Enabled           bool `json:"enabled"`
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+}
+
+// MFARecoveryCodes are the recovery codes, they are only returned once
+type MFARecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code" valid:"required~code is required"`
+}
+
+type MFAVerifyRequest struct {
+	MFAToken string `json:"mfa_token" valid:"required~mfa token is required"`
+	// Code is a TOTP code or a recovery code
+	Code string `json:"code" valid:"required~code is required"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" valid:"required~refresh token is required"`
+}
+
+// UserPermissions are the roles of a user, including the ones granted by its
+// ordinal role, and the permissions they grant
+type UserPermissions struct {
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+}
+
+type PermissionRequest struct {
+	Name        string `json:"name" valid:"required~name is required"`
+	Description string `json:"description"`
+}
+
+type RoleRequest struct {
+	Name        string   `json:"name" path:"name" valid:"required~name is required"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+type UserRolesRequest struct {
+	UserID uint     `json:"-" path:"userId" valid:"required~user id is required"`
+	Roles  []string `json:"roles"`
+}
+
+type CreateAPIKeyRequest struct {
+	UserID    uint       `json:"-" path:"userId"`
+	Name      string     `json:"name" valid:"required~name is required"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreateAPIKeyResponse is the created API key, Key is never returned again
+type CreateAPIKeyResponse struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+type ServiceAccountRequest struct {
+	Name string `json:"name" valid:"required~name is required"`
+	Role Role   `json:"role"`
 }
 
 type Credentials struct {
@@ -63,6 +316,12 @@ type UpdateUserRequest struct {
 	Mobile string `json:"mobile" valid:"mobile~mobile is not valid"`
 }
 
+// ConfirmChangeRequest confirms the new email or mobile of the user with the
+// token sent to it
+type ConfirmChangeRequest struct {
+	Token string `json:"token" valid:"required~token is required"`
+}
+
 type UpdatePasswordRequest struct {
 	VerifyToken string   `json:"verify_token" valid:"required~verification token is required"`
 	NewPassword Password `json:"new_password" valid:"password~invalid password"`
@@ -84,12 +343,223 @@ type GoogleOAuthRequest struct {
 	RedirectURI string `json:"redirect_uri" valid:"required~redirect URI is required"`
 }
 
-// AppleOAuthRequest represents the request for Apple OAuth login
-// type appleOAuthRequest struct {
-// 	Code        string `json:"code" valid:"required~authorization code is required"`
-// 	RedirectURI string `json:"redirect_uri" valid:"required~redirect URI is required"`
-// 	IDToken     string `json:"id_token" valid:"required~ID token is required"`
-// }
+// UserIdentity is an identity of a provider linked to a user, users log in with it
+type UserIdentity struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `gorm:"column:created_at;not null" json:"created_at"`
+	UserID    uint      `gorm:"column:user_id;not null;index" json:"-"`
+	Provider  string    `gorm:"column:provider;not null;uniqueIndex:idx_user_identities_provider_subject" json:"provider"`
+	// Subject is the ID of the user at the provider
+	Subject string `gorm:"column:subject;not null;uniqueIndex:idx_user_identities_provider_subject" json:"-"`
+	Email   string `gorm:"column:email;not null;default:''" json:"email"`
+}
+
+func (UserIdentity) TableName() string {
+	return "user_identities"
+}
+
+// UserSession is a login of a user on a device. The JWT tokens of the login
+// carry its SessionID in the sid claim and the cookie session keeps it, its
+// refresh tokens are of the family SessionID. Revoked sessions are rejected
+type UserSession struct {
+	ID        uint   `gorm:"primaryKey" json:"id"`
+	UserID    uint   `gorm:"column:user_id;not null;index" json:"-"`
+	SessionID string `gorm:"column:session_id;not null;uniqueIndex" json:"-"`
+	UserAgent string `gorm:"column:user_agent;not null;default:''" json:"user_agent"`
+	// IP is the IP of the last request of the session
+	IP         string     `gorm:"column:ip;not null;default:''" json:"ip"`
+	CreatedAt  time.Time  `gorm:"column:created_at;not null" json:"created_at"`
+	LastSeenAt time.Time  `gorm:"column:last_seen_at;not null" json:"last_seen_at"`
+	ExpiresAt  time.Time  `gorm:"column:expires_at;not null;index" json:"expires_at"`
+	RevokedAt  *time.Time `gorm:"column:revoked_at" json:"-"`
+	// OrgID is the organization the session switched to, its JWT tokens carry it
+	// in the org claim. 0 when the session has no current organization
+	OrgID uint `gorm:"column:org_id;not null;default:0" json:"org_id,omitempty"`
+	// Current is whether the session is the one of the request listing the sessions
+	Current bool `gorm:"-" json:"current"`
+}
+
+func (UserSession) TableName() string {
+	return "user_sessions"
+}
+
+// Organization is a tenant, its users are its members with a role within it
+type Organization struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `gorm:"column:name;not null" json:"name"`
+	CreatedAt time.Time `gorm:"column:created_at;not null" json:"created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at;not null" json:"updated_at"`
+}
+
+func (Organization) TableName() string {
+	return "organizations"
+}
+
+// OrgMember is the membership of a user in an organization, Role is the
+// ordinal role of the user within the organization
+type OrgMember struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`
+	OrgID     uint      `gorm:"column:org_id;not null;uniqueIndex:idx_org_members_org_user" json:"org_id"`
+	UserID    uint      `gorm:"column:user_id;not null;uniqueIndex:idx_org_members_org_user;index" json:"user_id"`
+	Role      Role      `gorm:"column:role;not null" json:"role"`
+	CreatedAt time.Time `gorm:"column:created_at;not null" json:"created_at"`
+}
+
+func (OrgMember) TableName() string {
+	return "org_members"
+}
+
+// OrgMembership is an organization of a user with the role of the user within it
+type OrgMembership struct {
+	Organization
+	Role Role `gorm:"column:role" json:"role"`
+}
+
+// OrgMemberInfo is a member of an organization with its name and email
+type OrgMemberInfo struct {
+	UserID   uint      `gorm:"column:user_id" json:"user_id"`
+	Name     string    `gorm:"column:name" json:"name"`
+	Email    string    `gorm:"column:email" json:"email,omitempty"`
+	Role     Role      `gorm:"column:role" json:"role"`
+	JoinedAt time.Time `gorm:"column:joined_at" json:"joined_at"`
+}
+
+// OrgInvitation invites the owner of an email to join an organization with a
+// role, the token sent to the email is saved by its hash
+type OrgInvitation struct {
+	ID        uint   `gorm:"primaryKey" json:"id"`
+	OrgID     uint   `gorm:"column:org_id;not null;uniqueIndex:idx_org_invitations_org_email" json:"org_id"`
+	Email     string `gorm:"column:email;not null;uniqueIndex:idx_org_invitations_org_email" json:"email"`
+	Role      Role   `gorm:"column:role;not null" json:"role"`
+	TokenHash string `gorm:"column:token_hash;not null;uniqueIndex" json:"-"`
+	// InvitedBy is the user who sent the invitation
+	InvitedBy uint      `gorm:"column:invited_by;not null;default:0" json:"invited_by"`
+	CreatedAt time.Time `gorm:"column:created_at;not null" json:"created_at"`
+	ExpiresAt time.Time `gorm:"column:expires_at;not null" json:"expires_at"`
+}
+
+func (OrgInvitation) TableName() string {
+	return "org_invitations"
+}
+
+// AuditEvent is a security event of the auth service, see Options.AuditSink
+type AuditEvent struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `gorm:"column:created_at;not null;index" json:"created_at"`
+	Action    string    `gorm:"column:action;not null;index" json:"action"`
+	Outcome   string    `gorm:"column:outcome;not null" json:"outcome"`
+	// ActorID is the authenticated user of the request, 0 for anonymous requests
+	ActorID uint `gorm:"column:actor_id;not null;default:0;index" json:"actor_id"`
+	// SubjectID is the user the event is about, 0 for unknown users
+	SubjectID uint         `gorm:"column:subject_id;not null;default:0;index" json:"subject_id"`
+	IP        string       `gorm:"column:ip;not null;default:''" json:"ip"`
+	UserAgent string       `gorm:"column:user_agent;not null;default:''" json:"user_agent"`
+	RequestID string       `gorm:"column:request_id;not null;default:''" json:"request_id"`
+	Details   AuditDetails `gorm:"column:details;type:text;not null;default:''" json:"details,omitempty"`
+}
+
+func (AuditEvent) TableName() string {
+	return "auth_audit"
+}
+
+// AuditQuery filters the audit events, UserID matches the actor or the subject
+// of the events. From is inclusive and To exclusive
+type AuditQuery struct {
+	UserID   uint      `query:"user_id"`
+	Action   string    `query:"action"`
+	Outcome  string    `query:"outcome"`
+	From     time.Time `query:"from"`
+	To       time.Time `query:"to"`
+	Page     int       `query:"page"`
+	PageSize int       `query:"page_size"`
+}
+
+// AuditEventPage is a page of the audit events matching a query, Total is the
+// number of matching events
+type AuditEventPage struct {
+	Events   []AuditEvent `json:"events"`
+	Total    int64        `json:"total"`
+	Page     int          `json:"page"`
+	PageSize int          `json:"page_size"`
+}
+
+// UserQuery filters, sorts and pages the users. Search matches the name, email
+// or mobile, Verified the users with a verified email or mobile. Sort is one of
+// id, name, email, role, created_at and updated_at, Order asc or desc
+type UserQuery struct {
+	Search      string    `query:"search"`
+	Email       string    `query:"email"`
+	Role        Role      `query:"role"`
+	Verified    *bool     `query:"verified"`
+	Disabled    *bool     `query:"disabled"`
+	CreatedFrom time.Time `query:"created_from"`
+	CreatedTo   time.Time `query:"created_to"`
+	Sort        string    `query:"sort"`
+	Order       string    `query:"order"`
+	Page        int       `query:"page"`
+	PageSize    int       `query:"page_size"`
+}
+
+// UserPage is a page of the users matching a query, Total is the number of
+// matching users
+type UserPage struct {
+	Users    []User `json:"users"`
+	Total    int64  `json:"total"`
+	Page     int    `json:"page"`
+	PageSize int    `json:"page_size"`
+}
+
+type UserRoleRequest struct {
+	UserID uint `json:"-" path:"userId" valid:"required~user id is required"`
+	Role   Role `json:"role" valid:"required~role is required"`
+}
+
+// DeleteUserRequest deletes the user of the path, hard deletes remove the user
+// and its data instead of marking it deleted
+type DeleteUserRequest struct {
+	UserID uint `path:"userId" valid:"required~user id is required"`
+	Hard   bool `query:"hard"`
+}
+
+type OrgRequest struct {
+	Name string `json:"name" valid:"required~name is required"`
+}
+
+// SwitchOrgRequest switches the current organization of the session, 0 leaves
+// the current organization
+type SwitchOrgRequest struct {
+	OrgID uint `json:"org_id"`
+}
+
+type OrgMemberRoleRequest struct {
+	UserID uint `json:"-" path:"userId" valid:"required~user id is required"`
+	Role   Role `json:"role" valid:"required~role is required"`
+}
+
+type InviteRequest struct {
+	Email string `json:"email" valid:"required~email is required,email~email is not valid"`
+	Role  Role   `json:"role" valid:"required~role is required"`
+}
+
+type AcceptInvitationRequest struct {
+	Token string `json:"token" valid:"required~token is required"`
+}
+
+type OAuthStartRequest struct {
+	Provider    string `path:"provider" valid:"required~provider is required"`
+	RedirectURI string `query:"redirect_uri" valid:"required~redirect URI is required"`
+}
+
+// OAuthStartResponse has the URL of the login page of the provider
+type OAuthStartResponse struct {
+	URL string `json:"url"`
+}
+
+type OAuthCallbackRequest struct {
+	Provider string `json:"-" path:"provider" valid:"required~provider is required"`
+	Code     string `json:"code" valid:"required~authorization code is required"`
+	State    string `json:"state" valid:"required~state is required"`
+}
 
 // GoogleUserInfo represents the user info from Google OAuth
 type googleUserInfo struct {
@@ -0,0 +1,313 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	htmltemplate "html/template"
+	"text/template"
+
+	"github.com/unluckythoughts/go-microservice/v2/tools/mail"
+	"go.uber.org/zap"
+)
+
+// MessageKind is the kind of message sent to a user
+type MessageKind string
+
+const (
+	// MessageVerify carries the token verifying an email or mobile
+	MessageVerify MessageKind = "verify"
+	// MessagePasswordReset carries the token resetting the password
+	MessagePasswordReset MessageKind = "password_reset"
+	// MessageWelcome welcomes a registered user, it has no token
+	MessageWelcome MessageKind = "welcome"
+	// MessageUnlock carries the token unlocking an account locked after failed logins
+	MessageUnlock MessageKind = "unlock"
+	// MessageMagicLink carries the magic link logging in without a password
+	MessageMagicLink MessageKind = "magic_link"
+	// MessageOTP carries the one-time code logging in without a password
+	MessageOTP MessageKind = "otp"
+	// MessageChange carries the token confirming the new email or mobile of a user
+	MessageChange MessageKind = "change"
+	// MessageChangeNotice tells the current email or mobile of a user that a
+	// change was asked for, it has no token
+	MessageChangeNotice MessageKind = "change_notice"
+	// MessageInvitation carries the token joining the organization of Message.Org
+	MessageInvitation MessageKind = "invitation"
+)
+
+// Channels the messages are sent on
+const (
+	ChannelEmail  = "email"
+	ChannelMobile = "mobile"
+)
+
+// Message is a message to a user, the templates render it
+type Message struct {
+	Kind MessageKind
+	// Channel is ChannelEmail or ChannelMobile
+	Channel string
+	// To is the email or the mobile the message is sent to
+	To string
+	// Name is the name of the user, empty when the user is not known yet
+	Name string
+	// Token is the verification, reset, change, unlock or invitation token, empty for welcome
+	// and change notice messages
+	Token string
+	// Link is the magic link, empty for the other kinds or without Options.MagicLinkURL
+	Link string
+	// Org is the name of the organization of the invitations
+	Org string
+}
+
+// Notifier sends the messages of the auth service to the users
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// MessageTemplate is the template of a message kind, the templates get the Message
+// as data. Email is an HTML template, Subject and Text are text templates
+type MessageTemplate struct {
+	Subject string
+	Email   string
+	// Text is the body of the text messages
+	Text string
+}
+
+// Templates are the templates of the message kinds
+type Templates map[MessageKind]MessageTemplate
+
+// DefaultTemplates returns the templates used for the kinds missing from the
+// templates given to the notifiers
+func DefaultTemplates() Templates {
+	return Templates{
+		MessageVerify: {
+			Subject: "Verify your email",
+			Email:   `<p>Hi{{with .Name}} {{.}}{{end}},</p><p>Your verification code is <b>{{.Token}}</b>.</p>`,
+			Text:    "Your verification code is {{.Token}}",
+		},
+		MessagePasswordReset: {
+			Subject: "Reset your password",
+			Email: `<p>Hi{{with .Name}} {{.}}{{end}},</p><p>Your password reset code is <b>{{.Token}}</b>.</p>` +
+				`<p>If you did not ask to reset your password, you can ignore this email.</p>`,
+			Text: "Your password reset code is {{.Token}}",
+		},
+		MessageWelcome: {
+			Subject: "Welcome",
+			Email:   `<p>Hi{{with .Name}} {{.}}{{end}},</p><p>Your account has been created.</p>`,
+			Text:    "Welcome{{with .Name}} {{.}}{{end}}, your account has been created",
+		},
+		MessageUnlock: {
+			Subject: "Your account has been locked",
+			Email: `<p>Hi{{with .Name}} {{.}}{{end}},</p><p>Your account has been locked after too many failed logins.</p>` +
+				`<p>Your unlock code is <b>{{.Token}}</b>.</p>`,
+			Text: "Your account has been locked after too many failed logins, your unlock code is {{.Token}}",
+		},
+		MessageMagicLink: {
+			Subject: "Your login link",
+			Email: `<p>Hi{{with .Name}} {{.}}{{end}},</p>` +
+				`{{if .Link}}<p><a href="{{.Link}}">Log in</a></p>{{else}}<p>Your login code is <b>{{.Token}}</b>.</p>{{end}}` +
+				`<p>It only works on the device you asked for it on. If you did not ask for it, you can ignore this email.</p>`,
+			Text: "{{if .Link}}Log in with {{.Link}}{{else}}Your login code is {{.Token}}{{end}}",
+		},
+		MessageOTP: {
+			Subject: "Your login code",
+			Email:   `<p>Your login code is <b>{{.Token}}</b>.</p>`,
+			Text:    "Your login code is {{.Token}}",
+		},
+		MessageChange: {
+			Subject: "Confirm your new {{.Channel}}",
+			Email:   `<p>Hi{{with .Name}} {{.}}{{end}},</p><p>Your confirmation code is <b>{{.Token}}</b>.</p>`,
+			Text:    "Your confirmation code is {{.Token}}",
+		},
+		MessageChangeNotice: {
+			Subject: "Your {{.Channel}} is being changed",
+			Email: `<p>Hi{{with .Name}} {{.}}{{end}},</p><p>A change of the {{.Channel}} of your account was asked for.</p>` +
+				`<p>If it was not you, change your password.</p>`,
+			Text: "A change of the {{.Channel}} of your account was asked for, if it was not you change your password",
+		},
+		MessageInvitation: {
+			Subject: "You are invited to join {{.Org}}",
+			Email:   `<p>You are invited to join <b>{{.Org}}</b>.</p><p>Your invitation code is <b>{{.Token}}</b>.</p>`,
+			Text:    "You are invited to join {{.Org}}, your invitation code is {{.Token}}",
+		},
+	}
+}
+
+// withDefaults returns the templates with the default templates of the missing kinds
+func (t Templates) withDefaults() Templates {
+	merged := DefaultTemplates()
+	for kind, tmpl := range t {
+		merged[kind] = tmpl
+	}
+
+	return merged
+}
+
+func (t Templates) get(kind MessageKind) (MessageTemplate, error) {
+	tmpl, ok := t[kind]
+	if !ok {
+		return MessageTemplate{}, fmt.Errorf("no template for message kind %s", kind)
+	}
+
+	return tmpl, nil
+}
+
+// renderText renders the text template with the message
+func renderText(name, text string, msg Message) (string, error) {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	buf := bytes.Buffer{}
+	if err := tmpl.Execute(&buf, msg); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// renderHTML renders the HTML template with the message, escaping its values
+func renderHTML(name, text string, msg Message) (string, error) {
+	tmpl, err := htmltemplate.New(name).Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	buf := bytes.Buffer{}
+	if err := tmpl.Execute(&buf, msg); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// EmailSender sends emails, it is implemented by mail.Service
+type EmailSender interface {
+	SendEmail(e *mail.Email) error
+}
+
+// MailNotifier sends the messages by email
+type MailNotifier struct {
+	sender    EmailSender
+	templates Templates
+}
+
+// NewMailNotifier returns a notifier sending the messages with the sender,
+// the templates override the default templates of their kinds
+func NewMailNotifier(sender EmailSender, templates Templates) *MailNotifier {
+	return &MailNotifier{sender: sender, templates: templates.withDefaults()}
+}
+
+func (n *MailNotifier) Notify(_ context.Context, msg Message) error {
+	tmpl, err := n.templates.get(msg.Kind)
+	if err != nil {
+		return err
+	}
+
+	subject, err := renderText(string(msg.Kind), tmpl.Subject, msg)
+	if err != nil {
+		return fmt.Errorf("could not render the subject of the %s email: %w", msg.Kind, err)
+	}
+	body, err := renderHTML(string(msg.Kind), tmpl.Email, msg)
+	if err != nil {
+		return fmt.Errorf("could not render the %s email: %w", msg.Kind, err)
+	}
+
+	return n.sender.SendEmail(&mail.Email{To: []string{msg.To}, Subject: subject, Body: body})
+}
+
+// TextSender sends text messages, it is implemented by alerts.TextClient
+type TextSender interface {
+	Send(message, number string) error
+}
+
+// TextNotifier sends the messages by text message
+type TextNotifier struct {
+	sender    TextSender
+	templates Templates
+}
+
+// NewTextNotifier returns a notifier sending the messages with the sender,
+// the templates override the default templates of their kinds
+func NewTextNotifier(sender TextSender, templates Templates) *TextNotifier {
+	return &TextNotifier{sender: sender, templates: templates.withDefaults()}
+}
+
+func (n *TextNotifier) Notify(_ context.Context, msg Message) error {
+	tmpl, err := n.templates.get(msg.Kind)
+	if err != nil {
+		return err
+	}
+
+	text, err := renderText(string(msg.Kind), tmpl.Text, msg)
+	if err != nil {
+		return fmt.Errorf("could not render the %s text message: %w", msg.Kind, err)
+	}
+
+	return n.sender.Send(text, msg.To)
+}
+
+// ChannelNotifier sends the messages with the notifier of their channel
+type ChannelNotifier struct {
+	Email  Notifier
+	Mobile Notifier
+}
+
+func (n ChannelNotifier) Notify(ctx context.Context, msg Message) error {
+	var notifier Notifier
+	switch msg.Channel {
+	case ChannelEmail:
+		notifier = n.Email
+	case ChannelMobile:
+		notifier = n.Mobile
+	}
+	if notifier == nil {
+		return fmt.Errorf("no notifier for channel %s", msg.Channel)
+	}
+
+	return notifier.Notify(ctx, msg)
+}
+
+// LogNotifier only logs the messages, for development and tests. The tokens
+// are only logged at debug level
+type LogNotifier struct {
+	l *zap.Logger
+}
+
+// NewLogNotifier returns a notifier logging the messages with the logger
+func NewLogNotifier(l *zap.Logger) *LogNotifier {
+	return &LogNotifier{l: l}
+}
+
+func (n *LogNotifier) Notify(_ context.Context, msg Message) error {
+	n.l.Info("message logged instead of sent",
+		zap.String("kind", string(msg.Kind)), zap.String("channel", msg.Channel), zap.String("to", msg.To))
+	if msg.Token != "" {
+		n.l.Debug("message token", zap.String("kind", string(msg.Kind)), zap.String("token", msg.Token))
+	}
+	return nil
+}
+
+// notify sends the message to the user, failures are logged and returned
+func (s *Service) notify(msg Message) error {
+	err := s.notifier.Notify(s.getContext(), msg)
+	if err != nil {
+		s.l.Sugar().Errorw("failed to send message",
+			"kind", msg.Kind, "channel", msg.Channel, "to", msg.To, "error", err)
+	}
+
+	return err
+}
+
+// sendWelcome sends the welcome message to the email of the new user, or its
+// mobile when it has no email. Failures do not fail the registration
+func (s *Service) sendWelcome(user *User) {
+	msg := Message{Kind: MessageWelcome, Channel: ChannelEmail, To: user.Email, Name: user.Name}
+	if user.Email == "" {
+		msg.Channel, msg.To = ChannelMobile, user.Mobile.String()
+	}
+
+	_ = s.notify(msg)
+}
@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/unluckythoughts/go-microservice/v2/tools/mail"
+)
+
+// recordingNotifier keeps the messages instead of sending them
+type recordingNotifier struct {
+	messages []Message
+}
+
+func (n *recordingNotifier) Notify(_ context.Context, msg Message) error {
+	n.messages = append(n.messages, msg)
+	return nil
+}
+
+type fakeEmailSender struct {
+	emails []*mail.Email
+}
+
+func (f *fakeEmailSender) SendEmail(e *mail.Email) error {
+	f.emails = append(f.emails, e)
+	return nil
+}
+
+type fakeTextSender struct {
+	texts   []string
+	numbers []string
+	err     error
+}
+
+func (f *fakeTextSender) Send(message, number string) error {
+	f.texts = append(f.texts, message)
+	f.numbers = append(f.numbers, number)
+	return f.err
+}
+
+func TestMailNotifier(t *testing.T) {
+	sender := &fakeEmailSender{}
+	n := NewMailNotifier(sender, Templates{
+		MessageWelcome: {Subject: "Hello {{.Name}}", Email: "<p>Welcome {{.Name}}</p>"},
+	})
+
+	msg := Message{Kind: MessageVerify, Channel: ChannelEmail, To: "user@example.com", Name: "<b>user</b>", Token: "123456"}
+	require.NoError(t, n.Notify(context.Background(), msg))
+	require.Len(t, sender.emails, 1)
+	assert.Equal(t, []string{"user@example.com"}, sender.emails[0].To)
+	assert.Equal(t, "Verify your email", sender.emails[0].Subject)
+	assert.Contains(t, sender.emails[0].Body, "<b>123456</b>")
+	assert.Contains(t, sender.emails[0].Body, "&lt;b&gt;user&lt;/b&gt;", "the values are escaped")
+
+	// templates override the defaults of their kind only
+	msg = Message{Kind: MessageWelcome, Channel: ChannelEmail, To: "user@example.com", Name: "user"}
+	require.NoError(t, n.Notify(context.Background(), msg))
+	require.Len(t, sender.emails, 2)
+	assert.Equal(t, "Hello user", sender.emails[1].Subject)
+	assert.Equal(t, "<p>Welcome user</p>", sender.emails[1].Body)
+
+	err := n.Notify(context.Background(), Message{Kind: "unknown", To: "user@example.com"})
+	assert.Error(t, err)
+}
+
+func TestTextNotifier(t *testing.T) {
+	sender := &fakeTextSender{}
+	n := NewTextNotifier(sender, nil)
+
+	msg := Message{Kind: MessagePasswordReset, Channel: ChannelMobile, To: "+1 5555555555", Token: "654321"}
+	require.NoError(t, n.Notify(context.Background(), msg))
+	assert.Equal(t, []string{"Your password reset code is 654321"}, sender.texts)
+	assert.Equal(t, []string{"+1 5555555555"}, sender.numbers)
+
+	sender.err = errors.New("unavailable")
+	assert.ErrorIs(t, n.Notify(context.Background(), msg), sender.err)
+}
+
+func TestChannelNotifier(t *testing.T) {
+	email, mobile := &recordingNotifier{}, &recordingNotifier{}
+	n := ChannelNotifier{Email: email, Mobile: mobile}
+
+	require.NoError(t, n.Notify(context.Background(), Message{Kind: MessageWelcome, Channel: ChannelEmail}))
+	require.NoError(t, n.Notify(context.Background(), Message{Kind: MessageWelcome, Channel: ChannelMobile}))
+	assert.Len(t, email.messages, 1)
+	assert.Len(t, mobile.messages, 1)
+
+	assert.Error(t, ChannelNotifier{Email: email}.Notify(context.Background(), Message{Channel: ChannelMobile}))
+}
+
+func TestSendWelcome(t *testing.T) {
+	s := newTestService(t)
+	notifier := &recordingNotifier{}
+	s.notifier = notifier
+
+	s.sendWelcome(&User{Name: "user", Email: "user@example.com"})
+	user := &User{Name: "mobile user"}
+	require.NoError(t, user.Mobile.Set("+1 5555555555"))
+	s.sendWelcome(user)
+
+	require.Len(t, notifier.messages, 2)
+	assert.Equal(t, Message{Kind: MessageWelcome, Channel: ChannelEmail, To: "user@example.com", Name: "user"}, notifier.messages[0])
+	assert.Equal(t, ChannelMobile, notifier.messages[1].Channel)
+	assert.Equal(t, user.Mobile.String(), notifier.messages[1].To)
+}
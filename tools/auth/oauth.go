@@ -0,0 +1,290 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	localcontext "github.com/unluckythoughts/go-microservice/v2/tools/context"
+	"github.com/unluckythoughts/go-microservice/v2/utils"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+const (
+	// oauthFlowSessionKey keeps the login with a provider in progress in the session
+	oauthFlowSessionKey = "oauth_flow"
+	// oauthFlowValid is the time users have to log in on the page of the provider
+	oauthFlowValid   = 10 * time.Minute
+	oauthStateLength = 32
+)
+
+// oauthFlow is a login with a provider in progress, it is kept in the session
+// of the browser between the redirect to the provider and the callback
+type oauthFlow struct {
+	Provider string      `json:"provider"`
+	Params   OAuthParams `json:"params"`
+	// LinkUserID is the user linking the identity, 0 for logins
+	LinkUserID uint      `json:"link_user_id,omitempty"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// getOAuthProviders returns the providers by name, the Google provider is added
+// for the GoogleOauth options
+func getOAuthProviders(opts Options) (map[string]OAuthProvider, error) {
+	providers := map[string]OAuthProvider{}
+	for _, p := range opts.OAuthProviders {
+		if p.Name() == "" {
+			return nil, fmt.Errorf("OAuth provider has no name")
+		} else if _, ok := providers[p.Name()]; ok {
+			return nil, fmt.Errorf("OAuth provider %s is set twice", p.Name())
+		}
+		providers[p.Name()] = p
+	}
+
+	_, ok := providers["google"]
+	if !ok && opts.GoogleOauth.ClientID != "" && opts.GoogleOauth.ClientSecret != "" {
+		providers["google"] = NewGoogleProvider(opts.GoogleOauth.ClientID, opts.GoogleOauth.ClientSecret)
+	}
+
+	return providers, nil
+}
+
+func (s *Service) getOAuthProvider(name string) (OAuthProvider, error) {
+	p, ok := s.oauthProviders[name]
+	if !ok {
+		return nil, ErrOAuthProviderNotFound
+	}
+
+	return p, nil
+}
+
+// startOAuth returns a new login with the provider redirecting to the redirect
+// URI, and the URL of the login page. linkUserID is the user linking the
+// identity, 0 for logins
+func (s *Service) startOAuth(provider, redirectURI string, linkUserID uint) (*oauthFlow, string, error) {
+	p, err := s.getOAuthProvider(provider)
+	if err != nil {
+		return nil, "", err
+	}
+
+	state, err := utils.GenerateRandomString(oauthStateLength)
+	if err != nil {
+		return nil, "", err
+	}
+	nonce, err := utils.GenerateRandomString(oauthStateLength)
+	if err != nil {
+		return nil, "", err
+	}
+
+	flow := &oauthFlow{
+		Provider: provider,
+		Params: OAuthParams{
+			RedirectURI:  redirectURI,
+			State:        state,
+			Nonce:        nonce,
+			CodeVerifier: oauth2.GenerateVerifier(),
+		},
+		LinkUserID: linkUserID,
+		ExpiresAt:  s.now().Add(oauthFlowValid),
+	}
+
+	url, err := p.AuthCodeURL(s.getContext(), flow.Params)
+	if err != nil {
+		return nil, "", ErrOAuthFailed.WithCause(err)
+	}
+
+	return flow, url, nil
+}
+
+// finishOAuth returns the identity of the code returned by the provider to the
+// callback, after checking the state of the flow
+func (s *Service) finishOAuth(flow *oauthFlow, provider, state, code string) (*OAuthIdentity, error) {
+	if flow == nil || flow.Provider != provider ||
+		subtle.ConstantTimeCompare([]byte(flow.Params.State), []byte(state)) != 1 {
+		return nil, ErrInvalidOAuthState
+	}
+	if flow.ExpiresAt.Before(s.now()) {
+		return nil, ErrInvalidOAuthState
+	}
+
+	p, err := s.getOAuthProvider(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := p.Exchange(s.getContext(), code, flow.Params)
+	if err != nil {
+		return nil, ErrOAuthFailed.WithCause(err)
+	}
+
+	return identity, nil
+}
+
+// saveOAuthFlow keeps the flow in the session, replacing the previous one
+func saveOAuthFlow(ctx localcontext.Context, flow *oauthFlow) error {
+	data, err := json.Marshal(flow)
+	if err != nil {
+		return err
+	}
+
+	return ctx.PutSessionValue(oauthFlowSessionKey, string(data))
+}
+
+// popOAuthFlow removes the flow from the session and returns it, nil if there is
+// none, so its state can only be used once
+func popOAuthFlow(ctx localcontext.Context) *oauthFlow {
+	session := ctx.GetSession()
+	if session == nil {
+		return nil
+	}
+
+	data, _ := session.Values[oauthFlowSessionKey].(string)
+	delete(session.Values, oauthFlowSessionKey)
+
+	flow := &oauthFlow{}
+	if err := json.Unmarshal([]byte(data), flow); err != nil {
+		return nil
+	}
+
+	return flow
+}
+
+// getIdentity returns the linked identity of the provider, nil if it is not linked
+func (s *Service) getIdentity(provider, subject string) (*UserIdentity, error) {
+	identity := &UserIdentity{}
+	err := s.db.Where("provider = ? AND subject = ?", provider, subject).First(identity).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+
+	return identity, err
+}
+
+// OAuthLogin returns the user of the identity of the provider. Identities that
+// are not linked yet are linked to the user of their email when both the
+// provider and the user verified it, or to a new user with the role when self
+// registration is enabled
+func (s *Service) OAuthLogin(provider string, identity *OAuthIdentity, role Role) (*User, error) {
+	linked, err := s.getIdentity(provider, identity.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if linked != nil {
+		user, err := s.GetUserByID(linked.UserID)
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, ErrIdentityNotLinked
+		} else if err != nil {
+			return nil, err
+		} else if user.ServiceAccount {
+			return nil, ErrIdentityNotLinked
+		}
+		return user, nil
+	}
+
+	if identity.Email != "" {
+		user, err := s.GetUserByEmail(identity.Email)
+		if err == nil {
+			// unverified emails could be anyone's, on either side: anyone could have
+			// signed up with the email and a password they know. Their users have
+			// to log in and link the identity
+			if !identity.EmailVerified || !user.EmailVerified || user.ServiceAccount {
+				return nil, ErrIdentityNotLinked
+			}
+			_, err = s.LinkIdentity(user.ID, provider, identity)
+			return user, err
+		} else if !errors.Is(err, ErrUserNotFound) {
+			return nil, err
+		}
+	}
+
+	if !s.selfRegistration || identity.Email == "" {
+		return nil, ErrIdentityNotLinked
+	}
+
+	user := &User{
+		Name:          identity.Name,
+		Email:         identity.Email,
+		EmailVerified: identity.EmailVerified,
+		Role:          role,
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		return tx.Create(&UserIdentity{
+			UserID:   user.ID,
+			Provider: provider,
+			Subject:  identity.Subject,
+			Email:    identity.Email,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.sendWelcome(user)
+
+	return user, nil
+}
+
+// LinkIdentity links the identity of the provider to the user, so the user can
+// log in with it
+func (s *Service) LinkIdentity(userID uint, provider string, identity *OAuthIdentity) (*UserIdentity, error) {
+	linked, err := s.getIdentity(provider, identity.Subject)
+	if err != nil {
+		return nil, err
+	} else if linked != nil {
+		if linked.UserID != userID {
+			return nil, ErrIdentityLinked
+		}
+		return linked, nil
+	}
+
+	linked = &UserIdentity{
+		UserID:   userID,
+		Provider: provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+	}
+	if err := s.db.Create(linked).Error; err != nil {
+		return nil, err
+	}
+
+	return linked, nil
+}
+
+// ListIdentities returns the identities linked to the user
+func (s *Service) ListIdentities(userID uint) ([]UserIdentity, error) {
+	identities := []UserIdentity{}
+	err := s.db.Where("user_id = ?", userID).Order("id").Find(&identities).Error
+	return identities, err
+}
+
+// UnlinkIdentity unlinks the identity from the user, users without a password
+// keep at least one identity
+func (s *Service) UnlinkIdentity(userID, id uint) error {
+	identity := &UserIdentity{}
+	err := s.db.Where("id = ? AND user_id = ?", id, userID).First(identity).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrIdentityNotFound
+	} else if err != nil {
+		return err
+	}
+
+	var withPassword, others int64
+	err = s.db.Model(&User{}).Where("id = ? AND password <> ''", userID).Count(&withPassword).Error
+	if err != nil {
+		return err
+	}
+	err = s.db.Model(&UserIdentity{}).Where("user_id = ? AND id <> ?", userID, id).Count(&others).Error
+	if err != nil {
+		return err
+	}
+	if withPassword == 0 && others == 0 {
+		return ErrLastLoginMethod
+	}
+
+	return s.db.Delete(identity).Error
+}
@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"errors"
+
+	localcontext "github.com/unluckythoughts/go-microservice/v2/tools/context"
+	"github.com/unluckythoughts/go-microservice/v2/tools/web"
+)
+
+// identityPathRequest is a request for the identity of the path
+type identityPathRequest struct {
+	ID uint `path:"id" valid:"required~identity id is required"`
+}
+
+// getOAuthError returns the coded errors of the OAuth methods as is and wraps the others
+func getOAuthError(err error) error {
+	for _, coded := range []error{
+		ErrOAuthProviderNotFound, ErrInvalidOAuthState, ErrOAuthFailed, ErrIdentityNotLinked,
+		ErrIdentityLinked, ErrIdentityNotFound, ErrLastLoginMethod,
+	} {
+		if errors.Is(err, coded) {
+			return err
+		}
+	}
+
+	return ErrInternal.WithCause(err)
+}
+
+// startOAuthFlow starts a login with the provider and keeps it in the session
+func (s *Service) startOAuthFlow(ctx localcontext.Context, req OAuthStartRequest, linkUserID uint) (OAuthStartResponse, error) {
+	flow, url, err := s.startOAuth(req.Provider, req.RedirectURI, linkUserID)
+	if err != nil {
+		return OAuthStartResponse{}, getOAuthError(err)
+	}
+
+	if err := saveOAuthFlow(ctx, flow); err != nil {
+		return OAuthStartResponse{}, ErrInternal.WithCause(err)
+	}
+
+	return OAuthStartResponse{URL: url}, nil
+}
+
+// StartOAuthHandler returns the URL of the login page of the provider, which
+// redirects to the redirect URI with the code and state for the callback
+// example path: GET .../oauth/:provider?redirect_uri=...
+func (s *Service) StartOAuthHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, req OAuthStartRequest) (OAuthStartResponse, error) {
+		return s.startOAuthFlow(ctx, req, 0)
+	})(r)
+}
+
+// GetOAuthCallbackHandler returns a handler logging in with the code returned
+// by the provider, new users are registered with the role
+// example path: POST .../oauth/:provider/callback
+func (s *Service) GetOAuthCallbackHandler(role Role) web.Handler {
+	return func(r web.Request) (any, error) {
+		return typed(s, func(s *Service, ctx localcontext.Context, req OAuthCallbackRequest) (LoginResponse, error) {
+			flow := popOAuthFlow(ctx)
+			if flow != nil && flow.LinkUserID != 0 {
+				return LoginResponse{}, ErrInvalidOAuthState
+			}
+
+			identity, err := s.finishOAuth(flow, req.Provider, req.State, req.Code)
+			if err != nil {
+				return LoginResponse{}, getOAuthError(err)
+			}
+
+			user, err := s.OAuthLogin(req.Provider, identity, role)
+			if err != nil {
+				return LoginResponse{}, getOAuthError(err)
+			}
+
+			s.audit(AuditOAuthLogin, user.ID, "provider", req.Provider)
+			return s.getLoginResponse(r, user)
+		})(r)
+	}
+}
+
+// StartLinkIdentityHandler returns the URL of the login page of the provider to
+// link its identity to the authenticated user
+// example path: GET .../oauth/:provider/link?redirect_uri=...
+func (s *Service) StartLinkIdentityHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, req OAuthStartRequest) (OAuthStartResponse, error) {
+		user, err := getAuthenticatedUser(ctx)
+		if err != nil {
+			return OAuthStartResponse{}, err
+		}
+
+		return s.startOAuthFlow(ctx, req, user.ID)
+	})(r)
+}
+
+// LinkIdentityHandler links the identity of the code returned by the provider
+// to the authenticated user
+// example path: POST .../oauth/:provider/link
+func (s *Service) LinkIdentityHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, req OAuthCallbackRequest) (*UserIdentity, error) {
+		user, err := getAuthenticatedUser(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		flow := popOAuthFlow(ctx)
+		if flow != nil && flow.LinkUserID != user.ID {
+			return nil, ErrInvalidOAuthState
+		}
+
+		identity, err := s.finishOAuth(flow, req.Provider, req.State, req.Code)
+		if err != nil {
+			return nil, getOAuthError(err)
+		}
+
+		linked, err := s.LinkIdentity(user.ID, req.Provider, identity)
+		if err != nil {
+			return nil, getOAuthError(err)
+		}
+
+		s.audit(AuditIdentityLinked, user.ID, "provider", req.Provider)
+		return linked, nil
+	})(r)
+}
+
+// ListIdentitiesHandler returns the identities linked to the authenticated user
+// example path: GET .../identities
+func (s *Service) ListIdentitiesHandler(r web.Request) (any, error) {
+	return handle(s, func(s *Service, r web.Request) (any, error) {
+		user, err := GetAuthenticatedUser(r)
+		if err != nil {
+			return nil, err
+		}
+
+		identities, err := s.ListIdentities(user.ID)
+		if err != nil {
+			return nil, ErrInternal.WithCause(err)
+		}
+
+		return identities, nil
+	})(r)
+}
+
+// UnlinkIdentityHandler unlinks an identity from the authenticated user
+// example path: DELETE .../identities/:id
+func (s *Service) UnlinkIdentityHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, req identityPathRequest) (string, error) {
+		user, err := getAuthenticatedUser(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		if err := s.UnlinkIdentity(user.ID, req.ID); err != nil {
+			return "", getOAuthError(err)
+		}
+
+		s.audit(AuditIdentityUnlinked, user.ID, "identity_id", req.ID)
+		return "identity unlinked successfully", nil
+	})(r)
+}
@@ -0,0 +1,292 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/unluckythoughts/go-microservice/v2/tools/web"
+)
+
+const (
+	fakeClientID    = "client-id"
+	fakeRedirectURI = "https://app.example.com/callback"
+)
+
+// fakeAuthorization is a code issued by the fake IdP with the PKCE challenge
+// it was issued for and the claims of its ID token
+type fakeAuthorization struct {
+	challenge string
+	claims    jwt.MapClaims
+}
+
+// fakeIdP is an OpenID provider serving discovery, JWKS and token endpoints
+type fakeIdP struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mut         sync.Mutex
+	keys        *web.KeySet
+	codes       map[string]fakeAuthorization
+	jwksFetches int
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	idp := &fakeIdP{t: t, codes: map[string]fakeAuthorization{}}
+	idp.rotate("key-1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		idp.mut.Lock()
+		idp.jwksFetches++
+		keys := idp.keys
+		idp.mut.Unlock()
+		keys.JWKSHandler().ServeHTTP(w, r)
+	})
+	mux.HandleFunc("/token", idp.token)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+
+	return idp
+}
+
+// rotate signs the next ID tokens with a new key, only the new key is published
+func (idp *fakeIdP) rotate(kid string) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(idp.t, err)
+	key, err := web.NewKey(kid, private)
+	require.NoError(idp.t, err)
+
+	idp.mut.Lock()
+	idp.keys = web.NewKeySet(key)
+	idp.mut.Unlock()
+}
+
+func (idp *fakeIdP) token(w http.ResponseWriter, r *http.Request) {
+	require.NoError(idp.t, r.ParseForm())
+
+	idp.mut.Lock()
+	auth, ok := idp.codes[r.PostForm.Get("code")]
+	delete(idp.codes, r.PostForm.Get("code"))
+	keys := idp.keys
+	idp.mut.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != auth.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	idToken, err := keys.Sign(auth.claims)
+	require.NoError(idp.t, err)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "access-token",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+// authorize logs the user of the claims in on the login page, it returns the
+// code and state of the redirect to the client. The claims override the
+// standard claims of the ID token
+func (idp *fakeIdP) authorize(authURL string, claims jwt.MapClaims) (code, state string) {
+	u, err := url.Parse(authURL)
+	require.NoError(idp.t, err)
+	q := u.Query()
+	require.Equal(idp.t, "S256", q.Get("code_challenge_method"))
+	require.Equal(idp.t, fakeClientID, q.Get("client_id"))
+	require.Equal(idp.t, fakeRedirectURI, q.Get("redirect_uri"))
+
+	idClaims := jwt.MapClaims{
+		"iss":   idp.server.URL,
+		"aud":   fakeClientID,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": q.Get("nonce"),
+	}
+	for k, v := range claims {
+		idClaims[k] = v
+	}
+
+	code = "code-" + q.Get("state")[:8]
+	idp.mut.Lock()
+	idp.codes[code] = fakeAuthorization{challenge: q.Get("code_challenge"), claims: idClaims}
+	idp.mut.Unlock()
+
+	return code, q.Get("state")
+}
+
+func newOAuthTestService(t *testing.T) (*Service, *fakeIdP, *OIDCProvider) {
+	idp := newFakeIdP(t)
+	provider := NewOIDCProvider(OIDCConfig{
+		Name:         "fake",
+		Issuer:       idp.server.URL,
+		ClientID:     fakeClientID,
+		ClientSecret: "client-secret",
+	})
+
+	s := newTestService(t)
+	s.oauthProviders = map[string]OAuthProvider{"fake": provider}
+	return s, idp, provider
+}
+
+// oauthLogin logs in on the fake IdP with the claims and returns the identity
+func oauthLogin(t *testing.T, s *Service, idp *fakeIdP, claims jwt.MapClaims) (*OAuthIdentity, error) {
+	flow, authURL, err := s.startOAuth("fake", fakeRedirectURI, 0)
+	require.NoError(t, err)
+
+	code, state := idp.authorize(authURL, claims)
+	return s.finishOAuth(flow, "fake", state, code)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	s, idp, _ := newOAuthTestService(t)
+
+	identity, err := oauthLogin(t, s, idp, jwt.MapClaims{
+		"sub": "fake-user-1", "email": "new@example.com", "email_verified": "true", "name": "New User",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, &OAuthIdentity{
+		Subject: "fake-user-1", Email: "new@example.com", EmailVerified: true, Name: "New User",
+	}, identity)
+
+	user, err := s.OAuthLogin("fake", identity, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "new@example.com", user.Email)
+	assert.True(t, user.EmailVerified)
+
+	// the next login finds the linked identity
+	again, err := s.OAuthLogin("fake", identity, 1)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, again.ID)
+
+	identities, err := s.ListIdentities(user.ID)
+	require.NoError(t, err)
+	require.Len(t, identities, 1)
+	assert.Equal(t, "fake", identities[0].Provider)
+
+	_, _, err = s.startOAuth("unknown", fakeRedirectURI, 0)
+	assert.ErrorIs(t, err, ErrOAuthProviderNotFound)
+}
+
+func TestOIDCLoginChecks(t *testing.T) {
+	s, idp, _ := newOAuthTestService(t)
+	clock := &fixedClock{t: time.Now()}
+	s.now = clock.now
+
+	flow, authURL, err := s.startOAuth("fake", fakeRedirectURI, 0)
+	require.NoError(t, err)
+	code, state := idp.authorize(authURL, jwt.MapClaims{"sub": "user"})
+
+	_, err = s.finishOAuth(flow, "fake", "other-state", code)
+	assert.ErrorIs(t, err, ErrInvalidOAuthState)
+	_, err = s.finishOAuth(nil, "fake", state, code)
+	assert.ErrorIs(t, err, ErrInvalidOAuthState, "the state has to be kept in the session")
+
+	clock.add(oauthFlowValid + time.Second)
+	_, err = s.finishOAuth(flow, "fake", state, code)
+	assert.ErrorIs(t, err, ErrInvalidOAuthState)
+	clock.add(-oauthFlowValid)
+
+	// the code only works with the PKCE verifier it was issued for
+	other := *flow
+	other.Params.CodeVerifier = "other-verifier-other-verifier-other-verifier"
+	_, err = s.finishOAuth(&other, "fake", state, code)
+	assert.ErrorIs(t, err, ErrOAuthFailed)
+
+	for name, claims := range map[string]jwt.MapClaims{
+		"nonce":    {"sub": "user", "nonce": "other-nonce"},
+		"audience": {"sub": "user", "aud": "other-client"},
+		"issuer":   {"sub": "user", "iss": "https://other.example.com"},
+		"expiry":   {"sub": "user", "exp": time.Now().Add(-time.Minute).Unix()},
+		"subject":  {"sub": ""},
+	} {
+		_, err := oauthLogin(t, s, idp, claims)
+		assert.ErrorIs(t, err, ErrOAuthFailed, name)
+	}
+}
+
+func TestOIDCKeyRotation(t *testing.T) {
+	s, idp, provider := newOAuthTestService(t)
+
+	_, err := oauthLogin(t, s, idp, jwt.MapClaims{"sub": "user"})
+	require.NoError(t, err)
+
+	// the JWKS is fetched again for unknown keys, at most once a minute
+	idp.rotate("key-2")
+	_, err = oauthLogin(t, s, idp, jwt.MapClaims{"sub": "user"})
+	assert.ErrorIs(t, err, ErrOAuthFailed)
+
+	provider.fetchedAt = provider.fetchedAt.Add(-jwksRefreshInterval)
+	_, err = oauthLogin(t, s, idp, jwt.MapClaims{"sub": "user"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, idp.jwksFetches)
+}
+
+func TestOAuthLoginLinksVerifiedEmails(t *testing.T) {
+	s, _, user := newMFATestService(t)
+
+	_, err := s.OAuthLogin("fake", &OAuthIdentity{Subject: "unverified", Email: user.Email}, 1)
+	assert.ErrorIs(t, err, ErrIdentityNotLinked, "unverified emails do not link users")
+	_, err = s.OAuthLogin("fake", &OAuthIdentity{Subject: "verified", Email: user.Email, EmailVerified: true}, 1)
+	assert.ErrorIs(t, err, ErrIdentityNotLinked, "users who did not verify their email are not linked")
+
+	require.NoError(t, s.db.Model(&User{}).Where("id = ?", user.ID).Update("email_verified", true).Error)
+	linked, err := s.OAuthLogin("fake", &OAuthIdentity{Subject: "verified", Email: user.Email, EmailVerified: true}, 1)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, linked.ID)
+
+	s.selfRegistration = false
+	_, err = s.OAuthLogin("fake", &OAuthIdentity{Subject: "new", Email: "new@example.com", EmailVerified: true}, 1)
+	assert.ErrorIs(t, err, ErrIdentityNotLinked)
+}
+
+func TestLinkAndUnlinkIdentities(t *testing.T) {
+	s, _, user := newMFATestService(t)
+
+	first, err := s.LinkIdentity(user.ID, "fake", &OAuthIdentity{Subject: "first"})
+	require.NoError(t, err)
+	again, err := s.LinkIdentity(user.ID, "fake", &OAuthIdentity{Subject: "first"})
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, again.ID)
+
+	other, err := s.OAuthLogin("fake", &OAuthIdentity{Subject: "other", Email: "other@example.com", EmailVerified: true}, 1)
+	require.NoError(t, err)
+	_, err = s.LinkIdentity(other.ID, "fake", &OAuthIdentity{Subject: "first"})
+	assert.ErrorIs(t, err, ErrIdentityLinked)
+
+	// users without a password keep their last identity
+	otherIdentities, err := s.ListIdentities(other.ID)
+	require.NoError(t, err)
+	require.Len(t, otherIdentities, 1)
+	assert.ErrorIs(t, s.UnlinkIdentity(other.ID, otherIdentities[0].ID), ErrLastLoginMethod)
+	assert.ErrorIs(t, s.UnlinkIdentity(other.ID, first.ID), ErrIdentityNotFound)
+
+	require.NoError(t, s.UnlinkIdentity(user.ID, first.ID))
+	_, err = s.OAuthLogin("fake", &OAuthIdentity{Subject: "first"}, 1)
+	assert.ErrorIs(t, err, ErrIdentityNotLinked)
+}
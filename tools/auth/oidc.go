@@ -0,0 +1,383 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/unluckythoughts/go-microservice/v2/tools/web"
+	"golang.org/x/oauth2"
+)
+
+// jwksRefreshInterval is the minimum time between two fetches of the JWKS of a
+// provider, tokens signed with unknown keys fetch it again after it
+const jwksRefreshInterval = time.Minute
+
+// OAuthProvider is an identity provider users log in with
+type OAuthProvider interface {
+	// Name identifies the provider in the routes and the linked identities, e.g. "google"
+	Name() string
+	// AuthCodeURL returns the URL of the login page of the provider
+	AuthCodeURL(ctx context.Context, params OAuthParams) (string, error)
+	// Exchange exchanges the authorization code returned by the login page for
+	// the identity of the user, checking the nonce of ID tokens
+	Exchange(ctx context.Context, code string, params OAuthParams) (*OAuthIdentity, error)
+}
+
+// OAuthParams are the parameters of a login with a provider
+type OAuthParams struct {
+	RedirectURI string
+	State       string
+	// Nonce is checked against the nonce claim of ID tokens
+	Nonce string
+	// CodeVerifier is the PKCE verifier, the login page gets its S256 challenge
+	CodeVerifier string
+}
+
+// OAuthIdentity is the user returned by a provider
+type OAuthIdentity struct {
+	// Subject is the ID of the user at the provider
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Picture       string
+}
+
+// OIDCConfig is the configuration of an OpenID Connect provider
+type OIDCConfig struct {
+	// Name identifies the provider, e.g. "google"
+	Name string
+	// Issuer is the issuer URL of the provider, its endpoints are discovered
+	// from Issuer + "/.well-known/openid-configuration"
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// Scopes default to openid, email and profile
+	Scopes []string
+	// HTTPClient calls the provider, default is http.DefaultClient
+	HTTPClient *http.Client
+}
+
+// oidcDiscovery is the part of the OpenID provider metadata used for the logins
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCProvider logs users in with an OpenID Connect provider, the ID tokens
+// are verified with the JWKS of the provider
+type OIDCProvider struct {
+	cfg OIDCConfig
+
+	mut       sync.Mutex
+	discovery *oidcDiscovery
+	keys      *web.KeySet
+	fetchedAt time.Time
+}
+
+// NewOIDCProvider returns the provider of the configuration, the endpoints are
+// discovered on the first login
+func NewOIDCProvider(cfg OIDCConfig) *OIDCProvider {
+	cfg.Issuer = strings.TrimRight(cfg.Issuer, "/")
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+
+	return &OIDCProvider{cfg: cfg}
+}
+
+// NewGoogleProvider returns the OpenID Connect provider of Google accounts
+func NewGoogleProvider(clientID, clientSecret string) *OIDCProvider {
+	return NewOIDCProvider(OIDCConfig{
+		Name:         "google",
+		Issuer:       "https://accounts.google.com",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+	})
+}
+
+func (p *OIDCProvider) Name() string {
+	return p.cfg.Name
+}
+
+// getJSON decodes the JSON response of the URL into v
+func getJSON(ctx context.Context, client *http.Client, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", url, resp.Status)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// getDiscovery returns the metadata of the provider, fetching it on first use
+func (p *OIDCProvider) getDiscovery(ctx context.Context) (*oidcDiscovery, error) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	d := &oidcDiscovery{}
+	if err := getJSON(ctx, p.cfg.HTTPClient, p.cfg.Issuer+"/.well-known/openid-configuration", d); err != nil {
+		return nil, fmt.Errorf("could not discover the provider %s: %w", p.cfg.Name, err)
+	}
+	if d.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("provider %s has issuer %s, expected %s", p.cfg.Name, d.Issuer, p.cfg.Issuer)
+	}
+
+	p.discovery = d
+	return d, nil
+}
+
+// getKeys returns the keys verifying the ID tokens, they are fetched again
+// when refresh is set and the last fetch is older than jwksRefreshInterval
+func (p *OIDCProvider) getKeys(ctx context.Context, jwksURI string, refresh bool) (*web.KeySet, error) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	if p.keys != nil && (!refresh || time.Since(p.fetchedAt) < jwksRefreshInterval) {
+		return p.keys, nil
+	}
+
+	doc := json.RawMessage{}
+	if err := getJSON(ctx, p.cfg.HTTPClient, jwksURI, &doc); err != nil {
+		return nil, fmt.Errorf("could not get the keys of the provider %s: %w", p.cfg.Name, err)
+	}
+	keys, err := web.ParseJWKS(doc)
+	if err != nil {
+		return nil, fmt.Errorf("could not get the keys of the provider %s: %w", p.cfg.Name, err)
+	}
+
+	p.keys, p.fetchedAt = keys, time.Now()
+	return keys, nil
+}
+
+func (p *OIDCProvider) oauth2Config(d *oidcDiscovery, redirectURI string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		Endpoint:     oauth2.Endpoint{AuthURL: d.AuthorizationEndpoint, TokenURL: d.TokenEndpoint},
+		RedirectURL:  redirectURI,
+		Scopes:       p.cfg.Scopes,
+	}
+}
+
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, params OAuthParams) (string, error) {
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return p.oauth2Config(d, params.RedirectURI).AuthCodeURL(params.State,
+		oauth2.S256ChallengeOption(params.CodeVerifier),
+		oauth2.SetAuthURLParam("nonce", params.Nonce),
+	), nil
+}
+
+func (p *OIDCProvider) Exchange(ctx context.Context, code string, params OAuthParams) (*OAuthIdentity, error) {
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, p.cfg.HTTPClient)
+	token, err := p.oauth2Config(d, params.RedirectURI).Exchange(ctx, code, oauth2.VerifierOption(params.CodeVerifier))
+	if err != nil {
+		return nil, fmt.Errorf("could not exchange the code: %w", err)
+	}
+
+	idToken, _ := token.Extra("id_token").(string)
+	if idToken == "" {
+		return nil, errors.New("the provider did not return an ID token")
+	}
+
+	return p.verifyIDToken(ctx, d, idToken, params.Nonce)
+}
+
+// verifyIDToken returns the identity of the ID token after checking its
+// signature, issuer, audience, expiry and nonce
+func (p *OIDCProvider) verifyIDToken(ctx context.Context, d *oidcDiscovery, idToken, nonce string) (*OAuthIdentity, error) {
+	parse := func(refresh bool) (*jwt.Token, error) {
+		keys, err := p.getKeys(ctx, d.JWKSURI, refresh)
+		if err != nil {
+			return nil, err
+		}
+
+		return keys.Parse(idToken,
+			jwt.WithIssuer(p.cfg.Issuer),
+			jwt.WithAudience(p.cfg.ClientID),
+			jwt.WithExpirationRequired(),
+		)
+	}
+
+	parsed, err := parse(false)
+	// the provider may have rotated its keys
+	if errors.Is(err, web.ErrUnknownKey) {
+		parsed, err = parse(true)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+
+	claims, ok := parsed.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("invalid ID token claims")
+	}
+	tokenNonce, _ := claims["nonce"].(string)
+	if subtle.ConstantTimeCompare([]byte(tokenNonce), []byte(nonce)) != 1 {
+		return nil, errors.New("invalid ID token nonce")
+	}
+	// tokens issued to several clients have to be issued for this one
+	if aud, _ := claims.GetAudience(); len(aud) > 1 {
+		if azp, _ := claims["azp"].(string); azp != p.cfg.ClientID {
+			return nil, errors.New("invalid ID token authorized party")
+		}
+	}
+
+	sub, err := claims.GetSubject()
+	if err != nil || sub == "" {
+		return nil, errors.New("ID token has no subject")
+	}
+
+	identity := &OAuthIdentity{Subject: sub}
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["name"].(string)
+	identity.Picture, _ = claims["picture"].(string)
+	// some providers, e.g. Apple, send the boolean claims as strings
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = verified
+	case string:
+		identity.EmailVerified, _ = strconv.ParseBool(verified)
+	}
+
+	return identity, nil
+}
+
+// OAuth2Config is the configuration of an OAuth2 provider without OpenID Connect,
+// the user is read from its user info endpoint
+type OAuth2Config struct {
+	// Name identifies the provider, e.g. "github"
+	Name         string
+	ClientID     string
+	ClientSecret string
+	AuthURL      string
+	TokenURL     string
+	UserInfoURL  string
+	Scopes       []string
+	// Identity returns the identity of the user info response
+	Identity func(info map[string]any) (*OAuthIdentity, error)
+	// HTTPClient calls the provider, default is http.DefaultClient
+	HTTPClient *http.Client
+}
+
+// OAuth2Provider logs users in with an OAuth2 provider without OpenID Connect,
+// it has no ID token so the nonce is not used
+type OAuth2Provider struct {
+	cfg OAuth2Config
+}
+
+// NewOAuth2Provider returns the provider of the configuration
+func NewOAuth2Provider(cfg OAuth2Config) *OAuth2Provider {
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+
+	return &OAuth2Provider{cfg: cfg}
+}
+
+// NewGitHubProvider returns the provider of GitHub accounts, their public email
+// is not verified so it does not link existing users
+func NewGitHubProvider(clientID, clientSecret string) *OAuth2Provider {
+	return NewOAuth2Provider(OAuth2Config{
+		Name:         "github",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		AuthURL:      "https://github.com/login/oauth/authorize",
+		TokenURL:     "https://github.com/login/oauth/access_token",
+		UserInfoURL:  "https://api.github.com/user",
+		Scopes:       []string{"read:user", "user:email"},
+		Identity: func(info map[string]any) (*OAuthIdentity, error) {
+			id, ok := info["id"].(float64)
+			if !ok {
+				return nil, errors.New("GitHub user has no id")
+			}
+
+			identity := &OAuthIdentity{Subject: strconv.FormatInt(int64(id), 10)}
+			identity.Email, _ = info["email"].(string)
+			identity.Name, _ = info["name"].(string)
+			identity.Picture, _ = info["avatar_url"].(string)
+			return identity, nil
+		},
+	})
+}
+
+func (p *OAuth2Provider) Name() string {
+	return p.cfg.Name
+}
+
+func (p *OAuth2Provider) oauth2Config(redirectURI string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		Endpoint:     oauth2.Endpoint{AuthURL: p.cfg.AuthURL, TokenURL: p.cfg.TokenURL},
+		RedirectURL:  redirectURI,
+		Scopes:       p.cfg.Scopes,
+	}
+}
+
+func (p *OAuth2Provider) AuthCodeURL(_ context.Context, params OAuthParams) (string, error) {
+	return p.oauth2Config(params.RedirectURI).AuthCodeURL(params.State,
+		oauth2.S256ChallengeOption(params.CodeVerifier)), nil
+}
+
+func (p *OAuth2Provider) Exchange(ctx context.Context, code string, params OAuthParams) (*OAuthIdentity, error) {
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, p.cfg.HTTPClient)
+	conf := p.oauth2Config(params.RedirectURI)
+	token, err := conf.Exchange(ctx, code, oauth2.VerifierOption(params.CodeVerifier))
+	if err != nil {
+		return nil, fmt.Errorf("could not exchange the code: %w", err)
+	}
+
+	info := map[string]any{}
+	if err := getJSON(ctx, conf.Client(ctx, token), p.cfg.UserInfoURL, &info); err != nil {
+		return nil, fmt.Errorf("could not get the user info: %w", err)
+	}
+
+	identity, err := p.cfg.Identity(info)
+	if err != nil {
+		return nil, err
+	} else if identity.Subject == "" {
+		return nil, errors.New("the user info has no subject")
+	}
+
+	return identity, nil
+}
@@ -0,0 +1,318 @@
+package auth
+
+import (
+	"errors"
+
+	localcontext "github.com/unluckythoughts/go-microservice/v2/tools/context"
+	"github.com/unluckythoughts/go-microservice/v2/tools/web"
+)
+
+// invitationPathRequest is a request for the invitation of the path
+type invitationPathRequest struct {
+	ID uint `path:"id" valid:"required~invitation id is required"`
+}
+
+// getOrgError returns the coded errors of the organization methods as is and wraps the others
+func getOrgError(err error) error {
+	for _, coded := range []error{
+		ErrOrgNotFound, ErrNotOrgMember, ErrNoCurrentOrg, ErrOrgMemberExists, ErrLastOrgAdmin, ErrInvalidRole,
+		ErrInvalidInvitation, ErrInvitationNotFound, ErrInvitationEmailMismatch, ErrUnauthorized, ErrForbidden,
+	} {
+		if errors.Is(err, coded) {
+			return err
+		}
+	}
+
+	return ErrInternal.WithCause(err)
+}
+
+// getOrgRequest returns the authenticated user and the current organization of the request
+func getOrgRequest(ctx localcontext.Context) (*User, *OrgMembership, error) {
+	user, err := getAuthenticatedUser(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	membership, err := getCurrentOrgFromContext(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, membership, nil
+}
+
+// GetCreateOrgHandler returns a handler creating an organization, the
+// authenticated user becomes its member with the admin role
+// example path: POST .../orgs
+func (s *Service) GetCreateOrgHandler(adminRole Role) web.Handler {
+	return typed(s, func(s *Service, ctx localcontext.Context, body OrgRequest) (*Organization, error) {
+		user, err := getAuthenticatedUser(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		org, err := s.CreateOrg(user.ID, body.Name, adminRole)
+		if err != nil {
+			return nil, getOrgError(err)
+		}
+
+		s.audit(AuditOrgCreated, user.ID, "org_id", org.ID)
+		return org, nil
+	})
+}
+
+// ListOrgsHandler returns the organizations of the authenticated user
+// example path: GET .../orgs
+func (s *Service) ListOrgsHandler(r web.Request) (any, error) {
+	return handle(s, func(s *Service, r web.Request) (any, error) {
+		user, err := GetAuthenticatedUser(r)
+		if err != nil {
+			return nil, err
+		}
+
+		list, err := s.ListUserOrgs(user.ID)
+		if err != nil {
+			return nil, ErrInternal.WithCause(err)
+		}
+
+		return list, nil
+	})(r)
+}
+
+// SwitchOrgHandler switches the current organization of the session and returns
+// a JWT token with the organization in its org claim, the refreshed tokens and
+// the cookie session keep it
+// example path: POST .../orgs/switch
+func (s *Service) SwitchOrgHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, body SwitchOrgRequest) (LoginResponse, error) {
+		resp := LoginResponse{}
+		user, err := getAuthenticatedUser(ctx)
+		if err != nil {
+			return resp, err
+		}
+
+		sessionID := getAuthenticatedSession(ctx)
+		if _, err := s.SwitchOrg(user.ID, sessionID, body.OrgID); err != nil {
+			return resp, getOrgError(err)
+		}
+
+		// requests authenticated with a JWT token have no cookie session
+		_ = ctx.PutSessionValue(orgIDKey, body.OrgID)
+
+		resp.Token, err = s.createAccessToken(user.ID, sessionID, body.OrgID)
+		if err != nil {
+			return resp, ErrInternal.WithCause(err)
+		}
+
+		s.audit(AuditOrgSwitched, user.ID, "org_id", body.OrgID)
+		return resp, nil
+	})(r)
+}
+
+// AcceptInvitationHandler makes the authenticated user a member of the
+// organization of the invitation sent to its email
+// example path: POST .../orgs/invitations/accept
+func (s *Service) AcceptInvitationHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, body AcceptInvitationRequest) (*OrgMembership, error) {
+		user, err := getAuthenticatedUser(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		membership, err := s.AcceptOrgInvitation(user, body.Token)
+		if errors.Is(err, ErrInvalidInvitation) || errors.Is(err, ErrInvitationEmailMismatch) {
+			s.auditFailure(AuditOrgInvitationAccepted, user.ID, "reason", "invalid_invitation")
+			return nil, err
+		} else if err != nil {
+			return nil, getOrgError(err)
+		}
+
+		s.audit(AuditOrgInvitationAccepted, user.ID, "org_id", membership.ID, "role", membership.Role)
+		return membership, nil
+	})(r)
+}
+
+// GetCurrentOrgHandler returns the current organization of the request with
+// the role of the authenticated user within it
+// example path: GET .../org
+func (s *Service) GetCurrentOrgHandler(r web.Request) (any, error) {
+	return GetCurrentOrg(r)
+}
+
+// RenameOrgHandler changes the name of the current organization
+// example path: PUT .../org
+func (s *Service) RenameOrgHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, body OrgRequest) (*Organization, error) {
+		user, membership, err := getOrgRequest(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		org, err := s.RenameOrg(membership.ID, body.Name)
+		if err != nil {
+			return nil, getOrgError(err)
+		}
+
+		s.audit(AuditOrgUpdated, user.ID, "org_id", org.ID)
+		return org, nil
+	})(r)
+}
+
+// DeleteOrgHandler deletes the current organization with its memberships and invitations
+// example path: DELETE .../org
+func (s *Service) DeleteOrgHandler(r web.Request) (any, error) {
+	return handle(s, func(s *Service, r web.Request) (any, error) {
+		user, err := GetAuthenticatedUser(r)
+		if err != nil {
+			return nil, err
+		}
+		membership, err := GetCurrentOrg(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := s.DeleteOrg(membership.ID); err != nil {
+			return nil, getOrgError(err)
+		}
+
+		s.audit(AuditOrgDeleted, user.ID, "org_id", membership.ID)
+		return "organization deleted successfully", nil
+	})(r)
+}
+
+// ListOrgMembersHandler returns the members of the current organization
+// example path: GET .../org/members
+func (s *Service) ListOrgMembersHandler(r web.Request) (any, error) {
+	return handle(s, func(s *Service, r web.Request) (any, error) {
+		membership, err := GetCurrentOrg(r)
+		if err != nil {
+			return nil, err
+		}
+
+		list, err := s.ListOrgMembers(membership.ID)
+		if err != nil {
+			return nil, ErrInternal.WithCause(err)
+		}
+
+		return list, nil
+	})(r)
+}
+
+// GetSetOrgMemberRoleHandler returns a handler changing the role of a member of
+// the current organization up to the role of the authenticated member, the
+// last member with adminRole cannot be demoted
+// example path: PUT .../org/members/:userId
+func (s *Service) GetSetOrgMemberRoleHandler(adminRole Role) web.Handler {
+	return typed(s, func(s *Service, ctx localcontext.Context, body OrgMemberRoleRequest) (string, error) {
+		_, membership, err := getOrgRequest(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		if err := s.SetOrgMemberRole(membership, body.UserID, body.Role, adminRole); err != nil {
+			return "", getOrgError(err)
+		}
+
+		s.audit(AuditOrgMemberRoleChanged, body.UserID, "org_id", membership.ID, "role", body.Role)
+		return "member role updated successfully", nil
+	})
+}
+
+// GetRemoveOrgMemberHandler returns a handler removing a member from the
+// current organization, the last member with adminRole cannot be removed
+// example path: DELETE .../org/members/:userId
+func (s *Service) GetRemoveOrgMemberHandler(adminRole Role) web.Handler {
+	return typed(s, func(s *Service, ctx localcontext.Context, req userPathRequest) (string, error) {
+		_, membership, err := getOrgRequest(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		if err := s.RemoveOrgMember(membership, req.UserID, adminRole); err != nil {
+			return "", getOrgError(err)
+		}
+
+		s.audit(AuditOrgMemberRemoved, req.UserID, "org_id", membership.ID)
+		return "member removed successfully", nil
+	})
+}
+
+// GetLeaveOrgHandler returns a handler removing the authenticated user from the
+// current organization, the last member with adminRole cannot leave
+// example path: POST .../org/leave
+func (s *Service) GetLeaveOrgHandler(adminRole Role) web.Handler {
+	return handle(s, func(s *Service, r web.Request) (any, error) {
+		user, err := GetAuthenticatedUser(r)
+		if err != nil {
+			return nil, err
+		}
+		membership, err := GetCurrentOrg(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := s.RemoveOrgMember(membership, user.ID, adminRole); err != nil {
+			return nil, getOrgError(err)
+		}
+		_ = r.GetContext().PutSessionValue(orgIDKey, uint(0))
+
+		s.audit(AuditOrgMemberRemoved, user.ID, "org_id", membership.ID)
+		return "organization left successfully", nil
+	})
+}
+
+// ListOrgInvitationsHandler returns the pending invitations of the current organization
+// example path: GET .../org/invitations
+func (s *Service) ListOrgInvitationsHandler(r web.Request) (any, error) {
+	return handle(s, func(s *Service, r web.Request) (any, error) {
+		membership, err := GetCurrentOrg(r)
+		if err != nil {
+			return nil, err
+		}
+
+		list, err := s.ListOrgInvitations(membership.ID)
+		if err != nil {
+			return nil, ErrInternal.WithCause(err)
+		}
+
+		return list, nil
+	})(r)
+}
+
+// InviteOrgMemberHandler sends an invitation to join the current organization
+// to an email, with a role up to the role of the authenticated member
+// example path: POST .../org/invitations
+func (s *Service) InviteOrgMemberHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, body InviteRequest) (*OrgInvitation, error) {
+		user, membership, err := getOrgRequest(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		invitation, err := s.InviteOrgMember(membership, user.ID, body.Email, body.Role)
+		if err != nil {
+			return nil, getOrgError(err)
+		}
+
+		s.audit(AuditOrgMemberInvited, 0, "org_id", membership.ID, "invitation_id", invitation.ID, "role", body.Role)
+		return invitation, nil
+	})(r)
+}
+
+// RevokeOrgInvitationHandler deletes an invitation of the current organization
+// example path: DELETE .../org/invitations/:id
+func (s *Service) RevokeOrgInvitationHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, req invitationPathRequest) (string, error) {
+		_, membership, err := getOrgRequest(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		if err := s.RevokeOrgInvitation(membership.ID, req.ID); err != nil {
+			return "", getOrgError(err)
+		}
+
+		s.audit(AuditOrgInvitationRevoked, 0, "org_id", membership.ID, "invitation_id", req.ID)
+		return "invitation revoked successfully", nil
+	})(r)
+}
@@ -0,0 +1,434 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+
+	localcontext "github.com/unluckythoughts/go-microservice/v2/tools/context"
+	"github.com/unluckythoughts/go-microservice/v2/tools/web"
+	"github.com/unluckythoughts/go-microservice/v2/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	// orgIDKey is the cookie session value with the current organization of the session
+	orgIDKey = "auth_org_id"
+	// invitationTokenLength is the length of the tokens of the invitations
+	invitationTokenLength = 32
+)
+
+// orgContextKey is the request context key of the current organization of the request
+type orgContextKey struct{}
+
+// setCurrentOrg puts the current organization of the request in the request context
+func setCurrentOrg(ctx localcontext.Context, membership *OrgMembership) {
+	_ = ctx.WithValue(orgContextKey{}, membership)
+}
+
+// getCurrentOrg returns the current organization of the request, nil without organization
+func getCurrentOrg(ctx localcontext.Context) *OrgMembership {
+	membership, _ := ctx.Value(orgContextKey{}).(*OrgMembership)
+	return membership
+}
+
+// GetCurrentOrg returns the organization the authenticated user switched to,
+// with the role of the user within it. It returns ErrNoCurrentOrg when the
+// request has no current organization
+func GetCurrentOrg(r web.Request) (*OrgMembership, error) {
+	return getCurrentOrgFromContext(r.GetContext())
+}
+
+func getCurrentOrgFromContext(ctx localcontext.Context) (*OrgMembership, error) {
+	membership := getCurrentOrg(ctx)
+	if membership == nil {
+		return nil, ErrNoCurrentOrg
+	}
+
+	return membership, nil
+}
+
+// isOrgRole reports whether the role is one of Options.OrgRoles
+func (s *Service) isOrgRole(role Role) bool {
+	_, ok := s.orgRoles[role]
+	return ok
+}
+
+// validateOrgRole returns ErrInvalidRole unless the role is one of Options.OrgRoles
+func (s *Service) validateOrgRole(role Role) error {
+	if !s.isOrgRole(role) {
+		return ErrInvalidRole
+	}
+
+	return nil
+}
+
+// checkOrgRole returns ErrForbidden when the role is higher than the role of
+// the member acting in the organization, members only give, change or remove
+// roles up to their own
+func checkOrgRole(membership *OrgMembership, role Role) error {
+	if role > membership.Role {
+		return ErrForbidden
+	}
+
+	return nil
+}
+
+// CreateOrg creates an organization, the user creating it becomes its member
+// with the admin role
+func (s *Service) CreateOrg(userID uint, name string, adminRole Role) (*Organization, error) {
+	if err := s.validateOrgRole(adminRole); err != nil {
+		return nil, err
+	}
+
+	org := &Organization{Name: strings.TrimSpace(name)}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(org).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&OrgMember{OrgID: org.ID, UserID: userID, Role: adminRole, CreatedAt: s.now()}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return org, nil
+}
+
+// GetOrg returns the organization
+func (s *Service) GetOrg(orgID uint) (*Organization, error) {
+	org := &Organization{}
+	err := s.db.First(org, orgID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrOrgNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return org, nil
+}
+
+// RenameOrg changes the name of the organization
+func (s *Service) RenameOrg(orgID uint, name string) (*Organization, error) {
+	result := s.db.Model(&Organization{}).Where("id = ?", orgID).Update("name", strings.TrimSpace(name))
+	if result.Error != nil {
+		return nil, result.Error
+	} else if result.RowsAffected == 0 {
+		return nil, ErrOrgNotFound
+	}
+
+	return s.GetOrg(orgID)
+}
+
+// DeleteOrg deletes the organization with its memberships and invitations, the
+// sessions that switched to it have no current organization anymore
+func (s *Service) DeleteOrg(orgID uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []any{&OrgMember{}, &OrgInvitation{}} {
+			if err := tx.Where("org_id = ?", orgID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+
+		err := tx.Model(&UserSession{}).Where("org_id = ?", orgID).Update("org_id", 0).Error
+		if err != nil {
+			return err
+		}
+
+		result := tx.Delete(&Organization{}, orgID)
+		if result.Error != nil {
+			return result.Error
+		} else if result.RowsAffected == 0 {
+			return ErrOrgNotFound
+		}
+		return nil
+	})
+}
+
+// orgMembershipQuery returns the query of the organizations of the memberships
+func (s *Service) orgMembershipQuery() *gorm.DB {
+	return s.db.Model(&Organization{}).
+		Select("organizations.*, org_members.role").
+		Joins("JOIN org_members ON org_members.org_id = organizations.id")
+}
+
+// ListUserOrgs returns the organizations the user is a member of, by name
+func (s *Service) ListUserOrgs(userID uint) ([]OrgMembership, error) {
+	list := []OrgMembership{}
+	err := s.orgMembershipQuery().
+		Where("org_members.user_id = ?", userID).
+		Order("organizations.name, organizations.id").
+		Scan(&list).Error
+	return list, err
+}
+
+// GetOrgMembership returns the organization with the role of the user within
+// it, ErrNotOrgMember when the user is not a member of the organization
+func (s *Service) GetOrgMembership(orgID, userID uint) (*OrgMembership, error) {
+	membership := &OrgMembership{}
+	err := s.orgMembershipQuery().
+		Where("org_members.org_id = ? AND org_members.user_id = ?", orgID, userID).
+		Take(membership).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotOrgMember
+	} else if err != nil {
+		return nil, err
+	}
+
+	return membership, nil
+}
+
+// SwitchOrg makes the organization the current organization of the session of
+// the user, 0 leaves the current organization. sessionID is empty for the
+// logins before sessions were recorded, their refreshed tokens have no organization
+func (s *Service) SwitchOrg(userID uint, sessionID string, orgID uint) (*OrgMembership, error) {
+	var membership *OrgMembership
+	if orgID != 0 {
+		var err error
+		if membership, err = s.GetOrgMembership(orgID, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	if sessionID == "" {
+		return membership, nil
+	}
+
+	err := s.db.Model(&UserSession{}).
+		Where("session_id = ? AND user_id = ?", sessionID, userID).
+		Update("org_id", orgID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return membership, nil
+}
+
+// ListOrgMembers returns the members of the organization, the first to join first
+func (s *Service) ListOrgMembers(orgID uint) ([]OrgMemberInfo, error) {
+	list := []OrgMemberInfo{}
+	err := s.db.Model(&OrgMember{}).
+		Select("org_members.user_id, users.name, users.email, org_members.role, org_members.created_at AS joined_at").
+		Joins("JOIN users ON users.id = org_members.user_id AND users.deleted_at IS NULL").
+		Where("org_members.org_id = ?", orgID).
+		Order("org_members.created_at, org_members.id").
+		Scan(&list).Error
+	return list, err
+}
+
+// checkOtherOrgAdmin returns ErrLastOrgAdmin when the member of userID is the
+// only member of the organization with the admin role or a higher one
+func checkOtherOrgAdmin(tx *gorm.DB, orgID, userID uint, adminRole Role) error {
+	var count int64
+	err := tx.Model(&OrgMember{}).
+		Where("org_id = ? AND user_id <> ? AND role >= ?", orgID, userID, adminRole).
+		Count(&count).Error
+	if err != nil {
+		return err
+	} else if count == 0 {
+		return ErrLastOrgAdmin
+	}
+
+	return nil
+}
+
+// getOrgMember returns the membership of the user in the organization
+func getOrgMember(tx *gorm.DB, orgID, userID uint) (*OrgMember, error) {
+	member := &OrgMember{}
+	err := tx.Where("org_id = ? AND user_id = ?", orgID, userID).First(member).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotOrgMember
+	} else if err != nil {
+		return nil, err
+	}
+
+	return member, nil
+}
+
+// SetOrgMemberRole changes the role of the member within the organization, the
+// last member with the admin role or a higher one cannot be demoted. The
+// member of actor changes the roles up to its own
+func (s *Service) SetOrgMemberRole(actor *OrgMembership, userID uint, role, adminRole Role) error {
+	if err := s.validateOrgRole(role); err != nil {
+		return err
+	} else if err := checkOrgRole(actor, role); err != nil {
+		return err
+	}
+
+	orgID := actor.ID
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		member, err := getOrgMember(tx, orgID, userID)
+		if err != nil {
+			return err
+		} else if err := checkOrgRole(actor, member.Role); err != nil {
+			return err
+		}
+
+		if member.Role >= adminRole && role < adminRole {
+			if err := checkOtherOrgAdmin(tx, orgID, userID, adminRole); err != nil {
+				return err
+			}
+		}
+
+		return tx.Model(member).Update("role", role).Error
+	})
+}
+
+// RemoveOrgMember removes the user from the organization, the last member with
+// the admin role or a higher one cannot be removed. The member of actor removes
+// the members with a role up to its own. The sessions of the user that
+// switched to the organization have no current organization anymore
+func (s *Service) RemoveOrgMember(actor *OrgMembership, userID uint, adminRole Role) error {
+	orgID := actor.ID
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		member, err := getOrgMember(tx, orgID, userID)
+		if err != nil {
+			return err
+		} else if err := checkOrgRole(actor, member.Role); err != nil {
+			return err
+		}
+
+		if member.Role >= adminRole {
+			if err := checkOtherOrgAdmin(tx, orgID, userID, adminRole); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Delete(member).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&UserSession{}).
+			Where("user_id = ? AND org_id = ?", userID, orgID).
+			Update("org_id", 0).Error
+	})
+}
+
+// InviteOrgMember sends an invitation to join the organization of the inviting
+// member with the role to the email, it replaces the previous invitation of
+// the email. invitedBy is the user sending the invitation, members invite with
+// roles up to their own
+func (s *Service) InviteOrgMember(inviter *OrgMembership, invitedBy uint, email string, role Role) (*OrgInvitation, error) {
+	if err := s.validateOrgRole(role); err != nil {
+		return nil, err
+	} else if err := checkOrgRole(inviter, role); err != nil {
+		return nil, err
+	}
+	orgID := inviter.ID
+
+	org, err := s.GetOrg(orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	email = strings.ToLower(strings.TrimSpace(email))
+	var members int64
+	err = s.db.Model(&OrgMember{}).
+		Joins("JOIN users ON users.id = org_members.user_id").
+		Where("org_members.org_id = ? AND LOWER(users.email) = ?", orgID, email).
+		Count(&members).Error
+	if err != nil {
+		return nil, err
+	} else if members > 0 {
+		return nil, ErrOrgMemberExists
+	}
+
+	token, err := utils.GenerateRandomString(invitationTokenLength)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	invitation := &OrgInvitation{
+		OrgID: orgID,
+		Email: email,
+		Role:  role,
+		// the tokens are random like the refresh tokens, they are saved by the same hash
+		TokenHash: hashRefreshToken(token),
+		InvitedBy: invitedBy,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.invitationValid),
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("org_id = ? AND email = ?", orgID, email).Delete(&OrgInvitation{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(invitation).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.notify(Message{Kind: MessageInvitation, Channel: ChannelEmail, To: email, Token: token, Org: org.Name})
+	if err != nil {
+		return nil, err
+	}
+
+	return invitation, nil
+}
+
+// ListOrgInvitations returns the invitations of the organization that were not
+// accepted yet, the last sent first
+func (s *Service) ListOrgInvitations(orgID uint) ([]OrgInvitation, error) {
+	list := []OrgInvitation{}
+	err := s.db.Where("org_id = ?", orgID).Order("created_at DESC, id DESC").Find(&list).Error
+	return list, err
+}
+
+// RevokeOrgInvitation deletes the invitation of the organization, its token
+// cannot be accepted anymore
+func (s *Service) RevokeOrgInvitation(orgID, id uint) error {
+	result := s.db.Where("id = ? AND org_id = ?", id, orgID).Delete(&OrgInvitation{})
+	if result.Error != nil {
+		return result.Error
+	} else if result.RowsAffected == 0 {
+		return ErrInvitationNotFound
+	}
+
+	return nil
+}
+
+// AcceptOrgInvitation makes the user a member of the organization of the
+// invitation with its role. The invitation has to be sent to the email of the
+// user, it can only be accepted once
+func (s *Service) AcceptOrgInvitation(user *User, token string) (*OrgMembership, error) {
+	invitation := &OrgInvitation{}
+	err := s.db.Where("token_hash = ?", hashRefreshToken(token)).First(invitation).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidInvitation
+	} else if err != nil {
+		return nil, err
+	}
+
+	if !invitation.ExpiresAt.After(s.now()) {
+		return nil, ErrInvalidInvitation
+	} else if !strings.EqualFold(invitation.Email, user.Email) {
+		return nil, ErrInvitationEmailMismatch
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// a concurrent request accepted the invitation first
+		result := tx.Where("id = ?", invitation.ID).Delete(&OrgInvitation{})
+		if result.Error != nil {
+			return result.Error
+		} else if result.RowsAffected == 0 {
+			return ErrInvalidInvitation
+		}
+
+		if _, err := getOrgMember(tx, invitation.OrgID, user.ID); err == nil {
+			return ErrOrgMemberExists
+		} else if !errors.Is(err, ErrNotOrgMember) {
+			return err
+		}
+
+		return tx.Create(&OrgMember{
+			OrgID: invitation.OrgID, UserID: user.ID, Role: invitation.Role, CreatedAt: s.now(),
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetOrgMembership(invitation.OrgID, user.ID)
+}
@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testOrgAdminRole = Role(10)
+
+func TestOrgs(t *testing.T) {
+	s, _, user := newMFATestService(t)
+
+	acme, err := s.CreateOrg(user.ID, " Acme ", testOrgAdminRole)
+	require.NoError(t, err)
+	assert.Equal(t, "Acme", acme.Name)
+	globex, err := s.CreateOrg(user.ID, "Globex", testOrgAdminRole)
+	require.NoError(t, err)
+
+	orgs, err := s.ListUserOrgs(user.ID)
+	require.NoError(t, err)
+	require.Len(t, orgs, 2)
+	assert.Equal(t, acme.ID, orgs[0].ID)
+	assert.Equal(t, "Acme", orgs[0].Name)
+	assert.Equal(t, testOrgAdminRole, orgs[0].Role, "the creator is the admin of the organization")
+
+	renamed, err := s.RenameOrg(globex.ID, "Globex Corp")
+	require.NoError(t, err)
+	assert.Equal(t, "Globex Corp", renamed.Name)
+	_, err = s.RenameOrg(globex.ID+10, "Nobody")
+	assert.ErrorIs(t, err, ErrOrgNotFound)
+
+	other := &User{Name: "other", Email: "other@example.com", Password: "Password@123", Role: 1}
+	require.NoError(t, s.CreateUser(other))
+	_, err = s.GetOrgMembership(acme.ID, other.ID)
+	assert.ErrorIs(t, err, ErrNotOrgMember)
+
+	require.NoError(t, s.DeleteOrg(globex.ID))
+	_, err = s.GetOrg(globex.ID)
+	assert.ErrorIs(t, err, ErrOrgNotFound)
+	_, err = s.GetOrgMembership(globex.ID, user.ID)
+	assert.ErrorIs(t, err, ErrNotOrgMember, "the memberships are deleted with the organization")
+	assert.ErrorIs(t, s.DeleteOrg(globex.ID), ErrOrgNotFound)
+}
+
+func TestSwitchOrg(t *testing.T) {
+	s, _, user := newMFATestService(t)
+
+	org, err := s.CreateOrg(user.ID, "Acme", testOrgAdminRole)
+	require.NoError(t, err)
+	session, err := s.createSession(user.ID, "laptop", "10.0.0.1")
+	require.NoError(t, err)
+	refresh, err := s.createRefreshToken(s.db, user.ID, session.SessionID)
+	require.NoError(t, err)
+
+	membership, err := s.SwitchOrg(user.ID, session.SessionID, org.ID)
+	require.NoError(t, err)
+	assert.Equal(t, org.ID, membership.ID)
+
+	// the refreshed tokens keep the organization in their org claim
+	rt, _, err := s.rotateRefreshToken(refresh)
+	require.NoError(t, err)
+	sessionID, orgID, err := s.refreshSession(rt.FamilyID, "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, org.ID, orgID)
+
+	token, err := s.createAccessToken(user.ID, sessionID, orgID)
+	require.NoError(t, err)
+	claims, err := s.getUserDataFromAuthHeader("Bearer " + token)
+	require.NoError(t, err)
+	assert.Equal(t, org.ID, claims.orgID)
+
+	membership, err = s.SwitchOrg(user.ID, session.SessionID, 0)
+	require.NoError(t, err)
+	assert.Nil(t, membership)
+	_, orgID, err = s.refreshSession(rt.FamilyID, "10.0.0.1")
+	require.NoError(t, err)
+	assert.Zero(t, orgID)
+
+	other := &User{Name: "other", Email: "other@example.com", Password: "Password@123", Role: 1}
+	require.NoError(t, s.CreateUser(other))
+	_, err = s.SwitchOrg(other.ID, "", org.ID)
+	assert.ErrorIs(t, err, ErrNotOrgMember)
+}
+
+func TestOrgInvitations(t *testing.T) {
+	s, clock, admin := newMFATestService(t)
+	notifier := &recordingNotifier{}
+	s.notifier = notifier
+
+	org, err := s.CreateOrg(admin.ID, "Acme", testOrgAdminRole)
+	require.NoError(t, err)
+	invitee := &User{Name: "invitee", Email: "invitee@example.com", Password: "Password@123", Role: 1}
+	require.NoError(t, s.CreateUser(invitee))
+	stranger := &User{Name: "stranger", Email: "stranger@example.com", Password: "Password@123", Role: 1}
+	require.NoError(t, s.CreateUser(stranger))
+	inviter, err := s.GetOrgMembership(org.ID, admin.ID)
+	require.NoError(t, err)
+
+	_, err = s.InviteOrgMember(inviter, admin.ID, "invitee@example.com", 5)
+	assert.ErrorIs(t, err, ErrInvalidRole)
+	_, err = s.InviteOrgMember(inviter, admin.ID, "User@Example.com", 1)
+	assert.ErrorIs(t, err, ErrOrgMemberExists)
+
+	invitation, err := s.InviteOrgMember(inviter, admin.ID, "Invitee@Example.com", 1)
+	require.NoError(t, err)
+	assert.Equal(t, "invitee@example.com", invitation.Email)
+	assert.Equal(t, clock.now().Add(s.invitationValid), invitation.ExpiresAt)
+
+	require.Len(t, notifier.messages, 1)
+	msg := notifier.messages[0]
+	assert.Equal(t, MessageInvitation, msg.Kind)
+	assert.Equal(t, "invitee@example.com", msg.To)
+	assert.Equal(t, "Acme", msg.Org)
+	assert.NotEmpty(t, msg.Token)
+
+	list, err := s.ListOrgInvitations(org.ID)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+
+	_, err = s.AcceptOrgInvitation(stranger, msg.Token)
+	assert.ErrorIs(t, err, ErrInvitationEmailMismatch)
+	_, err = s.AcceptOrgInvitation(invitee, "wrong")
+	assert.ErrorIs(t, err, ErrInvalidInvitation)
+
+	membership, err := s.AcceptOrgInvitation(invitee, msg.Token)
+	require.NoError(t, err)
+	assert.Equal(t, org.ID, membership.ID)
+	assert.Equal(t, Role(1), membership.Role)
+	_, err = s.AcceptOrgInvitation(invitee, msg.Token)
+	assert.ErrorIs(t, err, ErrInvalidInvitation, "invitations can only be accepted once")
+
+	members, err := s.ListOrgMembers(org.ID)
+	require.NoError(t, err)
+	require.Len(t, members, 2)
+	assert.Equal(t, admin.ID, members[0].UserID)
+	assert.Equal(t, "invitee", members[1].Name)
+
+	// expired and revoked invitations cannot be accepted
+	_, err = s.InviteOrgMember(inviter, admin.ID, "stranger@example.com", 1)
+	require.NoError(t, err)
+	clock.add(s.invitationValid + time.Second)
+	_, err = s.AcceptOrgInvitation(stranger, notifier.messages[1].Token)
+	assert.ErrorIs(t, err, ErrInvalidInvitation)
+
+	revoked, err := s.InviteOrgMember(inviter, admin.ID, "stranger@example.com", 1)
+	require.NoError(t, err)
+	list, err = s.ListOrgInvitations(org.ID)
+	require.NoError(t, err)
+	assert.Len(t, list, 1, "the new invitation replaces the previous one of the email")
+	require.NoError(t, s.RevokeOrgInvitation(org.ID, revoked.ID))
+	_, err = s.AcceptOrgInvitation(stranger, notifier.messages[2].Token)
+	assert.ErrorIs(t, err, ErrInvalidInvitation)
+	assert.ErrorIs(t, s.RevokeOrgInvitation(org.ID, revoked.ID), ErrInvitationNotFound)
+}
+
+func TestOrgLastAdmin(t *testing.T) {
+	s, _, admin := newMFATestService(t)
+
+	org, err := s.CreateOrg(admin.ID, "Acme", testOrgAdminRole)
+	require.NoError(t, err)
+	member := &User{Name: "member", Email: "member@example.com", Password: "Password@123", Role: 1}
+	require.NoError(t, s.CreateUser(member))
+	require.NoError(t, s.db.Create(&OrgMember{OrgID: org.ID, UserID: member.ID, Role: 1}).Error)
+	actor, err := s.GetOrgMembership(org.ID, admin.ID)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, s.SetOrgMemberRole(actor, admin.ID, 1, testOrgAdminRole), ErrLastOrgAdmin)
+	assert.ErrorIs(t, s.RemoveOrgMember(actor, admin.ID, testOrgAdminRole), ErrLastOrgAdmin)
+	assert.ErrorIs(t, s.SetOrgMemberRole(actor, member.ID, 5, testOrgAdminRole), ErrInvalidRole)
+	assert.ErrorIs(t, s.SetOrgMemberRole(actor, member.ID+1, 1, testOrgAdminRole), ErrNotOrgMember)
+
+	require.NoError(t, s.SetOrgMemberRole(actor, member.ID, testOrgAdminRole, testOrgAdminRole))
+	require.NoError(t, s.SetOrgMemberRole(actor, admin.ID, 1, testOrgAdminRole), "another admin is left")
+
+	// the sessions of removed members have no current organization anymore
+	session, err := s.createSession(admin.ID, "laptop", "10.0.0.1")
+	require.NoError(t, err)
+	_, err = s.SwitchOrg(admin.ID, session.SessionID, org.ID)
+	require.NoError(t, err)
+	require.NoError(t, s.RemoveOrgMember(actor, admin.ID, testOrgAdminRole))
+
+	found, err := s.findSession(session.SessionID)
+	require.NoError(t, err)
+	assert.Zero(t, found.OrgID)
+	_, err = s.GetOrgMembership(org.ID, admin.ID)
+	assert.ErrorIs(t, err, ErrNotOrgMember)
+}
+
+func TestOrgRoleCaps(t *testing.T) {
+	s, _, admin := newMFATestService(t)
+
+	assert.True(t, s.isOrgRole(testOrgAdminRole))
+	assert.False(t, s.isOrgRole(99), "the user roles are not organization roles")
+	_, err := s.CreateOrg(admin.ID, "Acme", 99)
+	assert.ErrorIs(t, err, ErrInvalidRole)
+
+	org, err := s.CreateOrg(admin.ID, "Acme", testOrgAdminRole)
+	require.NoError(t, err)
+	member := &User{Name: "member", Email: "member@example.com", Password: "Password@123", Role: 1}
+	require.NoError(t, s.CreateUser(member))
+	require.NoError(t, s.db.Create(&OrgMember{OrgID: org.ID, UserID: member.ID, Role: 1}).Error)
+	actor, err := s.GetOrgMembership(org.ID, member.ID)
+	require.NoError(t, err)
+
+	// members only give and take away the roles up to their own
+	_, err = s.InviteOrgMember(actor, member.ID, "invitee@example.com", testOrgAdminRole)
+	assert.ErrorIs(t, err, ErrForbidden)
+	assert.ErrorIs(t, s.SetOrgMemberRole(actor, member.ID, testOrgAdminRole, testOrgAdminRole), ErrForbidden)
+	assert.ErrorIs(t, s.SetOrgMemberRole(actor, admin.ID, 1, testOrgAdminRole), ErrForbidden)
+	assert.ErrorIs(t, s.RemoveOrgMember(actor, admin.ID, testOrgAdminRole), ErrForbidden)
+
+	_, err = s.InviteOrgMember(actor, member.ID, "invitee@example.com", 1)
+	assert.NoError(t, err)
+	require.NoError(t, s.RemoveOrgMember(actor, member.ID, testOrgAdminRole), "members can leave")
+}
@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+
+	localcontext "github.com/unluckythoughts/go-microservice/v2/tools/context"
+	"github.com/unluckythoughts/go-microservice/v2/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	// passwordlessDeviceSessionKey keeps the device token in the session of the
+	// browser asking for the passwordless token
+	passwordlessDeviceSessionKey = "passwordless_device"
+	deviceTokenLength            = 32
+	magicLinkTokenLength         = 32
+	otpCodeDigits                = 6
+)
+
+// deviceHash returns the verify device of the passwordless tokens sent for the
+// device, so the tokens are only valid with the device token
+func deviceHash(deviceToken string) string {
+	sum := sha256.Sum256([]byte(deviceToken))
+	return hex.EncodeToString(sum[:16])
+}
+
+// generateOTPCode returns a random numeric code
+func generateOTPCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%0*d", otpCodeDigits, n.Int64()), nil
+}
+
+// createPasswordlessToken creates a single use token of the purpose for the email
+// or mobile bound to the device token, it replaces the previous token of the device
+func (s *Service) createPasswordlessToken(to, purpose, deviceToken string) (string, error) {
+	generate := func() (string, error) {
+		return utils.GenerateRandomString(magicLinkTokenLength)
+	}
+	if purpose == otpPurpose {
+		generate = generateOTPCode
+	}
+
+	return s.saveVerification(&Verify{
+		Target:    to,
+		Purpose:   purpose,
+		Device:    deviceHash(deviceToken),
+		ExpiresAt: s.now().Add(s.passwordlessTokenValid),
+	}, generate)
+}
+
+// useVerification deletes the verification so its token cannot be used again
+func (s *Service) useVerification(v *Verify) error {
+	if v.ExpiresAt.Before(s.now()) {
+		return ErrExpiredToken
+	}
+
+	// the row is deleted for good, a soft deleted row would hide the next token of its target
+	res := s.db.Unscoped().Where("id = ? AND token = ?", v.ID, v.Token).Delete(&Verify{})
+	if res.Error != nil {
+		return res.Error
+	} else if res.RowsAffected == 0 {
+		// a concurrent request used the token first
+		return ErrInvalidVerifyToken
+	}
+
+	return nil
+}
+
+// UseMagicLink returns the email of the magic link token after deleting it,
+// the token is only valid with the device token of the request that asked for it
+func (s *Service) UseMagicLink(token, deviceToken string) (string, error) {
+	v, err := s.getVerification(token, magicLinkPurpose)
+	if err != nil {
+		return "", err
+	} else if v.Device != deviceHash(deviceToken) {
+		return "", ErrInvalidVerifyToken
+	}
+
+	return v.Target, s.useVerification(v)
+}
+
+// UseOTPCode deletes the one-time code sent to the mobile, the code is only
+// valid with the device token of the request that asked for it
+func (s *Service) UseOTPCode(mobile, code, deviceToken string) error {
+	v := Verify{}
+	err := s.db.Where("target = ? AND purpose = ? AND device = ? AND token = ?",
+		mobile, otpPurpose, deviceHash(deviceToken), code).
+		First(&v).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInvalidVerifyToken
+	} else if err != nil {
+		return err
+	}
+
+	return s.useVerification(&v)
+}
+
+// getDeviceToken returns the device token of the session, a new one is saved in
+// the session if it has none. Requests without a session get a new token
+func getDeviceToken(ctx localcontext.Context) (string, error) {
+	if val, err := ctx.GetSessionValue(passwordlessDeviceSessionKey); err == nil {
+		if token, ok := val.(string); ok && token != "" {
+			return token, nil
+		}
+	}
+
+	token, err := utils.GenerateRandomString(deviceTokenLength)
+	if err != nil {
+		return "", err
+	}
+	// clients without sessions send the token of the response back instead
+	_ = ctx.PutSessionValue(passwordlessDeviceSessionKey, token)
+
+	return token, nil
+}
+
+// getRequestDeviceToken returns the device token sent in the body, or the one
+// of the session
+func getRequestDeviceToken(ctx localcontext.Context, deviceToken string) string {
+	if deviceToken != "" {
+		return deviceToken
+	}
+
+	val, err := ctx.GetSessionValue(passwordlessDeviceSessionKey)
+	if err != nil {
+		return ""
+	}
+	token, _ := val.(string)
+	return token
+}
+
+// getMagicLink returns the link of the client page logging in with the token,
+// empty without Options.MagicLinkURL
+func (s *Service) getMagicLink(token string) (string, error) {
+	if s.magicLinkURL == "" {
+		return "", nil
+	}
+
+	u, err := url.Parse(s.magicLinkURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// getPasswordlessUser returns the user of the verified email or mobile, it is
+// created with the role when unknown and self registration is enabled. The
+// email or mobile is marked verified, unless the user has a password: anyone
+// could have signed up with it, the owner of the email or mobile would log
+// into an account whose password someone else knows
+func (s *Service) getPasswordlessUser(email, mobile string, role Role) (*User, error) {
+	var user *User
+	var err error
+	if email != "" {
+		user, err = s.GetUserByEmail(email)
+	} else {
+		user, err = s.GetUserByMobile(mobile)
+	}
+
+	if errors.Is(err, ErrUserNotFound) {
+		if !s.selfRegistration {
+			return nil, ErrInvalidVerifyToken
+		}
+
+		user = &User{Email: email, Mobile: Mobile(mobile), Role: role}
+		user.EmailVerified = email != ""
+		user.MobileVerified = mobile != ""
+		if err := s.CreateUser(user); err != nil {
+			return nil, err
+		}
+		s.sendWelcome(user)
+
+		return user, nil
+	} else if err != nil {
+		return nil, err
+	}
+	// service accounts only authenticate with API keys
+	if user.ServiceAccount {
+		return nil, ErrInvalidVerifyToken
+	}
+
+	column, verified := "email_verified", user.EmailVerified
+	if email == "" {
+		column, verified = "mobile_verified", user.MobileVerified
+	}
+	if !verified {
+		withPassword, err := s.hasPassword(user.ID)
+		if err != nil {
+			return nil, err
+		} else if withPassword {
+			return nil, ErrUnverifiedAccount
+		}
+		if err := s.db.Model(&User{}).Where("id = ?", user.ID).Update(column, true).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
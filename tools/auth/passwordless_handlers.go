@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+
+	localcontext "github.com/unluckythoughts/go-microservice/v2/tools/context"
+	"github.com/unluckythoughts/go-microservice/v2/tools/web"
+)
+
+// getPasswordlessError returns the coded errors of the passwordless methods as is and wraps the others
+func getPasswordlessError(err error) error {
+	for _, coded := range []error{ErrInvalidVerifyToken, ErrExpiredToken, ErrTooManyAttempts, ErrUnverifiedAccount} {
+		if errors.Is(err, coded) {
+			return err
+		}
+	}
+
+	return ErrInternal.WithCause(err)
+}
+
+// sendPasswordlessToken sends a new token of the purpose to the email or mobile,
+// bound to the device of the request. Unknown emails and mobiles get no token
+// when self registration is disabled, with the same response
+func (s *Service) sendPasswordlessToken(ctx localcontext.Context, msg Message, purpose string) (PasswordlessResponse, error) {
+	if err := s.countPasswordlessRequest(msg.To); err != nil {
+		return PasswordlessResponse{}, err
+	}
+
+	deviceToken, err := getDeviceToken(ctx)
+	if err != nil {
+		return PasswordlessResponse{}, ErrInternal.WithCause(err)
+	}
+	resp := PasswordlessResponse{DeviceToken: deviceToken}
+
+	var user *User
+	if msg.Channel == ChannelEmail {
+		user, err = s.GetUserByEmail(msg.To)
+	} else {
+		user, err = s.GetUserByMobile(msg.To)
+	}
+	if errors.Is(err, ErrUserNotFound) {
+		if !s.selfRegistration {
+			return resp, nil
+		}
+	} else if err != nil {
+		return PasswordlessResponse{}, ErrInternal.WithCause(err)
+	} else {
+		msg.Name = user.Name
+	}
+
+	msg.Token, err = s.createPasswordlessToken(msg.To, purpose, deviceToken)
+	if err != nil {
+		return PasswordlessResponse{}, ErrInternal.WithCause(err)
+	}
+	if purpose == magicLinkPurpose {
+		msg.Link, err = s.getMagicLink(msg.Token)
+		if err != nil {
+			return PasswordlessResponse{}, ErrInternal.WithCause(err)
+		}
+	}
+
+	if err := s.notify(msg); err != nil {
+		return PasswordlessResponse{}, ErrInternal.WithCause(err)
+	}
+
+	return resp, nil
+}
+
+// passwordlessLogin returns the login response of the user of the verified email or mobile
+func (s *Service) passwordlessLogin(r web.Request, email, mobile string, role Role) (LoginResponse, error) {
+	user, err := s.getPasswordlessUser(email, mobile, role)
+	if err != nil {
+		return LoginResponse{}, getPasswordlessError(err)
+	}
+
+	s.audit(AuditPasswordlessLogin, user.ID)
+	return s.getLoginResponse(r, user)
+}
+
+// MagicLinkHandler sends a magic link to the email, it only works on the device asking for it
+// example path: POST .../magic-link
+func (s *Service) MagicLinkHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, body MagicLinkRequest) (PasswordlessResponse, error) {
+		msg := Message{Kind: MessageMagicLink, Channel: ChannelEmail, To: strings.TrimSpace(body.Email)}
+		return s.sendPasswordlessToken(ctx, msg, magicLinkPurpose)
+	})(r)
+}
+
+// OTPHandler sends a one-time code to the mobile, it only works on the device asking for it
+// example path: POST .../otp
+func (s *Service) OTPHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, body OTPRequest) (PasswordlessResponse, error) {
+		mobile := Mobile(body.Mobile)
+		msg := Message{Kind: MessageOTP, Channel: ChannelMobile, To: mobile.String()}
+		return s.sendPasswordlessToken(ctx, msg, otpPurpose)
+	})(r)
+}
+
+// GetVerifyMagicLinkHandler returns a handler logging in with the token of a
+// magic link, unknown emails are registered with the role
+// example path: POST .../magic-link/verify
+func (s *Service) GetVerifyMagicLinkHandler(role Role) web.Handler {
+	return func(r web.Request) (any, error) {
+		return typed(s, func(s *Service, ctx localcontext.Context, body VerifyMagicLinkRequest) (LoginResponse, error) {
+			email, err := s.UseMagicLink(body.Token, getRequestDeviceToken(ctx, body.DeviceToken))
+			if err != nil {
+				return LoginResponse{}, getPasswordlessError(err)
+			}
+
+			return s.passwordlessLogin(r, email, "", role)
+		})(r)
+	}
+}
+
+// GetVerifyOTPHandler returns a handler logging in with a one-time code sent
+// to a mobile, unknown mobiles are registered with the role
+// example path: POST .../otp/verify
+func (s *Service) GetVerifyOTPHandler(role Role) web.Handler {
+	return func(r web.Request) (any, error) {
+		return typed(s, func(s *Service, ctx localcontext.Context, body VerifyOTPRequest) (LoginResponse, error) {
+			m := Mobile(body.Mobile)
+			mobile := m.String()
+
+			// codes are short, wrong ones are throttled like passwords even
+			// when the login throttling is disabled
+			throttled, key := s.withPasswordlessThrottle(), otpAttemptKey(mobile)
+			if err := throttled.checkAttempts(key, ""); err != nil {
+				return LoginResponse{}, err
+			}
+
+			err := s.UseOTPCode(mobile, strings.TrimSpace(body.Code), getRequestDeviceToken(ctx, body.DeviceToken))
+			if errors.Is(err, ErrInvalidVerifyToken) {
+				throttled.failAttempt(key, "")
+				return LoginResponse{}, err
+			} else if err != nil {
+				return LoginResponse{}, getPasswordlessError(err)
+			}
+			throttled.resetAttempts(key)
+
+			return s.passwordlessLogin(r, "", mobile, role)
+		})(r)
+	}
+}
@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMagicLink(t *testing.T) {
+	s, clock, user := newMFATestService(t)
+
+	token, err := s.createPasswordlessToken(user.Email, magicLinkPurpose, "device-a")
+	require.NoError(t, err)
+	assert.Len(t, token, magicLinkTokenLength)
+
+	// the token only works on the device that asked for it
+	_, err = s.UseMagicLink(token, "device-b")
+	assert.ErrorIs(t, err, ErrInvalidVerifyToken)
+	_, err = s.UseMagicLink(token, "")
+	assert.ErrorIs(t, err, ErrInvalidVerifyToken)
+
+	email, err := s.UseMagicLink(token, "device-a")
+	require.NoError(t, err)
+	assert.Equal(t, user.Email, email)
+
+	_, err = s.UseMagicLink(token, "device-a")
+	assert.ErrorIs(t, err, ErrInvalidVerifyToken, "magic links work once")
+
+	// a new link of the device replaces the used one, which was deleted for good
+	token, err = s.createPasswordlessToken(user.Email, magicLinkPurpose, "device-a")
+	require.NoError(t, err)
+	clock.add(s.passwordlessTokenValid + time.Second)
+	_, err = s.UseMagicLink(token, "device-a")
+	assert.ErrorIs(t, err, ErrExpiredToken)
+}
+
+func TestOTPCode(t *testing.T) {
+	s, _, _ := newMFATestService(t)
+	mobile := "+1 5555555555"
+
+	first, err := s.createPasswordlessToken(mobile, otpPurpose, "device-a")
+	require.NoError(t, err)
+	assert.Len(t, first, otpCodeDigits)
+	other, err := s.createPasswordlessToken(mobile, otpPurpose, "device-b")
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, s.UseOTPCode(mobile, other, "device-a"), ErrInvalidVerifyToken)
+	require.NoError(t, s.UseOTPCode(mobile, first, "device-a"))
+	assert.ErrorIs(t, s.UseOTPCode(mobile, first, "device-a"), ErrInvalidVerifyToken)
+	require.NoError(t, s.UseOTPCode(mobile, other, "device-b"))
+}
+
+func TestPasswordlessUser(t *testing.T) {
+	s, _, user := newMFATestService(t)
+
+	// anyone could have signed up with the email, the password could be theirs
+	_, err := s.getPasswordlessUser(user.Email, "", 1)
+	assert.ErrorIs(t, err, ErrUnverifiedAccount)
+	unverified, err := s.GetUserByID(user.ID)
+	require.NoError(t, err)
+	assert.False(t, unverified.EmailVerified)
+
+	require.NoError(t, s.db.Model(&User{}).Where("id = ?", user.ID).Update("email_verified", true).Error)
+	got, err := s.getPasswordlessUser(user.Email, "", 1)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, got.ID)
+
+	created, err := s.getPasswordlessUser("", "+1 5555555555", 1)
+	require.NoError(t, err)
+	assert.True(t, created.MobileVerified)
+	assert.Equal(t, Role(1), created.Role)
+
+	// users without password are verified by their first passwordless login
+	require.NoError(t, s.db.Model(&User{}).Where("id = ?", created.ID).Update("mobile_verified", false).Error)
+	got, err = s.getPasswordlessUser("", "15555555555", 1)
+	require.NoError(t, err)
+	assert.Equal(t, created.ID, got.ID)
+	verified, err := s.GetUserByID(created.ID)
+	require.NoError(t, err)
+	assert.True(t, verified.MobileVerified)
+
+	s.selfRegistration = false
+	_, err = s.getPasswordlessUser("new@example.com", "", 1)
+	assert.ErrorIs(t, err, ErrInvalidVerifyToken)
+	_, err = s.GetUserByEmail("new@example.com")
+	assert.ErrorIs(t, err, ErrUserNotFound)
+}
+
+func TestPasswordlessRequestLimit(t *testing.T) {
+	s, clock, user := newMFATestService(t)
+	for range s.throttle.requestLimit {
+		require.NoError(t, s.countPasswordlessRequest(user.Email))
+	}
+	assert.ErrorIs(t, s.countPasswordlessRequest(user.Email), ErrTooManyAttempts)
+	assert.NoError(t, s.countPasswordlessRequest("other@example.com"), "the limit is per target")
+
+	clock.add(s.throttle.lockout + time.Second)
+	assert.NoError(t, s.countPasswordlessRequest(user.Email))
+
+	// disabling the login throttling keeps the passwordless limit
+	s.throttle = nil
+	for range s.passwordlessThrottle.requestLimit - 1 {
+		require.NoError(t, s.countPasswordlessRequest(user.Email))
+	}
+	assert.ErrorIs(t, s.countPasswordlessRequest(user.Email), ErrTooManyAttempts)
+	assert.NoError(t, s.countLoginRequest("10.0.0.1"))
+}
+
+func TestMagicLinkURL(t *testing.T) {
+	s := newTestService(t)
+
+	link, err := s.getMagicLink("abc")
+	require.NoError(t, err)
+	assert.Empty(t, link)
+
+	s.magicLinkURL = "https://app.example.com/login?next=%2Fhome"
+	link, err = s.getMagicLink("abc")
+	require.NoError(t, err)
+	assert.Equal(t, "https://app.example.com/login?next=%2Fhome&token=abc", link)
+}
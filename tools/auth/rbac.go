@@ -0,0 +1,461 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/unluckythoughts/go-microservice/v2/tools/web"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	roleNameRegex = regexp.MustCompile(`^[\w.-]+$`)
+	// permissions are colon separated segments, a * segment at the end
+	// grants all the permissions with the same prefix, e.g. orders:*
+	permissionRegex = regexp.MustCompile(`^(\*|[\w.-]+(:[\w.-]+)*(:\*)?)$`)
+)
+
+// matchPermission returns true if the granted permission grants the required one
+func matchPermission(granted, required string) bool {
+	if granted == required || granted == "*" {
+		return true
+	}
+
+	prefix, ok := strings.CutSuffix(granted, "*")
+	return ok && strings.HasPrefix(required, prefix)
+}
+
+// missingPermissions returns the required permissions missing from the granted ones
+func missingPermissions(granted []string, required []string) []string {
+	missing := []string{}
+	for _, req := range required {
+		found := false
+		for _, g := range granted {
+			if matchPermission(g, req) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, req)
+		}
+	}
+
+	return missing
+}
+
+func validatePermissions(names []string) error {
+	for _, name := range names {
+		if !permissionRegex.MatchString(name) {
+			return ErrInvalidPermission.WithDetails(map[string]string{"permission": name})
+		}
+	}
+
+	return nil
+}
+
+// getPermissions returns the permissions with the names, creating the missing ones
+func getPermissions(tx *gorm.DB, names []string) ([]Permission, error) {
+	if len(names) == 0 {
+		return []Permission{}, nil
+	}
+	if err := validatePermissions(names); err != nil {
+		return nil, err
+	}
+
+	perms := make([]Permission, 0, len(names))
+	for _, name := range names {
+		perms = append(perms, Permission{Name: name})
+	}
+	err := tx.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "name"}}, DoNothing: true}).
+		Create(&perms).Error
+	if err != nil {
+		return nil, err
+	}
+
+	perms = []Permission{}
+	err = tx.Where("name IN ?", names).Order("name").Find(&perms).Error
+	return perms, err
+}
+
+// CreatePermission creates a permission
+func (s *Service) CreatePermission(name, description string) (*Permission, error) {
+	if err := validatePermissions([]string{name}); err != nil {
+		return nil, err
+	}
+
+	perm := &Permission{Name: name, Description: description}
+	res := s.db.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "name"}}, DoNothing: true}).
+		Create(perm)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrPermissionExists
+	}
+
+	return perm, nil
+}
+
+// ListPermissions returns all the permissions
+func (s *Service) ListPermissions() ([]Permission, error) {
+	perms := []Permission{}
+	err := s.db.Order("name").Find(&perms).Error
+	return perms, err
+}
+
+// DeletePermission deletes the permission and removes it from the roles
+func (s *Service) DeletePermission(name string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		perm := Permission{}
+		err := tx.Where("name = ?", name).First(&perm).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrPermissionNotFound
+		} else if err != nil {
+			return err
+		}
+
+		if err := tx.Table("role_permissions").Where("permission_id = ?", perm.ID).Delete(nil).Error; err != nil {
+			return err
+		}
+
+		return tx.Delete(&perm).Error
+	})
+}
+
+// GetRole returns the role with its permissions
+func (s *Service) GetRole(name string) (*RoleDefinition, error) {
+	role := RoleDefinition{}
+	err := s.db.Preload("Permissions", func(db *gorm.DB) *gorm.DB {
+		return db.Order("name")
+	}).Where("name = ?", name).First(&role).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRoleNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &role, nil
+}
+
+// ListRoles returns all the roles with their permissions
+func (s *Service) ListRoles() ([]RoleDefinition, error) {
+	roles := []RoleDefinition{}
+	err := s.db.Preload("Permissions", func(db *gorm.DB) *gorm.DB {
+		return db.Order("name")
+	}).Order("name").Find(&roles).Error
+	return roles, err
+}
+
+// saveRole creates or updates the role with the permissions, creating the
+// missing permissions. exists is nil to upsert, otherwise the role must exist
+// or not
+func (s *Service) saveRole(name, description string, permissions []string, exists *bool) (*RoleDefinition, error) {
+	if !roleNameRegex.MatchString(name) {
+		return nil, ErrInvalidRoleName
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		perms, err := getPermissions(tx, permissions)
+		if err != nil {
+			return err
+		}
+
+		role := RoleDefinition{}
+		err = tx.Where("name = ?", name).First(&role).Error
+		found := err == nil
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		if exists != nil && *exists && !found {
+			return ErrRoleNotFound
+		} else if exists != nil && !*exists && found {
+			return ErrRoleExists
+		}
+
+		role.Name = name
+		role.Description = description
+		if err := tx.Omit("Permissions").Save(&role).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&role).Association("Permissions").Replace(perms)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetRole(name)
+}
+
+// CreateRole creates a role granting the permissions, the missing permissions are created
+func (s *Service) CreateRole(name, description string, permissions ...string) (*RoleDefinition, error) {
+	exists := false
+	return s.saveRole(name, description, permissions, &exists)
+}
+
+// UpdateRole replaces the description and permissions of the role
+func (s *Service) UpdateRole(name, description string, permissions ...string) (*RoleDefinition, error) {
+	exists := true
+	return s.saveRole(name, description, permissions, &exists)
+}
+
+// SaveRole creates the role or replaces its description and permissions,
+// e.g. to seed the roles on start up
+func (s *Service) SaveRole(name, description string, permissions ...string) (*RoleDefinition, error) {
+	return s.saveRole(name, description, permissions, nil)
+}
+
+// DeleteRole deletes the role and its assignments
+func (s *Service) DeleteRole(name string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		role := RoleDefinition{}
+		err := tx.Where("name = ?", name).First(&role).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrRoleNotFound
+		} else if err != nil {
+			return err
+		}
+
+		if err := tx.Model(&role).Association("Permissions").Clear(); err != nil {
+			return err
+		}
+		if err := tx.Where("role_id = ?", role.ID).Delete(&UserRoleAssignment{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Delete(&role).Error
+	})
+}
+
+// getRoleIDs returns the IDs of the roles with the names
+func getRoleIDs(tx *gorm.DB, names []string) ([]uint, error) {
+	if len(names) == 0 {
+		return []uint{}, nil
+	}
+
+	roles := []RoleDefinition{}
+	if err := tx.Where("name IN ?", names).Find(&roles).Error; err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, 0, len(roles))
+	found := map[string]bool{}
+	for _, role := range roles {
+		ids = append(ids, role.ID)
+		found[role.Name] = true
+	}
+	for _, name := range names {
+		if !found[name] {
+			return nil, ErrRoleNotFound.WithDetails(map[string]string{"role": name})
+		}
+	}
+
+	return ids, nil
+}
+
+// AssignRoles assigns the roles to the user, in addition to its current roles
+func (s *Service) AssignRoles(userID uint, roles ...string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		ids, err := getRoleIDs(tx, roles)
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+
+		assignments := make([]UserRoleAssignment, 0, len(ids))
+		for _, id := range ids {
+			assignments = append(assignments, UserRoleAssignment{UserID: userID, RoleID: id})
+		}
+
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&assignments).Error
+	})
+}
+
+// RevokeRoles removes the roles from the user
+func (s *Service) RevokeRoles(userID uint, roles ...string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		ids, err := getRoleIDs(tx, roles)
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+
+		return tx.Where("user_id = ? AND role_id IN ?", userID, ids).Delete(&UserRoleAssignment{}).Error
+	})
+}
+
+// SetUserRoles replaces the roles assigned to the user
+func (s *Service) SetUserRoles(userID uint, roles ...string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		ids, err := getRoleIDs(tx, roles)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Where("user_id = ?", userID).Delete(&UserRoleAssignment{}).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		assignments := make([]UserRoleAssignment, 0, len(ids))
+		for _, id := range ids {
+			assignments = append(assignments, UserRoleAssignment{UserID: userID, RoleID: id})
+		}
+
+		return tx.Create(&assignments).Error
+	})
+}
+
+// GetAssignedRoles returns the names of the roles assigned to the user,
+// without the roles granted by its ordinal role
+func (s *Service) GetAssignedRoles(userID uint) ([]string, error) {
+	names := []string{}
+	err := s.db.Model(&RoleDefinition{}).
+		Joins("JOIN user_roles ON user_roles.role_id = roles.id").
+		Where("user_roles.user_id = ?", userID).
+		Order("roles.name").
+		Pluck("roles.name", &names).Error
+	return names, err
+}
+
+// getOrdinalRoleNames returns the names of the ordinal roles granted by the
+// ordinal role of the user, the role itself and the lower ones
+func (s *Service) getOrdinalRoleNames(role Role) []string {
+	names := []string{}
+	for ordinal, name := range s.userRoles {
+		if ordinal <= role {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// GetUserPermissions returns the roles of the user, assigned or granted by
+// its ordinal role, and the permissions they grant. The mapping only goes from
+// the ordinal role to the roles, an assigned role never grants an ordinal role
+func (s *Service) GetUserPermissions(user *User) (*UserPermissions, error) {
+	assigned, err := s.GetAssignedRoles(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := map[string]bool{}
+	for _, name := range append(assigned, s.getOrdinalRoleNames(user.Role)...) {
+		roles[name] = true
+	}
+
+	up := &UserPermissions{Roles: make([]string, 0, len(roles))}
+	for name := range roles {
+		up.Roles = append(up.Roles, name)
+	}
+	sort.Strings(up.Roles)
+
+	if up.Permissions, err = s.getRolePermissions(up.Roles); err != nil {
+		return nil, err
+	}
+
+	return up, nil
+}
+
+// HasPermissions returns true if the roles of the user grant all the permissions
+func (s *Service) HasPermissions(user *User, permissions ...string) (bool, error) {
+	up, err := s.GetUserPermissions(user)
+	if err != nil {
+		return false, err
+	}
+
+	return len(missingPermissions(up.Permissions, permissions)) == 0, nil
+}
+
+// getRolePermissions returns the permissions granted by the roles with the names
+func (s *Service) getRolePermissions(roles []string) ([]string, error) {
+	perms := []string{}
+	if len(roles) == 0 {
+		return perms, nil
+	}
+
+	err := s.db.Model(&Permission{}).
+		Distinct("permissions.name").
+		Joins("JOIN role_permissions ON role_permissions.permission_id = permissions.id").
+		Joins("JOIN roles ON roles.id = role_permissions.role_id").
+		Where("roles.name IN ?", roles).
+		Order("permissions.name").
+		Pluck("permissions.name", &perms).Error
+	return perms, err
+}
+
+// checkGrantPermissions returns ErrForbidden unless the roles of the admin
+// grant all the permissions, admins only give the permissions they have
+func (s *Service) checkGrantPermissions(admin *User, permissions []string) error {
+	if len(permissions) == 0 {
+		return nil
+	}
+
+	up, err := s.GetUserPermissions(admin)
+	if err != nil {
+		return err
+	}
+	if missing := missingPermissions(up.Permissions, permissions); len(missing) > 0 {
+		return ErrForbidden.WithDetails(map[string][]string{"missing_permissions": missing})
+	}
+
+	return nil
+}
+
+// checkGrantRoles returns ErrForbidden unless the admin can create, change or
+// assign the roles: the roles named like an ordinal role at or above the role
+// of the admin and the roles granting permissions the admin lacks are refused
+func (s *Service) checkGrantRoles(admin *User, roles []string) error {
+	for _, name := range roles {
+		for ordinal, ordinalName := range s.userRoles {
+			if name == ordinalName && ordinal >= admin.Role {
+				return ErrForbidden.WithDetails(map[string]string{"role": name})
+			}
+		}
+	}
+
+	perms, err := s.getRolePermissions(roles)
+	if err != nil {
+		return err
+	}
+
+	return s.checkGrantPermissions(admin, perms)
+}
+
+// EnsurePermission returns a middleware allowing the users whose roles grant
+// all the permissions, requests authenticated with an API key also need
+// scopes granting them
+func (s *Service) EnsurePermission(permissions ...string) web.Middleware {
+	if err := validatePermissions(permissions); err != nil {
+		panic(fmt.Errorf("invalid permissions %v: %w", permissions, err))
+	}
+
+	return func(r web.MiddlewareRequest) error {
+		user, err := s.getUserFromRequest(r)
+		if err != nil {
+			return err
+		}
+
+		up, err := s.WithContext(r.GetContext()).GetUserPermissions(user)
+		if err != nil {
+			return ErrInternal.WithCause(err)
+		}
+
+		if missing := missingPermissions(up.Permissions, permissions); len(missing) > 0 {
+			return ErrForbidden.WithDetails(map[string][]string{"missing_permissions": missing})
+		}
+		if missing := missingAPIKeyScopes(r, permissions...); len(missing) > 0 {
+			return ErrForbidden.WithDetails(map[string][]string{"missing_scopes": missing})
+		}
+
+		setAuthenticatedUser(r.GetContext(), user)
+		return nil
+	}
+}
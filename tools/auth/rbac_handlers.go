@@ -0,0 +1,233 @@
+package auth
+
+import (
+	"errors"
+
+	localcontext "github.com/unluckythoughts/go-microservice/v2/tools/context"
+	"github.com/unluckythoughts/go-microservice/v2/tools/web"
+)
+
+type (
+	// namePathRequest is a request for the role or permission of the path
+	namePathRequest struct {
+		Name string `path:"name"`
+	}
+
+	// userPathRequest is a request for the user of the path
+	userPathRequest struct {
+		UserID uint `path:"userId" valid:"required~user id is required"`
+	}
+)
+
+// getRBACError returns the coded errors of the RBAC methods as is and wraps the others
+func getRBACError(err error) error {
+	for _, coded := range []error{
+		ErrInvalidRoleName, ErrInvalidPermission, ErrRoleExists,
+		ErrRoleNotFound, ErrPermissionExists, ErrPermissionNotFound, ErrUserNotFound, ErrForbidden,
+	} {
+		if errors.Is(err, coded) {
+			return err
+		}
+	}
+
+	return ErrInternal.WithCause(err)
+}
+
+// checkGrantRoleRequest returns ErrForbidden unless the authenticated admin can
+// give the role of the request and its permissions
+func (s *Service) checkGrantRoleRequest(ctx localcontext.Context, body RoleRequest) error {
+	admin, err := getAuthenticatedUser(ctx)
+	if err != nil {
+		return err
+	}
+	if err := s.checkGrantRoles(admin, []string{body.Name}); err != nil {
+		return getRBACError(err)
+	}
+	if err := s.checkGrantPermissions(admin, body.Permissions); err != nil {
+		return getRBACError(err)
+	}
+
+	return nil
+}
+
+// GetPermissionsHandler returns the roles and permissions of the authenticated user
+// example path: GET .../user/permissions
+func (s *Service) GetPermissionsHandler(r web.Request) (any, error) {
+	return handle(s, func(s *Service, r web.Request) (any, error) {
+		user, err := GetAuthenticatedUser(r)
+		if err != nil {
+			return nil, err
+		}
+
+		up, err := s.GetUserPermissions(user)
+		if err != nil {
+			return nil, ErrInternal.WithCause(err)
+		}
+
+		return up, nil
+	})(r)
+}
+
+// ListRolesHandler returns all the roles with their permissions
+// example path: GET .../admin/roles
+func (s *Service) ListRolesHandler(r web.Request) (any, error) {
+	return handle(s, func(s *Service, r web.Request) (any, error) {
+		roles, err := s.ListRoles()
+		if err != nil {
+			return nil, ErrInternal.WithCause(err)
+		}
+
+		return roles, nil
+	})(r)
+}
+
+// CreateRoleHandler creates a role, admins only create roles granting the
+// permissions they have
+// example path: POST .../admin/roles
+func (s *Service) CreateRoleHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, body RoleRequest) (*RoleDefinition, error) {
+		if err := s.checkGrantRoleRequest(ctx, body); err != nil {
+			return nil, err
+		}
+
+		role, err := s.CreateRole(body.Name, body.Description, body.Permissions...)
+		if err != nil {
+			return nil, getRBACError(err)
+		}
+
+		s.audit(AuditRoleCreated, 0, "role", role.Name, "permissions", body.Permissions)
+		return role, nil
+	})(r)
+}
+
+// GetRoleHandler returns the role with its permissions
+// example path: GET .../admin/roles/:name
+func (s *Service) GetRoleHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, req namePathRequest) (*RoleDefinition, error) {
+		role, err := s.GetRole(req.Name)
+		if err != nil {
+			return nil, getRBACError(err)
+		}
+		return role, nil
+	})(r)
+}
+
+// UpdateRoleHandler replaces the description and permissions of the role,
+// admins only give the role the permissions they have
+// example path: PUT .../admin/roles/:name
+func (s *Service) UpdateRoleHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, body RoleRequest) (*RoleDefinition, error) {
+		if err := s.checkGrantRoleRequest(ctx, body); err != nil {
+			return nil, err
+		}
+
+		role, err := s.UpdateRole(body.Name, body.Description, body.Permissions...)
+		if err != nil {
+			return nil, getRBACError(err)
+		}
+
+		s.audit(AuditRoleUpdated, 0, "role", role.Name, "permissions", body.Permissions)
+		return role, nil
+	})(r)
+}
+
+// DeleteRoleHandler deletes the role and its assignments
+// example path: DELETE .../admin/roles/:name
+func (s *Service) DeleteRoleHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, req namePathRequest) (string, error) {
+		if err := s.DeleteRole(req.Name); err != nil {
+			return "", getRBACError(err)
+		}
+
+		s.audit(AuditRoleDeleted, 0, "role", req.Name)
+		return "role deleted successfully", nil
+	})(r)
+}
+
+// ListPermissionsHandler returns all the permissions
+// example path: GET .../admin/permissions
+func (s *Service) ListPermissionsHandler(r web.Request) (any, error) {
+	return handle(s, func(s *Service, r web.Request) (any, error) {
+		perms, err := s.ListPermissions()
+		if err != nil {
+			return nil, ErrInternal.WithCause(err)
+		}
+
+		return perms, nil
+	})(r)
+}
+
+// CreatePermissionHandler creates a permission
+// example path: POST .../admin/permissions
+func (s *Service) CreatePermissionHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, body PermissionRequest) (*Permission, error) {
+		perm, err := s.CreatePermission(body.Name, body.Description)
+		if err != nil {
+			return nil, getRBACError(err)
+		}
+		return perm, nil
+	})(r)
+}
+
+// DeletePermissionHandler deletes the permission and removes it from the roles
+// example path: DELETE .../admin/permissions/:name
+func (s *Service) DeletePermissionHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, req namePathRequest) (string, error) {
+		if err := s.DeletePermission(req.Name); err != nil {
+			return "", getRBACError(err)
+		}
+		return "permission deleted successfully", nil
+	})(r)
+}
+
+// GetUserRolesHandler returns the roles and permissions of the user
+// example path: GET .../admin/users/:userId/roles
+func (s *Service) GetUserRolesHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, req userPathRequest) (*UserPermissions, error) {
+		user, err := s.GetUserByID(req.UserID)
+		if err != nil {
+			return nil, getRBACError(err)
+		}
+
+		up, err := s.GetUserPermissions(user)
+		if err != nil {
+			return nil, ErrInternal.WithCause(err)
+		}
+		return up, nil
+	})(r)
+}
+
+// SetUserRolesHandler replaces the roles assigned to the user, admins only
+// assign roles to the users they manage and roles granting the permissions
+// they have
+// example path: PUT .../admin/users/:userId/roles
+func (s *Service) SetUserRolesHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, body UserRolesRequest) (string, error) {
+		admin, err := s.checkManagedUser(ctx, body.UserID)
+		if err != nil {
+			return "", err
+		}
+		if err := s.checkGrantRoles(admin, body.Roles); err != nil {
+			return "", getRBACError(err)
+		}
+
+		if err := s.SetUserRoles(body.UserID, body.Roles...); err != nil {
+			return "", getRBACError(err)
+		}
+
+		s.audit(AuditUserRolesChanged, body.UserID, "roles", body.Roles)
+		return "user roles updated successfully", nil
+	})(r)
+}
+
+// ListAuditEventsHandler returns the page of the audit events matching the query
+// example path: GET .../admin/audit?user_id=1&action=login&page=2
+func (s *Service) ListAuditEventsHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, q AuditQuery) (*AuditEventPage, error) {
+		page, err := s.ListAuditEvents(q)
+		if err != nil {
+			return nil, ErrInternal.WithCause(err)
+		}
+		return page, nil
+	})(r)
+}
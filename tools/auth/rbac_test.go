@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchPermission(t *testing.T) {
+	tests := []struct {
+		granted, required string
+		match             bool
+	}{
+		{"orders:write", "orders:write", true},
+		{"orders:read", "orders:write", false},
+		{"orders:*", "orders:write", true},
+		{"orders:*", "orders:items:write", true},
+		{"orders:*", "users:write", false},
+		{"*", "users:write", true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.match, matchPermission(tt.granted, tt.required), "%s grants %s", tt.granted, tt.required)
+	}
+
+	assert.Equal(t, []string{"users:read"}, missingPermissions([]string{"orders:*"}, []string{"orders:write", "users:read"}))
+}
+
+func TestRoles(t *testing.T) {
+	s := newTestService(t)
+
+	role, err := s.CreateRole("support", "support team", "orders:read", "users:read")
+	require.NoError(t, err)
+	assert.Len(t, role.Permissions, 2)
+
+	_, err = s.CreateRole("support", "")
+	assert.ErrorIs(t, err, ErrRoleExists)
+	_, err = s.CreateRole("bad role", "")
+	assert.ErrorIs(t, err, ErrInvalidRoleName)
+	_, err = s.CreateRole("writer", "", "orders write")
+	assert.ErrorIs(t, err, ErrInvalidPermission)
+
+	role, err = s.UpdateRole("support", "support team", "orders:*")
+	require.NoError(t, err)
+	require.Len(t, role.Permissions, 1)
+	assert.Equal(t, "orders:*", role.Permissions[0].Name)
+
+	_, err = s.UpdateRole("missing", "")
+	assert.ErrorIs(t, err, ErrRoleNotFound)
+
+	perms, err := s.ListPermissions()
+	require.NoError(t, err)
+	assert.Len(t, perms, 3, "permissions removed from roles are kept")
+
+	_, err = s.CreatePermission("users:read", "")
+	assert.ErrorIs(t, err, ErrPermissionExists)
+	require.NoError(t, s.DeletePermission("orders:*"))
+	role, err = s.GetRole("support")
+	require.NoError(t, err)
+	assert.Empty(t, role.Permissions)
+
+	require.NoError(t, s.DeleteRole("support"))
+	assert.ErrorIs(t, s.DeleteRole("support"), ErrRoleNotFound)
+	roles, err := s.ListRoles()
+	require.NoError(t, err)
+	assert.Empty(t, roles)
+}
+
+func TestUserPermissions(t *testing.T) {
+	s := newTestService(t)
+
+	_, err := s.SaveRole("user", "", "orders:read")
+	require.NoError(t, err)
+	_, err = s.SaveRole("admin", "", "*")
+	require.NoError(t, err)
+	_, err = s.SaveRole("support", "", "orders:write", "users:read")
+	require.NoError(t, err)
+	_, err = s.SaveRole("billing", "", "invoices:*")
+	require.NoError(t, err)
+
+	user := &User{Role: 1}
+	user.ID = 5
+
+	// the ordinal role grants the role with its name
+	up, err := s.GetUserPermissions(user)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user"}, up.Roles)
+	assert.Equal(t, []string{"orders:read"}, up.Permissions)
+
+	require.NoError(t, s.AssignRoles(user.ID, "support", "billing"))
+	require.NoError(t, s.AssignRoles(user.ID, "support"))
+	assert.ErrorIs(t, s.AssignRoles(user.ID, "missing"), ErrRoleNotFound)
+
+	up, err = s.GetUserPermissions(user)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"billing", "support", "user"}, up.Roles)
+	assert.Equal(t, []string{"invoices:*", "orders:read", "orders:write", "users:read"}, up.Permissions)
+
+	ok, err := s.HasPermissions(user, "orders:write", "invoices:pay")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = s.HasPermissions(user, "users:write")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, s.RevokeRoles(user.ID, "billing"))
+	assigned, err := s.GetAssignedRoles(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"support"}, assigned)
+
+	// higher ordinal roles grant the roles of the lower ones
+	admin := &User{Role: 99}
+	admin.ID = 6
+	ok, err = s.HasPermissions(admin, "users:write", "orders:read")
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestRBACEscalation(t *testing.T) {
+	s, admin, user, _ := newImpersonationTestService(t)
+
+	_, err := s.SaveRole("superadmin", "", "*")
+	require.NoError(t, err)
+	_, err = s.SaveRole("support", "", "orders:read")
+	require.NoError(t, err)
+	_, err = s.SaveRole("admin", "", "orders:*")
+	require.NoError(t, err)
+
+	// admins cannot give themselves or others roles or permissions they lack
+	assert.ErrorIs(t, s.checkManageUser(admin, admin.ID), ErrForbidden, "admins cannot assign roles to themselves")
+	assert.ErrorIs(t, s.checkGrantRoles(admin, []string{"superadmin"}), ErrForbidden)
+	assert.ErrorIs(t, s.checkGrantPermissions(admin, []string{"*"}), ErrForbidden)
+	assert.ErrorIs(t, s.checkGrantPermissions(admin, []string{"users:write"}), ErrForbidden)
+	assert.ErrorIs(t, s.checkGrantRoles(admin, []string{"admin"}), ErrForbidden, "roles named like the ordinal role of the admin")
+	assert.NoError(t, s.checkGrantRoles(admin, []string{"support"}), "the admin role grants orders:*")
+	assert.NoError(t, s.checkGrantPermissions(admin, []string{"orders:write"}))
+
+	// assigned roles grant their permissions but never an ordinal role
+	require.NoError(t, s.SetUserRoles(user.ID, "admin"))
+	up, err := s.GetUserPermissions(user)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"orders:*"}, up.Permissions)
+	assert.ErrorIs(t, s.checkGrantRoles(user, []string{"admin"}), ErrForbidden, "the assigned admin role does not make the user an admin")
+}
@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// refreshTokenBytes is the number of random bytes of a refresh token
+const refreshTokenBytes = 32
+
+// hashRefreshToken returns the hash saved for the refresh token. Refresh tokens
+// are random, so unlike passwords they do not need a salted hash, which could
+// not be looked up
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// createRefreshToken saves a new refresh token of the family for the user and returns it
+func (s *Service) createRefreshToken(db *gorm.DB, userID uint, familyID string) (string, error) {
+	b := make([]byte, refreshTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate refresh token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	rt := RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: hashRefreshToken(token),
+		ExpiresAt: time.Now().Add(s.refreshTokenValid),
+	}
+	if err := db.Create(&rt).Error; err != nil {
+		return "", fmt.Errorf("could not save refresh token: %w", err)
+	}
+
+	return token, nil
+}
+
+// CreateRefreshToken creates the first refresh token of a new family for the user,
+// e.g. on log in. The family is a new session of the user, so it can be revoked
+func (s *Service) CreateRefreshToken(userID uint) (string, error) {
+	session, err := s.createSession(userID, "", "")
+	if err != nil {
+		return "", err
+	}
+
+	return s.createRefreshToken(s.db, userID, session.SessionID)
+}
+
+// RotateRefreshToken exchanges the refresh token for a new one of the same family
+// and returns the ID of its user. Presenting a token that was already rotated
+// revokes the whole family, as the token was stolen from either the client or
+// the attacker using it
+func (s *Service) RotateRefreshToken(token string) (uint, string, error) {
+	rt, newToken, err := s.rotateRefreshToken(token)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return rt.UserID, newToken, nil
+}
+
+// rotateRefreshToken is RotateRefreshToken returning the rotated token
+func (s *Service) rotateRefreshToken(token string) (*RefreshToken, string, error) {
+	rt := RefreshToken{}
+	err := s.db.Where("token_hash = ?", hashRefreshToken(token)).First(&rt).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, "", ErrInvalidRefreshToken
+	} else if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	if rt.RevokedAt != nil || !rt.ExpiresAt.After(now) {
+		return nil, "", ErrInvalidRefreshToken
+	}
+	if rt.RotatedAt != nil {
+		return nil, "", s.revokeReusedRefreshToken(rt)
+	}
+
+	var newToken string
+	reused := false
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// only one of concurrent rotations of the same token updates the row
+		res := tx.Model(&RefreshToken{}).
+			Where("id = ? AND rotated_at IS NULL AND revoked_at IS NULL", rt.ID).
+			Update("rotated_at", now)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			reused = true
+			return nil
+		}
+
+		var err error
+		newToken, err = s.createRefreshToken(tx, rt.UserID, rt.FamilyID)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if reused {
+		return nil, "", s.revokeReusedRefreshToken(rt)
+	}
+
+	return &rt, newToken, nil
+}
+
+// revokeReusedRefreshToken revokes the family of a refresh token presented after
+// it was rotated and returns ErrRefreshTokenReused
+func (s *Service) revokeReusedRefreshToken(rt RefreshToken) error {
+	s.l.Warn("refresh token reused, revoking its family",
+		zap.Uint("user_id", rt.UserID), zap.Uint("token_id", rt.ID))
+
+	err := s.db.Model(&RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", rt.FamilyID).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return fmt.Errorf("could not revoke refresh tokens: %w", err)
+	}
+
+	return ErrRefreshTokenReused
+}
+
+// RevokeUserRefreshTokens revokes all the refresh tokens of the user
+func (s *Service) RevokeUserRefreshTokens(userID uint) error {
+	return s.db.Model(&RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newTestService(t *testing.T) *Service {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "auth.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(
+		&User{}, &Verify{}, &RefreshToken{}, &Permission{}, &RoleDefinition{}, &UserRoleAssignment{},
+		&APIKey{}, &UserMFA{}, &MFARecoveryCode{}, &LoginAttempt{}, &UserIdentity{},
+		&UserSession{}, &AuditEvent{}, &Organization{}, &OrgMember{}, &OrgInvitation{},
+	))
+
+	return New(Options{DB: db, Logger: zap.NewNop(), JwtKey: "test-key"})
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	s := newTestService(t)
+
+	first, err := s.CreateRefreshToken(5)
+	require.NoError(t, err)
+
+	userID, second, err := s.RotateRefreshToken(first)
+	require.NoError(t, err)
+	assert.Equal(t, uint(5), userID)
+	assert.NotEqual(t, first, second)
+
+	userID, third, err := s.RotateRefreshToken(second)
+	require.NoError(t, err)
+	assert.Equal(t, uint(5), userID)
+
+	tokens := []RefreshToken{}
+	require.NoError(t, s.db.Order("id").Find(&tokens).Error)
+	require.Len(t, tokens, 3)
+	for _, rt := range tokens {
+		assert.Equal(t, tokens[0].FamilyID, rt.FamilyID)
+		assert.NotContains(t, []string{first, second, third}, rt.TokenHash)
+	}
+	assert.NotNil(t, tokens[0].RotatedAt)
+	assert.NotNil(t, tokens[1].RotatedAt)
+	assert.Nil(t, tokens[2].RotatedAt)
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	s := newTestService(t)
+
+	first, err := s.CreateRefreshToken(5)
+	require.NoError(t, err)
+	other, err := s.CreateRefreshToken(5)
+	require.NoError(t, err)
+
+	_, second, err := s.RotateRefreshToken(first)
+	require.NoError(t, err)
+
+	_, _, err = s.RotateRefreshToken(first)
+	assert.ErrorIs(t, err, ErrRefreshTokenReused)
+
+	// the token rotated from the reused one is revoked with it
+	_, _, err = s.RotateRefreshToken(second)
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+
+	// tokens of other log ins are not
+	_, _, err = s.RotateRefreshToken(other)
+	assert.NoError(t, err)
+}
+
+func TestInvalidRefreshToken(t *testing.T) {
+	s := newTestService(t)
+
+	_, _, err := s.RotateRefreshToken("unknown")
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+
+	expired, err := s.CreateRefreshToken(5)
+	require.NoError(t, err)
+	require.NoError(t, s.db.Model(&RefreshToken{}).
+		Where("token_hash = ?", hashRefreshToken(expired)).
+		Update("expires_at", time.Now().Add(-time.Minute)).Error)
+
+	_, _, err = s.RotateRefreshToken(expired)
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+
+	revoked, err := s.CreateRefreshToken(6)
+	require.NoError(t, err)
+	require.NoError(t, s.RevokeUserRefreshTokens(6))
+
+	_, _, err = s.RotateRefreshToken(revoked)
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+}
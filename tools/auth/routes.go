@@ -2,40 +2,456 @@ package auth
 
 import (
 	"fmt"
+	"net/http"
 	"strings"
 
 	"github.com/unluckythoughts/go-microservice/v2/tools/web"
 )
 
+// RegisterAuthRoutes attaches the auth routes under prefix + "/auth".
+// r can be the service router or a group, in which case the prefix is
+// relative to the group and can be left empty.
 func RegisterAuthRoutes(r web.Router, prefix string, as *Service, userRole Role) error {
 	if userRole == 0 {
 		userRole = Role(1)
 	}
 
-	if prefix == "" {
-		prefix = "/"
-	} else if !strings.HasPrefix(prefix, "/") {
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
 		return fmt.Errorf("route prefix has to start with '/'")
 	}
 
 	prefix = strings.TrimRight(prefix, "/")
+	g := r.Group(prefix + "/auth")
+
+	tags := []string{"auth"}
+	// the routes changing the credentials and sessions of the user cannot be impersonated
+	deny := as.DenyImpersonation()
 
 	// Auth routes
-	r.POST(prefix+"/auth/login", as.LoginHandler)
-	r.POST(prefix+"/auth/register", as.GetRegisterHandlerForUserRole(userRole))
-	r.GET(prefix+"/auth/verify/:target/:token", as.VerifyTokenHandler)
-	r.PUT(prefix+"/auth/update-password", as.UpdatePasswordHandler)
+	g.POST("/login", web.Doc{
+		Summary: "log in with email or mobile and password, failed logins delay and lock the next ones", Tags: tags,
+		Request: Credentials{}, Response: LoginResponse{}, Errors: []int{http.StatusUnauthorized, http.StatusTooManyRequests},
+	}, as.LoginHandler)
+	g.POST("/refresh", web.Doc{
+		Summary: "exchange a refresh token for new tokens, reusing a refresh token revokes it", Tags: tags,
+		Request: RefreshRequest{}, Response: LoginResponse{}, Errors: []int{http.StatusUnauthorized},
+	}, as.RefreshHandler)
+	g.POST("/unlock", web.Doc{
+		Summary: "unlock the account locked after failed logins with the token sent by email", Tags: tags,
+		Request: UnlockRequest{}, Response: "", Errors: []int{http.StatusBadRequest},
+	}, as.UnlockHandler)
+	g.POST("/mfa/verify", web.Doc{
+		Summary: "exchange the MFA token of the login response and a TOTP or recovery code for the tokens", Tags: tags,
+		Request: MFAVerifyRequest{}, Response: LoginResponse{}, Errors: []int{http.StatusUnauthorized},
+	}, as.VerifyMFAHandler)
+	g.POST("/magic-link", web.Doc{
+		Summary: "send a magic link to the email, it only works on the device asking for it", Tags: tags,
+		Request: MagicLinkRequest{}, Response: PasswordlessResponse{}, Errors: []int{http.StatusTooManyRequests},
+	}, as.MagicLinkHandler)
+	g.POST("/magic-link/verify", web.Doc{
+		Summary: "log in with the token of a magic link, unknown emails are registered", Tags: tags,
+		Request: VerifyMagicLinkRequest{}, Response: LoginResponse{}, Errors: []int{http.StatusBadRequest},
+	}, as.GetVerifyMagicLinkHandler(userRole))
+	g.POST("/otp", web.Doc{
+		Summary: "send a one-time code to the mobile, it only works on the device asking for it", Tags: tags,
+		Request: OTPRequest{}, Response: PasswordlessResponse{}, Errors: []int{http.StatusTooManyRequests},
+	}, as.OTPHandler)
+	g.POST("/otp/verify", web.Doc{
+		Summary: "log in with a one-time code sent to the mobile, unknown mobiles are registered", Tags: tags,
+		Request: VerifyOTPRequest{}, Response: LoginResponse{}, Errors: []int{http.StatusBadRequest, http.StatusTooManyRequests},
+	}, as.GetVerifyOTPHandler(userRole))
+	if as.selfRegistration {
+		g.POST("/register", web.Doc{
+			Summary: "register a new user", Tags: tags,
+			Request: RegisterRequest{}, Response: "",
+		}, as.GetRegisterHandlerForUserRole(userRole))
+	}
+	g.GET("/verify/:target/:token", web.Doc{
+		Summary: "verify the token sent to an email or mobile", Tags: tags,
+		Response: true, Errors: []int{http.StatusBadRequest},
+	}, as.VerifyTokenHandler)
+	g.PUT("/update-password", web.Doc{
+		Summary: "set a new password with a reset password token", Tags: tags,
+		Request: UpdatePasswordRequest{}, Response: "",
+	}, as.UpdatePasswordHandler)
 
 	// Protected auth routes
-	r.GET(prefix+"/auth/logout", as.EnsureRole(userRole), as.LogoutHandler)
+	g.GET("/logout", web.Doc{
+		Summary: "log out and revoke the session of the token or cookie", Tags: tags,
+		Response: "", Auth: true,
+	}, as.EnsureRole(userRole), as.LogoutHandler)
+	g.POST("/logout/everywhere", web.Doc{
+		Summary: "log out of all the sessions of the logged in user, including the current one", Tags: tags,
+		Response: "", Auth: true,
+	}, as.EnsureRole(userRole), deny, as.LogoutEverywhereHandler)
 
 	// Password reset and update routes
-	r.PATCH(prefix+"/auth/reset-password/:target", as.EnsureRole(userRole), as.ResetPasswordHandler)
-	r.PUT(prefix+"/auth/change-password", as.EnsureRole(userRole), as.ChangePasswordHandler)
+	g.PATCH("/reset-password/:target", web.Doc{
+		Summary: "send a reset password token to the user email or mobile", Tags: tags,
+		Response: "", Auth: true, Errors: []int{http.StatusBadRequest},
+	}, as.EnsureRole(userRole), deny, as.ResetPasswordHandler)
+	g.PUT("/change-password", web.Doc{
+		Summary: "change the password of the user", Tags: tags,
+		Request: ChangePasswordRequest{}, Response: "", Auth: true,
+	}, as.EnsureRole(userRole), deny, as.ChangePasswordHandler)
 
 	// User routes
-	r.GET(prefix+"/auth/user", as.EnsureRole(userRole), as.GetUserHandler)
-	r.PUT(prefix+"/auth/user", as.EnsureRole(userRole), as.UpdateUserHandler)
+	g.GET("/user", web.Doc{
+		Summary: "get the logged in user", Tags: tags,
+		Response: User{}, Auth: true,
+	}, as.EnsureRole(userRole), as.GetUserHandler)
+	g.PUT("/user", web.Doc{
+		Summary: "update the logged in user", Tags: tags,
+		Request: UpdateUserRequest{}, Response: "", Auth: true,
+	}, as.EnsureRole(userRole), deny, as.UpdateUserHandler)
+	g.POST("/user/confirm-change", web.Doc{
+		Summary: "confirm the new email or mobile of the logged in user", Tags: tags,
+		Request: ConfirmChangeRequest{}, Response: "", Auth: true,
+		Errors: []int{http.StatusBadRequest, http.StatusConflict},
+	}, as.EnsureRole(userRole), deny, as.ConfirmContactChangeHandler)
+	g.GET("/user/permissions", web.Doc{
+		Summary: "get the roles and permissions of the logged in user", Tags: tags,
+		Response: UserPermissions{}, Auth: true,
+	}, as.EnsureRole(userRole), as.GetPermissionsHandler)
+
+	// Session routes
+	g.GET("/sessions", web.Doc{
+		Summary: "list the sessions of the logged in user on their devices", Tags: tags,
+		Response: []UserSession{}, Auth: true,
+	}, as.EnsureRole(userRole), as.ListSessionsHandler)
+	g.DELETE("/sessions", web.Doc{
+		Summary: "log out of the other sessions of the logged in user", Tags: tags,
+		Response: "", Auth: true,
+	}, as.EnsureRole(userRole), deny, as.RevokeOtherSessionsHandler)
+	g.DELETE("/sessions/:id", web.Doc{
+		Summary: "log out of a session of the logged in user, its refresh tokens are revoked", Tags: tags,
+		Response: "", Auth: true, Errors: []int{http.StatusNotFound},
+	}, as.EnsureRole(userRole), deny, as.RevokeSessionHandler)
+
+	// MFA routes
+	g.GET("/mfa", web.Doc{
+		Summary: "get whether MFA is enabled for the logged in user", Tags: tags,
+		Response: MFAStatus{}, Auth: true,
+	}, as.EnsureRole(userRole), as.GetMFAStatusHandler)
+	g.POST("/mfa/enroll", web.Doc{
+		Summary: "create a TOTP secret, MFA is enabled once a first code is confirmed", Tags: tags,
+		Response: MFAEnrollment{}, Auth: true, Errors: []int{http.StatusConflict},
+	}, as.EnsureRole(userRole), deny, as.EnrollMFAHandler)
+	g.POST("/mfa/confirm", web.Doc{
+		Summary: "enable MFA with a first TOTP code, returns the recovery codes once", Tags: tags,
+		Request: MFACodeRequest{}, Response: MFARecoveryCodes{}, Auth: true, Errors: []int{http.StatusBadRequest, http.StatusUnauthorized},
+	}, as.EnsureRole(userRole), deny, as.ConfirmMFAHandler)
+	g.POST("/mfa/disable", web.Doc{
+		Summary: "disable MFA with a TOTP or recovery code", Tags: tags,
+		Request: MFACodeRequest{}, Response: "", Auth: true, Errors: []int{http.StatusBadRequest, http.StatusUnauthorized},
+	}, as.EnsureRole(userRole), deny, as.DisableMFAHandler)
+	g.POST("/mfa/recovery-codes", web.Doc{
+		Summary: "replace the recovery codes, checking a TOTP or recovery code", Tags: tags,
+		Request: MFACodeRequest{}, Response: MFARecoveryCodes{}, Auth: true, Errors: []int{http.StatusBadRequest, http.StatusUnauthorized},
+	}, as.EnsureRole(userRole), deny, as.RegenerateRecoveryCodesHandler)
+
+	// API key routes
+	g.GET("/api-keys", web.Doc{
+		Summary: "list the API keys of the logged in user", Tags: tags,
+		Response: []APIKey{}, Auth: true,
+	}, as.EnsureRole(userRole), as.ListAPIKeysHandler)
+	g.POST("/api-keys", web.Doc{
+		Summary: "create an API key, the key is only returned once", Tags: tags,
+		Request: CreateAPIKeyRequest{}, Response: CreateAPIKeyResponse{}, Auth: true, Errors: []int{http.StatusBadRequest},
+	}, as.EnsureRole(userRole), deny, as.CreateAPIKeyHandler)
+	g.DELETE("/api-keys/:id", web.Doc{
+		Summary: "revoke an API key of the logged in user", Tags: tags,
+		Response: "", Auth: true, Errors: []int{http.StatusNotFound},
+	}, as.EnsureRole(userRole), deny, as.RevokeAPIKeyHandler)
+
+	// OAuth routes
+	g.GET("/oauth/:provider", web.Doc{
+		Summary: "get the login page URL of the identity provider, it redirects to the redirect_uri with a code and state", Tags: tags,
+		Request: OAuthStartRequest{}, Response: OAuthStartResponse{}, Errors: []int{http.StatusNotFound},
+	}, as.StartOAuthHandler)
+	g.POST("/oauth/:provider/callback", web.Doc{
+		Summary: "log in with the code and state returned by the identity provider, unknown identities are registered", Tags: tags,
+		Request: OAuthCallbackRequest{}, Response: LoginResponse{}, Errors: []int{http.StatusBadRequest, http.StatusUnauthorized},
+	}, as.GetOAuthCallbackHandler(userRole))
+	g.GET("/oauth/:provider/link", web.Doc{
+		Summary: "get the login page URL of the identity provider to link an identity to the logged in user", Tags: tags,
+		Request: OAuthStartRequest{}, Response: OAuthStartResponse{}, Auth: true, Errors: []int{http.StatusNotFound},
+	}, as.EnsureRole(userRole), deny, as.StartLinkIdentityHandler)
+	g.POST("/oauth/:provider/link", web.Doc{
+		Summary: "link the identity of the code and state returned by the identity provider to the logged in user", Tags: tags,
+		Request: OAuthCallbackRequest{}, Response: UserIdentity{}, Auth: true, Errors: []int{http.StatusBadRequest, http.StatusConflict},
+	}, as.EnsureRole(userRole), deny, as.LinkIdentityHandler)
+	g.GET("/identities", web.Doc{
+		Summary: "list the identities linked to the logged in user", Tags: tags,
+		Response: []UserIdentity{}, Auth: true,
+	}, as.EnsureRole(userRole), as.ListIdentitiesHandler)
+	g.DELETE("/identities/:id", web.Doc{
+		Summary: "unlink an identity from the logged in user", Tags: tags,
+		Response: "", Auth: true, Errors: []int{http.StatusBadRequest, http.StatusNotFound},
+	}, as.EnsureRole(userRole), deny, as.UnlinkIdentityHandler)
+
+	return nil
+}
+
+// RegisterRBACRoutes attaches the routes managing the roles, permissions, role
+// assignments, service accounts and API keys and querying the audit log under
+// prefix + "/admin", for the users with adminRole
+func RegisterRBACRoutes(r web.Router, prefix string, as *Service, adminRole Role) error {
+	if adminRole == 0 {
+		return fmt.Errorf("admin role is required")
+	}
+
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		return fmt.Errorf("route prefix has to start with '/'")
+	}
+
+	prefix = strings.TrimRight(prefix, "/")
+	g := r.Group(prefix+"/admin", as.EnsureGlobalRole(adminRole))
+
+	tags := []string{"auth admin"}
+	notFound := []int{http.StatusNotFound}
+	forbidden := []int{http.StatusForbidden, http.StatusNotFound}
+
+	g.GET("/roles", web.Doc{
+		Summary: "list the roles with their permissions", Tags: tags,
+		Response: []RoleDefinition{}, Auth: true,
+	}, as.ListRolesHandler)
+	g.POST("/roles", web.Doc{
+		Summary: "create a role, missing permissions are created", Tags: tags,
+		Request: RoleRequest{}, Response: RoleDefinition{}, Auth: true, Errors: []int{http.StatusForbidden, http.StatusConflict},
+	}, as.CreateRoleHandler)
+	g.GET("/roles/:name", web.Doc{
+		Summary: "get a role with its permissions", Tags: tags,
+		Response: RoleDefinition{}, Auth: true, Errors: notFound,
+	}, as.GetRoleHandler)
+	g.PUT("/roles/:name", web.Doc{
+		Summary: "replace the description and permissions of a role", Tags: tags,
+		Request: RoleRequest{}, Response: RoleDefinition{}, Auth: true, Errors: forbidden,
+	}, as.UpdateRoleHandler)
+	g.DELETE("/roles/:name", web.Doc{
+		Summary: "delete a role and its assignments", Tags: tags,
+		Response: "", Auth: true, Errors: notFound,
+	}, as.DeleteRoleHandler)
+
+	g.GET("/permissions", web.Doc{
+		Summary: "list the permissions", Tags: tags,
+		Response: []Permission{}, Auth: true,
+	}, as.ListPermissionsHandler)
+	g.POST("/permissions", web.Doc{
+		Summary: "create a permission", Tags: tags,
+		Request: PermissionRequest{}, Response: Permission{}, Auth: true, Errors: []int{http.StatusConflict},
+	}, as.CreatePermissionHandler)
+	g.DELETE("/permissions/:name", web.Doc{
+		Summary: "delete a permission and remove it from the roles", Tags: tags,
+		Response: "", Auth: true, Errors: notFound,
+	}, as.DeletePermissionHandler)
+
+	g.GET("/users/:userId/roles", web.Doc{
+		Summary: "get the roles and permissions of a user", Tags: tags,
+		Response: UserPermissions{}, Auth: true, Errors: notFound,
+	}, as.GetUserRolesHandler)
+	g.PUT("/users/:userId/roles", web.Doc{
+		Summary: "replace the roles assigned to a user", Tags: tags,
+		Request: UserRolesRequest{}, Response: "", Auth: true, Errors: forbidden,
+	}, as.SetUserRolesHandler)
+
+	g.POST("/service-accounts", web.Doc{
+		Summary: "create a service account, it authenticates with API keys", Tags: tags,
+		Request: ServiceAccountRequest{}, Response: User{}, Auth: true, Errors: []int{http.StatusConflict},
+	}, as.CreateServiceAccountHandler)
+	g.GET("/users/:userId/api-keys", web.Doc{
+		Summary: "list the API keys of a user or service account", Tags: tags,
+		Response: []APIKey{}, Auth: true,
+	}, as.ListUserAPIKeysHandler)
+	g.POST("/users/:userId/api-keys", web.Doc{
+		Summary: "create an API key for a user or service account, the key is only returned once", Tags: tags,
+		Request: CreateAPIKeyRequest{}, Response: CreateAPIKeyResponse{}, Auth: true, Errors: notFound,
+	}, as.CreateUserAPIKeyHandler)
+	g.DELETE("/users/:userId/api-keys/:id", web.Doc{
+		Summary: "revoke an API key of a user or service account", Tags: tags,
+		Response: "", Auth: true, Errors: notFound,
+	}, as.RevokeUserAPIKeyHandler)
+
+	g.GET("/audit", web.Doc{
+		Summary: "query the audit events by user, action, outcome and time range, the last first", Tags: tags,
+		Response: AuditEventPage{}, Auth: true,
+	}, as.ListAuditEventsHandler)
+
+	return nil
+}
+
+// RegisterAdminRoutes attaches the routes listing and managing the users under
+// prefix + "/admin", for the users with adminRole
+func RegisterAdminRoutes(r web.Router, prefix string, as *Service, adminRole Role) error {
+	if adminRole == 0 {
+		return fmt.Errorf("admin role is required")
+	}
+
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		return fmt.Errorf("route prefix has to start with '/'")
+	}
+
+	prefix = strings.TrimRight(prefix, "/")
+	g := r.Group(prefix+"/admin", as.EnsureGlobalRole(adminRole))
+
+	tags := []string{"auth admin"}
+	notFound := []int{http.StatusNotFound}
+	// admins only manage the users with a lower role than theirs
+	managed := []int{http.StatusForbidden, http.StatusNotFound}
+
+	g.GET("/users", web.Doc{
+		Summary: "search the users by name, email or mobile and filter them by email, role, verification, suspension and creation time", Tags: tags,
+		Response: UserPage{}, Auth: true, Errors: []int{http.StatusBadRequest},
+	}, as.ListUsersHandler)
+	g.GET("/users/:userId", web.Doc{
+		Summary: "get a user", Tags: tags,
+		Response: User{}, Auth: true, Errors: notFound,
+	}, as.AdminGetUserHandler)
+	g.PUT("/users/:userId/role", web.Doc{
+		Summary: "change the role of a user", Tags: tags,
+		Request: UserRoleRequest{}, Response: "", Auth: true, Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound},
+	}, as.UpdateUserRoleHandler)
+	g.POST("/users/:userId/suspend", web.Doc{
+		Summary: "suspend a user, it is logged out everywhere and cannot log in until unsuspended", Tags: tags,
+		Response: "", Auth: true, Errors: managed,
+	}, as.SuspendUserHandler)
+	g.POST("/users/:userId/unsuspend", web.Doc{
+		Summary: "unsuspend a user", Tags: tags,
+		Response: "", Auth: true, Errors: managed,
+	}, as.UnsuspendUserHandler)
+	g.POST("/users/:userId/reset-password", web.Doc{
+		Summary: "replace the password of a user, log it out everywhere and send it a password reset", Tags: tags,
+		Response: "", Auth: true, Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound},
+	}, as.ForcePasswordResetHandler)
+	g.DELETE("/users/:userId", web.Doc{
+		Summary: "delete a user, hard=true removes the user and its data instead of marking it deleted", Tags: tags,
+		Response: "", Auth: true, Errors: managed,
+	}, as.DeleteUserHandler)
 
 	return nil
 }
+
+// RegisterOrgRoutes attaches the routes of the organizations under prefix + "/auth".
+// The users with userRole create organizations, switch between the ones they
+// are members of and accept invitations. The /org routes act on the current
+// organization of the request, the members with orgAdminRole within it manage
+// it, its members and its invitations. orgAdminRole is one of Options.OrgRoles
+func RegisterOrgRoutes(r web.Router, prefix string, as *Service, userRole, orgAdminRole Role) error {
+	if userRole == 0 {
+		userRole = Role(1)
+	}
+	if orgAdminRole == 0 {
+		return fmt.Errorf("organization admin role is required")
+	} else if !as.isOrgRole(orgAdminRole) {
+		return fmt.Errorf("organization admin role has to be one of the organization roles")
+	}
+
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		return fmt.Errorf("route prefix has to start with '/'")
+	}
+
+	prefix = strings.TrimRight(prefix, "/")
+	g := r.Group(prefix + "/auth")
+
+	tags := []string{"auth organizations"}
+	// impersonation tokens carry their organization, they cannot switch or leave it
+	deny := as.DenyImpersonation()
+	noOrg := []int{http.StatusBadRequest, http.StatusForbidden}
+	notFound := []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound}
+	lastAdmin := []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusConflict}
+
+	g.GET("/orgs", web.Doc{
+		Summary: "list the organizations of the logged in user with its role within them", Tags: tags,
+		Response: []OrgMembership{}, Auth: true,
+	}, as.EnsureGlobalRole(userRole), as.ListOrgsHandler)
+	g.POST("/orgs", web.Doc{
+		Summary: "create an organization, the logged in user becomes its admin", Tags: tags,
+		Request: OrgRequest{}, Response: Organization{}, Auth: true,
+	}, as.EnsureGlobalRole(userRole), as.GetCreateOrgHandler(orgAdminRole))
+	g.POST("/orgs/switch", web.Doc{
+		Summary: "switch the current organization of the session, 0 leaves it, the new token carries it", Tags: tags,
+		Request: SwitchOrgRequest{}, Response: LoginResponse{}, Auth: true, Errors: []int{http.StatusForbidden},
+	}, as.EnsureGlobalRole(userRole), deny, as.SwitchOrgHandler)
+	g.POST("/orgs/invitations/accept", web.Doc{
+		Summary: "join the organization of an invitation sent to the email of the logged in user", Tags: tags,
+		Request: AcceptInvitationRequest{}, Response: OrgMembership{}, Auth: true,
+		Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusConflict},
+	}, as.EnsureGlobalRole(userRole), deny, as.AcceptInvitationHandler)
+
+	g.GET("/org", web.Doc{
+		Summary: "get the current organization with the role of the logged in user within it", Tags: tags,
+		Response: OrgMembership{}, Auth: true, Errors: noOrg,
+	}, as.EnsureRole(userRole), as.GetCurrentOrgHandler)
+	g.PUT("/org", web.Doc{
+		Summary: "rename the current organization", Tags: tags,
+		Request: OrgRequest{}, Response: Organization{}, Auth: true, Errors: noOrg,
+	}, as.EnsureRole(orgAdminRole), as.RenameOrgHandler)
+	g.DELETE("/org", web.Doc{
+		Summary: "delete the current organization with its members and invitations", Tags: tags,
+		Response: "", Auth: true, Errors: noOrg,
+	}, as.EnsureRole(orgAdminRole), deny, as.DeleteOrgHandler)
+	g.POST("/org/leave", web.Doc{
+		Summary: "leave the current organization, its last admin cannot leave", Tags: tags,
+		Response: "", Auth: true, Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusConflict},
+	}, as.EnsureRole(userRole), deny, as.GetLeaveOrgHandler(orgAdminRole))
+
+	g.GET("/org/members", web.Doc{
+		Summary: "list the members of the current organization", Tags: tags,
+		Response: []OrgMemberInfo{}, Auth: true, Errors: noOrg,
+	}, as.EnsureRole(userRole), as.ListOrgMembersHandler)
+	g.PUT("/org/members/:userId", web.Doc{
+		Summary: "change the role of a member of the current organization, its last admin cannot be demoted", Tags: tags,
+		Request: OrgMemberRoleRequest{}, Response: "", Auth: true, Errors: lastAdmin,
+	}, as.EnsureRole(orgAdminRole), as.GetSetOrgMemberRoleHandler(orgAdminRole))
+	g.DELETE("/org/members/:userId", web.Doc{
+		Summary: "remove a member from the current organization, its last admin cannot be removed", Tags: tags,
+		Response: "", Auth: true, Errors: lastAdmin,
+	}, as.EnsureRole(orgAdminRole), deny, as.GetRemoveOrgMemberHandler(orgAdminRole))
+
+	g.GET("/org/invitations", web.Doc{
+		Summary: "list the pending invitations of the current organization", Tags: tags,
+		Response: []OrgInvitation{}, Auth: true, Errors: noOrg,
+	}, as.EnsureRole(orgAdminRole), as.ListOrgInvitationsHandler)
+	g.POST("/org/invitations", web.Doc{
+		Summary: "invite an email to join the current organization with a role, the token is sent to the email", Tags: tags,
+		Request: InviteRequest{}, Response: OrgInvitation{}, Auth: true, Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusConflict},
+	}, as.EnsureRole(orgAdminRole), as.InviteOrgMemberHandler)
+	g.DELETE("/org/invitations/:id", web.Doc{
+		Summary: "revoke an invitation of the current organization", Tags: tags,
+		Response: "", Auth: true, Errors: notFound,
+	}, as.EnsureRole(orgAdminRole), as.RevokeOrgInvitationHandler)
+
+	return nil
+}
+
+// RegisterImpersonationRoutes attaches the route issuing the tokens of the
+// users with adminRole impersonating users under prefix + "/auth". The routes
+// using Service.DenyImpersonation refuse the impersonated requests
+func RegisterImpersonationRoutes(r web.Router, prefix string, as *Service, adminRole Role) error {
+	if adminRole == 0 {
+		return fmt.Errorf("admin role is required")
+	}
+
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		return fmt.Errorf("route prefix has to start with '/'")
+	}
+
+	prefix = strings.TrimRight(prefix, "/")
+	g := r.Group(prefix + "/auth")
+
+	tags := []string{"auth admin"}
+
+	g.POST("/impersonate/:userId", web.Doc{
+		Summary: "get a short lived token acting as a user with a lower role, its requests are audited and logging out with it ends the impersonation", Tags: tags,
+		Request: ImpersonateRequest{}, Response: ImpersonationResponse{}, Auth: true, Errors: []int{http.StatusForbidden, http.StatusNotFound},
+	}, as.EnsureGlobalRole(adminRole), as.DenyImpersonation(), as.ImpersonateHandler)
+
+	return nil
+}
+
+// RegisterJWKSRoute serves the public keys verifying the JWT tokens on
+// /.well-known/jwks.json, r should be the service router so the path is
+// at the root. HS256 keys are never published
+func RegisterJWKSRoute(r web.Router, as *Service) {
+	r.Handle(http.MethodGet, "/.well-known/jwks.json", as.keys.JWKSHandler())
+}
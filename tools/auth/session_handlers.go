@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"errors"
+
+	localcontext "github.com/unluckythoughts/go-microservice/v2/tools/context"
+	"github.com/unluckythoughts/go-microservice/v2/tools/web"
+)
+
+// sessionPathRequest is a request for the session of the path
+type sessionPathRequest struct {
+	ID uint `path:"id" valid:"required~session id is required"`
+}
+
+// ListSessionsHandler returns the active sessions of the authenticated user,
+// the session of the request is marked current
+// example path: GET .../sessions
+func (s *Service) ListSessionsHandler(r web.Request) (any, error) {
+	return handle(s, func(s *Service, r web.Request) (any, error) {
+		user, err := GetAuthenticatedUser(r)
+		if err != nil {
+			return nil, err
+		}
+
+		list, err := s.ListSessions(user.ID)
+		if err != nil {
+			return nil, ErrInternal.WithCause(err)
+		}
+
+		current := getAuthenticatedSession(r.GetContext())
+		for i := range list {
+			list[i].Current = current != "" && list[i].SessionID == current
+		}
+
+		return list, nil
+	})(r)
+}
+
+// RevokeSessionHandler logs the authenticated user out of a session
+// example path: DELETE .../sessions/:id
+func (s *Service) RevokeSessionHandler(r web.Request) (any, error) {
+	return typed(s, func(s *Service, ctx localcontext.Context, req sessionPathRequest) (string, error) {
+		user, err := getAuthenticatedUser(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		err = s.RevokeSession(user.ID, req.ID)
+		if errors.Is(err, ErrSessionNotFound) {
+			return "", err
+		} else if err != nil {
+			return "", ErrInternal.WithCause(err)
+		}
+
+		s.audit(AuditSessionRevoked, user.ID, "session_id", req.ID)
+		return "session revoked successfully", nil
+	})(r)
+}
+
+// RevokeOtherSessionsHandler logs the authenticated user out of all the
+// sessions but the one of the request
+// example path: DELETE .../sessions
+func (s *Service) RevokeOtherSessionsHandler(r web.Request) (any, error) {
+	return handle(s, func(s *Service, r web.Request) (any, error) {
+		user, err := GetAuthenticatedUser(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := s.RevokeSessions(user.ID, getAuthenticatedSession(r.GetContext())); err != nil {
+			return nil, ErrInternal.WithCause(err)
+		}
+
+		s.audit(AuditSessionsRevoked, user.ID, "current", false)
+		return "other sessions revoked successfully", nil
+	})(r)
+}
+
+// LogoutEverywhereHandler logs the authenticated user out of all the sessions,
+// including the one of the request
+// example path: POST .../logout/everywhere
+func (s *Service) LogoutEverywhereHandler(r web.Request) (any, error) {
+	return handle(s, func(s *Service, r web.Request) (any, error) {
+		user, err := GetAuthenticatedUser(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := s.RevokeSessions(user.ID, ""); err != nil {
+			return nil, ErrInternal.WithCause(err)
+		}
+		s.audit(AuditSessionsRevoked, user.ID, "current", true)
+
+		if err := s.clearRequestAuth(r); err != nil {
+			return nil, err
+		}
+		return "logout successful", nil
+	})(r)
+}
@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	localcontext "github.com/unluckythoughts/go-microservice/v2/tools/context"
+	"github.com/unluckythoughts/go-microservice/v2/utils"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	// sessionIDKey is the cookie session value with the session ID of the login
+	sessionIDKey    = "auth_session_id"
+	sessionIDLength = 32
+	// sessionTouchInterval is how often the requests of a session are recorded
+	sessionTouchInterval = time.Minute
+	maxUserAgentLength   = 512
+)
+
+// sessionContextKey is the request context key of the session ID of the authenticated user
+type sessionContextKey struct{}
+
+// sessionValid returns how long sessions last without requests, as long as
+// their refresh tokens or their JWT tokens without refresh tokens
+func (s *Service) sessionValid() time.Duration {
+	if s.refreshTokenValid > s.tokenValid {
+		return s.refreshTokenValid
+	}
+
+	return s.tokenValid
+}
+
+// createSession records a new login of the user on the device of the user agent and IP
+func (s *Service) createSession(userID uint, userAgent, ip string) (*UserSession, error) {
+	sessionID, err := utils.GenerateRandomString(sessionIDLength)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = strings.ToValidUTF8(userAgent[:maxUserAgentLength], "")
+	}
+
+	now := s.now()
+	// expired sessions are not listed or accepted anymore
+	if err := s.db.Where("user_id = ? AND expires_at < ?", userID, now).Delete(&UserSession{}).Error; err != nil {
+		return nil, err
+	}
+
+	session := &UserSession{
+		UserID:     userID,
+		SessionID:  sessionID,
+		UserAgent:  userAgent,
+		IP:         ip,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(s.sessionValid()),
+	}
+	if err := s.db.Create(session).Error; err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// findSession returns the session of the session ID, nil if there is none
+func (s *Service) findSession(sessionID string) (*UserSession, error) {
+	session := &UserSession{}
+	err := s.db.Where("session_id = ?", sessionID).First(session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+
+	return session, err
+}
+
+// isActive returns whether the session was neither revoked nor expired at now
+func (session *UserSession) isActive(now time.Time) bool {
+	return session.RevokedAt == nil && session.ExpiresAt.After(now)
+}
+
+// touchSession records a request of the session from the IP, sessions with
+// requests do not expire
+func (s *Service) touchSession(session *UserSession, ip string) {
+	now := s.now()
+	err := s.db.Model(&UserSession{}).
+		Where("id = ? AND revoked_at IS NULL", session.ID).
+		Updates(map[string]any{"ip": ip, "last_seen_at": now, "expires_at": now.Add(s.sessionValid())}).Error
+	if err != nil {
+		s.l.Warn("could not record the request of the session", zap.Uint("session_id", session.ID), zap.Error(err))
+	}
+}
+
+// checkSession returns ErrSessionRevoked unless the session of the user is
+// active and records the request from the IP. The tokens and cookies without
+// session ID, issued before sessions were recorded, are refused: revoking the
+// sessions of the user would not end them
+func (s *Service) checkSession(userID uint, sessionID, ip string) error {
+	if sessionID == "" {
+		return ErrSessionRevoked
+	}
+
+	session, err := s.findSession(sessionID)
+	if err != nil {
+		return ErrInternal.WithCause(err)
+	}
+
+	now := s.now()
+	if session == nil || session.UserID != userID || !session.isActive(now) {
+		return ErrSessionRevoked
+	}
+	if now.Sub(session.LastSeenAt) >= sessionTouchInterval || session.IP != ip {
+		s.touchSession(session, ip)
+	}
+
+	return nil
+}
+
+// refreshSession records the refresh of the tokens of the refresh token family
+// from the IP and returns the session ID of the family and the current
+// organization of the session. The families issued before sessions were
+// recorded have no session, they cannot be refreshed
+func (s *Service) refreshSession(familyID, ip string) (string, uint, error) {
+	session, err := s.findSession(familyID)
+	if err != nil {
+		return "", 0, err
+	} else if session == nil || !session.isActive(s.now()) {
+		return "", 0, ErrInvalidRefreshToken
+	}
+
+	s.touchSession(session, ip)
+	return session.SessionID, session.OrgID, nil
+}
+
+// ListSessions returns the active sessions of the user, the last used first
+func (s *Service) ListSessions(userID uint) ([]UserSession, error) {
+	list := []UserSession{}
+	err := s.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, s.now()).
+		Order("last_seen_at DESC, id DESC").
+		Find(&list).Error
+	return list, err
+}
+
+// endSession revokes the session of the session ID and its refresh tokens
+func (s *Service) endSession(sessionID string) error {
+	now := s.now()
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&UserSession{}).
+			Where("session_id = ? AND revoked_at IS NULL", sessionID).
+			Update("revoked_at", now).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(&RefreshToken{}).
+			Where("family_id = ? AND revoked_at IS NULL", sessionID).
+			Update("revoked_at", now).Error
+	})
+}
+
+// RevokeSession logs the user out of the session, its refresh tokens are revoked
+func (s *Service) RevokeSession(userID, id uint) error {
+	session := &UserSession{}
+	err := s.db.Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).First(session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrSessionNotFound
+	} else if err != nil {
+		return err
+	}
+
+	return s.endSession(session.SessionID)
+}
+
+// RevokeSessions logs the user out of all the sessions but the one of
+// exceptSessionID, empty to log out everywhere. All the refresh tokens of the
+// other sessions are revoked, including the ones issued before sessions were recorded
+func (s *Service) RevokeSessions(userID uint, exceptSessionID string) error {
+	now := s.now()
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&UserSession{}).
+			Where("user_id = ? AND session_id <> ? AND revoked_at IS NULL", userID, exceptSessionID).
+			Update("revoked_at", now).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(&RefreshToken{}).
+			Where("user_id = ? AND family_id <> ? AND revoked_at IS NULL", userID, exceptSessionID).
+			Update("revoked_at", now).Error
+	})
+}
+
+// setAuthenticatedSession puts the session ID of the authenticated user in the request context
+func setAuthenticatedSession(ctx localcontext.Context, sessionID string) {
+	_ = ctx.WithValue(sessionContextKey{}, sessionID)
+}
+
+// getAuthenticatedSession returns the session ID of the authenticated user, it
+// is empty for API keys and the tokens issued before sessions were recorded
+func getAuthenticatedSession(ctx localcontext.Context) string {
+	sessionID, _ := ctx.Value(sessionContextKey{}).(string)
+	return sessionID
+}
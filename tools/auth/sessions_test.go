@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	localcontext "github.com/unluckythoughts/go-microservice/v2/tools/context"
+	"github.com/unluckythoughts/go-microservice/v2/tools/web"
+	"go.uber.org/zap"
+)
+
+// bearerRequest is a request authenticated with the JWT token
+type bearerRequest struct {
+	web.MiddlewareRequest
+	ctx   localcontext.Context
+	token string
+}
+
+func newBearerRequest(token string) bearerRequest {
+	return bearerRequest{ctx: localcontext.NewContext(zap.NewNop()), token: token}
+}
+
+func (r bearerRequest) GetContext() localcontext.Context { return r.ctx }
+func (r bearerRequest) GetRemoteAddr() string            { return "10.0.0.1" }
+func (r bearerRequest) GetHeader(key string) string {
+	if key == "Authorization" {
+		return "Bearer " + r.token
+	}
+	return ""
+}
+
+func TestSessions(t *testing.T) {
+	s, clock, user := newMFATestService(t)
+
+	laptop, err := s.createSession(user.ID, "laptop", "10.0.0.1")
+	require.NoError(t, err)
+	phone, err := s.createSession(user.ID, "phone", "10.0.0.2")
+	require.NoError(t, err)
+
+	token, err := s.createAccessToken(user.ID, phone.SessionID, 0)
+	require.NoError(t, err)
+	claims, err := s.getUserDataFromAuthHeader("Bearer " + token)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, claims.userID)
+	assert.Equal(t, phone.SessionID, claims.sessionID)
+	sessionID := claims.sessionID
+
+	require.NoError(t, s.checkSession(user.ID, sessionID, "10.0.0.2"))
+	assert.ErrorIs(t, s.checkSession(user.ID+1, sessionID, "10.0.0.2"), ErrSessionRevoked)
+	assert.ErrorIs(t, s.checkSession(user.ID, "unknown", "10.0.0.2"), ErrSessionRevoked)
+	assert.ErrorIs(t, s.checkSession(user.ID, "", "10.0.0.2"), ErrSessionRevoked, "tokens without session cannot be revoked")
+
+	// the requests of the sessions are recorded, the last used session first
+	clock.add(2 * time.Minute)
+	require.NoError(t, s.checkSession(user.ID, laptop.SessionID, "10.0.0.3"))
+	list, err := s.ListSessions(user.ID)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, laptop.ID, list[0].ID)
+	assert.Equal(t, "10.0.0.3", list[0].IP)
+	assert.Equal(t, "laptop", list[0].UserAgent)
+	assert.WithinDuration(t, clock.now(), list[0].LastSeenAt, time.Second)
+
+	// revoking a session revokes its refresh tokens
+	refresh, err := s.createRefreshToken(s.db, user.ID, phone.SessionID)
+	require.NoError(t, err)
+	require.NoError(t, s.RevokeSession(user.ID, phone.ID))
+	assert.ErrorIs(t, s.checkSession(user.ID, phone.SessionID, "10.0.0.2"), ErrSessionRevoked)
+	_, _, err = s.RotateRefreshToken(refresh)
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+	assert.ErrorIs(t, s.RevokeSession(user.ID, phone.ID), ErrSessionNotFound)
+	assert.ErrorIs(t, s.RevokeSession(user.ID+1, laptop.ID), ErrSessionNotFound)
+
+	// sessions expire without requests
+	clock.add(s.sessionValid())
+	assert.ErrorIs(t, s.checkSession(user.ID, laptop.SessionID, "10.0.0.3"), ErrSessionRevoked)
+	list, err = s.ListSessions(user.ID)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+}
+
+func TestRefreshSession(t *testing.T) {
+	s, _, user := newMFATestService(t)
+
+	session, err := s.createSession(user.ID, "phone", "10.0.0.1")
+	require.NoError(t, err)
+	refresh, err := s.createRefreshToken(s.db, user.ID, session.SessionID)
+	require.NoError(t, err)
+
+	rt, _, err := s.rotateRefreshToken(refresh)
+	require.NoError(t, err)
+	sessionID, _, err := s.refreshSession(rt.FamilyID, "10.0.0.2")
+	require.NoError(t, err)
+	assert.Equal(t, session.SessionID, sessionID)
+
+	// the families issued before sessions were recorded have no session, they
+	// would refresh into tokens that cannot be revoked
+	legacy, err := s.createRefreshToken(s.db, user.ID, "legacy-family")
+	require.NoError(t, err)
+	rt, _, err = s.rotateRefreshToken(legacy)
+	require.NoError(t, err)
+	_, _, err = s.refreshSession(rt.FamilyID, "10.0.0.2")
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+
+	created, err := s.CreateRefreshToken(user.ID)
+	require.NoError(t, err)
+	rt, _, err = s.rotateRefreshToken(created)
+	require.NoError(t, err)
+	sessionID, _, err = s.refreshSession(rt.FamilyID, "10.0.0.2")
+	require.NoError(t, err)
+	assert.Equal(t, rt.FamilyID, sessionID, "the created families are sessions")
+}
+
+func TestRevokeSessions(t *testing.T) {
+	s, _, user := newMFATestService(t)
+
+	current, err := s.createSession(user.ID, "laptop", "10.0.0.1")
+	require.NoError(t, err)
+	other, err := s.createSession(user.ID, "phone", "10.0.0.2")
+	require.NoError(t, err)
+	currentRefresh, err := s.createRefreshToken(s.db, user.ID, current.SessionID)
+	require.NoError(t, err)
+	legacyRefresh, err := s.CreateRefreshToken(user.ID)
+	require.NoError(t, err)
+
+	// logging out the other sessions keeps the current one
+	require.NoError(t, s.RevokeSessions(user.ID, current.SessionID))
+	assert.ErrorIs(t, s.checkSession(user.ID, other.SessionID, "10.0.0.2"), ErrSessionRevoked)
+	require.NoError(t, s.checkSession(user.ID, current.SessionID, "10.0.0.1"))
+	_, _, err = s.RotateRefreshToken(legacyRefresh)
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+	_, currentRefresh, err = s.RotateRefreshToken(currentRefresh)
+	require.NoError(t, err)
+
+	// logging out everywhere includes the current session
+	require.NoError(t, s.RevokeSessions(user.ID, ""))
+	assert.ErrorIs(t, s.checkSession(user.ID, current.SessionID, "10.0.0.1"), ErrSessionRevoked)
+	_, _, err = s.RotateRefreshToken(currentRefresh)
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+
+	list, err := s.ListSessions(user.ID)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+}
+
+func TestTokensWithoutSessionRefused(t *testing.T) {
+	s, _, user := newMFATestService(t)
+
+	session, err := s.createSession(user.ID, "laptop", "10.0.0.1")
+	require.NoError(t, err)
+	token, err := s.createAccessToken(user.ID, session.SessionID, 0)
+	require.NoError(t, err)
+	legacy, err := s.createAccessToken(user.ID, "", 0)
+	require.NoError(t, err)
+
+	authenticated, err := s.getUserFromRequest(newBearerRequest(token))
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, authenticated.ID)
+
+	// logging out everywhere ends every token of the user, those without session too
+	require.NoError(t, s.RevokeSessions(user.ID, ""))
+	_, err = s.getUserFromRequest(newBearerRequest(token))
+	assert.ErrorIs(t, err, ErrSessionRevoked)
+	_, err = s.getUserFromRequest(newBearerRequest(legacy))
+	assert.ErrorIs(t, err, ErrSessionRevoked)
+}
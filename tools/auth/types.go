@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"regexp"
+	"strings"
 
 	"github.com/unluckythoughts/go-microservice/v2/utils"
 )
@@ -154,3 +155,67 @@ type Role uint
 func (r *Role) Value() string {
 	return fmt.Sprintf("%d", r)
 }
+
+// Scopes are the scopes of an API key, saved as a comma separated list
+type Scopes []string
+
+// Value implements driver.Valuer
+func (sc Scopes) Value() (driver.Value, error) {
+	return strings.Join(sc, ","), nil
+}
+
+// Scan implements sql.Scanner
+func (sc *Scopes) Scan(value interface{}) error {
+	var v string
+	switch value := value.(type) {
+	case nil:
+	case string:
+		v = value
+	case []byte:
+		v = string(value)
+	default:
+		return fmt.Errorf("scopes: cannot scan type %T", value)
+	}
+
+	*sc = Scopes{}
+	if v != "" {
+		*sc = strings.Split(v, ",")
+	}
+	return nil
+}
+
+// AuditDetails are the details of an audit event, saved as a JSON object
+type AuditDetails map[string]any
+
+// Value implements driver.Valuer
+func (d AuditDetails) Value() (driver.Value, error) {
+	if len(d) == 0 {
+		return "", nil
+	}
+
+	data, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan implements sql.Scanner
+func (d *AuditDetails) Scan(value interface{}) error {
+	var v []byte
+	switch value := value.(type) {
+	case nil:
+	case string:
+		v = []byte(value)
+	case []byte:
+		v = value
+	default:
+		return fmt.Errorf("audit details: cannot scan type %T", value)
+	}
+
+	*d = nil
+	if len(v) == 0 {
+		return nil
+	}
+	return json.Unmarshal(v, d)
+}
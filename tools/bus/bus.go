@@ -5,6 +5,8 @@ import (
 	"sync"
 
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/unluckythoughts/go-microservice/v2/tools/health"
+	"github.com/unluckythoughts/go-microservice/v2/tools/metrics"
 	"github.com/wagslane/go-rabbitmq"
 	"go.uber.org/zap"
 )
@@ -25,27 +27,38 @@ type Options struct {
 	User     string `env:"BUS_USER" envDefault:"guest"`
 	Password string `env:"BUS_PASSWORD" envDefault:"guest"`
 	AppName  string `env:"BUS_APP_NAME" envDefault:"api"`
+	// Metrics records the published and consumed messages, nil disables it
+	Metrics *metrics.Registry
 }
 
 type Handler func(msg Message) error
 
 type IBus interface {
+	health.Checker
 	AddHandler(topic string, handler Handler) (err error)
 	Publish(msg Message) (err error)
+	// Close stops all consumers, flushes pending messages and closes the connections
+	Close() (err error)
 }
 
 type bus struct {
 	l       *zap.SugaredLogger
 	appName string
-
-	mqc *rabbitmq.Conn
-	mqp *rabbitmq.Publisher
-	kp  *kafka.Producer
-	kc  *kafka.Consumer
-
-	mut      sync.RWMutex
-	once     sync.Once
-	handlers map[string]Handler
+	mqURL   string
+	metrics *metrics.Registry
+
+	mqc  *rabbitmq.Conn
+	mqp  *rabbitmq.Publisher
+	mqcs []*rabbitmq.Consumer
+	kp   *kafka.Producer
+	kc   *kafka.Consumer
+
+	mut       sync.RWMutex
+	once      sync.Once
+	closeOnce sync.Once
+	handlers  map[string]Handler
+	stop      chan struct{}
+	stopped   chan struct{}
 }
 
 func getRabbitMQURL(opts Options) string {
@@ -73,8 +86,9 @@ func mqSanityCheck(conn *rabbitmq.Conn) error {
 }
 
 func (b *bus) getMq(opts Options) (*rabbitmq.Conn, *rabbitmq.Publisher) {
+	b.mqURL = getRabbitMQURL(opts)
 	conn, err := rabbitmq.NewConn(
-		getRabbitMQURL(opts),
+		b.mqURL,
 		rabbitmq.WithConnectionOptionsLogger(opts.Logger.Sugar()),
 	)
 	if err != nil {
@@ -146,9 +160,12 @@ func New(opts Options) IBus {
 	b := &bus{
 		l:       opts.Logger.Sugar(),
 		appName: opts.AppName,
+		metrics: opts.Metrics,
 
-		once: sync.Once{},
-		mut:  sync.RWMutex{},
+		once:    sync.Once{},
+		mut:     sync.RWMutex{},
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
 	}
 
 	switch opts.Type {
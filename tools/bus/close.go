@@ -0,0 +1,66 @@
+package bus
+
+import (
+	"fmt"
+)
+
+// kafkaFlushTimeoutMs is the maximum time to wait for queued kafka messages
+// to be delivered while closing the producer
+const kafkaFlushTimeoutMs = 5000
+
+// Close stops all consumers, flushes pending messages and closes the connections.
+// It is safe to call Close more than once.
+func (b *bus) Close() (err error) {
+	b.closeOnce.Do(func() {
+		close(b.stop)
+
+		if b.mqc != nil {
+			err = b.closeMq()
+		}
+
+		if b.kc != nil {
+			err = b.closeKafka()
+		}
+	})
+
+	return err
+}
+
+func (b *bus) closeMq() error {
+	b.mut.RLock()
+	consumers := b.mqcs
+	b.mut.RUnlock()
+
+	for _, consumer := range consumers {
+		consumer.Close()
+	}
+
+	b.mqp.Close()
+	if err := b.mqc.Close(); err != nil {
+		return fmt.Errorf("could not close rabbitmq connection: %w", err)
+	}
+
+	b.l.Info("Closed RabbitMQ connection")
+	return nil
+}
+
+func (b *bus) closeKafka() error {
+	// wait for the consumer loop to exit, if it was ever started
+	started := true
+	b.once.Do(func() { started = false })
+	if started {
+		<-b.stopped
+	}
+
+	if err := b.kc.Close(); err != nil {
+		return fmt.Errorf("could not close kafka consumer: %w", err)
+	}
+
+	if remaining := b.kp.Flush(kafkaFlushTimeoutMs); remaining > 0 {
+		b.l.Warnf("%d kafka messages were not delivered before close", remaining)
+	}
+	b.kp.Close()
+
+	b.l.Info("Closed Kafka connection")
+	return nil
+}
@@ -5,12 +5,38 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/unluckythoughts/go-microservice/v2/tools/tracing"
 	"github.com/wagslane/go-rabbitmq"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func (b *bus) handleMQMessage(handler Handler) func(d rabbitmq.Delivery) rabbitmq.Action {
+// kafkaPollTimeout is how long the consumer loop blocks waiting for a message
+// before checking whether the bus is being closed
+const kafkaPollTimeout = 500 * time.Millisecond
+
+// consume runs the handler with the message in a consumer span continuing
+// the trace of the publisher, from the message headers
+func (b *bus) consume(system, topic string, msg Message, handler Handler) (err error) {
+	ctx, span := tracing.Start(tracing.ExtractMap(msg.Context(), msg.Headers), "consume "+topic,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", system),
+			attribute.String("messaging.destination.name", topic),
+			attribute.String("messaging.message.id", msg.ID),
+		),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	err = handler(msg.WithContext(ctx))
+	b.metrics.BusConsumed(topic, err)
+	return err
+}
+
+func (b *bus) handleMQMessage(topic string, handler Handler) func(d rabbitmq.Delivery) rabbitmq.Action {
 	return func(d rabbitmq.Delivery) rabbitmq.Action {
 		msg := Message{
 			ID:           d.MessageId,
@@ -18,9 +44,15 @@ func (b *bus) handleMQMessage(handler Handler) func(d rabbitmq.Delivery) rabbitm
 			Type:         d.Type,
 			PublishTime:  d.Timestamp,
 			Body:         d.Body,
+			Headers:      map[string]string{},
+		}
+		for key, value := range d.Headers {
+			if v, ok := value.(string); ok {
+				msg.Headers[key] = v
+			}
 		}
 
-		if err := handler(msg); err != nil {
+		if err := b.consume("rabbitmq", topic, msg, handler); err != nil {
 			b.l.Errorf("error handling message: %v", err)
 			return rabbitmq.NackRequeue
 		}
@@ -32,9 +64,19 @@ func (b *bus) handleMQMessage(handler Handler) func(d rabbitmq.Delivery) rabbitm
 func (b *bus) handleKafkaMessages() {
 	b.once.Do(func() {
 		go func() {
+			defer close(b.stopped)
 			for {
-				msg, err := b.kc.ReadMessage(-1)
+				select {
+				case <-b.stop:
+					return
+				default:
+				}
+
+				msg, err := b.kc.ReadMessage(kafkaPollTimeout)
 				if err != nil {
+					if kafkaErr, ok := err.(kafka.Error); ok && kafkaErr.IsTimeout() {
+						continue // no message within the poll window, check for stop
+					}
 					if kafkaErr, ok := err.(kafka.Error); ok && kafkaErr.Code() == kafka.ErrUnknownTopicOrPart {
 						continue // transient: no topics matching regex exist yet
 					}
@@ -50,11 +92,17 @@ func (b *bus) handleKafkaMessages() {
 
 				var m Message
 				if err := json.Unmarshal(msg.Value, &m); err != nil {
+					b.metrics.BusConsumed(*msg.TopicPartition.Topic, err)
 					b.l.Errorf("error unmarshalling message: %v", err)
 					continue
 				}
 
-				if err := handler(m); err != nil {
+				m.Headers = map[string]string{}
+				for _, header := range msg.Headers {
+					m.Headers[header.Key] = string(header.Value)
+				}
+
+				if err := b.consume("kafka", *msg.TopicPartition.Topic, m, handler); err != nil {
 					b.l.Errorf("error handling message: %v", err)
 				}
 			}
@@ -76,8 +124,12 @@ func (b *bus) addMqHandler(topic string, handler Handler) error {
 		return err
 	}
 
+	b.mut.Lock()
+	b.mqcs = append(b.mqcs, consumer)
+	b.mut.Unlock()
+
 	go func() {
-		err = consumer.Run(b.handleMQMessage(handler))
+		err = consumer.Run(b.handleMQMessage(topic, handler))
 		if err != nil {
 			b.l.Errorf("Bus consumer of %s stopped with error: %v", topic+"_queue", err)
 			consumer.Close()
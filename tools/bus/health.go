@@ -0,0 +1,43 @@
+package bus
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// defaultHealthTimeout is used when the health check context has no deadline
+const defaultHealthTimeout = 2 * time.Second
+
+func (b *bus) Name() string {
+	return "bus"
+}
+
+// Check verifies the broker is reachable
+func (b *bus) Check(ctx context.Context) error {
+	timeout := defaultHealthTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+
+	if b.kp != nil {
+		if _, err := b.kp.GetMetadata(nil, false, int(timeout.Milliseconds())); err != nil {
+			return fmt.Errorf("could not get kafka metadata: %w", err)
+		}
+		return nil
+	}
+
+	if b.mqc != nil {
+		// go-rabbitmq does not expose the state of its managed connection,
+		// so open a short lived connection to check the broker
+		conn, err := amqp.DialConfig(b.mqURL, amqp.Config{Dial: amqp.DefaultDial(timeout)})
+		if err != nil {
+			return fmt.Errorf("could not connect to rabbitmq: %w", err)
+		}
+		return conn.Close()
+	}
+
+	return fmt.Errorf("bus is not initialized")
+}
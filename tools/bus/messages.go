@@ -1,11 +1,13 @@
 package bus
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"time"
 
 	"github.com/gofrs/uuid"
+	"github.com/unluckythoughts/go-microservice/v2/tools/tracing"
 )
 
 type Message struct {
@@ -15,6 +17,38 @@ type Message struct {
 	Type         string
 	PublishTime  time.Time
 	Body         []byte
+	// Headers are sent as Kafka or AMQP headers, they carry the traceparent
+	// of the publisher
+	Headers map[string]string `json:"-"`
+
+	ctx context.Context
+}
+
+// WithContext returns a copy of the message published in the trace of ctx
+func (m Message) WithContext(ctx context.Context) Message {
+	m.ctx = ctx
+	return m
+}
+
+// Context returns the context of the message, for consumed messages it
+// carries the span continuing the trace of the publisher
+func (m Message) Context() context.Context {
+	if m.ctx == nil {
+		return context.Background()
+	}
+
+	return m.ctx
+}
+
+// getHeaders returns a copy of the message headers with the traceparent of ctx
+func (m Message) getHeaders(ctx context.Context) map[string]string {
+	headers := make(map[string]string, len(m.Headers)+1)
+	for key, value := range m.Headers {
+		headers[key] = value
+	}
+	tracing.InjectMap(ctx, headers)
+
+	return headers
 }
 
 // From sets the message fields from the given body and other parameters
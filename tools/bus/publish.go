@@ -3,22 +3,75 @@ package bus
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/unluckythoughts/go-microservice/v2/tools/tracing"
 	"github.com/wagslane/go-rabbitmq"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// startPublishSpan starts the producer span of the message sent to the topics
+func startPublishSpan(msg Message, system string, topics ...string) (Message, trace.Span) {
+	ctx, span := tracing.Start(msg.Context(), "publish "+strings.Join(topics, ","),
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", system),
+			attribute.String("messaging.destination.name", strings.Join(topics, ",")),
+			attribute.String("messaging.message.id", msg.ID),
+		),
+	)
+
+	return msg.WithContext(ctx), span
+}
+
+func (b *bus) publishMQ(msg Message) (err error) {
+	msg, span := startPublishSpan(msg, "rabbitmq", msg.RoutingKeys...)
+	defer func() { tracing.End(span, err) }()
+
+	headers := rabbitmq.Table{}
+	for key, value := range msg.getHeaders(msg.Context()) {
+		headers[key] = value
+	}
+
+	err = b.mqp.Publish(
+		msg.Body,
+		msg.RoutingKeys,
+		rabbitmq.WithPublishOptionsExchange("topic_exchange"),
+		rabbitmq.WithPublishOptionsCorrelationID(msg.CorelationID),
+		rabbitmq.WithPublishOptionsMessageID(msg.ID),
+		rabbitmq.WithPublishOptionsType(msg.Type),
+		rabbitmq.WithPublishOptionsAppID(b.appName),
+		rabbitmq.WithPublishOptionsHeaders(headers),
+	)
+	for _, topic := range msg.RoutingKeys {
+		b.metrics.BusPublished(topic, err)
+	}
+	return err
+}
+
+func (b *bus) publishKafka(msg Message, msgBytes []byte, topic string) (err error) {
+	msg, span := startPublishSpan(msg, "kafka", topic)
+	defer func() { tracing.End(span, err) }()
+
+	headers := []kafka.Header{}
+	for key, value := range msg.getHeaders(msg.Context()) {
+		headers = append(headers, kafka.Header{Key: key, Value: []byte(value)})
+	}
+
+	err = b.kp.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Value:          msgBytes,
+		Headers:        headers,
+	}, nil)
+	b.metrics.BusPublished(topic, err)
+	return err
+}
+
 func (b *bus) Publish(msg Message) (err error) {
 	if b.mqc != nil {
-		return b.mqp.Publish(
-			msg.Body,
-			msg.RoutingKeys,
-			rabbitmq.WithPublishOptionsExchange("topic_exchange"),
-			rabbitmq.WithPublishOptionsCorrelationID(msg.CorelationID),
-			rabbitmq.WithPublishOptionsMessageID(msg.ID),
-			rabbitmq.WithPublishOptionsType(msg.Type),
-			rabbitmq.WithPublishOptionsAppID(b.appName),
-		)
+		return b.publishMQ(msg)
 	}
 
 	if b.kp != nil {
@@ -28,11 +81,7 @@ func (b *bus) Publish(msg Message) (err error) {
 		}
 
 		for _, topic := range msg.RoutingKeys {
-			err := b.kp.Produce(&kafka.Message{
-				TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
-				Value:          msgBytes,
-			}, nil)
-			if err != nil {
+			if err := b.publishKafka(msg, msgBytes, topic); err != nil {
 				return fmt.Errorf("could not produce message to Kafka: %w", err)
 			}
 		}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/go-redis/redis/v8"
 	"github.com/pkg/errors"
+	"github.com/unluckythoughts/go-microservice/v2/tools/metrics"
 	"go.uber.org/zap"
 )
 
@@ -27,6 +28,8 @@ type (
 		DB         int    `env:"CACHE_DB" envDefault:"0"`
 		DisableSSL bool   `env:"CACHE_DISABLE_SSL" envDefault:"true"`
 		Debug      bool   `env:"CACHE_DEBUG" envDefault:"false"`
+		// Metrics records the command latency, nil disables it
+		Metrics *metrics.Registry
 	}
 )
 
@@ -62,13 +65,11 @@ func New(opts Options) *redis.Client {
 		}
 	}
 
-	l := &cacheLogger{opts.Logger}
+	l := &cacheLogger{l: opts.Logger, debug: opts.Debug, metrics: opts.Metrics}
 
 	redis.SetLogger(l)
 	r := redis.NewClient(config)
-	if opts.Debug {
-		r.AddHook(l)
-	}
+	r.AddHook(l)
 
 	sanityCheck(r)
 	opts.Logger.Info("Connected to redis")
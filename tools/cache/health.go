@@ -0,0 +1,30 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/unluckythoughts/go-microservice/v2/tools/health"
+)
+
+type healthChecker struct {
+	r *redis.Client
+}
+
+// NewHealthChecker returns a health checker that pings the cache
+func NewHealthChecker(r *redis.Client) health.Checker {
+	return &healthChecker{r: r}
+}
+
+func (h *healthChecker) Name() string {
+	return "cache"
+}
+
+func (h *healthChecker) Check(ctx context.Context) error {
+	if err := h.r.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("could not ping cache: %w", err)
+	}
+
+	return nil
+}
@@ -2,15 +2,67 @@ package cache
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/unluckythoughts/go-microservice/v2/tools/metrics"
+	"github.com/unluckythoughts/go-microservice/v2/tools/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
-type cacheLogger struct {
-	l *zap.Logger
+type (
+	// cacheLogger logs the commands when debug is enabled, records their latency
+	// and traces them as child spans of the span in the command context
+	cacheLogger struct {
+		l       *zap.Logger
+		debug   bool
+		metrics *metrics.Registry
+	}
+
+	// command is stored in the context of a command or pipeline while it runs
+	command struct {
+		start time.Time
+		span  trace.Span
+	}
+
+	commandKey struct{}
+)
+
+// start returns the context of the command, with a span if ctx is traced
+func (l *cacheLogger) start(ctx context.Context, name string) context.Context {
+	c := &command{start: time.Now()}
+	if tracing.HasSpan(ctx) {
+		ctx, c.span = tracing.Start(ctx, "redis "+name,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("db.system", "redis"),
+				attribute.String("db.operation.name", name),
+			),
+		)
+	}
+
+	return context.WithValue(ctx, commandKey{}, c)
+}
+
+// observe records the latency of the command started with start and ends its span
+func (l *cacheLogger) observe(ctx context.Context, name string, err error) {
+	c, ok := ctx.Value(commandKey{}).(*command)
+	if !ok {
+		return
+	}
+
+	if errors.Is(err, redis.Nil) {
+		err = nil
+	}
+	l.metrics.ObserveCacheCommand(name, time.Since(c.start), err)
+	if c.span != nil {
+		tracing.End(c.span, err)
+	}
 }
 
 func (l *cacheLogger) Printf(ctx context.Context, format string, v ...interface{}) {
@@ -18,19 +70,34 @@ func (l *cacheLogger) Printf(ctx context.Context, format string, v ...interface{
 }
 
 func (l *cacheLogger) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
-	return ctx, nil
+	return l.start(ctx, cmd.Name()), nil
 }
 
 func (l *cacheLogger) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
-	l.l.Debug(cmd.String())
+	l.observe(ctx, cmd.Name(), cmd.Err())
+	if l.debug {
+		l.l.Debug(cmd.String())
+	}
 	return nil
 }
 
 func (l *cacheLogger) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
-	return ctx, nil
+	return l.start(ctx, "pipeline"), nil
 }
 
 func (l *cacheLogger) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if cmd.Err() != nil && !errors.Is(cmd.Err(), redis.Nil) {
+			err = cmd.Err()
+			break
+		}
+	}
+	l.observe(ctx, "pipeline", err)
+	if !l.debug {
+		return nil
+	}
+
 	var strCmds []string
 	for _, cmd := range cmds {
 		strCmds = append(strCmds, cmd.String())
@@ -6,6 +6,8 @@ import (
 	"time"
 
 	"github.com/gorilla/sessions"
+	"github.com/unluckythoughts/go-microservice/v2/tools/tracing"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
@@ -29,6 +31,9 @@ type Context interface {
 	StartTime() time.Time
 	WithFields(fields ...zapcore.Field)
 	WithValue(key any, value any) error
+	// StartSpan returns a child context with a new span, its logger
+	// has the trace_id and span_id of the span
+	StartSpan(name string, opts ...trace.SpanStartOption) (Context, trace.Span)
 }
 
 type ctx struct {
@@ -44,10 +49,17 @@ func IsWebContext(c context.Context) (Context, bool) {
 }
 
 func NewContext(l *zap.Logger) *ctx {
-	c, cancel := context.WithCancel(context.Background())
+	return NewContextFrom(context.Background(), l)
+}
+
+// NewContextFrom returns a context derived from parent, it is canceled
+// when the parent is canceled, e.g. when the client of a http request disconnects.
+// If parent carries a span, its trace_id and span_id are added to the logger.
+func NewContextFrom(parent context.Context, l *zap.Logger) *ctx {
+	c, cancel := context.WithCancel(parent)
 	return &ctx{
 		st:      time.Now(),
-		l:       l,
+		l:       l.With(tracing.LogFields(parent)...),
 		Context: c,
 		cancel:  cancel,
 	}
@@ -63,6 +75,16 @@ func (c *ctx) WithTimeout(duration time.Duration) Context {
 	}
 }
 
+func (c *ctx) StartSpan(name string, opts ...trace.SpanStartOption) (Context, trace.Span) {
+	spanCtx, span := tracing.Start(c.Context, name, opts...)
+	return &ctx{
+		Context: spanCtx,
+		st:      c.st,
+		l:       c.l.With(tracing.LogFields(spanCtx)...),
+		cancel:  c.cancel,
+	}, span
+}
+
 func (c *ctx) Cancel() {
 	if c.cancel != nil {
 		c.cancel()
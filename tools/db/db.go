@@ -3,11 +3,11 @@ package db
 import (
 	"fmt"
 
+	"github.com/unluckythoughts/go-microservice/v2/tools/metrics"
 	"go.uber.org/zap"
 	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
-	gormLogger "gorm.io/gorm/logger"
 )
 
 type Type uint
@@ -21,6 +21,8 @@ type Options struct {
 	Type   Type `env:"DB_TYPE" envDefault:"1"`
 	Debug  bool `env:"DB_DEBUG" envDefault:"false"`
 	Logger *zap.Logger
+	// Metrics records the query latency, nil disables it
+	Metrics *metrics.Registry
 	// for SQLite
 	FilePath string `env:"DB_FILE_PATH" envDefault:"./data/sqlite.db"`
 	// for Postgres
@@ -82,11 +84,7 @@ func New(opts Options) (db *gorm.DB) {
 		panic(fmt.Errorf("incorrect db type: %d", opts.Type))
 	}
 
-	if opts.Debug {
-		db = db.Session(&gorm.Session{Logger: &dbLogger{l: l}})
-	} else {
-		db = db.Session(&gorm.Session{Logger: gormLogger.Default.LogMode(gormLogger.Silent)})
-	}
+	db = db.Session(&gorm.Session{Logger: &dbLogger{l: l, debug: opts.Debug, metrics: opts.Metrics}})
 	sanityCheck(db)
 	opts.Logger.Info("Connected to db")
 	return db
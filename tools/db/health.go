@@ -0,0 +1,35 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/unluckythoughts/go-microservice/v2/tools/health"
+	"gorm.io/gorm"
+)
+
+type healthChecker struct {
+	db *gorm.DB
+}
+
+// NewHealthChecker returns a health checker that pings the database
+func NewHealthChecker(db *gorm.DB) health.Checker {
+	return &healthChecker{db: db}
+}
+
+func (h *healthChecker) Name() string {
+	return "db"
+}
+
+func (h *healthChecker) Check(ctx context.Context) error {
+	sqlDB, err := h.db.DB()
+	if err != nil {
+		return fmt.Errorf("could not get db connection pool: %w", err)
+	}
+
+	if err := sqlDB.PingContext(ctx); err != nil {
+		return fmt.Errorf("could not ping db: %w", err)
+	}
+
+	return nil
+}
@@ -2,12 +2,18 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"regexp"
 	"strings"
 	"time"
 
+	"github.com/unluckythoughts/go-microservice/v2/tools/metrics"
+	"github.com/unluckythoughts/go-microservice/v2/tools/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 	gormLogger "gorm.io/gorm/logger"
 )
 
@@ -15,23 +21,62 @@ var (
 	spacePattern = regexp.MustCompile(`(\t|\n)+`)
 )
 
+// dbLogger logs the queries when debug is enabled, records their latency
+// and traces them as child spans of the span in the query context
 type dbLogger struct {
-	l *zap.Logger
+	l       *zap.Logger
+	debug   bool
+	metrics *metrics.Registry
 }
 
 func (dbl *dbLogger) LogMode(level gormLogger.LogLevel) gormLogger.Interface {
-	return &dbLogger{}
+	l := *dbl
+	return &l
+}
+
+// getOperation returns the statement type of the query, e.g. select
+func getOperation(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) < 1 {
+		return "unknown"
+	}
+
+	return strings.ToLower(fields[0])
+}
+
+// traceQuery records the query as a span, only for queries run in a traced context
+func traceQuery(ctx context.Context, begin time.Time, sql string, err error) {
+	if !tracing.HasSpan(ctx) {
+		return
+	}
+
+	operation := getOperation(sql)
+	_, span := tracing.Start(ctx, "db "+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(begin),
+		trace.WithAttributes(attribute.String("db.operation.name", operation)),
+	)
+	tracing.End(span, err)
 }
 
 func (dbl *dbLogger) Info(ctx context.Context, s string, v ...interface{}) {
+	if !dbl.debug {
+		return
+	}
 	dbl.l.Info(fmt.Sprintf(spacePattern.ReplaceAllString(s, " "), v...))
 }
 
 func (dbl *dbLogger) Warn(ctx context.Context, s string, v ...interface{}) {
+	if !dbl.debug {
+		return
+	}
 	dbl.l.Warn(fmt.Sprintf(spacePattern.ReplaceAllString(s, " "), v...))
 }
 
 func (dbl *dbLogger) Error(ctx context.Context, s string, v ...interface{}) {
+	if !dbl.debug {
+		return
+	}
 	dbl.l.Error(fmt.Sprintf(spacePattern.ReplaceAllString(s, " "), v...))
 }
 
@@ -39,6 +84,15 @@ func (dbl *dbLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql
 	elapsed := time.Since(begin)
 
 	sql, rows := fc()
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = nil
+	}
+	dbl.metrics.ObserveDBQuery(getOperation(sql), elapsed, err)
+	traceQuery(ctx, begin, sql, err)
+	if !dbl.debug {
+		return
+	}
+
 	sql = spacePattern.ReplaceAllString(sql, " ")
 	if strings.Contains(strings.ToLower(sql), "password") {
 		sql = "[REDACTED PASSWORD QUERY]"
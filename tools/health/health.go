@@ -0,0 +1,230 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+type (
+	// Checker is implemented by components that can report their health
+	Checker interface {
+		// Name returns the component name reported in the health status
+		Name() string
+		// Check returns an error if the component is not healthy
+		Check(ctx context.Context) error
+	}
+
+	// Status is the health status of a component or of the whole service
+	Status string
+
+	// ComponentStatus is the result of the last health check of a component
+	ComponentStatus struct {
+		Status      Status     `json:"status"`
+		Latency     string     `json:"latency"`
+		LatencyMs   float64    `json:"latency_ms"`
+		Error       string     `json:"error,omitempty"`
+		LastError   string     `json:"last_error,omitempty"`
+		LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+		CheckedAt   time.Time  `json:"checked_at"`
+	}
+
+	// Report is the aggregated health status of a set of components
+	Report struct {
+		Status     Status                     `json:"status"`
+		Components map[string]ComponentStatus `json:"components"`
+	}
+
+	Options struct {
+		// Timeout is the maximum duration of a single component check
+		// Default is 2 seconds
+		Timeout time.Duration
+		// CacheTTL is how long a check result is reused before the check runs again
+		// Default is 5 seconds
+		CacheTTL time.Duration
+	}
+
+	// Registry keeps the liveness and readiness checks of the service
+	Registry struct {
+		timeout  time.Duration
+		cacheTTL time.Duration
+		mut      sync.RWMutex
+		live     []*entry
+		ready    []*entry
+	}
+
+	entry struct {
+		checker Checker
+		mut     sync.Mutex
+		status  ComponentStatus
+		expires time.Time
+	}
+
+	checkerFunc struct {
+		name string
+		fn   func(ctx context.Context) error
+	}
+)
+
+const (
+	StatusOK   Status = "ok"
+	StatusFail Status = "fail"
+
+	defaultTimeout  = 2 * time.Second
+	defaultCacheTTL = 5 * time.Second
+)
+
+// CheckerFunc returns a Checker with the given name that runs fn
+func CheckerFunc(name string, fn func(ctx context.Context) error) Checker {
+	return &checkerFunc{name: name, fn: fn}
+}
+
+func (c *checkerFunc) Name() string {
+	return c.name
+}
+
+func (c *checkerFunc) Check(ctx context.Context) error {
+	return c.fn(ctx)
+}
+
+// New creates a new health check registry
+func New(opts Options) *Registry {
+	r := &Registry{
+		timeout:  defaultTimeout,
+		cacheTTL: defaultCacheTTL,
+	}
+
+	if opts.Timeout > 0 {
+		r.timeout = opts.Timeout
+	}
+	if opts.CacheTTL > 0 {
+		r.cacheTTL = opts.CacheTTL
+	}
+
+	return r
+}
+
+// AddLivenessCheck registers a check that fails only when the process has to be restarted.
+// Liveness checks are part of the readiness report as well.
+func (r *Registry) AddLivenessCheck(c Checker) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	r.live = append(r.live, &entry{checker: c})
+}
+
+// AddReadinessCheck registers a check that fails when the service cannot serve traffic,
+// e.g. when a dependency like the database is down
+func (r *Registry) AddReadinessCheck(c Checker) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	r.ready = append(r.ready, &entry{checker: c})
+}
+
+// Live returns the report of all liveness checks
+func (r *Registry) Live(ctx context.Context) Report {
+	r.mut.RLock()
+	entries := append([]*entry{}, r.live...)
+	r.mut.RUnlock()
+
+	return r.run(ctx, entries)
+}
+
+// Ready returns the report of all liveness and readiness checks
+func (r *Registry) Ready(ctx context.Context) Report {
+	r.mut.RLock()
+	entries := append(append([]*entry{}, r.live...), r.ready...)
+	r.mut.RUnlock()
+
+	return r.run(ctx, entries)
+}
+
+// run runs the given checks concurrently and aggregates the results
+func (r *Registry) run(ctx context.Context, entries []*entry) Report {
+	report := Report{
+		Status:     StatusOK,
+		Components: make(map[string]ComponentStatus, len(entries)),
+	}
+
+	results := make([]ComponentStatus, len(entries))
+	wg := sync.WaitGroup{}
+	for i, e := range entries {
+		wg.Add(1)
+		go func(i int, e *entry) {
+			defer wg.Done()
+			results[i] = r.check(ctx, e)
+		}(i, e)
+	}
+	wg.Wait()
+
+	for i, e := range entries {
+		report.Components[e.checker.Name()] = results[i]
+		if results[i].Status != StatusOK {
+			report.Status = StatusFail
+		}
+	}
+
+	return report
+}
+
+// check returns the cached status of the entry, running the check if the cache expired.
+// Concurrent callers wait for a single running check instead of starting their own.
+func (r *Registry) check(ctx context.Context, e *entry) ComponentStatus {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	now := time.Now()
+	if now.Before(e.expires) {
+		return e.status
+	}
+
+	// the result is cached for every caller, the request of the first caller
+	// disconnecting or timing out must not fail the check
+	checkCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), r.timeout)
+	defer cancel()
+
+	err := runCheck(checkCtx, e.checker)
+	latency := time.Since(now)
+
+	status := ComponentStatus{
+		Status:      StatusOK,
+		Latency:     latency.String(),
+		LatencyMs:   float64(latency.Microseconds()) / 1000,
+		LastError:   e.status.LastError,
+		LastErrorAt: e.status.LastErrorAt,
+		CheckedAt:   now,
+	}
+
+	if err != nil {
+		status.Status = StatusFail
+		status.Error = err.Error()
+		status.LastError = err.Error()
+		status.LastErrorAt = &now
+	}
+
+	e.status = status
+	e.expires = now.Add(r.cacheTTL)
+	return status
+}
+
+// runCheck runs the check and returns a timeout error if it does not return in time
+func runCheck(ctx context.Context, c Checker) error {
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- errors.New("health check panicked")
+			}
+		}()
+		done <- c.Check(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return errors.New("health check timed out")
+	}
+}
@@ -0,0 +1,110 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadyAllHealthy(t *testing.T) {
+	r := New(Options{})
+	r.AddLivenessCheck(CheckerFunc("worker", func(ctx context.Context) error { return nil }))
+	r.AddReadinessCheck(CheckerFunc("db", func(ctx context.Context) error { return nil }))
+
+	report := r.Ready(context.Background())
+	assert.Equal(t, StatusOK, report.Status)
+	assert.Len(t, report.Components, 2)
+	assert.Equal(t, StatusOK, report.Components["db"].Status)
+}
+
+func TestReadyFailingDependency(t *testing.T) {
+	r := New(Options{})
+	r.AddReadinessCheck(CheckerFunc("db", func(ctx context.Context) error { return errors.New("connection refused") }))
+
+	report := r.Ready(context.Background())
+	assert.Equal(t, StatusFail, report.Status)
+	assert.Equal(t, "connection refused", report.Components["db"].Error)
+	assert.Equal(t, "connection refused", report.Components["db"].LastError)
+	assert.NotNil(t, report.Components["db"].LastErrorAt)
+}
+
+func TestLiveIgnoresReadinessChecks(t *testing.T) {
+	r := New(Options{})
+	r.AddLivenessCheck(CheckerFunc("worker", func(ctx context.Context) error { return nil }))
+	r.AddReadinessCheck(CheckerFunc("db", func(ctx context.Context) error { return errors.New("down") }))
+
+	report := r.Live(context.Background())
+	assert.Equal(t, StatusOK, report.Status)
+	assert.NotContains(t, report.Components, "db")
+}
+
+func TestCheckTimeout(t *testing.T) {
+	r := New(Options{Timeout: 10 * time.Millisecond})
+	r.AddReadinessCheck(CheckerFunc("slow", func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}))
+
+	report := r.Ready(context.Background())
+	assert.Equal(t, StatusFail, report.Status)
+	assert.Contains(t, report.Components["slow"].Error, "timed out")
+}
+
+func TestCheckResultIsCached(t *testing.T) {
+	var calls atomic.Int32
+	r := New(Options{CacheTTL: time.Minute})
+	r.AddReadinessCheck(CheckerFunc("db", func(ctx context.Context) error {
+		calls.Add(1)
+		return nil
+	}))
+
+	r.Ready(context.Background())
+	r.Ready(context.Background())
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestLastErrorKeptAfterRecovery(t *testing.T) {
+	var fail atomic.Bool
+	fail.Store(true)
+	r := New(Options{CacheTTL: time.Nanosecond})
+	r.AddReadinessCheck(CheckerFunc("cache", func(ctx context.Context) error {
+		if fail.Load() {
+			return errors.New("timeout")
+		}
+		return nil
+	}))
+
+	r.Ready(context.Background())
+	fail.Store(false)
+	time.Sleep(time.Millisecond)
+
+	status := r.Ready(context.Background()).Components["cache"]
+	assert.Equal(t, StatusOK, status.Status)
+	assert.Empty(t, status.Error)
+	assert.Equal(t, "timeout", status.LastError)
+}
+
+func TestCheckIgnoresCallerCancellation(t *testing.T) {
+	r := New(Options{CacheTTL: time.Minute})
+	r.AddReadinessCheck(CheckerFunc("db", func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(20 * time.Millisecond):
+			return nil
+		}
+	}))
+
+	// the client of the first probe disconnected before the check finished
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	report := r.Ready(ctx)
+	assert.Equal(t, StatusOK, report.Status)
+
+	report = r.Ready(context.Background())
+	assert.Equal(t, StatusOK, report.Status, "no false failure is cached")
+}
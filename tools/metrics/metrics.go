@@ -0,0 +1,282 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	statusOK    = "ok"
+	statusError = "error"
+)
+
+type (
+	Options struct {
+		// Namespace is prefixed to the names of all the metrics, e.g. api_http_requests_total
+		Namespace string `env:"METRICS_NAMESPACE" envDefault:""`
+	}
+
+	// Registry holds the built-in metrics of the service and the metrics
+	// registered by the handlers. A nil Registry records nothing, so components
+	// can use it without checking if metrics are enabled.
+	Registry struct {
+		namespace string
+		registry  *prometheus.Registry
+
+		httpRequests      *prometheus.CounterVec
+		httpDuration      *prometheus.HistogramVec
+		httpInFlight      prometheus.Gauge
+		socketConnections prometheus.Gauge
+		socketConnected   prometheus.Counter
+		socketMessages    *prometheus.CounterVec
+		socketDuration    *prometheus.HistogramVec
+		busPublished      *prometheus.CounterVec
+		busPublishErrors  *prometheus.CounterVec
+		busConsumed       *prometheus.CounterVec
+		busConsumeErrors  *prometheus.CounterVec
+		workerRuns        *prometheus.CounterVec
+		workerFailures    *prometheus.CounterVec
+		workerDuration    *prometheus.HistogramVec
+		dbDuration        *prometheus.HistogramVec
+		dbErrors          *prometheus.CounterVec
+		cacheDuration     *prometheus.HistogramVec
+		cacheErrors       *prometheus.CounterVec
+	}
+)
+
+// New returns a registry with the built-in metrics and the go runtime and process collectors
+func New(opts Options) *Registry {
+	m := &Registry{
+		namespace: opts.Namespace,
+		registry:  prometheus.NewRegistry(),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	m.httpRequests = m.NewCounter("http_requests_total", "Number of http requests by route, method and status", "route", "method", "status")
+	m.httpDuration = m.NewHistogram("http_request_duration_seconds", "Latency of the http requests by route and method", nil, "route", "method")
+	m.httpInFlight = m.newGauge("http_requests_in_flight", "Number of http requests being served")
+
+	m.socketConnections = m.newGauge("socket_connections", "Number of open socket connections")
+	m.socketConnected = m.newCounter("socket_connections_total", "Number of socket connections opened")
+	m.socketMessages = m.NewCounter("socket_messages_total", "Number of socket messages by method and status", "method", "status")
+	m.socketDuration = m.NewHistogram("socket_message_duration_seconds", "Latency of the socket messages by method", nil, "method")
+
+	m.busPublished = m.NewCounter("bus_messages_published_total", "Number of messages published by topic", "topic")
+	m.busPublishErrors = m.NewCounter("bus_publish_errors_total", "Number of messages that failed to publish by topic", "topic")
+	m.busConsumed = m.NewCounter("bus_messages_consumed_total", "Number of messages consumed by topic", "topic")
+	m.busConsumeErrors = m.NewCounter("bus_consume_errors_total", "Number of messages that failed to be handled by topic", "topic")
+
+	m.workerRuns = m.NewCounter("worker_job_runs_total", "Number of cron job runs by job", "job")
+	m.workerFailures = m.NewCounter("worker_job_failures_total", "Number of failed cron job runs by job", "job")
+	m.workerDuration = m.NewHistogram("worker_job_duration_seconds", "Duration of the cron job runs by job", nil, "job")
+
+	m.dbDuration = m.NewHistogram("db_query_duration_seconds", "Latency of the db queries by operation", nil, "operation")
+	m.dbErrors = m.NewCounter("db_query_errors_total", "Number of failed db queries by operation", "operation")
+
+	m.cacheDuration = m.NewHistogram("cache_command_duration_seconds", "Latency of the cache commands by command", nil, "command")
+	m.cacheErrors = m.NewCounter("cache_command_errors_total", "Number of failed cache commands by command", "command")
+
+	return m
+}
+
+// Register registers custom collectors, e.g. metrics created with the prometheus package
+func (m *Registry) Register(cs ...prometheus.Collector) error {
+	for _, c := range cs {
+		if err := m.registry.Register(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// NewCounter registers and returns a counter with the given labels, it panics
+// if a metric with the same name is already registered
+func (m *Registry) NewCounter(name, help string, labels ...string) *prometheus.CounterVec {
+	c := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: m.namespace,
+		Name:      name,
+		Help:      help,
+	}, labels)
+	m.registry.MustRegister(c)
+	return c
+}
+
+// NewGauge registers and returns a gauge with the given labels, it panics
+// if a metric with the same name is already registered
+func (m *Registry) NewGauge(name, help string, labels ...string) *prometheus.GaugeVec {
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: m.namespace,
+		Name:      name,
+		Help:      help,
+	}, labels)
+	m.registry.MustRegister(g)
+	return g
+}
+
+// NewHistogram registers and returns a histogram with the given buckets and labels,
+// nil buckets use the prometheus default buckets. It panics if a metric with the
+// same name is already registered.
+func (m *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *prometheus.HistogramVec {
+	h := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: m.namespace,
+		Name:      name,
+		Help:      help,
+		Buckets:   buckets,
+	}, labels)
+	m.registry.MustRegister(h)
+	return h
+}
+
+func (m *Registry) newCounter(name, help string) prometheus.Counter {
+	c := prometheus.NewCounter(prometheus.CounterOpts{Namespace: m.namespace, Name: name, Help: help})
+	m.registry.MustRegister(c)
+	return c
+}
+
+func (m *Registry) newGauge(name, help string) prometheus.Gauge {
+	g := prometheus.NewGauge(prometheus.GaugeOpts{Namespace: m.namespace, Name: name, Help: help})
+	m.registry.MustRegister(g)
+	return g
+}
+
+// Handler returns the http handler serving the metrics in the prometheus text format
+func (m *Registry) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// Gatherer returns the underlying prometheus registry to read the metrics
+func (m *Registry) Gatherer() prometheus.Gatherer {
+	return m.registry
+}
+
+func getStatus(err error) string {
+	if err != nil {
+		return statusError
+	}
+
+	return statusOK
+}
+
+// StartHTTPRequest marks a http request as in flight, the returned function
+// has to be called once it is served
+func (m *Registry) StartHTTPRequest() func() {
+	if m == nil {
+		return func() {}
+	}
+
+	m.httpInFlight.Inc()
+	return m.httpInFlight.Dec
+}
+
+// ObserveHTTPRequest records a served http request, route is the route
+// pattern, e.g. /users/:id, so the number of series stays bounded
+func (m *Registry) ObserveHTTPRequest(route, method string, status int, d time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.httpRequests.WithLabelValues(route, method, strconv.Itoa(status)).Inc()
+	m.httpDuration.WithLabelValues(route, method).Observe(d.Seconds())
+}
+
+// SocketConnected records a new socket connection
+func (m *Registry) SocketConnected() {
+	if m == nil {
+		return
+	}
+
+	m.socketConnected.Inc()
+	m.socketConnections.Inc()
+}
+
+// SocketDisconnected records a closed socket connection
+func (m *Registry) SocketDisconnected() {
+	if m == nil {
+		return
+	}
+
+	m.socketConnections.Dec()
+}
+
+// ObserveSocketMessage records a handled socket message
+func (m *Registry) ObserveSocketMessage(method string, d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+
+	m.socketMessages.WithLabelValues(method, getStatus(err)).Inc()
+	m.socketDuration.WithLabelValues(method).Observe(d.Seconds())
+}
+
+// BusPublished records a message published to the topic
+func (m *Registry) BusPublished(topic string, err error) {
+	if m == nil {
+		return
+	}
+
+	if err != nil {
+		m.busPublishErrors.WithLabelValues(topic).Inc()
+		return
+	}
+
+	m.busPublished.WithLabelValues(topic).Inc()
+}
+
+// BusConsumed records a message consumed from the topic
+func (m *Registry) BusConsumed(topic string, err error) {
+	if m == nil {
+		return
+	}
+
+	m.busConsumed.WithLabelValues(topic).Inc()
+	if err != nil {
+		m.busConsumeErrors.WithLabelValues(topic).Inc()
+	}
+}
+
+// ObserveWorkerJob records a cron job run
+func (m *Registry) ObserveWorkerJob(job string, d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+
+	m.workerRuns.WithLabelValues(job).Inc()
+	m.workerDuration.WithLabelValues(job).Observe(d.Seconds())
+	if err != nil {
+		m.workerFailures.WithLabelValues(job).Inc()
+	}
+}
+
+// ObserveDBQuery records a db query, operation is the sql statement type, e.g. select
+func (m *Registry) ObserveDBQuery(operation string, d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+
+	m.dbDuration.WithLabelValues(operation).Observe(d.Seconds())
+	if err != nil {
+		m.dbErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+// ObserveCacheCommand records a cache command, e.g. get
+func (m *Registry) ObserveCacheCommand(command string, d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+
+	m.cacheDuration.WithLabelValues(command).Observe(d.Seconds())
+	if err != nil {
+		m.cacheErrors.WithLabelValues(command).Inc()
+	}
+}
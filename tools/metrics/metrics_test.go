@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func scrape(m *Registry) string {
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/_metrics", nil))
+	return w.Body.String()
+}
+
+func TestBuiltInMetrics(t *testing.T) {
+	m := New(Options{Namespace: "api"})
+	errFailed := errors.New("failed")
+
+	m.BusPublished("orders.created", nil)
+	m.BusPublished("orders.created", errFailed)
+	m.BusConsumed("orders.created", errFailed)
+	m.ObserveWorkerJob("cleanup", time.Second, nil)
+	m.ObserveWorkerJob("cleanup", time.Second, errFailed)
+	m.ObserveDBQuery("select", time.Millisecond, nil)
+	m.ObserveCacheCommand("get", time.Millisecond, errFailed)
+	m.SocketConnected()
+	m.SocketConnected()
+	m.SocketDisconnected()
+	m.ObserveSocketMessage("_status", time.Millisecond, nil)
+
+	body := scrape(m)
+	assert.Contains(t, body, `api_bus_messages_published_total{topic="orders.created"} 1`)
+	assert.Contains(t, body, `api_bus_publish_errors_total{topic="orders.created"} 1`)
+	assert.Contains(t, body, `api_bus_messages_consumed_total{topic="orders.created"} 1`)
+	assert.Contains(t, body, `api_bus_consume_errors_total{topic="orders.created"} 1`)
+	assert.Contains(t, body, `api_worker_job_runs_total{job="cleanup"} 2`)
+	assert.Contains(t, body, `api_worker_job_failures_total{job="cleanup"} 1`)
+	assert.Contains(t, body, `api_db_query_duration_seconds_count{operation="select"} 1`)
+	assert.Contains(t, body, `api_cache_command_errors_total{command="get"} 1`)
+	assert.Contains(t, body, `api_socket_connections 1`)
+	assert.Contains(t, body, `api_socket_connections_total 2`)
+	assert.Contains(t, body, `api_socket_messages_total{method="_status",status="ok"} 1`)
+	assert.Contains(t, body, "go_goroutines")
+}
+
+func TestCustomMetrics(t *testing.T) {
+	m := New(Options{})
+
+	signups := m.NewCounter("signups_total", "Number of signups by plan", "plan")
+	signups.WithLabelValues("free").Inc()
+	assert.Contains(t, scrape(m), `signups_total{plan="free"} 1`)
+
+	assert.Panics(t, func() { m.NewCounter("signups_total", "duplicate", "plan") })
+}
+
+func TestNilRegistry(t *testing.T) {
+	var m *Registry
+
+	assert.NotPanics(t, func() {
+		m.StartHTTPRequest()()
+		m.ObserveHTTPRequest("/users/:id", http.MethodGet, http.StatusOK, time.Second)
+		m.SocketConnected()
+		m.SocketDisconnected()
+		m.ObserveSocketMessage("_status", time.Second, nil)
+		m.BusPublished("topic", nil)
+		m.BusConsumed("topic", nil)
+		m.ObserveWorkerJob("job", time.Second, nil)
+		m.ObserveDBQuery("select", time.Second, nil)
+		m.ObserveCacheCommand("get", time.Second, nil)
+	})
+}
@@ -3,7 +3,6 @@ package ratelimiter
 import (
 	"fmt"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -22,11 +21,17 @@ var rateLimitScript = redis.NewScript(`
 	return count
 `)
 
+// KeyFunc returns the key the requests are counted under, e.g. the API key or
+// the user of the request. Requests it returns "" for are counted by client IP.
+type KeyFunc func(r web.Request) string
+
 type Options struct {
 	RateLimitMax        int `env:"RATE_LIMIT_MAX" envDefault:"100"`
 	RateLimitWindowSecs int `env:"RATE_LIMIT_WINDOW_SECS" envDefault:"60"`
 	Logger              *zap.Logger
 	Cache               *redis.Client
+	// KeyFunc is optional, by default the requests are counted by client IP
+	KeyFunc KeyFunc
 }
 
 // RateLimiter enforces Redis-backed fixed-window rate limits.
@@ -35,6 +40,7 @@ type RateLimiter struct {
 	window      time.Duration
 	client      *redis.Client
 	logger      *zap.Logger
+	keyFunc     KeyFunc
 }
 
 // New creates a new RateLimiter backed by the given Redis client.
@@ -45,16 +51,37 @@ func New(opts Options) *RateLimiter {
 		window:      time.Duration(opts.RateLimitWindowSecs) * time.Second,
 		client:      opts.Cache,
 		logger:      opts.Logger,
+		keyFunc:     opts.KeyFunc,
+	}
+}
+
+// WithKey returns a copy of the rate limiter counting the requests under the
+// key returned by fn. Keys set by route middlewares, e.g. the authenticated
+// API key, are only known when its middleware runs after them on the route.
+func (rl *RateLimiter) WithKey(fn KeyFunc) *RateLimiter {
+	c := *rl
+	c.keyFunc = fn
+	return &c
+}
+
+// getKey returns the key the request is counted under
+func (rl *RateLimiter) getKey(r web.Request) string {
+	if rl.keyFunc != nil {
+		if key := rl.keyFunc(r); key != "" {
+			return key
+		}
 	}
+
+	return web.GetClientIP(r)
 }
 
 // GetMiddleware returns a web.Middleware that allows at most maxRequests within
-// each window duration, keyed by the request path and client IP.
+// each window duration, keyed by the request path and the key of KeyFunc or
+// the client IP.
 // If the Redis client is unavailable the check fails open (request is allowed).
 func (rl *RateLimiter) GetMiddleware() web.Middleware {
 	return func(r web.MiddlewareRequest) error {
-		clientIP := getClientIP(r)
-		key := fmt.Sprintf("rl:%s:%s", r.GetPath(), clientIP)
+		key := fmt.Sprintf("rl:%s:%s", r.GetPath(), rl.getKey(r))
 
 		count, err := rateLimitScript.Run(
 			r.GetContext(),
@@ -75,18 +102,3 @@ func (rl *RateLimiter) GetMiddleware() web.Middleware {
 		return nil
 	}
 }
-
-// getClientIP extracts the real client IP from well-known forwarding headers,
-// falling back to the TCP remote address when no proxy headers are present.
-func getClientIP(r web.Request) string {
-	if ip := r.GetHeader("X-Real-IP"); ip != "" {
-		return strings.TrimSpace(ip)
-	}
-	if fwd := r.GetHeader("X-Forwarded-For"); fwd != "" {
-		// X-Forwarded-For can be a comma-separated list; the first entry is
-		// the originating client.
-		parts := strings.SplitN(fwd, ",", 2)
-		return strings.TrimSpace(parts[0])
-	}
-	return r.GetRemoteAddr()
-}
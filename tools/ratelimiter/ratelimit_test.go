@@ -0,0 +1,91 @@
+package ratelimiter
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	localcontext "github.com/unluckythoughts/go-microservice/v2/tools/context"
+	"github.com/unluckythoughts/go-microservice/v2/tools/web"
+	"go.uber.org/zap"
+)
+
+// testRequest is a request from remoteAddr with the headers
+type testRequest struct {
+	web.MiddlewareRequest
+	ctx        localcontext.Context
+	headers    http.Header
+	remoteAddr string
+}
+
+func newTestRequest(remoteAddr, apiKey string) *testRequest {
+	r := &testRequest{
+		ctx:        localcontext.NewContext(zap.NewNop()),
+		headers:    http.Header{},
+		remoteAddr: remoteAddr,
+	}
+	if apiKey != "" {
+		r.headers.Set("X-API-Key", apiKey)
+	}
+
+	return r
+}
+
+func (r *testRequest) GetHeader(key string) string           { return r.headers.Get(key) }
+func (r *testRequest) GetPath() string                       { return "/api" }
+func (r *testRequest) GetRemoteAddr() string                 { return r.remoteAddr }
+func (r *testRequest) GetContext() localcontext.Context      { return r.ctx }
+func (r *testRequest) GetInternalRequest() *http.Request     { return nil }
+func (r *testRequest) SetContextValue(_ string, _ any) error { return nil }
+
+// apiKeyFunc counts the requests by their API key
+func apiKeyFunc(r web.Request) string {
+	if key := r.GetHeader("X-API-Key"); key != "" {
+		return "apikey:" + key
+	}
+
+	return ""
+}
+
+func TestKeyFunc(t *testing.T) {
+	rl := New(Options{Logger: zap.NewNop(), KeyFunc: apiKeyFunc})
+
+	assert.Equal(t, "apikey:k1", rl.getKey(newTestRequest("10.0.0.1", "k1")), "the API key is the key")
+	assert.Equal(t, "10.0.0.1", rl.getKey(newTestRequest("10.0.0.1", "")), "requests without a key are counted by IP")
+}
+
+func TestWithKey(t *testing.T) {
+	rl := New(Options{Logger: zap.NewNop()})
+	keyed := rl.WithKey(apiKeyFunc)
+
+	req := newTestRequest("10.0.0.1", "k1")
+	assert.Equal(t, "apikey:k1", keyed.getKey(req))
+	assert.Equal(t, "10.0.0.1", rl.getKey(req), "the original limiter is not changed")
+	assert.Nil(t, rl.keyFunc)
+}
+
+func TestMiddlewareCountsByKey(t *testing.T) {
+	// New reads the limits from the environment
+	t.Setenv("RATE_LIMIT_MAX", "2")
+	t.Setenv("RATE_LIMIT_WINDOW_SECS", "60")
+	mr := miniredis.RunT(t)
+	rl := New(Options{
+		Logger:  zap.NewNop(),
+		Cache:   redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		KeyFunc: apiKeyFunc,
+	})
+	mw := rl.GetMiddleware()
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, mw(newTestRequest("10.0.0.1", "k1")))
+	}
+	err := mw(newTestRequest("10.0.0.1", "k1"))
+	require.Error(t, err)
+	assert.Equal(t, "too_many_requests", web.ErrorCode(err))
+
+	assert.NoError(t, mw(newTestRequest("10.0.0.1", "k2")), "the other keys of the IP have their own limit")
+	assert.NoError(t, mw(newTestRequest("10.0.0.1", "")), "the requests without key have the limit of the IP")
+}
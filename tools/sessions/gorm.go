@@ -0,0 +1,136 @@
+package sessions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// cleanupInterval is how often the expired sessions are deleted from the table
+const cleanupInterval = time.Hour
+
+type (
+	// DBSession is a row of the sessions table of the db session store
+	DBSession struct {
+		ID        string    `gorm:"primaryKey"`
+		UserID    string    `gorm:"index"`
+		Data      []byte    `gorm:"not null"`
+		CreatedAt time.Time `gorm:"not null"`
+		UpdatedAt time.Time `gorm:"not null"`
+		ExpiresAt time.Time `gorm:"not null;index"`
+	}
+
+	// gormBackend saves the sessions in the sessions table
+	gormBackend struct {
+		db          *gorm.DB
+		mut         sync.Mutex
+		lastCleanup time.Time
+	}
+)
+
+func (DBSession) TableName() string {
+	return "sessions"
+}
+
+// NewGormStore creates a session store keeping the sessions in the sessions table,
+// they expire after MaxAge seconds without being saved
+func NewGormStore(opts Options, db *gorm.DB) Store {
+	return newServerStore(&gormBackend{db: db}, opts)
+}
+
+func (b *gormBackend) load(ctx context.Context, id string) (*record, error) {
+	s := DBSession{}
+	err := b.db.WithContext(ctx).Where("id = ? AND expires_at > ?", id, time.Now()).First(&s).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("could not load session: %w", err)
+	}
+
+	return &record{
+		UserID:    s.UserID,
+		Data:      s.Data,
+		CreatedAt: s.CreatedAt,
+		ExpiresAt: s.ExpiresAt,
+	}, nil
+}
+
+func (b *gormBackend) save(ctx context.Context, id string, r record) error {
+	b.deleteExpired(ctx)
+
+	s := DBSession{
+		ID:        id,
+		UserID:    r.UserID,
+		Data:      r.Data,
+		CreatedAt: r.CreatedAt,
+		ExpiresAt: r.ExpiresAt,
+	}
+
+	err := b.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"user_id", "data", "updated_at", "expires_at"}),
+	}).Create(&s).Error
+	if err != nil {
+		return fmt.Errorf("could not save session: %w", err)
+	}
+
+	return nil
+}
+
+// deleteExpired deletes the expired sessions at most once every cleanupInterval
+func (b *gormBackend) deleteExpired(ctx context.Context) {
+	b.mut.Lock()
+	if time.Since(b.lastCleanup) < cleanupInterval {
+		b.mut.Unlock()
+		return
+	}
+	b.lastCleanup = time.Now()
+	b.mut.Unlock()
+
+	// expired sessions are never loaded, failing to delete them is harmless
+	_ = b.db.WithContext(ctx).Where("expires_at <= ?", time.Now()).Delete(&DBSession{}).Error
+}
+
+func (b *gormBackend) delete(ctx context.Context, id string) error {
+	if err := b.db.WithContext(ctx).Where("id = ?", id).Delete(&DBSession{}).Error; err != nil {
+		return fmt.Errorf("could not delete session: %w", err)
+	}
+
+	return nil
+}
+
+func (b *gormBackend) list(ctx context.Context, userID string) ([]Info, error) {
+	rows := []DBSession{}
+	err := b.db.WithContext(ctx).
+		Where("user_id = ? AND expires_at > ?", userID, time.Now()).
+		Order("created_at").
+		Find(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("could not list sessions: %w", err)
+	}
+
+	infos := make([]Info, 0, len(rows))
+	for _, s := range rows {
+		infos = append(infos, Info{
+			ID:        s.ID,
+			UserID:    s.UserID,
+			CreatedAt: s.CreatedAt,
+			ExpiresAt: s.ExpiresAt,
+		})
+	}
+
+	return infos, nil
+}
+
+func (b *gormBackend) deleteUser(ctx context.Context, userID string) error {
+	if err := b.db.WithContext(ctx).Where("user_id = ?", userID).Delete(&DBSession{}).Error; err != nil {
+		return fmt.Errorf("could not delete sessions: %w", err)
+	}
+
+	return nil
+}
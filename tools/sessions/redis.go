@@ -0,0 +1,149 @@
+package sessions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	redisSessionPrefix = "session:"
+	redisUserPrefix    = "session:user:"
+)
+
+// redisBackend saves the sessions as JSON with a TTL, and the IDs of the
+// sessions of every user in a sorted set scored by their expiry
+type redisBackend struct {
+	client *redis.Client
+}
+
+// NewRedisStore creates a session store keeping the sessions in redis,
+// they expire after MaxAge seconds without being saved
+func NewRedisStore(opts Options, client *redis.Client) Store {
+	return newServerStore(&redisBackend{client: client}, opts)
+}
+
+func (b *redisBackend) load(ctx context.Context, id string) (*record, error) {
+	data, err := b.client.Get(ctx, redisSessionPrefix+id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("could not load session: %w", err)
+	}
+
+	r := &record{}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, fmt.Errorf("could not decode session: %w", err)
+	}
+
+	return r, nil
+}
+
+func (b *redisBackend) save(ctx context.Context, id string, r record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("could not encode session: %w", err)
+	}
+
+	_, err = b.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Set(ctx, redisSessionPrefix+id, data, time.Until(r.ExpiresAt))
+		if r.UserID != "" {
+			userKey := redisUserPrefix + r.UserID
+			p.ZAdd(ctx, userKey, &redis.Z{Score: float64(r.ExpiresAt.Unix()), Member: id})
+			p.ExpireAt(ctx, userKey, r.ExpiresAt)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not save session: %w", err)
+	}
+
+	return nil
+}
+
+func (b *redisBackend) delete(ctx context.Context, id string) error {
+	r, err := b.load(ctx, id)
+	if err != nil || r == nil {
+		return err
+	}
+
+	_, err = b.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Del(ctx, redisSessionPrefix+id)
+		if r.UserID != "" {
+			p.ZRem(ctx, redisUserPrefix+r.UserID, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not delete session: %w", err)
+	}
+
+	return nil
+}
+
+// getUserSessionIDs returns the IDs of the sessions of the user that did not expire
+func (b *redisBackend) getUserSessionIDs(ctx context.Context, userID string) ([]string, error) {
+	userKey := redisUserPrefix + userID
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	if err := b.client.ZRemRangeByScore(ctx, userKey, "-inf", now).Err(); err != nil {
+		return nil, fmt.Errorf("could not list sessions: %w", err)
+	}
+
+	ids, err := b.client.ZRange(ctx, userKey, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("could not list sessions: %w", err)
+	}
+
+	return ids, nil
+}
+
+func (b *redisBackend) list(ctx context.Context, userID string) ([]Info, error) {
+	ids, err := b.getUserSessionIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := []Info{}
+	for _, id := range ids {
+		r, err := b.load(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		// the session was deleted or it belongs to another user after a log in
+		if r == nil || r.UserID != userID {
+			continue
+		}
+
+		infos = append(infos, Info{
+			ID:        id,
+			UserID:    r.UserID,
+			CreatedAt: r.CreatedAt,
+			ExpiresAt: r.ExpiresAt,
+		})
+	}
+
+	return infos, nil
+}
+
+func (b *redisBackend) deleteUser(ctx context.Context, userID string) error {
+	ids, err := b.getUserSessionIDs(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	keys := []string{redisUserPrefix + userID}
+	for _, id := range ids {
+		keys = append(keys, redisSessionPrefix+id)
+	}
+
+	if err := b.client.Del(ctx, keys...).Err(); err != nil {
+		return fmt.Errorf("could not delete sessions: %w", err)
+	}
+
+	return nil
+}
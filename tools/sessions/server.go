@@ -0,0 +1,208 @@
+package sessions
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/gob"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"go.uber.org/zap"
+)
+
+// sessionIDBytes is the number of random bytes of a session ID
+const sessionIDBytes = 32
+
+type (
+	// record is a session saved by a server side store
+	record struct {
+		UserID    string    `json:"user_id"`
+		Data      []byte    `json:"data"`
+		CreatedAt time.Time `json:"created_at"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	// backend saves the session records of a server side store
+	backend interface {
+		// load returns the record of the session, nil if it does not exist or expired
+		load(ctx context.Context, id string) (*record, error)
+		save(ctx context.Context, id string, r record) error
+		delete(ctx context.Context, id string) error
+		list(ctx context.Context, userID string) ([]Info, error)
+		deleteUser(ctx context.Context, userID string) error
+	}
+
+	// serverStore keeps the session values in a backend and only an
+	// opaque session ID in the cookie, so sessions can be revoked
+	serverStore struct {
+		backend backend
+		options sessions.Options
+		l       *zap.Logger
+	}
+)
+
+func newServerStore(b backend, opts Options) *serverStore {
+	setSessionName(opts)
+
+	return &serverStore{
+		backend: b,
+		options: getCookieOptions(opts),
+		l:       opts.Logger,
+	}
+}
+
+// newSessionID returns a random url safe session ID
+func newSessionID() (string, error) {
+	b := make([]byte, sessionIDBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate session id: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// getUserID returns the ID of the user the session belongs to, if any
+func getUserID(session *sessions.Session) string {
+	userID, ok := session.Values[UserIDKey]
+	if !ok || userID == nil {
+		return ""
+	}
+
+	return fmt.Sprint(userID)
+}
+
+func encodeValues(values map[any]any) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(values); err != nil {
+		return nil, fmt.Errorf("could not encode session values: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func decodeValues(data []byte) (map[any]any, error) {
+	values := map[any]any{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&values); err != nil {
+		return nil, fmt.Errorf("could not decode session values: %w", err)
+	}
+
+	return values, nil
+}
+
+// New returns the session of the request cookie, or a new session
+// if there is no cookie or the session expired
+func (s *serverStore) New(req *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := s.options
+	session.Options = &opts
+	session.IsNew = true
+
+	cookie, err := req.Cookie(name)
+	if err != nil || cookie.Value == "" {
+		return session, nil
+	}
+
+	r, err := s.backend.load(req.Context(), cookie.Value)
+	if err != nil {
+		return session, err
+	}
+	if r == nil {
+		return session, nil
+	}
+
+	values, err := decodeValues(r.Data)
+	if err != nil {
+		return session, err
+	}
+
+	session.ID = cookie.Value
+	session.Values = values
+	session.IsNew = false
+	return session, nil
+}
+
+// Get returns the session of the request, cached for the request
+func (s *serverStore) Get(req *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(req).Get(s, name)
+}
+
+// Save saves the session values in the backend and the session ID in the
+// cookie, a negative MaxAge deletes the session
+func (s *serverStore) Save(req *http.Request, resp http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := s.backend.delete(req.Context(), session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(resp, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	// empty new sessions are not saved, so anonymous requests do not create records
+	if session.ID == "" && len(session.Values) == 0 {
+		return nil
+	}
+
+	data, err := encodeValues(session.Values)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	r := record{
+		UserID:    getUserID(session),
+		Data:      data,
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Duration(session.Options.MaxAge) * time.Second),
+	}
+
+	if session.ID != "" {
+		old, err := s.backend.load(req.Context(), session.ID)
+		if err != nil {
+			return err
+		}
+
+		if old != nil && old.UserID != r.UserID {
+			// the user logged in or out, a new ID prevents session fixation
+			if err := s.backend.delete(req.Context(), session.ID); err != nil {
+				return err
+			}
+			session.ID = ""
+		} else if old != nil {
+			r.CreatedAt = old.CreatedAt
+		}
+	}
+
+	if session.ID == "" {
+		if session.ID, err = newSessionID(); err != nil {
+			return err
+		}
+	}
+
+	if err := s.backend.save(req.Context(), session.ID, r); err != nil {
+		return err
+	}
+
+	http.SetCookie(resp, sessions.NewCookie(session.Name(), session.ID, session.Options))
+	return nil
+}
+
+// ListUserSessions returns the active sessions of the user
+func (s *serverStore) ListUserSessions(ctx context.Context, userID string) ([]Info, error) {
+	return s.backend.list(ctx, userID)
+}
+
+// DeleteSession deletes the session with the given ID
+func (s *serverStore) DeleteSession(ctx context.Context, id string) error {
+	return s.backend.delete(ctx, id)
+}
+
+// DeleteUserSessions deletes all the sessions of the user
+func (s *serverStore) DeleteUserSessions(ctx context.Context, userID string) error {
+	return s.backend.deleteUser(ctx, userID)
+}
@@ -1,7 +1,10 @@
 package sessions
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/sessions"
 	"github.com/unluckythoughts/go-microservice/v2/tools/web"
@@ -9,14 +12,30 @@ import (
 	"go.uber.org/zap"
 )
 
+const (
+	// StoreCookie keeps the session values in a signed cookie
+	StoreCookie = "cookie"
+	// StoreRedis keeps the session values in redis
+	StoreRedis = "redis"
+	// StoreDB keeps the session values in the sessions table
+	StoreDB = "db"
+
+	// UserIDKey is the session value with the ID of the logged in user,
+	// server side stores index the sessions by it
+	UserIDKey = "user_id"
+)
+
 var (
 	// Default session cookie name
 	sessionName = "session"
+
+	// ErrNotSupported is returned by the cookie store for the operations
+	// that need the sessions to be saved server side
+	ErrNotSupported = errors.New("not supported by the cookie session store")
 )
 
 type (
 	// Store interface for session management
-	// TODO: add redis store to save sessions
 	Store interface {
 		// Get retrieves a session for the given request
 		Get(req *http.Request, key string) (*sessions.Session, error)
@@ -24,10 +43,26 @@ type (
 		Save(req *http.Request, resp http.ResponseWriter, value *sessions.Session) error
 		// New creates a new session for the given request
 		New(req *http.Request, key string) (*sessions.Session, error)
+		// ListUserSessions returns the active sessions of the user
+		ListUserSessions(ctx context.Context, userID string) ([]Info, error)
+		// DeleteSession deletes the session with the given ID
+		DeleteSession(ctx context.Context, id string) error
+		// DeleteUserSessions deletes all the sessions of the user, e.g. to log out everywhere
+		DeleteUserSessions(ctx context.Context, userID string) error
+	}
+
+	// Info describes a session saved by a server side store
+	Info struct {
+		ID        string    `json:"id"`
+		UserID    string    `json:"userId"`
+		CreatedAt time.Time `json:"createdAt"`
+		ExpiresAt time.Time `json:"expiresAt"`
 	}
 
 	// Options contains configuration for session management
 	Options struct {
+		// Store is where the sessions are kept, cookie, redis or db
+		Store string `env:"SESSION_STORE" envDefault:"cookie"`
 		// Name is the name of the session cookie
 		Name string `env:"SESSION_NAME" envDefault:"session"`
 		// SecretKey is the key used to sign the session cookie
@@ -65,40 +100,66 @@ func (s *sessionStore) Save(req *http.Request, resp http.ResponseWriter, value *
 	return s.store.Save(req, resp, value)
 }
 
-// NewStore creates a new session store with the given options
-func NewStore(opts Options) Store {
-	var secretKey []byte
+// ListUserSessions is not supported, cookie sessions are not saved server side
+func (s *sessionStore) ListUserSessions(_ context.Context, _ string) ([]Info, error) {
+	return nil, ErrNotSupported
+}
 
-	if opts.SecretKey != "" {
-		secretKey = []byte(opts.SecretKey)
-	} else {
-		strKey, err := utils.GenerateRandomString(32)
-		if err != nil {
-			panic(err)
-		}
-		secretKey = []byte(strKey)
-		opts.Logger.Info("Generated random session secret key")
-		opts.Logger.Debug("Session secret initialized", zap.Int("length", len(secretKey)))
-	}
+// DeleteSession is not supported, cookie sessions are not saved server side
+func (s *sessionStore) DeleteSession(_ context.Context, _ string) error {
+	return ErrNotSupported
+}
+
+// DeleteUserSessions is not supported, cookie sessions are not saved server side
+func (s *sessionStore) DeleteUserSessions(_ context.Context, _ string) error {
+	return ErrNotSupported
+}
 
+// setSessionName sets the name of the session cookie read by the middleware
+func setSessionName(opts Options) {
 	if opts.Name != "" {
 		sessionName = opts.Name
 	}
+}
 
+// getCookieOptions returns the options of the session cookie
+func getCookieOptions(opts Options) sessions.Options {
 	// Default session cookie max age (24 hours)
 	sessionMaxAge := 24 * 60 * 60
 	if opts.MaxAge != 0 {
 		sessionMaxAge = opts.MaxAge
 	}
 
-	store := sessions.NewCookieStore(secretKey)
-	store.Options = &sessions.Options{
+	return sessions.Options{
 		Path:     "/",
 		MaxAge:   sessionMaxAge,
 		Secure:   opts.Secure,
 		HttpOnly: opts.HttpOnly,
 		SameSite: opts.SameSite,
 	}
+}
+
+// NewStore creates a new cookie session store with the given options
+func NewStore(opts Options) Store {
+	var secretKey []byte
+
+	if opts.SecretKey != "" {
+		secretKey = []byte(opts.SecretKey)
+	} else {
+		strKey, err := utils.GenerateRandomString(32)
+		if err != nil {
+			panic(err)
+		}
+		secretKey = []byte(strKey)
+		opts.Logger.Info("Generated random session secret key")
+		opts.Logger.Debug("Session secret initialized", zap.Int("length", len(secretKey)))
+	}
+
+	setSessionName(opts)
+
+	cookieOptions := getCookieOptions(opts)
+	store := sessions.NewCookieStore(secretKey)
+	store.Options = &cookieOptions
 
 	return &sessionStore{
 		store: store,
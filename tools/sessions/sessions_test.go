@@ -0,0 +1,176 @@
+package sessions
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newTestOptions() Options {
+	return Options{Name: "session", MaxAge: 3600, Logger: zap.NewNop()}
+}
+
+func newRedisTestStore(t *testing.T) (Store, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	return NewRedisStore(newTestOptions(), client), mr
+}
+
+func newGormTestStore(t *testing.T) Store {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "sessions.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&DBSession{}))
+
+	return NewGormStore(newTestOptions(), db)
+}
+
+// saveSession gets the session of the cookie, sets the values and saves it,
+// it returns the session cookie sent in the response
+func saveSession(t *testing.T, store Store, cookie *http.Cookie, values map[any]any) (*http.Cookie, bool) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+
+	session, err := store.Get(req, "session")
+	require.NoError(t, err)
+	isNew := session.IsNew
+	for key, value := range values {
+		session.Values[key] = value
+	}
+
+	w := httptest.NewRecorder()
+	require.NoError(t, store.Save(req, w, session))
+
+	cookies := w.Result().Cookies()
+	if len(cookies) == 0 {
+		return nil, isNew
+	}
+	return cookies[0], isNew
+}
+
+// getSession returns the session of the cookie
+func getSession(t *testing.T, store Store, cookie *http.Cookie) (map[any]any, bool) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookie)
+
+	session, err := store.Get(req, "session")
+	require.NoError(t, err)
+	return session.Values, session.IsNew
+}
+
+func testServerStore(t *testing.T, store Store) {
+	ctx := context.Background()
+
+	// anonymous requests without values do not create sessions
+	cookie, _ := saveSession(t, store, nil, nil)
+	assert.Nil(t, cookie)
+
+	anonymous, isNew := saveSession(t, store, nil, map[any]any{"theme": "dark"})
+	require.NotNil(t, anonymous)
+	assert.True(t, isNew)
+	assert.NotContains(t, anonymous.Value, "dark")
+
+	values, isNew := getSession(t, store, anonymous)
+	assert.False(t, isNew)
+	assert.Equal(t, "dark", values["theme"])
+
+	// logging in gives the session a new ID
+	first, isNew := saveSession(t, store, anonymous, map[any]any{UserIDKey: uint(5)})
+	assert.False(t, isNew)
+	assert.NotEqual(t, anonymous.Value, first.Value)
+	_, isNew = getSession(t, store, anonymous)
+	assert.True(t, isNew)
+
+	values, _ = getSession(t, store, first)
+	assert.Equal(t, uint(5), values[UserIDKey])
+	assert.Equal(t, "dark", values["theme"])
+
+	second, _ := saveSession(t, store, nil, map[any]any{UserIDKey: uint(5)})
+	_, _ = saveSession(t, store, nil, map[any]any{UserIDKey: uint(6)})
+
+	infos, err := store.ListUserSessions(ctx, "5")
+	require.NoError(t, err)
+	ids := []string{}
+	for _, info := range infos {
+		assert.Equal(t, "5", info.UserID)
+		assert.True(t, info.ExpiresAt.After(time.Now()))
+		ids = append(ids, info.ID)
+	}
+	assert.ElementsMatch(t, []string{first.Value, second.Value}, ids)
+
+	require.NoError(t, store.DeleteSession(ctx, second.Value))
+	infos, err = store.ListUserSessions(ctx, "5")
+	require.NoError(t, err)
+	assert.Len(t, infos, 1)
+
+	require.NoError(t, store.DeleteUserSessions(ctx, "5"))
+	_, isNew = getSession(t, store, first)
+	assert.True(t, isNew)
+
+	infos, err = store.ListUserSessions(ctx, "5")
+	require.NoError(t, err)
+	assert.Empty(t, infos)
+
+	infos, err = store.ListUserSessions(ctx, "6")
+	require.NoError(t, err)
+	assert.Len(t, infos, 1)
+}
+
+func TestRedisStore(t *testing.T) {
+	store, _ := newRedisTestStore(t)
+	testServerStore(t, store)
+}
+
+func TestGormStore(t *testing.T) {
+	testServerStore(t, newGormTestStore(t))
+}
+
+func TestRedisStoreExpiry(t *testing.T) {
+	store, mr := newRedisTestStore(t)
+
+	cookie, _ := saveSession(t, store, nil, map[any]any{UserIDKey: uint(5)})
+	mr.FastForward(2 * time.Hour)
+
+	_, isNew := getSession(t, store, cookie)
+	assert.True(t, isNew)
+}
+
+func TestServerStoreDeletesSessionWithNegativeMaxAge(t *testing.T) {
+	store := newGormTestStore(t)
+	cookie, _ := saveSession(t, store, nil, map[any]any{UserIDKey: uint(5)})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookie)
+	session, err := store.Get(req, "session")
+	require.NoError(t, err)
+	session.Options.MaxAge = -1
+
+	w := httptest.NewRecorder()
+	require.NoError(t, store.Save(req, w, session))
+	assert.Equal(t, -1, w.Result().Cookies()[0].MaxAge)
+
+	_, isNew := getSession(t, store, cookie)
+	assert.True(t, isNew)
+}
+
+func TestCookieStoreDoesNotListSessions(t *testing.T) {
+	store := NewStore(newTestOptions())
+
+	_, err := store.ListUserSessions(context.Background(), "5")
+	assert.ErrorIs(t, err, ErrNotSupported)
+	assert.ErrorIs(t, store.DeleteUserSessions(context.Background(), "5"), ErrNotSupported)
+}
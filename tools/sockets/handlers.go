@@ -54,13 +54,14 @@ func (s *Server) handleSocketRequest(req Request) {
 	s.handlerMutex.RLock()
 
 	handler, ok := s.handlers[req.Body.Method]
+	s.handlerMutex.RUnlock()
 	if !ok {
 		s.sendResponse(req, nil, errors.New("unknown method"))
 		return
 	}
-	s.handlerMutex.RUnlock()
 
 	data, err := handler(req)
+	s.metrics.ObserveSocketMessage(req.Body.Method, time.Since(req.Timestamp), err)
 	s.sendResponse(req, data, err)
 }
 
@@ -1,11 +1,13 @@
 package sockets
 
 import (
+	"context"
 	"net"
 	"sync"
 
 	"github.com/gobwas/ws"
 	"github.com/gobwas/ws/wsutil"
+	"github.com/unluckythoughts/go-microservice/v2/tools/metrics"
 	"go.uber.org/zap"
 )
 
@@ -16,6 +18,12 @@ type Server struct {
 	connections  map[string]net.Conn
 	handlers     map[string]Handler
 	workerCount  int
+	reqChan      chan Request
+	done         chan struct{}
+	closeMutex   sync.RWMutex
+	closed       bool
+	inFlight     sync.WaitGroup
+	metrics      *metrics.Registry
 }
 
 func New(l *zap.Logger, count int) *Server {
@@ -26,6 +34,8 @@ func New(l *zap.Logger, count int) *Server {
 		connections:  make(map[string]net.Conn),
 		handlers:     make(map[string]Handler),
 		workerCount:  20,
+		reqChan:      make(chan Request, 2000),
+		done:         make(chan struct{}),
 	}
 
 	if count > 0 {
@@ -39,18 +49,27 @@ func New(l *zap.Logger, count int) *Server {
 	return s
 }
 
+// SetMetrics sets the registry recording the connections and messages, nil disables it
+func (s *Server) SetMetrics(m *metrics.Registry) {
+	s.metrics = m
+}
+
 func (s *Server) addConn(conn net.Conn) {
 	defer s.connMutex.Unlock()
 	s.connMutex.Lock()
 
 	s.connections[conn.RemoteAddr().String()] = conn
+	s.metrics.SocketConnected()
 }
 
 func (s *Server) delConn(conn net.Conn) {
 	defer s.connMutex.Unlock()
 	s.connMutex.Lock()
 
-	delete(s.connections, conn.RemoteAddr().String())
+	if _, ok := s.connections[conn.RemoteAddr().String()]; ok {
+		delete(s.connections, conn.RemoteAddr().String())
+		s.metrics.SocketDisconnected()
+	}
 	err := conn.Close()
 	if err != nil {
 		s.l.Debug("error while closing connection", zap.String("conn", conn.RemoteAddr().String()))
@@ -61,3 +80,51 @@ func (s *Server) closeSocket(conn net.Conn, msg string) {
 	_ = wsutil.WriteServerMessage(conn, ws.OpClose, []byte(msg))
 	s.delConn(conn)
 }
+
+// isClosed reports whether the server has been shut down
+func (s *Server) isClosed() bool {
+	s.closeMutex.RLock()
+	defer s.closeMutex.RUnlock()
+
+	return s.closed
+}
+
+// Shutdown stops accepting new socket requests, waits for the in-flight
+// requests to finish or the context to expire, then closes all open connections
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closeMutex.Lock()
+	if s.closed {
+		s.closeMutex.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.closeMutex.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		s.inFlight.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	close(s.done)
+
+	// the responses of the in-flight requests are written before the connections are closed
+	s.connMutex.RLock()
+	conns := make([]net.Conn, 0, len(s.connections))
+	for _, conn := range s.connections {
+		conns = append(conns, conn)
+	}
+	s.connMutex.RUnlock()
+
+	for _, conn := range conns {
+		s.closeSocket(conn, "server is shutting down")
+	}
+
+	return err
+}
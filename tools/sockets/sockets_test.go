@@ -0,0 +1,134 @@
+package sockets
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// newTestConn returns the server side of a connection added to the server and its client side
+func newTestConn(t *testing.T, s *Server) (net.Conn, net.Conn) {
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+	s.addConn(server)
+
+	return server, client
+}
+
+func newTestRequest(conn net.Conn, method string) Request {
+	return Request{
+		Conn:      conn,
+		ID:        "req",
+		Logger:    zap.NewNop(),
+		Body:      RequestBody{ID: "1", Method: method},
+		Timestamp: time.Now(),
+	}
+}
+
+func TestShutdownWaitsForInFlightRequests(t *testing.T) {
+	s := New(zap.NewNop(), 1)
+	started, release := make(chan struct{}), make(chan struct{})
+	s.AddHandler("slow", func(r Request) (interface{}, error) {
+		close(started)
+		<-release
+		return "done", nil
+	})
+	s.StartSocketWorkers()
+
+	server, client := newTestConn(t, s)
+	require.True(t, s.enqueue(newTestRequest(server, "slow")))
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdown <- s.Shutdown(ctx)
+	}()
+
+	require.Eventually(t, s.isClosed, time.Second, time.Millisecond)
+	assert.False(t, s.enqueue(newTestRequest(server, "slow")), "new requests are refused")
+	select {
+	case <-shutdown:
+		t.Fatal("shutdown returned before the in-flight request finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	data, op, err := wsutil.ReadServerData(client)
+	require.NoError(t, err)
+	assert.Equal(t, ws.OpText, op, "the response is written before the connection is closed")
+	resp := Response{}
+	require.NoError(t, json.Unmarshal(data, &resp))
+	assert.True(t, resp.Success)
+	assert.Equal(t, "done", resp.Result)
+
+	frame, err := ws.ReadFrame(client)
+	require.NoError(t, err)
+	assert.Equal(t, ws.OpClose, frame.Header.OpCode)
+	require.NoError(t, <-shutdown)
+	assert.NoError(t, s.Shutdown(context.Background()), "shutdown runs once")
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	s := New(zap.NewNop(), 1)
+	release := make(chan struct{})
+	defer close(release)
+	s.AddHandler("stuck", func(r Request) (interface{}, error) {
+		<-release
+		return nil, nil
+	})
+	s.StartSocketWorkers()
+
+	server, client := newTestConn(t, s)
+	go func() { _, _ = io.Copy(io.Discard, client) }()
+	require.True(t, s.enqueue(newTestRequest(server, "stuck")))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+
+	s.connMutex.RLock()
+	defer s.connMutex.RUnlock()
+	assert.Empty(t, s.connections, "the connections are closed after the deadline")
+}
+
+func TestEnqueueDoesNotBlockShutdown(t *testing.T) {
+	s := New(zap.NewNop(), 1)
+	// no worker reads the queue, the request stays blocked on the send
+	s.reqChan = make(chan Request)
+
+	server, client := newTestConn(t, s)
+	go func() { _, _ = io.Copy(io.Discard, client) }()
+	enqueued := make(chan bool, 1)
+	go func() {
+		enqueued <- s.enqueue(newTestRequest(server, "_status"))
+	}()
+
+	// wait for the request to be counted before shutting down
+	time.Sleep(20 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(ctx) }()
+
+	select {
+	case err := <-shutdown:
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	case <-time.After(time.Second):
+		t.Fatal("shutdown is blocked by the queued request")
+	}
+	assert.False(t, <-enqueued, "the blocked request is dropped")
+}
@@ -10,21 +10,49 @@ import (
 	"go.uber.org/zap"
 )
 
-var (
-	reqchan = make(chan Request, 2000)
-)
-
 func (s *Server) StartSocketWorkers() {
 	for i := 0; i < s.workerCount; i++ {
 		go func(reqChan <-chan Request) {
-			for req := range reqChan {
-				s.handleSocketRequest(req)
+			for {
+				select {
+				case req := <-reqChan:
+					s.handleSocketRequest(req)
+					s.inFlight.Done()
+				case <-s.done:
+					return
+				}
 			}
-		}(reqchan)
+		}(s.reqChan)
+	}
+}
+
+// enqueue queues the request for the socket workers, it returns false
+// if the server is shutting down and the request was dropped
+func (s *Server) enqueue(req Request) bool {
+	s.closeMutex.RLock()
+	if s.closed {
+		s.closeMutex.RUnlock()
+		return false
+	}
+	s.inFlight.Add(1)
+	s.closeMutex.RUnlock()
+
+	// the send blocks while the queue is full, it must not hold up Shutdown
+	select {
+	case s.reqChan <- req:
+		return true
+	case <-s.done:
+		s.inFlight.Done()
+		return false
 	}
 }
 
 func (s *Server) HandleSocketConnection(conn net.Conn) {
+	if s.isClosed() {
+		s.closeSocket(conn, "server is shutting down")
+		return
+	}
+
 	s.addConn(conn)
 	for {
 		bytes, _, err := wsutil.ReadClientData(conn)
@@ -46,12 +74,16 @@ func (s *Server) HandleSocketConnection(conn net.Conn) {
 		}
 
 		reqID := uuid.Must(uuid.NewV4()).String()
-		reqchan <- Request{
+		ok := s.enqueue(Request{
 			Conn:      conn,
 			ID:        reqID,
 			Logger:    s.l.With(zap.String("reqId", reqID)),
 			Body:      body,
 			Timestamp: time.Now(),
+		})
+		if !ok {
+			s.closeSocket(conn, "server is shutting down")
+			return
 		}
 	}
 }
@@ -0,0 +1,167 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const (
+	// ExporterNone creates spans and propagates them without exporting them
+	ExporterNone = "none"
+	// ExporterStdout writes the spans to stdout as JSON
+	ExporterStdout = "stdout"
+	// ExporterOTLP sends the spans to an OTLP/HTTP collector
+	ExporterOTLP = "otlp"
+
+	// TraceparentHeader is the W3C trace context header
+	TraceparentHeader = "traceparent"
+
+	instrumentationName = "github.com/unluckythoughts/go-microservice/v2"
+)
+
+type (
+	Options struct {
+		ServiceName string
+		Logger      *zap.Logger
+		// Exporter is where the spans are sent, none, stdout or otlp
+		Exporter string `env:"TRACING_EXPORTER" envDefault:"none"`
+		// OTLPEndpoint is the host:port of the collector, empty uses OTEL_EXPORTER_OTLP_ENDPOINT
+		OTLPEndpoint string `env:"TRACING_OTLP_ENDPOINT" envDefault:""`
+		OTLPInsecure bool   `env:"TRACING_OTLP_INSECURE" envDefault:"true"`
+		// SampleRatio is the ratio of the new traces that are recorded, defaults to 1.
+		// Traces started by other services follow their sampling decision.
+		SampleRatio float64 `env:"TRACING_SAMPLE_RATIO" envDefault:"1"`
+	}
+
+	// Provider creates the spans of the service and exports them
+	Provider struct {
+		tp *sdktrace.TracerProvider
+	}
+)
+
+// propagator reads and writes the W3C traceparent header, it is used instead
+// of the global otel propagator so propagation works without any setup
+var propagator = propagation.TraceContext{}
+
+func getExporter(opts Options) (sdktrace.SpanExporter, error) {
+	switch opts.Exporter {
+	case "", ExporterNone:
+		return nil, nil
+	case ExporterStdout:
+		return stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterOTLP:
+		clientOpts := []otlptracehttp.Option{}
+		if opts.OTLPEndpoint != "" {
+			clientOpts = append(clientOpts, otlptracehttp.WithEndpoint(opts.OTLPEndpoint))
+		}
+		if opts.OTLPInsecure {
+			clientOpts = append(clientOpts, otlptracehttp.WithInsecure())
+		}
+		return otlptracehttp.New(context.Background(), clientOpts...)
+	default:
+		return nil, fmt.Errorf("unsupported tracing exporter: %s", opts.Exporter)
+	}
+}
+
+// New returns a provider exporting the spans with the configured exporter and
+// sets it as the global tracer provider used by Start
+func New(opts Options) *Provider {
+	exporter, err := getExporter(opts)
+	if err != nil {
+		panic(fmt.Errorf("could not create tracing exporter: %w", err))
+	}
+
+	if opts.SampleRatio <= 0 {
+		opts.SampleRatio = 1
+	}
+
+	tpOpts := []sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", opts.ServiceName))),
+	}
+	if exporter != nil {
+		tpOpts = append(tpOpts, sdktrace.WithBatcher(exporter))
+	}
+
+	p := &Provider{tp: sdktrace.NewTracerProvider(tpOpts...)}
+	otel.SetTracerProvider(p.tp)
+	otel.SetTextMapPropagator(propagator)
+
+	if opts.Logger != nil {
+		opts.Logger.Info("Tracing enabled", zap.String("exporter", opts.Exporter))
+	}
+	return p
+}
+
+// Shutdown exports the pending spans and stops the provider
+func (p *Provider) Shutdown(ctx context.Context) error {
+	return p.tp.Shutdown(ctx)
+}
+
+// Start starts a span as a child of the span in ctx, if any.
+// Until a Provider is created the spans are not recorded, but the span
+// of ctx is still propagated.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// End ends the span, marking it as failed if err is not nil
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Inject writes the traceparent of the span in ctx to the headers
+func Inject(ctx context.Context, h http.Header) {
+	propagator.Inject(ctx, propagation.HeaderCarrier(h))
+}
+
+// Extract returns ctx with the remote span of the traceparent in the headers
+func Extract(ctx context.Context, h http.Header) context.Context {
+	return propagator.Extract(ctx, propagation.HeaderCarrier(h))
+}
+
+// InjectMap writes the traceparent of the span in ctx to the map, e.g. message headers
+func InjectMap(ctx context.Context, m map[string]string) {
+	propagator.Inject(ctx, propagation.MapCarrier(m))
+}
+
+// ExtractMap returns ctx with the remote span of the traceparent in the map
+func ExtractMap(ctx context.Context, m map[string]string) context.Context {
+	return propagator.Extract(ctx, propagation.MapCarrier(m))
+}
+
+// HasSpan reports whether ctx carries a valid span
+func HasSpan(ctx context.Context) bool {
+	return trace.SpanContextFromContext(ctx).IsValid()
+}
+
+// LogFields returns the trace_id and span_id logger fields of the span in ctx,
+// or nothing if there is no span
+func LogFields(ctx context.Context) []zap.Field {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return nil
+	}
+
+	return []zap.Field{
+		zap.String("trace_id", sc.TraceID().String()),
+		zap.String("span_id", sc.SpanID().String()),
+	}
+}
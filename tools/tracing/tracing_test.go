@@ -0,0 +1,51 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestExtractAndInject(t *testing.T) {
+	New(Options{ServiceName: "test"})
+
+	in := http.Header{}
+	in.Set(TraceparentHeader, traceparent)
+	ctx, span := Start(Extract(context.Background(), in), "test")
+	defer span.End()
+
+	sc := trace.SpanContextFromContext(ctx)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID().String())
+	assert.NotEqual(t, "00f067aa0ba902b7", sc.SpanID().String())
+	assert.True(t, span.IsRecording())
+
+	out := map[string]string{}
+	InjectMap(ctx, out)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+sc.SpanID().String()+"-01", out[TraceparentHeader])
+
+	fields := LogFields(ctx)
+	assert.Len(t, fields, 2)
+	assert.Equal(t, "trace_id", fields[0].Key)
+	assert.Equal(t, sc.TraceID().String(), fields[0].String)
+	assert.Equal(t, sc.SpanID().String(), fields[1].String)
+}
+
+func TestNoSpan(t *testing.T) {
+	ctx := ExtractMap(context.Background(), map[string]string{TraceparentHeader: "invalid"})
+
+	assert.False(t, HasSpan(ctx))
+	assert.Empty(t, LogFields(ctx))
+
+	h := http.Header{}
+	Inject(ctx, h)
+	assert.Empty(t, h.Get(TraceparentHeader))
+}
+
+func TestUnsupportedExporter(t *testing.T) {
+	assert.Panics(t, func() { New(Options{Exporter: "zipkin"}) })
+}
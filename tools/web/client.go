@@ -2,21 +2,30 @@ package web
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/pkg/errors"
+	"github.com/unluckythoughts/go-microservice/v2/tools/tracing"
 	"github.com/unluckythoughts/go-microservice/v2/tools/web/proxy/httpproxy"
 	"github.com/unluckythoughts/go-microservice/v2/tools/web/proxy/socks5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Client interface {
 	Log(message string)
 	SetBearerToken(token string)
 	ClearBearerToken()
+	// WithContext returns a client sending its requests with the context, so they
+	// are canceled with it, e.g. with the request context in a handler.
+	// The returned client shares the headers of the client.
+	WithContext(ctx context.Context) Client
 
 	// GetResponse, PostResponse, PutResponse, PatchResponse, DeleteResponse
 	// are convenience methods for sending HTTP requests with the specified method and body.
@@ -35,6 +44,7 @@ type client struct {
 	HTTPClient  *http.Client
 	Headers     http.Header
 	BearerToken string
+	ctx         context.Context
 }
 
 var (
@@ -149,6 +159,12 @@ func (c *client) ClearBearerToken() {
 	}
 }
 
+func (c *client) WithContext(ctx context.Context) Client {
+	cc := *c
+	cc.ctx = ctx
+	return &cc
+}
+
 func (c *client) Log(message string) {
 	message = url.PathEscape(message)
 	url := fmt.Sprintf("/_log/**************%s**************", message)
@@ -165,9 +181,26 @@ func (c *client) Send(
 		return 0, errors.Wrap(err, "could not parse the request body")
 	}
 
+	ctx := c.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
 	url = c.BaseURL + url
-	// nolint:noctx
-	req, err := http.NewRequest(method, url, reqBody)
+	ctx, span := tracing.Start(ctx, "HTTP "+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", method),
+			// the query is left out as it may carry credentials
+			attribute.String("url.full", strings.SplitN(url, "?", 2)[0]),
+		),
+	)
+	defer func() {
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		tracing.End(span, err)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
 	if err != nil {
 		return 0, errors.Wrap(err, "could not create http request")
 	}
@@ -180,36 +213,62 @@ func (c *client) Send(
 			}
 		}
 	}
+	tracing.Inject(ctx, req.Header)
 
 	httpResp, err := c.HTTPClient.Do(req)
 	if err != nil {
 		return 0, errors.Wrap(err, "could not send request")
 	}
 
-	if resp != nil {
-		defer func() { _ = httpResp.Body.Close() }()
-		data, err := io.ReadAll(httpResp.Body)
-		if err != nil {
-			return 0, errors.Wrap(err, "could not read response body")
-		}
+	defer func() { _ = httpResp.Body.Close() }()
+	data, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not read response body")
+	}
 
+	if resp != nil {
 		err = json.Unmarshal(data, resp)
-		if err != nil {
+		if err != nil && httpResp.StatusCode < 400 {
 			return 0, errors.Wrapf(err, "could not unmarshal response body: %s", string(data))
 		}
 	}
 
 	if httpResp.StatusCode >= 400 {
-		baseResp, ok := resp.(HTTPResponse)
-		if ok {
-			return httpResp.StatusCode, fmt.Errorf("request failed: %d %s", httpResp.StatusCode, baseResp.Error)
-		}
-		return httpResp.StatusCode, fmt.Errorf("request failed with status %d, response: %v", httpResp.StatusCode, resp)
+		return httpResp.StatusCode, getResponseError(httpResp.StatusCode, data)
 	}
 
 	return httpResp.StatusCode, nil
 }
 
+// getResponseError returns the error of a failed response, the error code
+// and details of envelope and problem responses are kept so callers can
+// use web.ErrorCode on the error
+func getResponseError(status int, data []byte) error {
+	body := struct {
+		Error   string `json:"error"`
+		Detail  string `json:"detail"`
+		Code    string `json:"code"`
+		Details any    `json:"details"`
+	}{}
+
+	if err := json.Unmarshal(data, &body); err != nil || (body.Error == "" && body.Detail == "" && body.Code == "") {
+		// the message is kept for the callers, NewError hides it for server errors
+		return NewCodedError(status, getStatusErrorCode(status),
+			fmt.Sprintf("request failed with status %d, response: %s", status, string(data)))
+	}
+
+	message := body.Error
+	if message == "" {
+		message = body.Detail
+	}
+
+	e := NewCodedError(status, body.Code, fmt.Sprintf("request failed: %d %s", status, message))
+	if body.Details != nil {
+		e = e.WithDetails(body.Details)
+	}
+	return e
+}
+
 func (c *client) GetResponse(url string, resp any, reqHeaders ...http.Header) (status int, err error) {
 	return c.Send(http.MethodGet, url, emptyBody, resp, reqHeaders...)
 }
@@ -0,0 +1,74 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unluckythoughts/go-microservice/v2/tools/tracing"
+)
+
+func TestClientWithContext(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	resp := HTTPResponse{}
+	_, err := NewClient(server.URL).WithContext(ctx).GetResponse("/", &resp)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestClientErrorCode(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte(`{"ok":false,"id":"1","error":"email is taken","code":"users.email_taken"}`))
+	}))
+	defer server.Close()
+
+	resp := HTTPResponse{}
+	status, err := NewClient(server.URL).GetResponse("/", &resp)
+	assert.Equal(t, http.StatusConflict, status)
+	assert.Equal(t, "users.email_taken", ErrorCode(err))
+	assert.Equal(t, "email is taken", resp.Error)
+}
+
+func TestClientPropagatesTrace(t *testing.T) {
+	tracing.New(tracing.Options{})
+
+	received := ""
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get(tracing.TraceparentHeader)
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	defer upstream.Close()
+
+	r := newTestRouter()
+	r.GET("/proxy", func(r Request) (any, error) {
+		resp := HTTPResponse{}
+		_, err := NewClient(upstream.URL).WithContext(r.GetContext()).GetResponse("/", &resp)
+		return nil, err
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/proxy", nil)
+	req.Header.Set(tracing.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r._int.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Regexp(t, "^00-4bf92f3577b34da6a3ce929d0e0e4736-[0-9a-f]{16}-01$", received)
+	assert.Regexp(t, "^00-4bf92f3577b34da6a3ce929d0e0e4736-[0-9a-f]{16}-01$", w.Header().Get(tracing.TraceparentHeader))
+	assert.NotEqual(t, w.Header().Get(tracing.TraceparentHeader), received)
+}
@@ -42,12 +42,12 @@ func GenerateCSRFToken(ctx context.Context) (string, error) {
 func ValidateCSRFToken(r Request) error {
 	storedRaw, err := r.GetContext().GetSessionValue(csrfTokenSessionKey)
 	if err != nil {
-		return NewError(http.StatusForbidden, fmt.Errorf("CSRF token not found in session"))
+		return NewCodedError(http.StatusForbidden, "csrf.missing", "CSRF token not found in session")
 	}
 
 	stored, ok := storedRaw.(string)
 	if !ok || stored == "" {
-		return NewError(http.StatusForbidden, fmt.Errorf("CSRF token not found in session"))
+		return NewCodedError(http.StatusForbidden, "csrf.missing", "CSRF token not found in session")
 	}
 
 	provided := r.GetHeader(CSRFTokenHeader)
@@ -56,7 +56,7 @@ func ValidateCSRFToken(r Request) error {
 	}
 
 	if subtle.ConstantTimeCompare([]byte(stored), []byte(provided)) != 1 {
-		return NewError(http.StatusForbidden, fmt.Errorf("CSRF token validation failed"))
+		return NewCodedError(http.StatusForbidden, "csrf.invalid", "CSRF token validation failed")
 	}
 
 	return nil
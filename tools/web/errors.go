@@ -1,23 +1,203 @@
 package web
 
 import (
+	"context"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
+const (
+	// ErrorFormatEnvelope sends errors in the HTTPResponse envelope
+	ErrorFormatEnvelope = "envelope"
+	// ErrorFormatProblem sends errors as RFC 7807 application/problem+json
+	ErrorFormatProblem = "problem"
+
+	// ErrCodeValidation is the code of the errors with field validation details
+	ErrCodeValidation = "validation_failed"
+
+	// statusClientClosedRequest is the non standard status logged when the client
+	// disconnects before the response is sent
+	statusClientClosedRequest = 499
+)
+
+var (
+	errRequestTimeout  = NewCodedError(http.StatusGatewayTimeout, "request_timeout", "request timed out")
+	errRequestCanceled = NewCodedError(statusClientClosedRequest, "request_canceled", "request canceled")
+	errInternal        = NewCodedError(http.StatusInternalServerError, "internal_error", "internal server error")
+)
+
 type httpError struct {
 	err     error
 	code    int
 	message string
+	// errCode is the stable machine readable code, e.g. auth.invalid_credentials
+	errCode    string
+	details    any
+	cause      error
+	retryAfter time.Duration
+}
+
+// Problem is the RFC 7807 error response sent in the problem error format
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	ID       string `json:"id"`
+	Code     string `json:"code"`
+	Details  any    `json:"details,omitempty"`
 }
 
 func (he httpError) Error() string {
 	return he.message
 }
 
+// Unwrap returns the underlying error and cause
+func (he httpError) Unwrap() []error {
+	errs := []error{}
+	for _, err := range []error{he.err, he.cause} {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
+
+// Is reports whether the target is a coded error with the same code,
+// so errors.Is matches copies made by WithCause, WithDetails and WithRetryAfter
+func (he httpError) Is(target error) bool {
+	t, ok := target.(*httpError)
+	return ok && t != nil && he.errCode != "" && he.errCode == t.errCode && he.code == t.code
+}
+
+// StatusCode returns the http status code of the error
+func (he httpError) StatusCode() int {
+	return he.code
+}
+
+// ErrorCode returns the machine readable code of the error, errors created
+// without a code get one derived from the status, e.g. not_found
+func (he httpError) ErrorCode() string {
+	if he.errCode != "" {
+		return he.errCode
+	}
+
+	return getStatusErrorCode(he.code)
+}
+
+// Details returns the error details sent to the client
+func (he httpError) Details() any {
+	if he.details == nil && he.retryAfter > 0 {
+		return map[string]any{"retry_after": int(he.retryAfter.Seconds())}
+	}
+
+	return he.details
+}
+
+// Cause returns the error logged with the response but not sent to the client
+func (he httpError) Cause() error {
+	return he.cause
+}
+
+// WithDetails returns a copy of the error with details sent to the client
+func (he *httpError) WithDetails(details any) *httpError {
+	e := *he
+	e.details = details
+	return &e
+}
+
+// WithCause returns a copy of the error with the cause that is logged
+// but never sent to the client
+func (he *httpError) WithCause(cause error) *httpError {
+	e := *he
+	e.cause = cause
+	return &e
+}
+
+// WithRetryAfter returns a copy of the error that sets the Retry-After header
+func (he *httpError) WithRetryAfter(d time.Duration) *httpError {
+	e := *he
+	e.retryAfter = d
+	return &e
+}
+
+// getStatusErrorCode returns the default error code for the status, e.g. bad_request
+func getStatusErrorCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "error"
+	}
+
+	return strings.ReplaceAll(strings.ToLower(text), " ", "_")
+}
+
+// NewError returns an error with the status code. The message of err is sent
+// to the client for client errors, server errors send a generic message and
+// err is only logged as their cause
 func NewError(code int, err error) *httpError {
-	return &httpError{code: code, err: err, message: err.Error()}
+	he := &httpError{code: code, err: err, message: err.Error()}
+	if code >= http.StatusInternalServerError {
+		he.message = errInternal.message
+		he.cause = err
+	}
+
+	fieldErrs := FieldErrors{}
+	if errors.As(err, &fieldErrs) {
+		he.errCode = ErrCodeValidation
+		he.details = fieldErrs
+	}
+
+	return he
+}
+
+// NewCodedError returns an error with a stable machine readable code
+// that clients can match on instead of the message
+func NewCodedError(status int, code, message string) *httpError {
+	return &httpError{
+		code:    status,
+		err:     errors.New(message),
+		message: message,
+		errCode: code,
+	}
+}
+
+// ErrorCode returns the machine readable code of the error, or an
+// empty string if err is not a web error
+func ErrorCode(err error) string {
+	var he *httpError
+	if errors.As(err, &he) {
+		return he.ErrorCode()
+	}
+
+	return ""
+}
+
+// getHTTPError converts the error to a web error. Other errors are sent with
+// the given client error status code, or as a generic internal error whose
+// cause is logged but never sent. Errors caused by the request context being
+// canceled or timing out are sent as such, even when wrapped.
+func getHTTPError(err error, statusCode int) *httpError {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return errRequestTimeout.WithCause(err)
+	} else if errors.Is(err, context.Canceled) {
+		return errRequestCanceled.WithCause(err)
+	}
+
+	var he *httpError
+	if errors.As(err, &he) {
+		return he
+	}
+
+	if statusCode < 400 || statusCode >= 500 {
+		return errInternal.WithCause(err)
+	}
+
+	return NewError(statusCode, err)
 }
 
 func BadRequest(err ...error) *httpError {
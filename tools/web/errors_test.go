@@ -2,8 +2,10 @@ package web
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -39,9 +41,19 @@ func TestInternalServerErrorDefault(t *testing.T) {
 }
 
 func TestInternalServerErrorWithError(t *testing.T) {
-	e := InternalServerError(errors.New("db failure"))
+	cause := errors.New("db failure")
+	e := InternalServerError(cause)
 	assert.Equal(t, http.StatusInternalServerError, e.code)
-	assert.Equal(t, "db failure", e.Error())
+	assert.Equal(t, "internal server error", e.Error(), "the cause is not sent to the client")
+	assert.Equal(t, cause, e.Cause())
+	assert.True(t, errors.Is(e, cause))
+}
+
+func TestNewServerErrorHidesMessage(t *testing.T) {
+	e := NewError(http.StatusBadGateway, errors.New("dial tcp 10.0.0.5:5432: connection refused"))
+	assert.Equal(t, "internal server error", e.Error())
+	assert.Equal(t, "bad_gateway", e.ErrorCode())
+	assert.EqualError(t, e.Cause(), "dial tcp 10.0.0.5:5432: connection refused")
 }
 
 func TestInternalServerErrorWithNilError(t *testing.T) {
@@ -65,3 +77,39 @@ func TestNotFoundWithNilError(t *testing.T) {
 	e := NotFound(nil)
 	assert.Equal(t, http.StatusNotFound, e.code)
 }
+
+func TestNewCodedError(t *testing.T) {
+	e := NewCodedError(http.StatusUnauthorized, "auth.invalid_credentials", "invalid email or password")
+	assert.Equal(t, http.StatusUnauthorized, e.StatusCode())
+	assert.Equal(t, "auth.invalid_credentials", e.ErrorCode())
+	assert.Equal(t, "invalid email or password", e.Error())
+}
+
+func TestErrorCodeDefaultsToStatus(t *testing.T) {
+	assert.Equal(t, "not_found", NotFound().ErrorCode())
+	assert.Equal(t, "internal_server_error", ErrorCode(InternalServerError()))
+	assert.Equal(t, "", ErrorCode(errors.New("plain")))
+}
+
+func TestCodedErrorCopies(t *testing.T) {
+	base := NewCodedError(http.StatusTooManyRequests, "auth.rate_limited", "too many requests")
+	cause := errors.New("redis: counter 6 > 5")
+
+	e := base.WithCause(cause).WithRetryAfter(30 * time.Second)
+	assert.Nil(t, base.Cause())
+	assert.Equal(t, cause, e.Cause())
+	assert.Equal(t, "too many requests", e.Error())
+	assert.Equal(t, map[string]any{"retry_after": 30}, e.Details())
+
+	assert.True(t, errors.Is(e, base))
+	assert.True(t, errors.Is(e, cause))
+	assert.True(t, errors.Is(fmt.Errorf("login: %w", e), base))
+	assert.False(t, errors.Is(e, NewCodedError(http.StatusTooManyRequests, "other", "too many requests")))
+	assert.Equal(t, "auth.rate_limited", ErrorCode(fmt.Errorf("login: %w", e)))
+}
+
+func TestFieldErrorsAreDetails(t *testing.T) {
+	e := NewError(http.StatusBadRequest, FieldErrors{"name": "name is required"})
+	assert.Equal(t, ErrCodeValidation, e.ErrorCode())
+	assert.Equal(t, FieldErrors{"name": "name is required"}, e.Details())
+}
@@ -0,0 +1,74 @@
+package web
+
+import (
+	"net/http"
+	"strings"
+)
+
+// newGroup creates a group on the root router with the given prefix and middlewares
+func newGroup(root *router, prefix string, middlewares []Middleware) *group {
+	return &group{
+		root:        root,
+		prefix:      prefix,
+		middlewares: middlewares,
+	}
+}
+
+// Group returns a nested sub-router, its middlewares run after the parent group middlewares
+func (g *group) Group(prefix string, middlewares ...Middleware) Router {
+	prefix = strings.TrimRight(prefix, "/")
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+
+	mws := make([]Middleware, 0, len(g.middlewares)+len(middlewares))
+	mws = append(mws, g.middlewares...)
+	mws = append(mws, middlewares...)
+
+	return newGroup(g.root, g.prefix+prefix, mws)
+}
+
+// Use attaches middlewares to the routes registered on the group after this call
+func (g *group) Use(middlewares ...Middleware) {
+	g.middlewares = append(g.middlewares, middlewares...)
+}
+
+// UseFor set middlewares for a path prefix relative to the group prefix
+func (g *group) UseFor(pathPrefix string, middlewares ...Middleware) {
+	g.root.UseFor(joinPath(g.prefix, pathPrefix), middlewares...)
+}
+
+// GET attaches route with given path and handlers (Doc, ...Middleware, Handler)
+func (g *group) GET(path string, handlers ...any) {
+	g.root.handle(http.MethodGet, joinPath(g.prefix, path), g.middlewares, handlers)
+}
+
+// POST attaches route with given path and handlers (Doc, ...Middleware, Handler)
+func (g *group) POST(path string, handlers ...any) {
+	g.root.handle(http.MethodPost, joinPath(g.prefix, path), g.middlewares, handlers)
+}
+
+// PUT attaches route with given path and handlers (Doc, ...Middleware, Handler)
+func (g *group) PUT(path string, handlers ...any) {
+	g.root.handle(http.MethodPut, joinPath(g.prefix, path), g.middlewares, handlers)
+}
+
+// PATCH attaches route with given path and handlers (Doc, ...Middleware, Handler)
+func (g *group) PATCH(path string, handlers ...any) {
+	g.root.handle(http.MethodPatch, joinPath(g.prefix, path), g.middlewares, handlers)
+}
+
+// DELETE attaches route with given path and handlers (Doc, ...Middleware, Handler)
+func (g *group) DELETE(path string, handlers ...any) {
+	g.root.handle(http.MethodDelete, joinPath(g.prefix, path), g.middlewares, handlers)
+}
+
+// ServeFiles attaches path to root dir and serve static files
+func (g *group) ServeFiles(path string, root http.FileSystem) {
+	g.root.ServeFiles(joinPath(g.prefix, path), root)
+}
+
+// Handle attaches a plain http.Handler on the path relative to the group prefix
+func (g *group) Handle(method, path string, handler http.Handler) {
+	g.root.Handle(method, joinPath(g.prefix, path), handler)
+}
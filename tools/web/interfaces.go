@@ -8,24 +8,31 @@ import (
 
 // Router interface implementing general router
 type Router interface {
-	// GET params: path, ...web.Middleware, web.Handler
+	// GET params: path, web.Doc (optional), ...web.Middleware, web.Handler
 	GET(string, ...any)
-	// POST params: path, ...web.Middleware, web.Handler
+	// POST params: path, web.Doc (optional), ...web.Middleware, web.Handler
 	POST(string, ...any)
-	// PUT params: path, ...web.Middleware, web.Handler
+	// PUT params: path, web.Doc (optional), ...web.Middleware, web.Handler
 	PUT(string, ...any)
-	// PATCH params: path, ...web.Middleware, web.Handler
+	// PATCH params: path, web.Doc (optional), ...web.Middleware, web.Handler
 	PATCH(string, ...any)
-	// DELETE params: path, ...web.Middleware, web.Handler
+	// DELETE params: path, web.Doc (optional), ...web.Middleware, web.Handler
 	DELETE(string, ...any)
 	// ServeFiles attaches path to root dir and serve static files
 	ServeFiles(path string, root http.FileSystem)
+	// Handle attaches a plain http.Handler, its response is not wrapped in
+	// HTTPResponse and the middlewares do not run
+	Handle(method, path string, handler http.Handler)
 	// Use attaches middlewares to all routes
 	Use(...Middleware)
 	// UseFor set middlewares for a specific path prefix
 	// This allows you to apply middlewares only to routes that start with the given path prefix.
 	// it does not support glob patterns, so you need to specify the exact prefix.
 	UseFor(pathPrefix string, middlewares ...Middleware)
+	// Group returns a sub-router for routes under the given prefix.
+	// Groups can be nested, middlewares run in order: router, parent group,
+	// child group and then the route middlewares.
+	Group(prefix string, middlewares ...Middleware) Router
 }
 
 // Request interface implementing general server request
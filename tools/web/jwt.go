@@ -4,11 +4,14 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// CreateJWT signs the claims with HS256 and the shared secret, see KeySet for
+// asymmetric keys
 func CreateJWT(secretKey string, claims jwt.Claims) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString([]byte(secretKey))
 }
 
+// ParseJWT parses a token signed with HS256 and the shared secret
 func ParseJWT(secretKey string, tokenString string, opts ...jwt.ParserOption) (*jwt.Token, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (any, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -0,0 +1,412 @@
+package web
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Algorithms of the JWT signing keys, derived from the key type
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+	AlgES384 = "ES384"
+	AlgES512 = "ES512"
+	AlgEdDSA = "EdDSA"
+)
+
+// ErrUnknownKey is returned when parsing a token signed with a key missing from the key set
+var ErrUnknownKey = errors.New("token is signed with an unknown key")
+
+type (
+	// Key signs or verifies JWT tokens
+	Key struct {
+		// ID is sent in the kid header of the tokens signed with the key
+		ID string
+		// Algorithm is the alg of the tokens signed with the key
+		Algorithm string
+		// signer is nil for keys only verifying tokens
+		signer any
+		// verifier is the public key, or the secret of HMAC keys
+		verifier any
+		// retiresAt is when the key stops verifying tokens, zero for never
+		retiresAt time.Time
+	}
+
+	// KeySet signs JWT tokens with its current key and verifies them with the
+	// key of their kid header, so keys can be rotated while the tokens signed
+	// with the previous keys are still valid
+	KeySet struct {
+		mut     sync.RWMutex
+		current *Key
+		keys    []*Key
+	}
+
+	// JWK is a public key in the JSON Web Key format, RFC 7517
+	JWK struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid,omitempty"`
+		Use string `json:"use,omitempty"`
+		Alg string `json:"alg,omitempty"`
+		// RSA keys
+		N string `json:"n,omitempty"`
+		E string `json:"e,omitempty"`
+		// EC and OKP keys
+		Crv string `json:"crv,omitempty"`
+		X   string `json:"x,omitempty"`
+		Y   string `json:"y,omitempty"`
+	}
+
+	// JWKS is the JSON Web Key Set served on /.well-known/jwks.json
+	JWKS struct {
+		Keys []JWK `json:"keys"`
+	}
+)
+
+// NewKey creates a key from an RSA, ECDSA or Ed25519 private key, which signs and
+// verifies tokens, from their public key, which only verifies tokens, or from an
+// HMAC secret ([]byte). An empty id is replaced by the RFC 7638 thumbprint of
+// the public key, HMAC keys keep the empty id
+func NewKey(id string, key any) (*Key, error) {
+	k := &Key{ID: id}
+
+	switch key := key.(type) {
+	case []byte:
+		if len(key) == 0 {
+			return nil, errors.New("hmac secret is empty")
+		}
+		k.Algorithm, k.signer, k.verifier = AlgHS256, key, key
+	case *rsa.PrivateKey:
+		k.Algorithm, k.signer, k.verifier = AlgRS256, key, &key.PublicKey
+	case *rsa.PublicKey:
+		k.Algorithm, k.verifier = AlgRS256, key
+	case *ecdsa.PrivateKey:
+		k.signer, k.verifier = key, &key.PublicKey
+	case *ecdsa.PublicKey:
+		k.verifier = key
+	case ed25519.PrivateKey:
+		k.Algorithm, k.signer, k.verifier = AlgEdDSA, key, key.Public()
+	case ed25519.PublicKey:
+		k.Algorithm, k.verifier = AlgEdDSA, key
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+
+	if pub, ok := k.verifier.(*ecdsa.PublicKey); ok {
+		switch pub.Curve {
+		case elliptic.P256():
+			k.Algorithm = AlgES256
+		case elliptic.P384():
+			k.Algorithm = AlgES384
+		case elliptic.P521():
+			k.Algorithm = AlgES512
+		default:
+			return nil, fmt.Errorf("unsupported ecdsa curve %s", pub.Curve.Params().Name)
+		}
+	}
+
+	if k.ID == "" && k.Algorithm != AlgHS256 {
+		thumbprint, err := k.thumbprint()
+		if err != nil {
+			return nil, err
+		}
+		k.ID = thumbprint
+	}
+
+	return k, nil
+}
+
+// ParseKeyPEM parses a PEM encoded private key (PKCS #8, PKCS #1 or SEC 1)
+// or public key (PKIX or PKCS #1), the result can be passed to NewKey
+func ParseKeyPEM(data []byte) (any, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+
+	return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+}
+
+// signingMethod returns the jwt signing method of the key algorithm
+func (k *Key) signingMethod() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+// JWK returns the public key in the JWK format, false for HMAC keys
+func (k *Key) JWK() (JWK, bool) {
+	jwk := JWK{Kid: k.ID, Use: "sig", Alg: k.Algorithm}
+
+	switch pub := k.verifier.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = encodeJWKInt(pub.N.Bytes())
+		jwk.E = encodeJWKInt(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = pub.Curve.Params().Name
+		jwk.X = encodeJWKInt(pub.X.FillBytes(make([]byte, size)))
+		jwk.Y = encodeJWKInt(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = encodeJWKInt(pub)
+	default:
+		return JWK{}, false
+	}
+
+	return jwk, true
+}
+
+// thumbprint returns the RFC 7638 thumbprint of the public key
+func (k *Key) thumbprint() (string, error) {
+	jwk, ok := k.JWK()
+	if !ok {
+		return "", errors.New("hmac keys have no thumbprint")
+	}
+
+	// the required members in lexicographic order
+	var members string
+	switch jwk.Kty {
+	case "RSA":
+		members = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, jwk.E, jwk.N)
+	case "EC":
+		members = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, jwk.Crv, jwk.X, jwk.Y)
+	case "OKP":
+		members = fmt.Sprintf(`{"crv":%q,"kty":"OKP","x":%q}`, jwk.Crv, jwk.X)
+	}
+
+	sum := sha256.Sum256([]byte(members))
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func encodeJWKInt(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeJWKInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
+
+// PublicKey returns the public key of the JWK
+func (jwk JWK) PublicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeJWKInt(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rsa modulus: %w", err)
+		}
+		e, err := decodeJWKInt(jwk.E)
+		if err != nil || !e.IsInt64() {
+			return nil, errors.New("invalid rsa exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := decodeJWKInt(jwk.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ec point: %w", err)
+		}
+		y, err := decodeJWKInt(jwk.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ec point: %w", err)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+}
+
+// NewKeySet creates a key set signing tokens with the signing key and verifying
+// them with it and the verification keys
+func NewKeySet(signing *Key, verification ...*Key) *KeySet {
+	ks := &KeySet{current: signing, keys: []*Key{signing}}
+	ks.keys = append(ks.keys, verification...)
+
+	return ks
+}
+
+// ParseJWKS creates a key set verifying the tokens signed with the keys of
+// a JWKS document, e.g. the one published by another service
+func ParseJWKS(data []byte) (*KeySet, error) {
+	doc := JWKS{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("could not decode jwks: %w", err)
+	}
+
+	ks := &KeySet{}
+	for _, jwk := range doc.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		pub, err := jwk.PublicKey()
+		if err != nil {
+			return nil, fmt.Errorf("could not parse key %q: %w", jwk.Kid, err)
+		}
+		key, err := NewKey(jwk.Kid, pub)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse key %q: %w", jwk.Kid, err)
+		}
+		if jwk.Alg != "" && jwk.Alg != key.Algorithm {
+			return nil, fmt.Errorf("key %q has algorithm %s, expected %s", jwk.Kid, jwk.Alg, key.Algorithm)
+		}
+		ks.keys = append(ks.keys, key)
+	}
+
+	return ks, nil
+}
+
+// Rotate makes the key the signing key, the previous signing key keeps
+// verifying tokens for retireAfter, which should be at least the validity of
+// the tokens it signed
+func (ks *KeySet) Rotate(key *Key, retireAfter time.Duration) {
+	ks.mut.Lock()
+	defer ks.mut.Unlock()
+
+	if ks.current != nil && ks.current != key {
+		ks.current.retiresAt = time.Now().Add(retireAfter)
+	}
+	ks.current = key
+	ks.keys = append([]*Key{key}, ks.pruneKeys()...)
+}
+
+// pruneKeys returns the keys that did not retire
+func (ks *KeySet) pruneKeys() []*Key {
+	keys := make([]*Key, 0, len(ks.keys))
+	for _, k := range ks.keys {
+		if k.retiresAt.IsZero() || time.Now().Before(k.retiresAt) {
+			keys = append(keys, k)
+		}
+	}
+
+	return keys
+}
+
+// getKey returns the key with the ID that did not retire
+func (ks *KeySet) getKey(id string) (*Key, bool) {
+	ks.mut.RLock()
+	defer ks.mut.RUnlock()
+
+	for _, k := range ks.keys {
+		if k.ID == id && (k.retiresAt.IsZero() || time.Now().Before(k.retiresAt)) {
+			return k, true
+		}
+	}
+
+	return nil, false
+}
+
+// Sign returns a token with the claims signed with the signing key
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	ks.mut.RLock()
+	key := ks.current
+	ks.mut.RUnlock()
+
+	if key == nil || key.signer == nil {
+		return "", errors.New("key set has no signing key")
+	}
+
+	token := jwt.NewWithClaims(key.signingMethod(), claims)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+
+	return token.SignedString(key.signer)
+}
+
+// Parse parses the token and verifies it with the key of its kid header,
+// tokens without kid are verified with the key without ID
+func (ks *KeySet) Parse(tokenString string, opts ...jwt.ParserOption) (*jwt.Token, error) {
+	return jwt.Parse(tokenString, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := ks.getKey(kid)
+		if !ok {
+			return nil, ErrUnknownKey
+		}
+		// the alg header must not pick another algorithm for the key
+		if token.Method.Alg() != key.Algorithm {
+			return nil, jwt.ErrSignatureInvalid
+		}
+
+		return key.verifier, nil
+	}, opts...)
+}
+
+// JWKS returns the public keys of the key set, HMAC keys are never published
+func (ks *KeySet) JWKS() JWKS {
+	ks.mut.RLock()
+	defer ks.mut.RUnlock()
+
+	doc := JWKS{Keys: []JWK{}}
+	for _, k := range ks.pruneKeys() {
+		if jwk, ok := k.JWK(); ok {
+			doc.Keys = append(doc.Keys, jwk)
+		}
+	}
+
+	return doc
+}
+
+// JWKSHandler serves the JWKS of the key set, to be attached with Router.Handle
+// on /.well-known/jwks.json
+func (ks *KeySet) JWKSHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		_ = json.NewEncoder(w).Encode(ks.JWKS())
+	})
+}
@@ -0,0 +1,158 @@
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestClaims() jwt.MapClaims {
+	return jwt.MapClaims{"sub": "42", "exp": time.Now().Add(time.Hour).Unix()}
+}
+
+func generateTestKeys(t *testing.T) map[string]any {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	return map[string]any{AlgRS256: rsaKey, AlgES256: ecKey, AlgEdDSA: edKey}
+}
+
+func TestKeySetSignAndParse(t *testing.T) {
+	for alg, private := range generateTestKeys(t) {
+		t.Run(alg, func(t *testing.T) {
+			key, err := NewKey("", private)
+			require.NoError(t, err)
+			assert.Equal(t, alg, key.Algorithm)
+			assert.NotEmpty(t, key.ID)
+
+			ks := NewKeySet(key)
+			token, err := ks.Sign(newTestClaims())
+			require.NoError(t, err)
+
+			parsed, err := ks.Parse(token)
+			require.NoError(t, err)
+			assert.Equal(t, key.ID, parsed.Header["kid"])
+			sub, _ := parsed.Claims.GetSubject()
+			assert.Equal(t, "42", sub)
+
+			// other services verify the tokens with the published keys
+			data, err := json.Marshal(ks.JWKS())
+			require.NoError(t, err)
+			remote, err := ParseJWKS(data)
+			require.NoError(t, err)
+			_, err = remote.Parse(token)
+			assert.NoError(t, err)
+
+			_, err = remote.Sign(newTestClaims())
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestKeySetRejectsOtherAlgorithms(t *testing.T) {
+	keys := generateTestKeys(t)
+	key, err := NewKey("", keys[AlgRS256])
+	require.NoError(t, err)
+	ks := NewKeySet(key)
+
+	// a token signed with the public key as HMAC secret must not verify
+	der, err := x509.MarshalPKIXPublicKey(key.verifier)
+	require.NoError(t, err)
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, newTestClaims())
+	forged.Header["kid"] = key.ID
+	token, err := forged.SignedString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	require.NoError(t, err)
+
+	_, err = ks.Parse(token)
+	assert.ErrorIs(t, err, jwt.ErrSignatureInvalid)
+
+	other, err := NewKey("", keys[AlgES256])
+	require.NoError(t, err)
+	token, err = NewKeySet(other).Sign(newTestClaims())
+	require.NoError(t, err)
+
+	_, err = ks.Parse(token)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestKeySetRotation(t *testing.T) {
+	keys := generateTestKeys(t)
+	oldKey, err := NewKey("old", keys[AlgRS256])
+	require.NoError(t, err)
+	newKey, err := NewKey("new", keys[AlgEdDSA])
+	require.NoError(t, err)
+
+	ks := NewKeySet(oldKey)
+	oldToken, err := ks.Sign(newTestClaims())
+	require.NoError(t, err)
+
+	ks.Rotate(newKey, time.Hour)
+	newToken, err := ks.Sign(newTestClaims())
+	require.NoError(t, err)
+
+	parsed, err := ks.Parse(newToken)
+	require.NoError(t, err)
+	assert.Equal(t, "new", parsed.Header["kid"])
+	_, err = ks.Parse(oldToken)
+	assert.NoError(t, err, "tokens of the previous key are valid until it retires")
+	assert.Len(t, ks.JWKS().Keys, 2)
+
+	// the old key retired
+	oldKey.retiresAt = time.Now()
+	_, err = ks.Parse(oldToken)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	assert.Len(t, ks.JWKS().Keys, 1)
+}
+
+func TestHMACKeysAreNotPublished(t *testing.T) {
+	key, err := NewKey("", []byte("secret"))
+	require.NoError(t, err)
+	ks := NewKeySet(key)
+
+	// tokens of CreateJWT have no kid and stay valid
+	token, err := CreateJWT("secret", newTestClaims())
+	require.NoError(t, err)
+	_, err = ks.Parse(token)
+	assert.NoError(t, err)
+
+	r := newTestRouter()
+	r.Handle(http.MethodGet, "/.well-known/jwks.json", ks.JWKSHandler())
+	w := httptest.NewRecorder()
+	r._int.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"keys":[]}`, w.Body.String())
+}
+
+func TestParseKeyPEM(t *testing.T) {
+	for alg, private := range generateTestKeys(t) {
+		der, err := x509.MarshalPKCS8PrivateKey(private)
+		require.NoError(t, err)
+
+		parsed, err := ParseKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+		require.NoError(t, err, alg)
+		key, err := NewKey("", parsed)
+		require.NoError(t, err, alg)
+		assert.Equal(t, alg, key.Algorithm)
+	}
+
+	_, err := ParseKeyPEM([]byte("not a key"))
+	assert.Error(t, err)
+}
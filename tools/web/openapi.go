@@ -0,0 +1,533 @@
+package web
+
+import (
+	"encoding"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const openAPIVersion = "3.1.0"
+
+// Doc describes a route in the OpenAPI document served on /_openapi.json.
+// It is passed along with the route handlers, before the handler
+//
+//	r.PUT("/user", web.Doc{Summary: "update user", Request: UpdateUserRequest{}, Auth: true}, mw, handler)
+type Doc struct {
+	Summary     string
+	Description string
+	Tags        []string
+	// Request is a value of the request type, fields tagged with path, query
+	// or header are documented as parameters and the rest as the JSON body
+	Request any
+	// Response is a value of the type returned as data in the response envelope
+	Response any
+	// Auth marks the route as requiring an authenticated user
+	Auth bool
+	// Errors are the error status codes the route responds with
+	Errors     []int
+	Deprecated bool
+	// Hidden leaves the route out of the document
+	Hidden bool
+}
+
+// route is a registered route with its documentation
+type route struct {
+	method string
+	path   string
+	doc    Doc
+}
+
+type (
+	openAPIDocument struct {
+		OpenAPI    string                           `json:"openapi"`
+		Info       openAPIInfo                      `json:"info"`
+		Paths      map[string]map[string]*operation `json:"paths"`
+		Components components                       `json:"components"`
+	}
+
+	openAPIInfo struct {
+		Title   string `json:"title"`
+		Version string `json:"version"`
+	}
+
+	operation struct {
+		OperationID string                `json:"operationId"`
+		Summary     string                `json:"summary,omitempty"`
+		Description string                `json:"description,omitempty"`
+		Tags        []string              `json:"tags,omitempty"`
+		Parameters  []parameter           `json:"parameters,omitempty"`
+		RequestBody *requestBody          `json:"requestBody,omitempty"`
+		Responses   map[string]*apiResult `json:"responses"`
+		Security    []map[string][]string `json:"security,omitempty"`
+		Deprecated  bool                  `json:"deprecated,omitempty"`
+	}
+
+	parameter struct {
+		Name     string  `json:"name"`
+		In       string  `json:"in"`
+		Required bool    `json:"required,omitempty"`
+		Schema   *schema `json:"schema"`
+	}
+
+	requestBody struct {
+		Required bool                 `json:"required,omitempty"`
+		Content  map[string]mediaType `json:"content"`
+	}
+
+	apiResult struct {
+		Ref         string               `json:"$ref,omitempty"`
+		Description string               `json:"description,omitempty"`
+		Content     map[string]mediaType `json:"content,omitempty"`
+	}
+
+	mediaType struct {
+		Schema *schema `json:"schema"`
+	}
+
+	components struct {
+		Schemas         map[string]*schema        `json:"schemas"`
+		Responses       map[string]*apiResult     `json:"responses"`
+		SecuritySchemes map[string]securityScheme `json:"securitySchemes"`
+	}
+
+	securityScheme struct {
+		Type         string `json:"type"`
+		Scheme       string `json:"scheme,omitempty"`
+		BearerFormat string `json:"bearerFormat,omitempty"`
+	}
+
+	schema struct {
+		Ref                  string             `json:"$ref,omitempty"`
+		Type                 any                `json:"type,omitempty"`
+		Format               string             `json:"format,omitempty"`
+		Description          string             `json:"description,omitempty"`
+		Properties           map[string]*schema `json:"properties,omitempty"`
+		Required             []string           `json:"required,omitempty"`
+		Items                *schema            `json:"items,omitempty"`
+		AdditionalProperties *schema            `json:"additionalProperties,omitempty"`
+		AllOf                []*schema          `json:"allOf,omitempty"`
+		Const                any                `json:"const,omitempty"`
+	}
+)
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	pathParamRegex    = regexp.MustCompile(`[:*]([^/]+)`)
+)
+
+// addRoute records the route for the OpenAPI document
+func (r *router) addRoute(method, path string, doc Doc) {
+	r.routesMutex.Lock()
+	defer r.routesMutex.Unlock()
+
+	r.routes = append(r.routes, route{method: method, path: path, doc: doc})
+}
+
+// openAPIHandler serves the OpenAPI document of the registered routes
+func (r *router) openAPIHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(r.getOpenAPIDocument())
+}
+
+// getOpenAPIDocument builds the OpenAPI document from the registered routes
+func (r *router) getOpenAPIDocument() openAPIDocument {
+	r.routesMutex.Lock()
+	routes := append([]route{}, r.routes...)
+	r.routesMutex.Unlock()
+
+	g := newSchemaGenerator()
+	doc := openAPIDocument{
+		OpenAPI: openAPIVersion,
+		Info:    r.apiInfo,
+		Paths:   map[string]map[string]*operation{},
+		Components: components{
+			Schemas: g.schemas,
+			Responses: map[string]*apiResult{
+				"Error": r.getErrorResponse(),
+			},
+			SecuritySchemes: map[string]securityScheme{
+				"bearerAuth": {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+			},
+		},
+	}
+
+	g.schemas["HTTPResponse"] = &schema{
+		Type: "object",
+		Properties: map[string]*schema{
+			"ok":    {Type: "boolean"},
+			"id":    {Type: "string", Description: "request id"},
+			"error": {Type: "string"},
+			"data":  {},
+			"code":  {Type: "string", Description: "machine readable error code"},
+			"details": {
+				Description: "error details, e.g. the validation error of each field",
+			},
+		},
+		Required: []string{"ok", "id"},
+	}
+	if r.problem {
+		g.getSchema(reflect.TypeOf(Problem{}))
+	}
+
+	for _, rt := range routes {
+		if rt.doc.Hidden {
+			continue
+		}
+
+		path := pathParamRegex.ReplaceAllString(rt.path, "{$1}")
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = map[string]*operation{}
+		}
+		doc.Paths[path][strings.ToLower(rt.method)] = g.getOperation(rt)
+	}
+
+	return doc
+}
+
+// getErrorResponse documents the error response in the error format of the router
+func (r *router) getErrorResponse() *apiResult {
+	if r.problem {
+		return &apiResult{
+			Description: "error response",
+			Content: map[string]mediaType{"application/problem+json": {
+				Schema: &schema{Ref: "#/components/schemas/Problem"},
+			}},
+		}
+	}
+
+	return &apiResult{
+		Description: "error response",
+		Content: map[string]mediaType{"application/json": {Schema: &schema{AllOf: []*schema{
+			{Ref: "#/components/schemas/HTTPResponse"},
+			{Properties: map[string]*schema{"ok": {Const: false}}, Required: []string{"code"}},
+		}}}},
+	}
+}
+
+// getOperationID returns an id for the route, e.g. GET /users/:id is getUsersId
+func getOperationID(method, path string) string {
+	id := strings.ToLower(method)
+	for _, part := range strings.FieldsFunc(path, func(c rune) bool {
+		return !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9')
+	}) {
+		id += strings.ToUpper(part[:1]) + part[1:]
+	}
+
+	return id
+}
+
+// getOperation documents the route
+func (g *schemaGenerator) getOperation(rt route) *operation {
+	op := &operation{
+		OperationID: getOperationID(rt.method, rt.path),
+		Summary:     rt.doc.Summary,
+		Description: rt.doc.Description,
+		Tags:        rt.doc.Tags,
+		Deprecated:  rt.doc.Deprecated,
+		Responses:   map[string]*apiResult{},
+	}
+
+	op.Parameters = g.getParameters(rt.path, rt.doc.Request)
+	if rt.doc.Request != nil && rt.method != http.MethodGet && rt.method != http.MethodDelete {
+		if body := g.getBodySchema(reflect.TypeOf(rt.doc.Request)); body != nil {
+			op.RequestBody = &requestBody{
+				Required: true,
+				Content:  map[string]mediaType{"application/json": {Schema: body}},
+			}
+		}
+	}
+
+	success := &schema{Ref: "#/components/schemas/HTTPResponse"}
+	if rt.doc.Response != nil {
+		success = &schema{AllOf: []*schema{
+			success,
+			{Properties: map[string]*schema{"data": g.getSchema(reflect.TypeOf(rt.doc.Response))}},
+		}}
+	}
+
+	// status codes match the ones set by sendResponse
+	status := http.StatusOK
+	if rt.method == http.MethodPost {
+		status = http.StatusCreated
+	}
+	op.Responses[strconv.Itoa(status)] = &apiResult{
+		Description: http.StatusText(status),
+		Content:     map[string]mediaType{"application/json": {Schema: success}},
+	}
+
+	errs := append([]int{}, rt.doc.Errors...)
+	if rt.doc.Request != nil {
+		errs = append(errs, http.StatusBadRequest)
+	}
+	if rt.doc.Auth {
+		errs = append(errs, http.StatusUnauthorized)
+		op.Security = []map[string][]string{{"bearerAuth": {}}}
+	}
+	for _, code := range errs {
+		op.Responses[strconv.Itoa(code)] = &apiResult{Ref: "#/components/responses/Error"}
+	}
+	op.Responses["default"] = &apiResult{Ref: "#/components/responses/Error"}
+
+	return op
+}
+
+// getParameters documents the route params of the path and the path, query
+// and header fields of the request type
+func (g *schemaGenerator) getParameters(path string, req any) []parameter {
+	params := []parameter{}
+	documented := map[string]bool{}
+
+	if req != nil {
+		t := reflect.TypeOf(req)
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() == reflect.Struct {
+			params = g.getStructParameters(t, params)
+		}
+	}
+
+	for _, p := range params {
+		if p.In == "path" {
+			documented[p.Name] = true
+		}
+	}
+
+	pathParams := []parameter{}
+	for _, match := range pathParamRegex.FindAllStringSubmatch(path, -1) {
+		if !documented[match[1]] {
+			pathParams = append(pathParams, parameter{
+				Name:     match[1],
+				In:       "path",
+				Required: true,
+				Schema:   &schema{Type: "string"},
+			})
+		}
+	}
+
+	return append(pathParams, params...)
+}
+
+// getStructParameters documents the fields tagged with path, query or header
+func (g *schemaGenerator) getStructParameters(t reflect.Type, params []parameter) []parameter {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			params = g.getStructParameters(field.Type, params)
+			continue
+		}
+
+		for _, in := range []string{"path", "query", "header"} {
+			name := field.Tag.Get(in)
+			if name == "" {
+				continue
+			}
+
+			s := g.getSchema(field.Type)
+			if in != "query" && s.Type == "array" {
+				s = s.Items
+			}
+			params = append(params, parameter{
+				Name:     name,
+				In:       in,
+				Required: in == "path" || isRequiredField(field),
+				Schema:   s,
+			})
+			break
+		}
+	}
+
+	return params
+}
+
+// getBodySchema returns the schema of the request body, nil if the request
+// type only has params
+func (g *schemaGenerator) getBodySchema(t reflect.Type) *schema {
+	s := g.getSchema(t)
+	if s.Ref == "" {
+		return s
+	}
+
+	if len(g.schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")].Properties) == 0 {
+		return nil
+	}
+
+	return s
+}
+
+// isParamField reports whether the field is bound from the route params,
+// url query or headers instead of the body
+func isParamField(field reflect.StructField) bool {
+	return field.Tag.Get("path") != "" || field.Tag.Get("query") != "" || field.Tag.Get("header") != ""
+}
+
+// isRequiredField reports whether the field has the govalidator required rule
+func isRequiredField(field reflect.StructField) bool {
+	for _, rule := range strings.Split(field.Tag.Get("valid"), ",") {
+		if strings.Split(rule, "~")[0] == "required" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// schemaGenerator builds JSON schemas for go types, named struct types are
+// added to the document components and referenced
+type schemaGenerator struct {
+	schemas map[string]*schema
+	names   map[reflect.Type]string
+}
+
+func newSchemaGenerator() *schemaGenerator {
+	return &schemaGenerator{
+		schemas: map[string]*schema{},
+		names:   map[reflect.Type]string{},
+	}
+}
+
+// getSchemaName returns a unique component name for the type
+func (g *schemaGenerator) getSchemaName(t reflect.Type) string {
+	clean := func(s string) string {
+		return strings.Map(func(c rune) rune {
+			if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.' {
+				return c
+			}
+			return '_'
+		}, s)
+	}
+
+	name := clean(t.Name())
+	if _, taken := g.schemas[name]; !taken {
+		return name
+	}
+
+	pkg := t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]
+	name = clean(pkg + "." + t.Name())
+	for i := 2; ; i++ {
+		if _, taken := g.schemas[name]; !taken {
+			return name
+		}
+		name = clean(pkg+"."+t.Name()) + strconv.Itoa(i)
+	}
+}
+
+// getSchema returns the JSON schema of the type as encoded by encoding/json
+func (g *schemaGenerator) getSchema(t reflect.Type) *schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return &schema{Type: "string", Format: "date-time"}
+	case t == durationType:
+		return &schema{Type: "integer", Format: "int64", Description: "duration in nanoseconds"}
+	case t.Kind() == reflect.Struct && (t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType)):
+		// custom encoding, the shape is unknown
+		return &schema{}
+	case t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType):
+		return &schema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &schema{Type: "string"}
+	case reflect.Bool:
+		return &schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &schema{Type: "string", Format: "byte"}
+		}
+		return &schema{Type: "array", Items: g.getSchema(t.Elem())}
+	case reflect.Map:
+		return &schema{Type: "object", AdditionalProperties: g.getSchema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.getStructSchema(t)
+		}
+
+		name, ok := g.names[t]
+		if !ok {
+			name = g.getSchemaName(t)
+			g.names[t] = name
+			// reserve the name before generating to support recursive types
+			g.schemas[name] = &schema{}
+			*g.schemas[name] = *g.getStructSchema(t)
+		}
+		return &schema{Ref: "#/components/schemas/" + name}
+	default:
+		return &schema{}
+	}
+}
+
+// getStructSchema returns the object schema with the JSON fields of the struct
+func (g *schemaGenerator) getStructSchema(t reflect.Type) *schema {
+	s := &schema{Type: "object", Properties: map[string]*schema{}}
+	g.addStructProperties(t, s)
+	if len(s.Properties) == 0 {
+		s.Properties = nil
+	}
+
+	return s
+}
+
+// addStructProperties adds the JSON fields of the struct to the schema,
+// embedded structs are flattened like encoding/json does
+func (g *schemaGenerator) addStructProperties(t reflect.Type, s *schema) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || isParamField(field) {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+		ft := field.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+
+		if field.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			g.addStructProperties(ft, s)
+			continue
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		fs := g.getSchema(field.Type)
+		if strings.Contains(opts, "string") && fs.Ref == "" {
+			fs = &schema{Type: "string"}
+		}
+		s.Properties[name] = fs
+
+		if isRequiredField(field) {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
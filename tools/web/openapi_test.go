@@ -0,0 +1,126 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type openAPITestRequest struct {
+	ID     uint     `path:"id" json:"-"`
+	Page   int      `query:"page" json:"-"`
+	Tags   []string `query:"tag" json:"-"`
+	Tenant string   `header:"X-Tenant" valid:"required"`
+	Name   string   `json:"name" valid:"required~name is required"`
+	Email  string   `json:"email,omitempty"`
+}
+
+type openAPITestNode struct {
+	Name      string             `json:"name"`
+	CreatedAt time.Time          `json:"created_at"`
+	Children  []*openAPITestNode `json:"children"`
+}
+
+func getTestOpenAPIDocument(t *testing.T, register func(r *router)) map[string]any {
+	t.Helper()
+
+	r := newRouter(Options{Logger: zap.NewNop(), EnableOpenAPI: true, APITitle: "test", APIVersion: "1.2.3"})
+	register(r)
+
+	w := serve(r, http.MethodGet, "/_openapi.json")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	doc := map[string]any{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+	return doc
+}
+
+// get returns the value at the path of keys in the decoded document
+func get(t *testing.T, v any, keys ...string) any {
+	t.Helper()
+	for _, key := range keys {
+		m, ok := v.(map[string]any)
+		require.True(t, ok, "expected an object at %q", key)
+		v, ok = m[key]
+		require.True(t, ok, "missing key %q", key)
+	}
+	return v
+}
+
+func TestOpenAPIDocumentsRoutes(t *testing.T) {
+	doc := getTestOpenAPIDocument(t, func(r *router) {
+		g := r.Group("/api")
+		g.PUT("/users/:id", Doc{
+			Summary:  "update user",
+			Tags:     []string{"users"},
+			Request:  openAPITestRequest{},
+			Response: openAPITestNode{},
+			Auth:     true,
+			Errors:   []int{http.StatusNotFound},
+		}, okHandler)
+		g.POST("/nodes", okHandler)
+		g.GET("/hidden", Doc{Hidden: true}, okHandler)
+	})
+
+	assert.Equal(t, "3.1.0", doc["openapi"])
+	assert.Equal(t, "test", get(t, doc, "info", "title"))
+	assert.Equal(t, "1.2.3", get(t, doc, "info", "version"))
+
+	paths := get(t, doc, "paths").(map[string]any)
+	assert.Contains(t, paths, "/api/users/{id}")
+	assert.Contains(t, paths, "/api/nodes")
+	assert.NotContains(t, paths, "/api/hidden")
+
+	op := get(t, paths, "/api/users/{id}", "put")
+	assert.Equal(t, "putApiUsersId", get(t, op, "operationId"))
+	assert.Equal(t, "update user", get(t, op, "summary"))
+	assert.Equal(t, []any{map[string]any{"bearerAuth": []any{}}}, get(t, op, "security"))
+
+	params := get(t, op, "parameters").([]any)
+	require.Len(t, params, 4)
+	assert.Equal(t, map[string]any{"name": "id", "in": "path", "required": true, "schema": map[string]any{"type": "integer"}}, params[0])
+	assert.Equal(t, map[string]any{"name": "page", "in": "query", "schema": map[string]any{"type": "integer"}}, params[1])
+	assert.Equal(t, "array", get(t, params[2], "schema", "type"))
+	assert.Equal(t, map[string]any{"name": "X-Tenant", "in": "header", "required": true, "schema": map[string]any{"type": "string"}}, params[3])
+
+	bodyRef := get(t, op, "requestBody", "content", "application/json", "schema", "$ref")
+	assert.Equal(t, "#/components/schemas/openAPITestRequest", bodyRef)
+
+	body := get(t, doc, "components", "schemas", "openAPITestRequest")
+	assert.Equal(t, []any{"name"}, get(t, body, "required"))
+	assert.Len(t, get(t, body, "properties"), 2)
+
+	responses := get(t, op, "responses").(map[string]any)
+	for _, code := range []string{"200", "400", "401", "404", "default"} {
+		assert.Contains(t, responses, code)
+	}
+
+	envelope := get(t, responses, "200", "content", "application/json", "schema", "allOf").([]any)
+	require.Len(t, envelope, 2)
+	assert.Equal(t, "#/components/schemas/HTTPResponse", get(t, envelope[0], "$ref"))
+	assert.Equal(t, "#/components/schemas/openAPITestNode", get(t, envelope[1], "properties", "data", "$ref"))
+
+	node := get(t, doc, "components", "schemas", "openAPITestNode")
+	assert.Equal(t, "date-time", get(t, node, "properties", "created_at", "format"))
+	assert.Equal(t, "#/components/schemas/openAPITestNode", get(t, node, "properties", "children", "items", "$ref"))
+
+	// undocumented routes get the plain envelope and POST responds with 201
+	created := get(t, paths, "/api/nodes", "post", "responses", "201", "content", "application/json", "schema")
+	assert.Equal(t, map[string]any{"$ref": "#/components/schemas/HTTPResponse"}, created)
+}
+
+func TestOpenAPIDisabled(t *testing.T) {
+	r := newRouter(Options{Logger: zap.NewNop()})
+	assert.Equal(t, http.StatusNotFound, serve(r, http.MethodGet, "/_openapi.json").Code)
+}
+
+func TestDocIsNotAMiddleware(t *testing.T) {
+	r := newTestRouter()
+	r.GET("/doc", Doc{Summary: "documented"}, okHandler)
+	assert.Equal(t, http.StatusOK, serve(r, http.MethodGet, "/doc").Code)
+}
@@ -1,11 +1,13 @@
 package web
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"math"
+	"net"
 	"net/http"
 	"strings"
 	"time"
@@ -32,18 +34,43 @@ type request struct {
 	ctx         localcontext.Context
 }
 
+// RequestInfo identifies the request of a context and its client, e.g. for audit trails
+type RequestInfo struct {
+	// ID is the id of the request, sent in the responses
+	ID        string
+	IP        string
+	UserAgent string
+}
+
+// requestInfoKey is the context key of the RequestInfo of the request
+type requestInfoKey struct{}
+
 // newRequest creates a new request object with the given http request and httprouter params
 func (r *router) newRequest(req *http.Request, p httprouter.Params) *request {
 	reqID := uuid.Must(uuid.NewV4()).String()
 	l := r.l.With(zap.String("id", reqID))
 
-	return &request{
+	newReq := &request{
 		_int:        req,
 		routeParams: &p,
 		id:          reqID,
 		body:        reqBody{},
-		ctx:         localcontext.NewContext(l),
+		ctx:         localcontext.NewContextFrom(req.Context(), l),
 	}
+	_ = newReq.ctx.WithValue(requestInfoKey{}, RequestInfo{
+		ID:        reqID,
+		IP:        r.getClientIP(newReq),
+		UserAgent: req.UserAgent(),
+	})
+
+	return newReq
+}
+
+// GetRequestInfo returns the RequestInfo of the request of the context, false
+// for contexts that are not request contexts
+func GetRequestInfo(ctx context.Context) (RequestInfo, bool) {
+	info, ok := ctx.Value(requestInfoKey{}).(RequestInfo)
+	return info, ok
 }
 
 // timeElapsed returns the time elapsed since the request was created and the time in milliseconds
@@ -87,17 +114,27 @@ func (r *request) GetPath() string {
 	return r._int.URL.Path
 }
 
-// GetValidatedBody validates the body and updates the ptr reference, errors if any issues
-func (r *request) GetValidatedBody(ptr any) (err error) {
-	data := r.body.raw
-	if !r.body.read {
-		if data, err = io.ReadAll(r._int.Body); err != nil {
-			return NewError(http.StatusBadRequest, fmt.Errorf("failed to read request body: %w", err))
+// readBody reads the request body once and returns the cached raw body afterwards
+func (r *request) readBody() ([]byte, error) {
+	if !r.body.read && r._int.Body != nil {
+		data, err := io.ReadAll(r._int.Body)
+		if err != nil {
+			return nil, err
 		}
 		r.body.read = true
 		r.body.raw = data
 	}
 
+	return r.body.raw, nil
+}
+
+// GetValidatedBody validates the body and updates the ptr reference, errors if any issues
+func (r *request) GetValidatedBody(ptr any) (err error) {
+	data, err := r.readBody()
+	if err != nil {
+		return NewError(http.StatusBadRequest, fmt.Errorf("failed to read request body: %w", err))
+	}
+
 	if len(data) < 1 {
 		return nil
 	}
@@ -140,6 +177,88 @@ func (r *request) GetRemoteAddr() string {
 	return addr
 }
 
+// GetClientIP returns the IP of the client of the request, the forwarding
+// headers are only trusted when the request comes from Options.TrustedProxies.
+// Requests the router did not create get their remote address
+func GetClientIP(r Request) string {
+	if info, ok := GetRequestInfo(r.GetContext()); ok {
+		return info.IP
+	}
+	return r.GetRemoteAddr()
+}
+
+// getClientIP returns the IP of the client of the request, the forwarding
+// headers of the trusted proxies are read right to left, the first address
+// that is not a trusted proxy is the client
+func (r *router) getClientIP(req *request) string {
+	addr := req.GetRemoteAddr()
+	if !r.isTrustedProxy(addr) {
+		return addr
+	}
+
+	if fwd := req.GetHeader("X-Forwarded-For"); fwd != "" {
+		hops := strings.Split(fwd, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr = strings.TrimSpace(hops[i])
+			if !r.isTrustedProxy(addr) {
+				break
+			}
+		}
+		return addr
+	}
+	if ip := req.GetHeader("X-Real-IP"); ip != "" {
+		return strings.TrimSpace(ip)
+	}
+
+	return addr
+}
+
+// isTrustedProxy reports whether the address is one of the trusted proxies
+func (r *router) isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(strings.Trim(addr, "[]"))
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range r.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxies parses the IPs and CIDR ranges of the trusted proxies
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
 func (r *request) GetInternalRequest() *http.Request {
 	return r._int
 }
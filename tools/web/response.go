@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -16,16 +17,24 @@ type HTTPResponse struct {
 	ID    string      `json:"id"`
 	Error string      `json:"error,omitempty"`
 	Data  interface{} `json:"data,omitempty"`
+	// Code is the machine readable error code, e.g. auth.invalid_credentials
+	Code    string `json:"code,omitempty"`
+	Details any    `json:"details,omitempty"`
 }
 
 // response struct for handlers to set response
 type response struct {
 	respWriter http.ResponseWriter
 	request    *request
+	// problem sends errors as application/problem+json instead of the envelope
+	problem bool
+	// status is the status code sent, zero until the response is sent
+	status int
 }
 
-func newResponse(w http.ResponseWriter, r *request) *response {
-	return &response{respWriter: w, request: r}
+// newResponse creates the response for the request in the error format of the router
+func (r *router) newResponse(w http.ResponseWriter, req *request) *response {
+	return &response{respWriter: w, request: req, problem: r.problem}
 }
 
 // NotImplemented to be developed function handler
@@ -123,6 +132,11 @@ func logResponse(req *request, statusCode int, respBody *bytes.Buffer, respErr e
 		fields = append(fields, zap.Error(respErr))
 	}
 
+	var webError *httpError
+	if errors.As(respErr, &webError) && webError.cause != nil {
+		fields = append(fields, zap.NamedError("cause", webError.cause))
+	}
+
 	req.ctx.Logger().With(fields...).Debug(msg)
 }
 
@@ -141,46 +155,59 @@ func setDefaultResponseHeaders(h http.Header) {
 // sendResponse function to send response to http requests
 func sendResponse(resp *response, data interface{}, respErr error, statusCode int) {
 	base := HTTPResponse{
-		Ok:    true,
-		ID:    resp.request.id,
-		Error: InternalServerError().Error(),
+		Ok: true,
+		ID: resp.request.id,
 	}
 
-	webError := &httpError{}
 	setDefaultResponseHeaders(resp.respWriter.Header())
+	var body any = &base
 	if respErr == nil {
 		statusCode = 200
 		if resp.request.GetMethod() == http.MethodPost {
 			statusCode = 201
 		}
-		base.Error = ""
 		base.Data = data
-	} else if e, ok := respErr.(*httpError); ok {
-		base.Ok = false
-		statusCode = e.code
-		base.Error = e.message
-		base.Data = nil
-	} else if errors.As(respErr, webError) {
-		base.Ok = false
-		statusCode = webError.code
-		base.Error = webError.message
-		base.Data = nil
 	} else {
-		base.Ok = false
-		base.Error = respErr.Error()
+		webError := getHTTPError(respErr, statusCode)
+		statusCode = webError.code
+		if webError.retryAfter > 0 {
+			resp.respWriter.Header().Set("Retry-After", strconv.Itoa(int(webError.retryAfter.Seconds())))
+		}
+
+		if resp.problem {
+			resp.respWriter.Header().Set("Content-Type", "application/problem+json")
+			body = &Problem{
+				Type:     "about:blank",
+				Title:    http.StatusText(statusCode),
+				Status:   statusCode,
+				Detail:   webError.message,
+				Instance: resp.request.GetPath(),
+				ID:       resp.request.id,
+				Code:     webError.ErrorCode(),
+				Details:  webError.Details(),
+			}
+		} else {
+			base.Ok = false
+			base.Error = webError.message
+			base.Code = webError.ErrorCode()
+			base.Details = webError.Details()
+		}
 	}
 
-	resp.respWriter.WriteHeader(statusCode)
-	body := new(bytes.Buffer)
-	err := json.NewEncoder(body).Encode(base)
+	buf := new(bytes.Buffer)
+	err := json.NewEncoder(buf).Encode(body)
 	if err != nil {
-		resp.respWriter.WriteHeader(500)
-		base.Ok = false
-		base.Error = "error while parsing response body"
-		base.Data = nil
-		_ = json.NewEncoder(body).Encode(base)
+		statusCode = 500
+		buf.Reset()
+		_ = json.NewEncoder(buf).Encode(HTTPResponse{
+			ID:    resp.request.id,
+			Error: "error while parsing response body",
+			Code:  getStatusErrorCode(statusCode),
+		})
 	}
 
-	fmt.Fprint(resp.respWriter, body)
-	logResponse(resp.request, statusCode, body, respErr)
+	resp.status = statusCode
+	resp.respWriter.WriteHeader(statusCode)
+	fmt.Fprint(resp.respWriter, buf)
+	logResponse(resp.request, statusCode, buf, respErr)
 }
@@ -1,10 +1,14 @@
 package web
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
 )
 
 func TestIsSensitiveKey(t *testing.T) {
@@ -95,3 +99,75 @@ func TestUnAuthorized(t *testing.T) {
 	assert.True(t, ok)
 	assert.Equal(t, http.StatusUnauthorized, e.code)
 }
+
+var errTestCoded = NewCodedError(http.StatusConflict, "users.email_taken", "email is already registered")
+
+func failingHandler(r Request) (any, error) {
+	return nil, errTestCoded.
+		WithCause(errors.New("pq: duplicate key value violates unique constraint")).
+		WithDetails(map[string]string{"field": "email"})
+}
+
+func TestSendResponseCodedError(t *testing.T) {
+	r := newTestRouter()
+	r.POST("/users", failingHandler)
+
+	w := serve(r, http.MethodPost, "/users")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.NotContains(t, w.Body.String(), "duplicate key")
+
+	resp := HTTPResponse{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.False(t, resp.Ok)
+	assert.Equal(t, "users.email_taken", resp.Code)
+	assert.Equal(t, "email is already registered", resp.Error)
+	assert.Equal(t, map[string]any{"field": "email"}, resp.Details)
+}
+
+func TestSendResponsePlainError(t *testing.T) {
+	r := newTestRouter()
+	r.GET("/users", func(r Request) (any, error) {
+		return nil, errors.New(`pq: relation "users" does not exist`)
+	})
+
+	w := serve(r, http.MethodGet, "/users")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.NotContains(t, w.Body.String(), "pq:", "the cause is logged but never sent")
+
+	resp := HTTPResponse{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "internal_error", resp.Code)
+	assert.Equal(t, "internal server error", resp.Error)
+}
+
+func TestSendResponseProblem(t *testing.T) {
+	r := newRouter(Options{Logger: zap.NewNop(), ErrorFormat: ErrorFormatProblem})
+	r.POST("/users", failingHandler)
+
+	w := serve(r, http.MethodPost, "/users")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+	assert.NotContains(t, w.Body.String(), "duplicate key")
+
+	problem := Problem{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+	assert.Equal(t, "about:blank", problem.Type)
+	assert.Equal(t, "Conflict", problem.Title)
+	assert.Equal(t, http.StatusConflict, problem.Status)
+	assert.Equal(t, "email is already registered", problem.Detail)
+	assert.Equal(t, "/users", problem.Instance)
+	assert.Equal(t, "users.email_taken", problem.Code)
+	assert.NotEmpty(t, problem.ID)
+}
+
+func TestSendResponseRetryAfter(t *testing.T) {
+	r := newTestRouter()
+	r.GET("/limited", func(r Request) (any, error) {
+		return nil, NewCodedError(http.StatusTooManyRequests, "rate_limited", "slow down").WithRetryAfter(time.Minute)
+	})
+
+	w := serve(r, http.MethodGet, "/limited")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+}
@@ -1,14 +1,25 @@
 package web
 
 import (
+	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"reflect"
 	"runtime"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/pkg/errors"
+	"github.com/unluckythoughts/go-microservice/v2/tools/health"
+	"github.com/unluckythoughts/go-microservice/v2/tools/metrics"
+	"github.com/unluckythoughts/go-microservice/v2/tools/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -21,26 +32,63 @@ type (
 		_int        *httprouter.Router
 		l           *zap.Logger
 		cors        bool
-		middlewares map[string][]Middleware
+		middlewares []Middleware
+		prefixed    []prefixMiddlewares
+		health      *health.Registry
+		metrics     *metrics.Registry
+		openAPI     bool
+		problem     bool
+		timeout     time.Duration
+		// trustedProxies are the proxies whose forwarding headers give the client IP
+		trustedProxies []*net.IPNet
+		apiInfo        openAPIInfo
+		routes         []route
+		routesMutex    sync.Mutex
+	}
+
+	// Timeout is a route option overriding the request timeout of the router,
+	// passed along with the route handlers, zero disables the timeout
+	//
+	//	r.GET("/report", web.Timeout(time.Minute), handler)
+	Timeout time.Duration
+
+	// routeOptions are the options passed along with the route handlers
+	routeOptions struct {
+		doc     Doc
+		timeout *time.Duration
+	}
+
+	// prefixMiddlewares are middlewares applied to routes under a path prefix
+	prefixMiddlewares struct {
+		prefix      string
+		middlewares []Middleware
+	}
+
+	// group is a sub-router that prefixes its routes and runs its middlewares
+	// after the router and parent group middlewares
+	group struct {
+		root        *router
+		prefix      string
+		middlewares []Middleware
 	}
 )
 
 // notFoundHandler 404 http handler function
 func (r *router) notFoundHandler(w http.ResponseWriter, req *http.Request) {
 	msg := fmt.Sprintf("api route for %s %s not found", req.Method, req.URL.String())
-	sendResponse(newResponse(w, r.newRequest(req, nil)), nil, errors.New(msg), 404)
+	sendResponse(r.newResponse(w, r.newRequest(req, nil)), nil, errors.New(msg), 404)
 }
 
 // methodNotAllowedHandler 405 http handler function
 func (r *router) methodNotAllowedHandler(w http.ResponseWriter, req *http.Request) {
 	msg := "not allowed"
-	sendResponse(newResponse(w, r.newRequest(req, nil)), nil, errors.New(msg), 405)
+	sendResponse(r.newResponse(w, r.newRequest(req, nil)), nil, errors.New(msg), 405)
 }
 
 // panicHandler panic http handler function
 func (r *router) panicHandler(w http.ResponseWriter, req *http.Request, err any) {
 	panicErr := errors.New(err.(error).Error())
-	sendResponse(newResponse(w, r.newRequest(req, nil)), nil, panicErr, 500)
+	sendResponse(r.newResponse(w, r.newRequest(req, nil)), nil, panicErr, 500)
 }
 
 // healthcheckHandler healthcheck handler function
@@ -49,7 +97,30 @@ func (r *router) healthcheckHandler(w http.ResponseWriter, req *http.Request, _
 	fmt.Fprint(w, "ok")
 }
 
-// healthcheckHandler healthcheck handler function
+// sendHealthReport writes the health report, with status 503 if any check failed
+func sendHealthReport(w http.ResponseWriter, report health.Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status == health.StatusOK {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+// livenessHandler reports whether the process is alive and should not be restarted
+func (r *router) livenessHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	sendHealthReport(w, r.health.Live(req.Context()))
+}
+
+// readinessHandler reports whether the service and its dependencies can serve traffic
+func (r *router) readinessHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	sendHealthReport(w, r.health.Ready(req.Context()))
+}
+
+// log handler function to log the message in the url
 func (r *router) log(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
 	w.WriteHeader(http.StatusOK)
 	r.l.Info(p.ByName("message"))
@@ -57,10 +128,28 @@ func (r *router) log(w http.ResponseWriter, req *http.Request, p httprouter.Para
 
 // newRouter creates a new router with the provided options
 func newRouter(opts Options) *router {
+	trustedProxies, err := parseTrustedProxies(opts.TrustedProxies)
+	if err != nil {
+		panic(err)
+	}
+
 	r := &router{
-		_int: httprouter.New(),
-		l:    opts.Logger.Named("router"),
-		cors: opts.EnableCORS,
+		_int:           httprouter.New(),
+		l:              opts.Logger.Named("router"),
+		cors:           opts.EnableCORS,
+		openAPI:        opts.EnableOpenAPI,
+		problem:        opts.ErrorFormat == ErrorFormatProblem,
+		timeout:        opts.RequestTimeout,
+		metrics:        opts.Metrics,
+		trustedProxies: trustedProxies,
+		apiInfo: openAPIInfo{
+			Title:   opts.APITitle,
+			Version: opts.APIVersion,
+		},
+		health: health.New(health.Options{
+			Timeout:  opts.HealthCheckTimeout,
+			CacheTTL: opts.HealthCheckCacheTTL,
+		}),
 	}
 
 	r.attachBasicHandlers(opts.EnableCORS)
@@ -90,7 +179,7 @@ func setCORSHeaders(w http.ResponseWriter, req *http.Request) {
 // cors http handler function
 func (r *router) corsHandler(w http.ResponseWriter, req *http.Request) {
 	setCORSHeaders(w, req)
-	sendResponse(newResponse(w, r.newRequest(req, nil)), nil, nil, 200)
+	sendResponse(r.newResponse(w, r.newRequest(req, nil)), nil, nil, 200)
 }
 
 // attachBasicHandlers attaches basic handlers to the router
@@ -101,7 +190,15 @@ func (r *router) attachBasicHandlers(enableCors bool) {
 	r._int.MethodNotAllowed = http.HandlerFunc(r.methodNotAllowedHandler)
 	r._int.PanicHandler = r.panicHandler
 	r._int.GET("/_status", r.healthcheckHandler)
+	r._int.GET("/_live", r.livenessHandler)
+	r._int.GET("/_ready", r.readinessHandler)
 	r._int.GET("/_log/:message", r.log)
+	if r.openAPI {
+		r._int.GET("/_openapi.json", r.openAPIHandler)
+	}
+	if r.metrics != nil {
+		r._int.Handler(http.MethodGet, "/_metrics", r.metrics.Handler())
+	}
 
 	if enableCors {
 		r._int.GlobalOPTIONS = http.HandlerFunc(r.corsHandler)
@@ -127,6 +224,27 @@ func (r *router) getHandler(f any) (Handler, bool) {
 	return Handler(fn), ok
 }
 
+// getRouteOptions splits the route options from the middlewares and handler
+func getRouteOptions(handlers []any) ([]any, routeOptions) {
+	opts := routeOptions{}
+	rest := make([]any, 0, len(handlers))
+	for _, h := range handlers {
+		switch o := h.(type) {
+		case Doc:
+			opts.doc = o
+		case *Doc:
+			opts.doc = *o
+		case Timeout:
+			timeout := time.Duration(o)
+			opts.timeout = &timeout
+		default:
+			rest = append(rest, h)
+		}
+	}
+
+	return rest, opts
+}
+
 // getMiddlewares extracts the Middleware functions from the provided slice.
 // It expects each function to be of type func(MiddlewareRequest) error.
 // If the slice is empty, it returns an empty slice and true.
@@ -151,15 +269,42 @@ func (r *router) getMiddlewares(fns []any) (middlewares []Middleware, ok bool) {
 	return middlewares, true
 }
 
+// hasPathPrefix reports whether the path is the prefix or is under it,
+// matching whole path segments only, so /api matches /api/users but not /apis
+func hasPathPrefix(path, prefix string) bool {
+	prefix = strings.TrimRight(prefix, "/")
+	if prefix == "" {
+		return true
+	}
+
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// joinPath joins the group prefix with the route path
+func joinPath(prefix, path string) string {
+	if path == "" || path == "/" {
+		if prefix == "" {
+			return "/"
+		}
+		return prefix
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	return prefix + path
+}
+
 // getMiddlewaresForPath returns the middlewares for a specific path.
-// It first gets global middlewares, then checks for path-specific middlewares.
-// and combines them.
+// It first gets global middlewares, then the path prefix middlewares
+// ordered from the shortest to the longest prefix.
 func (r *router) getMiddlewaresForPath(path string) []Middleware {
-	middlewares := r.middlewares["*"]
+	middlewares := append([]Middleware{}, r.middlewares...)
 
-	for prefix, mw := range r.middlewares {
-		if strings.HasPrefix(path, prefix) {
-			middlewares = append(middlewares, mw...)
+	for _, pm := range r.prefixed {
+		if hasPathPrefix(path, pm.prefix) {
+			middlewares = append(middlewares, pm.middlewares...)
 		}
 	}
 
@@ -169,10 +314,10 @@ func (r *router) getMiddlewaresForPath(path string) []Middleware {
 // getRequestHandler returns a httprouter.Handle function that processes the request.
 // It extracts the handler and middlewares from the provided handlers slice
 // also find path specific middlewares on the router and combines them
-// with the provided middlewares.
+// with the group and provided middlewares, in that order.
 // The last element in the handlers slice is expected to be a Handler, while the rest are
 // expected to be Middleware functions
-func (r *router) getRouterHandlerForPath(path string, handlers []any) httprouter.Handle {
+func (r *router) getRouterHandlerForPath(path string, groupMiddlewares []Middleware, handlers []any, timeout time.Duration) httprouter.Handle {
 	handler, ok := r.getHandler(handlers[len(handlers)-1:][0])
 	if !ok {
 		panic(fmt.Errorf("last value of handlers has to be of type - web.Handler"))
@@ -184,6 +329,7 @@ func (r *router) getRouterHandlerForPath(path string, handlers []any) httprouter
 	}
 
 	pathMiddlewares := r.getMiddlewaresForPath(path)
+	pathMiddlewares = append(pathMiddlewares, groupMiddlewares...)
 	middlewares = append(pathMiddlewares, middlewares...)
 
 	return httprouter.Handle(func(w http.ResponseWriter, httpReq *http.Request, p httprouter.Params) {
@@ -191,8 +337,43 @@ func (r *router) getRouterHandlerForPath(path string, handlers []any) httprouter
 			setCORSHeaders(w, httpReq)
 		}
 
+		// continue the trace of the caller, if any, and send it back to it
+		spanCtx, span := tracing.Start(
+			tracing.Extract(httpReq.Context(), httpReq.Header),
+			httpReq.Method+" "+path,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", httpReq.Method),
+				attribute.String("http.route", path),
+			),
+		)
+		httpReq = httpReq.WithContext(spanCtx)
+		tracing.Inject(spanCtx, w.Header())
+
 		req := r.newRequest(httpReq, p)
-		resp := &response{request: req, respWriter: w}
+		if timeout > 0 {
+			req.ctx = req.ctx.WithTimeout(timeout)
+		}
+		defer req.ctx.Cancel()
+		resp := r.newResponse(w, req)
+
+		start := time.Now()
+		done := r.metrics.StartHTTPRequest()
+		defer func() {
+			done()
+			status := resp.status
+			if status == 0 {
+				// the handler panicked, the panic handler sends a 500
+				status = http.StatusInternalServerError
+			}
+			r.metrics.ObserveHTTPRequest(path, httpReq.Method, status, time.Since(start))
+
+			span.SetAttributes(attribute.Int("http.response.status_code", status))
+			if status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(status))
+			}
+			span.End()
+		}()
 
 		baseLogger := req.ctx.Logger()
 		for _, middleware := range middlewares {
@@ -223,56 +404,80 @@ func (r *router) getRouterHandlerForPath(path string, handlers []any) httprouter
 	})
 }
 
-// Use set router level middlewares, these apply to all routes on the router
-func (r *router) Use(middlewares ...Middleware) {
-	if len(r.middlewares["*"]) < 1 {
-		r.middlewares = make(map[string][]Middleware)
-	}
+// handle attaches the route on the internal router and records it
+// with its Doc for the OpenAPI document
+func (r *router) handle(method, path string, groupMiddlewares []Middleware, handlers []any) {
+	handlers, opts := getRouteOptions(handlers)
+	r.addRoute(method, path, opts.doc)
 
-	if len(middlewares) > 0 {
-		r.middlewares["*"] = append(r.middlewares["*"], middlewares...)
+	timeout := r.timeout
+	if opts.timeout != nil {
+		timeout = *opts.timeout
 	}
+	r._int.Handle(method, path, r.getRouterHandlerForPath(path, groupMiddlewares, handlers, timeout))
+}
+
+// Use set router level middlewares, these apply to all routes on the router
+func (r *router) Use(middlewares ...Middleware) {
+	r.middlewares = append(r.middlewares, middlewares...)
 }
 
 // UseFor set middlewares for a specific path prefix
 // This allows you to apply middlewares only to routes that start with the given path prefix.
 // it does not support glob patterns, so you need to specify the exact prefix.
+// Middlewares of shorter prefixes run before those of longer prefixes.
 func (r *router) UseFor(pathPrefix string, middlewares ...Middleware) {
-	if len(r.middlewares[pathPrefix]) < 1 {
-		r.middlewares[pathPrefix] = make([]Middleware, 0)
+	pathPrefix = strings.TrimRight(pathPrefix, "/")
+	for i := range r.prefixed {
+		if r.prefixed[i].prefix == pathPrefix {
+			r.prefixed[i].middlewares = append(r.prefixed[i].middlewares, middlewares...)
+			return
+		}
 	}
 
-	if len(middlewares) > 0 {
-		r.middlewares[pathPrefix] = append(r.middlewares[pathPrefix], middlewares...)
-	}
+	r.prefixed = append(r.prefixed, prefixMiddlewares{prefix: pathPrefix, middlewares: middlewares})
+	sort.SliceStable(r.prefixed, func(i, j int) bool {
+		return len(r.prefixed[i].prefix) < len(r.prefixed[j].prefix)
+	})
 }
 
-// GET attaches route with given path and handlers (...Middleware, Handler)
+// Group returns a sub-router for routes under the given prefix, the given middlewares
+// run after the router middlewares and before the route middlewares
+func (r *router) Group(prefix string, middlewares ...Middleware) Router {
+	return newGroup(r, "", nil).Group(prefix, middlewares...)
+}
+
+// GET attaches route with given path and handlers (Doc, ...Middleware, Handler)
 func (r *router) GET(path string, handlers ...any) {
-	r._int.GET(path, r.getRouterHandlerForPath(path, handlers))
+	r.handle(http.MethodGet, path, nil, handlers)
 }
 
-// POST attaches route with given path and handlers (...Middleware, Handler)
+// POST attaches route with given path and handlers (Doc, ...Middleware, Handler)
 func (r *router) POST(path string, handlers ...any) {
-	r._int.POST(path, r.getRouterHandlerForPath(path, handlers))
+	r.handle(http.MethodPost, path, nil, handlers)
 }
 
-// PUT attaches route with given path and handlers (...Middleware, Handler)
+// PUT attaches route with given path and handlers (Doc, ...Middleware, Handler)
 func (r *router) PUT(path string, handlers ...any) {
-	r._int.PUT(path, r.getRouterHandlerForPath(path, handlers))
+	r.handle(http.MethodPut, path, nil, handlers)
 }
 
-// PATCH attaches route with given path and handlers (...Middleware, Handler)
+// PATCH attaches route with given path and handlers (Doc, ...Middleware, Handler)
 func (r *router) PATCH(path string, handlers ...any) {
-	r._int.PATCH(path, r.getRouterHandlerForPath(path, handlers))
+	r.handle(http.MethodPatch, path, nil, handlers)
 }
 
-// DELETE attaches route with given path and handlers (...Middleware, Handler)
+// DELETE attaches route with given path and handlers (Doc, ...Middleware, Handler)
 func (r *router) DELETE(path string, handlers ...any) {
-	r._int.DELETE(path, r.getRouterHandlerForPath(path, handlers))
+	r.handle(http.MethodDelete, path, nil, handlers)
 }
 
 // ServeFiles attaches path to root dir and serve static files
 func (r *router) ServeFiles(path string, root http.FileSystem) {
 	r._int.ServeFiles(path, root)
 }
+
+// Handle attaches a plain http.Handler, e.g. for documents served as is
+func (r *router) Handle(method, path string, handler http.Handler) {
+	r._int.Handler(method, path, handler)
+}
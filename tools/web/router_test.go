@@ -0,0 +1,237 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unluckythoughts/go-microservice/v2/tools/metrics"
+	"go.uber.org/zap"
+)
+
+func newTestRouter() *router {
+	return newRouter(Options{Logger: zap.NewNop()})
+}
+
+// recordMiddleware returns a middleware that appends name to calls when it runs
+func recordMiddleware(calls *[]string, name string) Middleware {
+	return func(r MiddlewareRequest) error {
+		*calls = append(*calls, name)
+		return nil
+	}
+}
+
+func serve(r *router, method, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r._int.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	return w
+}
+
+func okHandler(r Request) (any, error) {
+	return "ok", nil
+}
+
+func TestUseForWithoutUse(t *testing.T) {
+	r := newTestRouter()
+	calls := []string{}
+
+	assert.NotPanics(t, func() {
+		r.UseFor("/api", recordMiddleware(&calls, "api"))
+	})
+
+	r.GET("/api/users", okHandler)
+	w := serve(r, http.MethodGet, "/api/users")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"api"}, calls)
+}
+
+func TestUseForMatchesWholeSegments(t *testing.T) {
+	r := newTestRouter()
+	calls := []string{}
+	r.UseFor("/api", recordMiddleware(&calls, "api"))
+
+	r.GET("/apis", okHandler)
+	serve(r, http.MethodGet, "/apis")
+	assert.Empty(t, calls)
+}
+
+func TestMiddlewareOrderIsDeterministic(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		r := newTestRouter()
+		calls := []string{}
+
+		r.UseFor("/api/v1/admin", recordMiddleware(&calls, "prefix-admin"))
+		r.UseFor("/api", recordMiddleware(&calls, "prefix-api"))
+		r.UseFor("/api/v1", recordMiddleware(&calls, "prefix-v1"))
+		r.Use(recordMiddleware(&calls, "global"))
+
+		api := r.Group("/api/v1", recordMiddleware(&calls, "group-v1"))
+		admin := api.Group("/admin", recordMiddleware(&calls, "group-admin"))
+		admin.GET("/users", recordMiddleware(&calls, "route"), okHandler)
+
+		w := serve(r, http.MethodGet, "/api/v1/admin/users")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, []string{
+			"global",
+			"prefix-api",
+			"prefix-v1",
+			"prefix-admin",
+			"group-v1",
+			"group-admin",
+			"route",
+		}, calls)
+	}
+}
+
+func TestGroupMiddlewaresAreScoped(t *testing.T) {
+	r := newTestRouter()
+	calls := []string{}
+
+	api := r.Group("/api", recordMiddleware(&calls, "api"))
+	api.Group("/admin", recordMiddleware(&calls, "admin"))
+	api.GET("/users", okHandler)
+	r.GET("/public", okHandler)
+
+	serve(r, http.MethodGet, "/api/users")
+	assert.Equal(t, []string{"api"}, calls)
+
+	calls = calls[:0]
+	serve(r, http.MethodGet, "/public")
+	assert.Empty(t, calls)
+}
+
+func TestGroupPaths(t *testing.T) {
+	r := newTestRouter()
+	api := r.Group("/api/v1/")
+	api.GET("", okHandler)
+	api.Group("admin").GET("/users/:id", okHandler)
+
+	assert.Equal(t, http.StatusOK, serve(r, http.MethodGet, "/api/v1").Code)
+	assert.Equal(t, http.StatusOK, serve(r, http.MethodGet, "/api/v1/admin/users/1").Code)
+	assert.Equal(t, http.StatusNotFound, serve(r, http.MethodGet, "/admin/users/1").Code)
+}
+
+// waitForContext blocks until the request context is done and returns its error
+func waitForContext(r Request) (any, error) {
+	select {
+	case <-r.GetContext().Done():
+		return nil, r.GetContext().Err()
+	case <-time.After(time.Second):
+		return "not canceled", nil
+	}
+}
+
+func TestRouteTimeout(t *testing.T) {
+	r := newTestRouter()
+	r.GET("/slow", Timeout(10*time.Millisecond), waitForContext)
+
+	w := serve(r, http.MethodGet, "/slow")
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"request_timeout"`)
+}
+
+func TestGlobalTimeoutCanBeDisabledPerRoute(t *testing.T) {
+	r := newRouter(Options{Logger: zap.NewNop(), RequestTimeout: 10 * time.Millisecond})
+	r.GET("/slow", waitForContext)
+	r.GET("/quick", Timeout(0), okHandler)
+
+	assert.Equal(t, http.StatusGatewayTimeout, serve(r, http.MethodGet, "/slow").Code)
+	assert.Equal(t, http.StatusOK, serve(r, http.MethodGet, "/quick").Code)
+}
+
+func TestRequestContextIsCanceledWithClient(t *testing.T) {
+	r := newTestRouter()
+	r.GET("/wait", waitForContext)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	w := httptest.NewRecorder()
+	r._int.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/wait", nil).WithContext(ctx))
+	assert.Equal(t, statusClientClosedRequest, w.Code)
+}
+
+func TestRequestInfo(t *testing.T) {
+	r := newTestRouter()
+	var info RequestInfo
+	r.GET("/info", func(r Request) (any, error) {
+		info, _ = GetRequestInfo(r.GetContext())
+		return "ok", nil
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/info", nil)
+	req.Header.Set("User-Agent", "test-agent")
+	req.Header.Set("X-Forwarded-For", "10.0.0.1, 10.0.0.2")
+	w := httptest.NewRecorder()
+	r._int.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "192.0.2.1", info.IP, "the forwarding headers of untrusted clients are ignored")
+	assert.Equal(t, "test-agent", info.UserAgent)
+	assert.Contains(t, w.Body.String(), `"id":"`+info.ID+`"`)
+
+	_, ok := GetRequestInfo(context.Background())
+	assert.False(t, ok)
+}
+
+func TestClientIP(t *testing.T) {
+	r := newRouter(Options{Logger: zap.NewNop(), TrustedProxies: []string{"192.0.2.1", "10.1.0.0/16"}})
+	var ip string
+	r.GET("/ip", func(r Request) (any, error) {
+		ip = GetClientIP(r)
+		return "ok", nil
+	})
+
+	for _, tc := range []struct {
+		name, remoteAddr string
+		headers          map[string]string
+		ip               string
+	}{
+		{"no headers", "192.0.2.1:1234", nil, "192.0.2.1"},
+		{"untrusted client", "192.0.2.2:1234", map[string]string{"X-Forwarded-For": "10.0.0.1", "X-Real-IP": "10.0.0.1"}, "192.0.2.2"},
+		{"trusted proxy", "192.0.2.1:1234", map[string]string{"X-Forwarded-For": "10.0.0.1"}, "10.0.0.1"},
+		{"spoofed first hop", "192.0.2.1:1234", map[string]string{"X-Forwarded-For": "1.2.3.4, 10.0.0.1, 10.1.2.3"}, "10.0.0.1"},
+		{"only proxies", "192.0.2.1:1234", map[string]string{"X-Forwarded-For": "10.1.0.1, 10.1.0.2"}, "10.1.0.1"},
+		{"real ip", "192.0.2.1:1234", map[string]string{"X-Real-IP": " 10.0.0.2 "}, "10.0.0.2"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+		req.RemoteAddr = tc.remoteAddr
+		for k, v := range tc.headers {
+			req.Header.Set(k, v)
+		}
+		r._int.ServeHTTP(httptest.NewRecorder(), req)
+		assert.Equal(t, tc.ip, ip, tc.name)
+	}
+
+	assert.Panics(t, func() {
+		newRouter(Options{Logger: zap.NewNop(), TrustedProxies: []string{"proxy"}})
+	})
+}
+
+func TestRouteMetrics(t *testing.T) {
+	m := metrics.New(metrics.Options{})
+	r := newRouter(Options{Logger: zap.NewNop(), Metrics: m})
+	r.GET("/users/:id", okHandler)
+	r.GET("/fail", func(r Request) (any, error) { return nil, NotFound() })
+
+	serve(r, http.MethodGet, "/users/1")
+	serve(r, http.MethodGet, "/users/2")
+	serve(r, http.MethodGet, "/fail")
+
+	w := serve(r, http.MethodGet, "/_metrics")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `http_requests_total{method="GET",route="/users/:id",status="200"} 2`)
+	assert.Contains(t, w.Body.String(), `http_requests_total{method="GET",route="/fail",status="404"} 1`)
+	assert.Contains(t, w.Body.String(), `http_request_duration_seconds_count{method="GET",route="/users/:id"} 2`)
+}
+
+func TestMetricsDisabled(t *testing.T) {
+	r := newTestRouter()
+	r.GET("/users/:id", okHandler)
+
+	assert.Equal(t, http.StatusOK, serve(r, http.MethodGet, "/users/1").Code)
+	assert.Equal(t, http.StatusNotFound, serve(r, http.MethodGet, "/_metrics").Code)
+}
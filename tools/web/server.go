@@ -1,10 +1,15 @@
 package web
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/unluckythoughts/go-microservice/v2/tools/health"
+	"github.com/unluckythoughts/go-microservice/v2/tools/metrics"
 	"github.com/unluckythoughts/go-microservice/v2/tools/sockets"
 	"go.uber.org/zap"
 )
@@ -18,6 +23,7 @@ type (
 		router         *router
 		socketServer   *sockets.Server
 		proxyTransport ProxyTransport
+		httpServer     *http.Server
 	}
 
 	Options struct {
@@ -27,6 +33,25 @@ type (
 		WorkerCount int    `env:"WEB_WORKER_COUNT" envDefault:"20"`
 		EnableCORS  bool   `env:"WEB_CORS" envDefault:"false"`
 		EnableProxy bool   `env:"WEB_PROXY" envDefault:"false"`
+		// TrustedProxies are the IPs and CIDR ranges of the reverse proxies whose
+		// X-Forwarded-For and X-Real-IP headers give the client IP, the headers of
+		// the other clients are ignored
+		TrustedProxies []string `env:"WEB_TRUSTED_PROXIES" envSeparator:","`
+		// HealthCheckTimeout is the maximum duration of a single check on /_live and /_ready
+		HealthCheckTimeout time.Duration `env:"WEB_HEALTH_CHECK_TIMEOUT" envDefault:"2s"`
+		// HealthCheckCacheTTL is how long a check result is reused before the check runs again
+		HealthCheckCacheTTL time.Duration `env:"WEB_HEALTH_CHECK_CACHE_TTL" envDefault:"5s"`
+		// EnableOpenAPI serves the OpenAPI document of the routes on /_openapi.json
+		EnableOpenAPI bool   `env:"WEB_OPENAPI" envDefault:"true"`
+		APITitle      string `env:"WEB_API_TITLE" envDefault:"API"`
+		APIVersion    string `env:"WEB_API_VERSION" envDefault:"1.0.0"`
+		// ErrorFormat is the format of error responses, envelope or problem (RFC 7807)
+		ErrorFormat string `env:"WEB_ERROR_FORMAT" envDefault:"envelope"`
+		// RequestTimeout cancels the request context after the duration, zero disables it,
+		// routes can override it with the web.Timeout route option
+		RequestTimeout time.Duration `env:"WEB_REQUEST_TIMEOUT" envDefault:"0s"`
+		// Metrics records the http and socket metrics and serves them on /_metrics, nil disables it
+		Metrics *metrics.Registry
 	}
 
 	ProxyTransport func(l *zap.Logger) http.RoundTripper
@@ -52,6 +77,7 @@ func (s *Server) setupRouter() http.Handler {
 // NewServer returns a new server object
 func NewServer(opts Options) *Server {
 	socketServer := sockets.New(opts.Logger.Named("socket"), opts.WorkerCount)
+	socketServer.SetMetrics(opts.Metrics)
 	s := &Server{
 		addr:         ":" + strconv.Itoa(opts.Port),
 		logger:       opts.Logger,
@@ -59,6 +85,10 @@ func NewServer(opts Options) *Server {
 		router:       newRouter(opts),
 		socketServer: socketServer,
 	}
+	s.httpServer = &http.Server{
+		Addr:    s.addr,
+		Handler: s.setupRouter(),
+	}
 
 	return s
 }
@@ -67,14 +97,41 @@ func (s *Server) SetProxyTransport(rt ProxyTransport) {
 	s.proxyTransport = rt
 }
 
-// Start runs http listener on the given address
-func (s *Server) Start() {
+// ListenAndServe runs http listener on the given address and blocks
+// until the server fails or is stopped with Shutdown
+func (s *Server) ListenAndServe() error {
 	s.socketServer.StartSocketWorkers()
 	s.logger.Info("Web server started on address: " + s.addr)
-	s.logger.Fatal(http.ListenAndServe(s.addr, s.setupRouter()).Error())
+	err := s.httpServer.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// Start runs http listener on the given address
+func (s *Server) Start() {
+	if err := s.ListenAndServe(); err != nil {
+		s.logger.Fatal(err.Error())
+	}
+}
+
+// Shutdown stops accepting new http and socket connections, then waits for
+// in-flight requests to finish or the context to expire
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.logger.Info("Shutting down web server")
+	err := s.httpServer.Shutdown(ctx)
+
+	return errors.Join(err, s.socketServer.Shutdown(ctx))
 }
 
 // GetRouter returns the router instance
 func (s *Server) GetRouter() Router {
 	return s.router
 }
+
+// GetHealth returns the registry of checks served on /_live and /_ready
+func (s *Server) GetHealth() *health.Registry {
+	return s.router.health
+}
@@ -0,0 +1,325 @@
+package web
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/unluckythoughts/go-microservice/v2/tools/context"
+)
+
+// TypedHandler is a handler with a typed request and response
+type TypedHandler[In, Out any] func(ctx context.Context, in In) (Out, error)
+
+// FieldErrors maps request field names to their error messages
+type FieldErrors map[string]string
+
+func (fe FieldErrors) Error() string {
+	fields := make([]string, 0, len(fe))
+	for field := range fe {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	msgs := make([]string, 0, len(fields))
+	for _, field := range fields {
+		msgs = append(msgs, field+": "+fe[field])
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+var (
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	durationType        = reflect.TypeOf(time.Duration(0))
+)
+
+// Typed converts a typed handler to a web.Handler.
+// The request is bound to In with Bind before fn is called, and the
+// returned Out is sent as the response data.
+func Typed[In, Out any](fn TypedHandler[In, Out]) Handler {
+	return func(r Request) (any, error) {
+		var in In
+		if err := Bind(r, &in); err != nil {
+			return nil, err
+		}
+
+		return fn(r.GetContext(), in)
+	}
+}
+
+// Bind fills the struct pointed by ptr from the request and validates it.
+// The JSON body is decoded first, then fields are set from
+// route params (`path:"id"`), url query params (`query:"page"`) and headers
+// (`header:"X-Tenant"`). Values are converted to the field type, supported
+// types are strings, bools, ints, uints, floats, time.Time, time.Duration,
+// encoding.TextUnmarshaler implementations, pointers and slices of these.
+// Finally the struct is validated with govalidator.
+// Conversion and validation failures are returned as a 400 error wrapping FieldErrors.
+func Bind(r Request, ptr any) error {
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return InternalServerError(fmt.Errorf("bind target has to be a non nil pointer, got %T", ptr))
+	}
+
+	if err := bindBody(r, ptr); err != nil {
+		return err
+	}
+
+	elem := v.Elem()
+	if elem.Kind() != reflect.Struct {
+		return nil
+	}
+
+	fieldErrs := FieldErrors{}
+	bindParams(r, elem, fieldErrs)
+	if len(fieldErrs) > 0 {
+		return NewError(http.StatusBadRequest, fieldErrs)
+	}
+
+	if _, err := govalidator.ValidateStruct(ptr); err != nil {
+		names := map[string]string{}
+		collectFieldNames(elem.Type(), names, map[reflect.Type]bool{})
+		collectValidationErrors(err, names, fieldErrs)
+		return NewError(http.StatusBadRequest, fieldErrs)
+	}
+
+	return nil
+}
+
+// readBody returns the raw request body, reading it only once
+func readBody(r Request) ([]byte, error) {
+	if req, ok := r.(*request); ok {
+		return req.readBody()
+	}
+
+	body := r.GetInternalRequest().Body
+	if body == nil {
+		return nil, nil
+	}
+
+	return io.ReadAll(body)
+}
+
+// bindBody decodes the JSON body into ptr, if there is any
+func bindBody(r Request, ptr any) error {
+	data, err := readBody(r)
+	if err != nil {
+		return NewError(http.StatusBadRequest, fmt.Errorf("failed to read request body: %w", err))
+	}
+
+	if len(data) < 1 {
+		return nil
+	}
+
+	if err := json.Unmarshal(data, ptr); err != nil {
+		typeErr := &json.UnmarshalTypeError{}
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			return NewError(http.StatusBadRequest, FieldErrors{
+				typeErr.Field: fmt.Sprintf("expected a value of type %s", typeErr.Type),
+			})
+		}
+		return NewError(http.StatusBadRequest, fmt.Errorf("failed to read request body: %w", err))
+	}
+
+	return nil
+}
+
+// getParamValues returns the values of the path, query or header param
+// the field is tagged with
+func getParamValues(r Request, field reflect.StructField) (name string, values []string, ok bool) {
+	if name = field.Tag.Get("path"); name != "" {
+		if value := r.GetRouteParam(name); value != "" {
+			values = []string{value}
+		}
+		return name, values, true
+	}
+
+	if name = field.Tag.Get("query"); name != "" {
+		return name, r.GetInternalRequest().URL.Query()[name], true
+	}
+
+	if name = field.Tag.Get("header"); name != "" {
+		return name, r.GetHeaders().Values(name), true
+	}
+
+	return "", nil, false
+}
+
+// bindParams sets the tagged fields of the struct from the request params
+func bindParams(r Request, v reflect.Value, fieldErrs FieldErrors) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			bindParams(r, v.Field(i), fieldErrs)
+			continue
+		}
+
+		name, values, ok := getParamValues(r, field)
+		if !ok || len(values) == 0 {
+			continue
+		}
+
+		if err := setField(v.Field(i), values); err != nil {
+			fieldErrs[name] = err.Error()
+		}
+	}
+}
+
+// setField sets the field from the given string values
+func setField(v reflect.Value, values []string) error {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return setField(v.Elem(), values)
+	}
+
+	if v.Kind() == reflect.Slice && !(v.CanAddr() && v.Addr().Type().Implements(textUnmarshalerType)) {
+		items := []string{}
+		for _, value := range values {
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+		}
+
+		slice := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setField(slice.Index(i), []string{item}); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+		return nil
+	}
+
+	return setValue(v, values[0])
+}
+
+// setValue converts the string to the type of v and sets it
+func setValue(v reflect.Value, value string) error {
+	if v.CanAddr() && v.Addr().Type().Implements(textUnmarshalerType) {
+		if err := v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value)); err != nil {
+			return fmt.Errorf("invalid value %q", value)
+		}
+		return nil
+	}
+
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("expected a boolean, got %q", value)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("expected an integer, got %q", value)
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("expected a positive integer, got %q", value)
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("expected a number, got %q", value)
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field type %s", v.Type())
+	}
+
+	return nil
+}
+
+// getFieldName returns the name of the field as seen by the client
+func getFieldName(field reflect.StructField) string {
+	for _, tag := range []string{"path", "query", "header", "json"} {
+		name := strings.Split(field.Tag.Get(tag), ",")[0]
+		if name != "" && name != "-" {
+			return name
+		}
+	}
+
+	return field.Name
+}
+
+// collectFieldNames maps the struct field names to the names seen by the client
+func collectFieldNames(t reflect.Type, names map[string]string, seen map[reflect.Type]bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || seen[t] {
+		return
+	}
+	seen[t] = true
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if _, ok := names[field.Name]; !ok {
+			names[field.Name] = getFieldName(field)
+		}
+		if field.Type.Kind() == reflect.Struct || field.Type.Kind() == reflect.Ptr {
+			collectFieldNames(field.Type, names, seen)
+		}
+	}
+}
+
+// collectValidationErrors converts govalidator errors to field errors
+func collectValidationErrors(err error, names map[string]string, fieldErrs FieldErrors) {
+	switch e := err.(type) {
+	case govalidator.Errors:
+		for _, inner := range e.Errors() {
+			collectValidationErrors(inner, names, fieldErrs)
+		}
+	case govalidator.Error:
+		name, ok := names[e.Name]
+		if !ok {
+			name = e.Name
+		}
+
+		path := []string{}
+		for _, p := range e.Path {
+			if mapped, ok := names[p]; ok {
+				p = mapped
+			}
+			path = append(path, p)
+		}
+
+		fieldErrs[strings.Join(append(path, name), ".")] = e.Err.Error()
+	default:
+		fieldErrs["_"] = err.Error()
+	}
+}
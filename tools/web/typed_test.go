@@ -0,0 +1,105 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/unluckythoughts/go-microservice/v2/tools/context"
+)
+
+type typedTestRequest struct {
+	ID      uint          `path:"id" json:"-"`
+	Page    int           `query:"page" json:"-"`
+	Active  *bool         `query:"active" json:"-"`
+	Tags    []string      `query:"tag" json:"-"`
+	Since   time.Time     `query:"since" json:"-"`
+	Timeout time.Duration `query:"timeout" json:"-"`
+	Tenant  string        `header:"X-Tenant" json:"-"`
+	Name    string        `json:"name" valid:"required~name is required"`
+	Email   string        `json:"email" valid:"email~email is not valid"`
+}
+
+func serveTyped(t *testing.T, method, target, body string, headers http.Header) (*httptest.ResponseRecorder, HTTPResponse) {
+	t.Helper()
+
+	r := newTestRouter()
+	r.handle(method, "/users/:id", nil, []any{Typed(func(ctx context.Context, in typedTestRequest) (string, error) {
+		return in.Name, nil
+	})})
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for key, values := range headers {
+		req.Header[key] = values
+	}
+
+	w := httptest.NewRecorder()
+	r._int.ServeHTTP(w, req)
+
+	resp := HTTPResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	return w, resp
+}
+
+func TestTypedBindsRequest(t *testing.T) {
+	r := newTestRouter()
+	var got typedTestRequest
+	r.handle(http.MethodPut, "/users/:id", nil, []any{Typed(func(ctx context.Context, in typedTestRequest) (string, error) {
+		got = in
+		return "ok", nil
+	})})
+
+	req := httptest.NewRequest(http.MethodPut,
+		"/users/42?page=3&active=true&tag=a,b&tag=c&since=2024-01-02T03:04:05Z&timeout=1m30s",
+		strings.NewReader(`{"name":"Alice","email":"alice@example.com"}`))
+	req.Header.Set("X-Tenant", "acme")
+	w := httptest.NewRecorder()
+	r._int.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	assert.Equal(t, uint(42), got.ID)
+	assert.Equal(t, 3, got.Page)
+	require.NotNil(t, got.Active)
+	assert.True(t, *got.Active)
+	assert.Equal(t, []string{"a", "b", "c"}, got.Tags)
+	assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), got.Since)
+	assert.Equal(t, 90*time.Second, got.Timeout)
+	assert.Equal(t, "acme", got.Tenant)
+	assert.Equal(t, "Alice", got.Name)
+}
+
+func TestTypedConversionErrors(t *testing.T) {
+	w, resp := serveTyped(t, http.MethodPut, "/users/abc?page=x", `{"name":"Alice"}`, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, resp.Error, "id: expected a positive integer")
+	assert.Contains(t, resp.Error, "page: expected an integer")
+}
+
+func TestTypedValidationErrors(t *testing.T) {
+	w, resp := serveTyped(t, http.MethodPut, "/users/1", `{"email":"not-an-email"}`, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, resp.Error, "name: name is required")
+	assert.Contains(t, resp.Error, "email: email is not valid")
+}
+
+func TestTypedBodyTypeError(t *testing.T) {
+	w, resp := serveTyped(t, http.MethodPut, "/users/1", `{"name":42}`, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, resp.Error, "name: expected a value of type string")
+}
+
+func TestBindNonPointerHidesCause(t *testing.T) {
+	err := Bind(nil, typedTestRequest{})
+	assert.Equal(t, "internal_server_error", ErrorCode(err))
+	assert.Equal(t, "internal server error", err.Error(), "the programming error is only logged")
+}
+
+func TestFieldErrorsAreSorted(t *testing.T) {
+	fe := FieldErrors{"b": "second", "a": "first"}
+	assert.Equal(t, "a: first; b: second", fe.Error())
+}
@@ -1,32 +1,38 @@
 package worker
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/robfig/cron/v3"
-	"github.com/unluckythoughts/go-microservice/v2/tools/context"
+	localcontext "github.com/unluckythoughts/go-microservice/v2/tools/context"
+	"github.com/unluckythoughts/go-microservice/v2/tools/metrics"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
 
 // TaskFunc is a function type for background tasks
-type TaskFunc func(ctx context.Context) error
+type TaskFunc func(ctx localcontext.Context) error
 
 // Worker manages background tasks and cron jobs
 type Worker struct {
 	cron      *cron.Cron
-	ctx       context.Context
+	ctx       localcontext.Context
+	running   atomic.Bool
 	db        *gorm.DB
 	wg        sync.WaitGroup
 	enableDL  bool // enable distributed locking
 	tasks     map[string]cron.EntryID
 	taskMutex sync.RWMutex
+	metrics   *metrics.Registry
 }
 
 // New creates a new Worker instance
-func New(c context.Context, db *gorm.DB) *Worker {
+func New(c localcontext.Context, db *gorm.DB) *Worker {
 	w := &Worker{
 		cron:  cron.New(cron.WithSeconds()),
 		ctx:   c,
@@ -44,6 +50,11 @@ func New(c context.Context, db *gorm.DB) *Worker {
 	return w
 }
 
+// SetMetrics sets the registry recording the cron job runs, nil disables it
+func (w *Worker) SetMetrics(m *metrics.Registry) {
+	w.metrics = m
+}
+
 func (w *Worker) Logger(name string) *zap.SugaredLogger {
 	l := w.ctx.Sugar().With("task", name)
 	return l
@@ -97,12 +108,15 @@ func hashString(s string) uint32 {
 // Start starts the worker's cron scheduler
 func (w *Worker) Start() {
 	w.cron.Start()
+	w.running.Store(true)
 }
 
-// Stop gracefully stops the worker, waiting for background tasks to complete
+// Stop gracefully stops the worker, waiting for running cron jobs and
+// background tasks to complete
 func (w *Worker) Stop() {
+	w.running.Store(false)
 	w.ctx.Cancel()
-	w.cron.Stop()
+	<-w.cron.Stop().Done()
 	w.wg.Wait()
 }
 
@@ -175,7 +189,10 @@ func (w *Worker) ScheduleCron(name string, schedule string, fn TaskFunc) error {
 		}
 
 		// Run the task
-		if err := fn(w.ctx); err != nil {
+		start := time.Now()
+		err := fn(w.ctx)
+		w.metrics.ObserveWorkerJob(name, time.Since(start), err)
+		if err != nil {
 			w.Logger(name).Error("Cron task error", err)
 		}
 	})
@@ -238,3 +255,21 @@ func (w *Worker) RunWithTimeout(name string, timeout time.Duration, fn TaskFunc)
 		return fmt.Errorf("task '%s' timed out after %v", name, timeout)
 	}
 }
+
+// Name returns the name of the worker health check
+func (w *Worker) Name() string {
+	return "worker"
+}
+
+// Check returns an error if the worker is not running
+func (w *Worker) Check(_ context.Context) error {
+	if !w.running.Load() {
+		return errors.New("worker is not running")
+	}
+
+	if err := w.ctx.Err(); err != nil {
+		return fmt.Errorf("worker context is done: %w", err)
+	}
+
+	return nil
+}
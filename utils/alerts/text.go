@@ -2,6 +2,7 @@ package alerts
 
 import (
 	"encoding/base64"
+	"fmt"
 	"net/http"
 	"net/url"
 
@@ -50,6 +51,22 @@ func getRequestBody(message, number string) []byte {
 	return []byte(msgData.Encode())
 }
 
+// Send sends the text message to the number, the message is not logged
+// since it can contain secrets like verification tokens
+func (c *TextClient) Send(message, number string) error {
+	body := getRequestBody(message, number)
+	resp := map[string]interface{}{}
+
+	status, err := c.webClient.PostResponse(messagesUrl, body, &resp)
+	if err != nil {
+		return fmt.Errorf("error sending text message to %s: %w", number, err)
+	} else if status != http.StatusOK && status != http.StatusCreated {
+		return fmt.Errorf("received status %d while sending text message to %s, response: %+v", status, number, resp)
+	}
+
+	return nil
+}
+
 func (c *TextClient) SendMessage(message, number string) {
 	body := getRequestBody(message, number)
 	resp := map[string]interface{}{}